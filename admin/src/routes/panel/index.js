@@ -2,6 +2,7 @@ import { h, Component } from 'preact';
 import style from './style';
 import Thread from './thread';
 import Login from './login';
+import { registerPasskey, webAuthnSupported } from './webauthn';
 
 const handleStateChange = (http, context, key) => {
 	if (http.readyState == 4 && http.status == 200) {
@@ -25,7 +26,7 @@ const getUrl = (state, window) => {
 export default class Panel extends Component {
 	constructor() {
 		super();
-		this.state = { threads: [], comments: [],  error: false, authorized: false, loaded: false, showPending: true, showDeleted: false, configLoaded: false, config: {}, path:undefined };
+		this.state = { threads: [], comments: [],  error: false, authorized: false, loaded: false, showPending: true, showDeleted: false, configLoaded: false, config: {}, path:undefined, csrfToken: "" };
 		this.loadThreads = this.loadThreads.bind(this);
 		this.loadComments = this.loadComments.bind(this);
 		this.loggedIn = this.loggedIn.bind(this);
@@ -35,6 +36,8 @@ export default class Panel extends Component {
 		this.showDeleted = this.showDeleted.bind(this);
 		this.updateComment = this.updateComment.bind(this);
 		this.fetchConfig = this.fetchConfig.bind(this);
+		this.logout = this.logout.bind(this);
+		this.addPasskey = this.addPasskey.bind(this);
 	}
 
 	showPending() {
@@ -84,6 +87,7 @@ export default class Panel extends Component {
 		var http = new XMLHttpRequest();
 		var url = getUrl(this.state, window) + "v1/admin/comments";
 		http.open("PATCH", url, true);
+		http.setRequestHeader("X-CSRF-Token", this.state.csrfToken);
 		var context = this;
 		http.onreadystatechange = function () {
 			if (http.readyState == 4 && http.status == 204) {
@@ -98,7 +102,34 @@ export default class Panel extends Component {
 	}
 	
 
-	loggedIn() {
+	logout() {
+		if (typeof window == "undefined") { return }
+		var http = new XMLHttpRequest();
+		var url = getUrl(this.state, window) + "v1/admin/logout";
+		http.open("POST", url, true);
+		http.setRequestHeader("X-CSRF-Token", this.state.csrfToken);
+		var context = this;
+		http.onreadystatechange = function () {
+			if (http.readyState == 4) {
+				context.setState({ authorized: false, threads: [], comments: [], csrfToken: "" })
+			}
+		}
+		http.send()
+	}
+
+	addPasskey() {
+		if (typeof window == "undefined") { return }
+		var name = window.prompt("Name the passkey", "");
+		if (name === null) { return }
+		registerPasskey(getUrl(this.state, window), this.state.csrfToken, name, function(ok) {
+			window.alert(ok ? "The passkey was added" : "The passkey could not be added");
+		});
+	}
+
+	loggedIn(csrfToken) {
+		if (csrfToken) {
+			this.setState({ csrfToken: csrfToken })
+		}
 		this.setState({ authorized: true })
 		this.setState({ loaded: false })
 	}
@@ -114,7 +145,7 @@ export default class Panel extends Component {
 		  }
 		  if (http.status == 200) {
 			var parsedResponse = JSON.parse(http.responseText)
-			context.setState({ configLoaded: true, config: Object.assign(context.state.config, parsedResponse), path: parsedResponse.path })
+			context.setState({ configLoaded: true, config: Object.assign(context.state.config, parsedResponse), path: parsedResponse.path, csrfToken: parsedResponse.csrfToken || context.state.csrfToken })
 		  } else {
 			context.setState({ configLoaded: true, error: true })
 			console.log("error while fetching config");
@@ -173,7 +204,7 @@ export default class Panel extends Component {
 
 			c = c.filter(filter)
 			if (c.length != 0) {
-				return <Thread url={getUrl(this.state, window)} key={"___thread" + t.Id} thread={t} comments={c} reload={this.reload} updateComment={this.updateComment}/>
+				return <Thread url={getUrl(this.state, window)} key={"___thread" + t.Id} thread={t} comments={c} reload={this.reload} updateComment={this.updateComment} csrfToken={this.state.csrfToken}/>
 			}
 			return null;
 		})
@@ -185,6 +216,8 @@ export default class Panel extends Component {
 					<div class={this.state.showPending ? style.mouthful_buttonActive : style.mouthful_button} onClick={this.showPending}>Show unconfirmed</div>
 					<div class={this.state.showPending == false && this.state.showDeleted == false ? style.mouthful_buttonActive : style.mouthful_button} onClick={this.hidePending}>Show all</div>
 					<div class={this.state.showDeleted ? style.mouthful_buttonActive : style.mouthful_button  } onClick={this.showDeleted}>Show deleted</div>
+					{this.state.config.webauthn && webAuthnSupported() ? <div class={style.mouthful_button} onClick={this.addPasskey}>Add a passkey</div> : null}
+					<div class={style.mouthful_button} onClick={this.logout}>Log out</div>
 				</div>
 				<div>
 					{resultDiv}
@@ -1,23 +1,50 @@
 import { h, Component } from 'preact';
 import style from './style';
+import { loginWithPasskey, webAuthnSupported } from './webauthn';
 
 
 export default class Login extends Component {
 	constructor(props) {
         super(props);
-		this.state = { value: '' };
+		this.state = { value: '', code: '', twoFactorRequired: false };
         this.onLogin = props.onLogin;
         
 		this.handleChange = this.handleChange.bind(this);
 		this.handleSubmit = this.handleSubmit.bind(this);
+		this.handleCodeChange = this.handleCodeChange.bind(this);
+		this.handleCodeSubmit = this.handleCodeSubmit.bind(this);
 		this.handleOauthClick = this.handleOauthClick.bind(this);
+		this.handlePasskeyClick = this.handlePasskeyClick.bind(this);
+		this.handleProxyClick = this.handleProxyClick.bind(this);
 	}
 	handleOauthClick(provider) {
 		window.location.replace(this.props.url + "v1/oauth/auth/" + provider);
 	}
+	handlePasskeyClick() {
+		var context = this;
+		loginWithPasskey(this.props.url, function(csrfToken) {
+			if (csrfToken) {
+				context.onLogin(csrfToken);
+			}
+		});
+	}
+	handleProxyClick() {
+		var context = this;
+		var http = new XMLHttpRequest();
+		http.open("POST", this.props.url + "v1/admin/login/proxy", true);
+		http.onreadystatechange = function() {
+			if(http.readyState == 4 && http.status == 204) {
+				context.onLogin(http.getResponseHeader("X-CSRF-Token"));
+			}
+		}
+		http.send();
+	}
 	handleChange(event) {
 		this.setState({ value: event.target.value });
 	}
+	handleCodeChange(event) {
+		this.setState({ code: event.target.value });
+	}
 
 	handleSubmit(event) {
 		var context = this;
@@ -31,12 +58,46 @@ export default class Login extends Component {
 		http.setRequestHeader("Content-type", "application/json");
 		http.onreadystatechange = function() {//Call a function when the state changes.
 			if(http.readyState == 4 && http.status == 204) {
-                context.onLogin();
-			} 
+                context.onLogin(http.getResponseHeader("X-CSRF-Token"));
+			} else if(http.readyState == 4 && http.status == 200) {
+				// the password was right, but the account asks for a second factor as well
+				context.setState({ value: '', twoFactorRequired: JSON.parse(http.responseText).twoFactorRequired });
+			}
 		}
 		http.send(JSON.stringify({password: context.state.value}));
 	}
+	handleCodeSubmit(event) {
+		var context = this;
+
+		event.preventDefault();
+		var http = new XMLHttpRequest();
+		var url = this.props.url + "v1/admin/login/2fa";
+		http.open("POST", url, true);
+		http.setRequestHeader("Content-type", "application/json");
+		http.onreadystatechange = function() {
+			if(http.readyState == 4 && http.status == 204) {
+				context.onLogin(http.getResponseHeader("X-CSRF-Token"));
+			} else if(http.readyState == 4 && http.status == 401) {
+				context.setState({ code: '' });
+			}
+		}
+		// the six digit codes come from the authenticator app, anything else is taken for a recovery code
+		var code = context.state.code.replace(/\s/g, '');
+		var body = /^[0-9]{6}$/.test(code) ? {code: code} : {recoveryCode: code};
+		http.send(JSON.stringify(body));
+	}
 	render() {
+		if (this.state.twoFactorRequired) {
+			return (
+				<div class={style.mouthful_login}>
+					<form onSubmit={this.handleCodeSubmit}>
+					<label class={style.passwordTitle}>Authentication or recovery code:</label>
+					<input type="text" autocomplete="one-time-code" value={this.state.code} onChange={this.handleCodeChange} />
+					<input class={style.mouthful_submit}type="submit" value="Submit" />
+					</form>
+				</div>
+			);
+		}
 		var login = <form onSubmit={this.handleSubmit}>
 		<label class={style.passwordTitle}>Password:</label>
 		<input type="password" value={this.state.value} onChange={this.handleChange} />
@@ -46,8 +107,16 @@ export default class Login extends Component {
 		var providersListItems = this.props.config.oauthProviders && this.props.config.oauthProviders.length > 0 
 		? this.props.config.oauthProviders.map(x => <li class={style.mouthful_admin_li}><a class={style.mouthful_admin_oauth_a} onClick={() => this.handleOauthClick(x)}>Log in with {x}</a></li>)
 		: null
+		var passkeyListItem = this.props.config.webauthn && webAuthnSupported()
+		? <li class={style.mouthful_admin_li}><a class={style.mouthful_admin_oauth_a} onClick={this.handlePasskeyClick}>Log in with a passkey</a></li>
+		: null
+		var proxyListItem = this.props.config.proxyAuth
+		? <li class={style.mouthful_admin_li}><a class={style.mouthful_admin_oauth_a} onClick={this.handleProxyClick}>Log in with single sign-on</a></li>
+		: null
 		var oauthProviders = <div>
 			<ul>
+				{proxyListItem}
+				{passkeyListItem}
 				{providersListItems}
 			</ul>
 		</div>
@@ -36,6 +36,7 @@ export default class Thread extends Component {
 		var http = new XMLHttpRequest();
 		var url = this.props.url + "v1/admin/comments";
 		http.open("DELETE", url, true);
+		http.setRequestHeader("X-CSRF-Token", this.props.csrfToken);
 		var context = this;
 		http.onreadystatechange = function () {
 			if (http.readyState == 4 && http.status == 204) {
@@ -59,6 +60,7 @@ export default class Thread extends Component {
 		var http = new XMLHttpRequest();
 		var url = this.props.url + "v1/admin/comments/restore";
 		http.open("POST", url, true);
+		http.setRequestHeader("X-CSRF-Token", this.props.csrfToken);
 		var context = this;
 		http.onreadystatechange = function () {
 			if (http.readyState == 4 && http.status == 204) {
@@ -137,7 +139,11 @@ export default class Thread extends Component {
 			})
 		}
 		const fullThread = comments.length > 0 ? (<div class="thread">
-			<h2>{this.props.thread.Path}</h2>
+			<h2>{this.props.thread.Title ? this.props.thread.Title : this.props.thread.Path}</h2>
+			<div class={style.mouthful_thread_meta}>
+				{this.props.thread.URL ? <a href={this.props.thread.URL}>{this.props.thread.Path}</a> : this.props.thread.Path}
+				{" · " + this.props.thread.CommentCount + " comments · last activity " + formatDate(this.props.thread.LastActivity)}
+			</div>
 			<div class="comments">
 				{comments}
 			</div>
@@ -0,0 +1,87 @@
+// the webauthn options and responses travel as json, with the binary fields base64url encoded
+const toBuffer = (value) => {
+	var base64 = value.replace(/-/g, '+').replace(/_/g, '/');
+	var binary = atob(base64 + '==='.slice((base64.length + 3) % 4));
+	var bytes = new Uint8Array(binary.length);
+	for (var i = 0; i < binary.length; i++) {
+		bytes[i] = binary.charCodeAt(i);
+	}
+	return bytes.buffer;
+}
+
+const fromBuffer = (buffer) => {
+	var bytes = new Uint8Array(buffer);
+	var binary = '';
+	for (var i = 0; i < bytes.length; i++) {
+		binary += String.fromCharCode(bytes[i]);
+	}
+	return btoa(binary).replace(/\+/g, '-').replace(/\//g, '_').replace(/=+$/, '');
+}
+
+const post = (url, csrfToken, body, callback) => {
+	var http = new XMLHttpRequest();
+	http.open("POST", url, true);
+	http.setRequestHeader("Content-type", "application/json");
+	if (csrfToken) {
+		http.setRequestHeader("X-CSRF-Token", csrfToken);
+	}
+	http.onreadystatechange = function() {
+		if (http.readyState == 4) {
+			callback(http);
+		}
+	}
+	http.send(body ? JSON.stringify(body) : null);
+}
+
+export const webAuthnSupported = () => typeof window != "undefined" && window.PublicKeyCredential && navigator.credentials;
+
+// loginWithPasskey logs in with one of the passkeys of the admin, calling back with the csrf token of the new session, or nothing if it didn't work out
+export const loginWithPasskey = (url, callback) => {
+	post(url + "v1/admin/webauthn/login/begin", null, null, function(http) {
+		if (http.status != 200) {
+			return callback();
+		}
+		var options = JSON.parse(http.responseText);
+		options.challenge = toBuffer(options.challenge);
+		navigator.credentials.get({ publicKey: options }).then(function(credential) {
+			post(url + "v1/admin/webauthn/login/finish", null, {
+				id: credential.id,
+				type: credential.type,
+				response: {
+					clientDataJSON: fromBuffer(credential.response.clientDataJSON),
+					authenticatorData: fromBuffer(credential.response.authenticatorData),
+					signature: fromBuffer(credential.response.signature),
+					userHandle: credential.response.userHandle ? fromBuffer(credential.response.userHandle) : undefined
+				}
+			}, function(http) {
+				callback(http.status == 204 ? http.getResponseHeader("X-CSRF-Token") : undefined);
+			});
+		}).catch(function() { callback(); });
+	});
+}
+
+// registerPasskey registers a new passkey for the logged in admin, calling back with whether it worked out
+export const registerPasskey = (url, csrfToken, name, callback) => {
+	post(url + "v1/admin/webauthn/register/begin", csrfToken, null, function(http) {
+		if (http.status != 200) {
+			return callback(false);
+		}
+		var options = JSON.parse(http.responseText);
+		options.challenge = toBuffer(options.challenge);
+		options.user.id = toBuffer(options.user.id);
+		options.excludeCredentials = options.excludeCredentials.map(x => Object.assign(x, { id: toBuffer(x.id) }));
+		navigator.credentials.create({ publicKey: options }).then(function(credential) {
+			post(url + "v1/admin/webauthn/register/finish", csrfToken, {
+				name: name,
+				id: credential.id,
+				type: credential.type,
+				response: {
+					clientDataJSON: fromBuffer(credential.response.clientDataJSON),
+					attestationObject: fromBuffer(credential.response.attestationObject)
+				}
+			}, function(http) {
+				callback(http.status == 200);
+			});
+		}).catch(function() { callback(false); });
+	});
+}
@@ -0,0 +1,268 @@
+package api
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/vkuznecovas/mouthful/api/model"
+	dbModel "github.com/vkuznecovas/mouthful/db/model"
+	"github.com/vkuznecovas/mouthful/global"
+)
+
+// the scopes the api tokens can be given
+const (
+	// ScopeRead lets the token read the threads, comments, sites, sessions and the audit log
+	ScopeRead = "read"
+	// ScopeModerate lets the token change, delete, pin and feature the comments, and manage the threads
+	ScopeModerate = "moderate"
+	// ScopeSettings lets the token manage the sites and revoke the admin sessions
+	ScopeSettings = "settings"
+)
+
+// APITokenScopes lists the scopes the api tokens can be given
+var APITokenScopes = []string{ScopeRead, ScopeModerate, ScopeSettings}
+
+// SpoonProvider is the provider of the admins the api tokens created with spoon act as. They are admins for as long as the token exists
+const SpoonProvider = "spoon"
+
+// apiTokenPrefix starts every api token, so they are easy to tell apart from the other secrets, and to look for in the leaked ones
+const apiTokenPrefix = "mouthful_"
+
+// apiTokenBytes is the amount of random bytes in an api token
+const apiTokenBytes = 32
+
+// apiTokenContextKey is the key the api token of the request is kept under in the gin context, so it's only fetched once per request
+const apiTokenContextKey = "mouthful-api-token"
+
+// apiTokenRouteScopes maps the admin routes the api tokens can call to the scope they need. The rest, such as managing the tokens themselves, the second factors and the passkeys, are left to the logged in admins
+var apiTokenRouteScopes = map[string]string{
+	"GET /v1/admin/me":                  ScopeRead,
+	"GET /v1/admin/sessions":            ScopeRead,
+	"GET /v1/admin/threads":             ScopeRead,
+	"GET /v1/admin/threads/aliases":     ScopeRead,
+	"GET /v1/admin/audit":               ScopeRead,
+	"GET /v1/admin/comments/all":        ScopeRead,
+	"GET /v1/admin/sites":               ScopeRead,
+	"POST /v1/admin/comments":           ScopeModerate,
+	"PATCH /v1/admin/comments":          ScopeModerate,
+	"DELETE /v1/admin/comments":         ScopeModerate,
+	"POST /v1/admin/comments/restore":   ScopeModerate,
+	"POST /v1/admin/comments/pin":       ScopeModerate,
+	"POST /v1/admin/comments/unpin":     ScopeModerate,
+	"POST /v1/admin/comments/feature":   ScopeModerate,
+	"POST /v1/admin/comments/unfeature": ScopeModerate,
+	"POST /v1/admin/threads/lock":       ScopeModerate,
+	"POST /v1/admin/threads/unlock":     ScopeModerate,
+	"POST /v1/admin/threads/archive":    ScopeModerate,
+	"PATCH /v1/admin/threads":           ScopeModerate,
+	"DELETE /v1/admin/threads":          ScopeModerate,
+	"POST /v1/admin/threads/merge":      ScopeModerate,
+	"PUT /v1/admin/threads/metadata":    ScopeModerate,
+	"POST /v1/admin/threads/aliases":    ScopeModerate,
+	"DELETE /v1/admin/threads/aliases":  ScopeModerate,
+	"POST /v1/admin/sites":              ScopeSettings,
+	"PATCH /v1/admin/sites":             ScopeSettings,
+	"DELETE /v1/admin/sites":            ScopeSettings,
+	"DELETE /v1/admin/sessions":         ScopeSettings,
+}
+
+// APITokenId returns the id the api token is stored under, which is its hash
+func APITokenId(token string) string {
+	hash := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(hash[:])
+}
+
+// NewAPIToken generates an api token acting as the admin of owner with the given scopes, expiring after the given amount of days, or never for 0.
+// It returns the token to hand out, along with what is stored of it
+func NewAPIToken(owner dbModel.APIToken, scopes []string, expiresInDays int) (string, dbModel.APIToken, error) {
+	if len(owner.Name) > global.MaxAPITokenNameLength {
+		return "", owner, fmt.Errorf("The name of the token can't be longer than %v characters", global.MaxAPITokenNameLength)
+	}
+	if expiresInDays < 0 {
+		return "", owner, fmt.Errorf("The token can't expire in a negative amount of days")
+	}
+	given := make(map[string]bool)
+	for _, v := range scopes {
+		given[strings.TrimSpace(v)] = true
+	}
+	owner.Scopes = ""
+	for _, v := range APITokenScopes {
+		if given[v] {
+			delete(given, v)
+			if owner.Scopes != "" {
+				owner.Scopes += ","
+			}
+			owner.Scopes += v
+		}
+	}
+	if len(given) > 0 || owner.Scopes == "" {
+		return "", owner, fmt.Errorf("The token needs one or more of the scopes %v", strings.Join(APITokenScopes, ", "))
+	}
+	random := make([]byte, apiTokenBytes)
+	_, err := rand.Read(random)
+	if err != nil {
+		return "", owner, err
+	}
+	token := apiTokenPrefix + base64.RawURLEncoding.EncodeToString(random)
+	owner.Id = APITokenId(token)
+	owner.CreatedAt = time.Now().UTC()
+	owner.ExpiresAt = nil
+	owner.LastUsedAt = nil
+	if expiresInDays > 0 {
+		expiresAt := owner.CreatedAt.Add(time.Duration(expiresInDays) * 24 * time.Hour)
+		owner.ExpiresAt = &expiresAt
+	}
+	return token, owner, nil
+}
+
+// bearerToken returns the api token the request carries in the Authorization header, if any
+func bearerToken(c *gin.Context) string {
+	header := c.GetHeader("Authorization")
+	if len(header) < 7 || !strings.EqualFold(header[:7], "Bearer ") {
+		return ""
+	}
+	return strings.TrimSpace(header[7:])
+}
+
+// apiTokenIdentity returns the identity of the admin the api token acts as
+func apiTokenIdentity(token dbModel.APIToken) adminIdentity {
+	return adminIdentity{userId: token.UserId, name: token.UserName, provider: token.Provider, siteId: token.SiteId}
+}
+
+// requestAPIToken returns the api token of the request, or nil if it has none, or the token was revoked, has expired or its admin is no longer one
+func (r *Router) requestAPIToken(c *gin.Context) *dbModel.APIToken {
+	if cached, ok := c.Get(apiTokenContextKey); ok {
+		return cached.(*dbModel.APIToken)
+	}
+	var result *dbModel.APIToken
+	if value := bearerToken(c); value != "" {
+		db := *r.db
+		token, err := db.GetAPIToken(APITokenId(value))
+		now := time.Now().UTC()
+		if err != nil {
+			if err != global.ErrAPITokenNotFound {
+				log.Println(err)
+			}
+		} else if (token.ExpiresAt == nil || token.ExpiresAt.After(now)) && r.isStillAdmin(apiTokenIdentity(token)) {
+			result = &token
+			// a write per request would be a lot for the scripts going through the comments one by one, the minute is plenty
+			if token.LastUsedAt == nil || now.Sub(*token.LastUsedAt) > time.Minute {
+				token.LastUsedAt = &now
+				err = db.SaveAPIToken(token)
+				if err != nil {
+					log.Println(err)
+				}
+			}
+		}
+	}
+	c.Set(apiTokenContextKey, result)
+	return result
+}
+
+// isAPITokenAllowed checks that the api token of the request is valid and has the scope the route needs
+func (r *Router) isAPITokenAllowed(c *gin.Context) bool {
+	scope, ok := apiTokenRouteScopes[c.Request.Method+" "+c.FullPath()]
+	if !ok {
+		return false
+	}
+	token := r.requestAPIToken(c)
+	return token != nil && token.HasScope(scope)
+}
+
+// abortWithAPITokenError responds with 404 for unknown tokens and 500 for everything else
+func abortWithAPITokenError(c *gin.Context, err error) {
+	if err == global.ErrAPITokenNotFound {
+		c.AbortWithStatusJSON(404, err.Error())
+		return
+	}
+	log.Println(err)
+	c.AbortWithStatusJSON(500, global.ErrInternalServerError.Error())
+}
+
+// GetAPITokens returns the api tokens that have not expired yet. The admins of a single site only get the tokens of their site
+func (r *Router) GetAPITokens(c *gin.Context) {
+	if !r.isAdmin(c) {
+		c.AbortWithStatusJSON(401, global.ErrUnauthorized.Error())
+		return
+	}
+	tokens, err := (*r.db).GetAPITokens()
+	if err != nil {
+		abortWithAPITokenError(c, err)
+		return
+	}
+	now := time.Now()
+	result := make([]dbModel.APIToken, 0, len(tokens))
+	for _, v := range tokens {
+		if v.ExpiresAt != nil && v.ExpiresAt.Before(now) {
+			continue
+		}
+		if r.canManageSite(c, v.SiteId) {
+			result = append(result, v)
+		}
+	}
+	c.JSON(200, result)
+}
+
+// CreateAPIToken creates an api token acting as the logged in admin. The token is only shown in the response
+func (r *Router) CreateAPIToken(c *gin.Context) {
+	if !r.isAdmin(c) {
+		c.AbortWithStatusJSON(401, global.ErrUnauthorized.Error())
+		return
+	}
+	var body model.APITokenBody
+	err := c.BindJSON(&body)
+	if err != nil {
+		log.Println(err)
+		c.AbortWithStatusJSON(400, global.ErrBadRequest.Error())
+		return
+	}
+	identity := r.sessionAdminIdentity(c)
+	owner := dbModel.APIToken{Name: body.Name, UserId: identity.userId, UserName: identity.name, Provider: identity.provider, SiteId: identity.siteId}
+	value, token, err := NewAPIToken(owner, body.Scopes, body.ExpiresInDays)
+	if err != nil {
+		c.AbortWithStatusJSON(400, err.Error())
+		return
+	}
+	err = (*r.db).SaveAPIToken(token)
+	if err != nil {
+		abortWithAPITokenError(c, err)
+		return
+	}
+	r.audit(c, dbModel.AuditAPITokenCreate, token.Id, token.Provider+":"+token.UserId+" "+token.Name+" ("+token.Scopes+")", token.SiteId)
+	c.JSON(200, model.CreateAPITokenResponse{Token: value, APIToken: token})
+}
+
+// RevokeAPIToken deletes an api token. The admins of a single site can only revoke the tokens of their site
+func (r *Router) RevokeAPIToken(c *gin.Context) {
+	if !r.isAdmin(c) {
+		c.AbortWithStatusJSON(401, global.ErrUnauthorized.Error())
+		return
+	}
+	var body model.APITokenFlagBody
+	err := c.BindJSON(&body)
+	if err != nil || body.Id == "" {
+		c.AbortWithStatusJSON(400, global.ErrBadRequest.Error())
+		return
+	}
+	db := *r.db
+	token, err := db.GetAPIToken(body.Id)
+	if err == nil && !r.canManageSite(c, token.SiteId) {
+		err = global.ErrAPITokenNotFound
+	}
+	if err == nil {
+		err = db.DeleteAPIToken(body.Id)
+	}
+	if err != nil {
+		abortWithAPITokenError(c, err)
+		return
+	}
+	r.audit(c, dbModel.AuditAPITokenRevoke, token.Id, token.Provider+":"+token.UserId+" "+token.Name, token.SiteId)
+	c.AbortWithStatus(204)
+}
@@ -0,0 +1,89 @@
+package api
+
+import (
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gofrs/uuid"
+
+	dbModel "github.com/vkuznecovas/mouthful/db/model"
+	"github.com/vkuznecovas/mouthful/global"
+)
+
+// audit records the administrative action on the site by id, nil standing for the default site, in the audit log, along with the admin who performed it and the ip they came from.
+// The action has already been performed by then, so failures are only logged
+func (r *Router) audit(c *gin.Context, action string, subject string, details string, siteId *uuid.UUID) {
+	db := *r.db
+	err := db.CreateAuditEntry(action, subject, details, r.auditActor(c), c.ClientIP(), siteId)
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+// auditActor returns the admin performing the action as provider:userId, followed by the id of the api token when it's done with one.
+// It's empty for the requests of the clients that are not logged in, such as the failed logins
+func (r *Router) auditActor(c *gin.Context) string {
+	identity := r.sessionAdminIdentity(c)
+	actor := ""
+	if identity.userId != "" {
+		actor = identity.provider + ":" + identity.userId
+	}
+	if token := r.requestAPIToken(c); token != nil {
+		actor += " token:" + token.Id
+	}
+	return actor
+}
+
+// parseAuditQuery reads the limit, before and beforeId query parameters. The next page starts before the CreatedAt and Id of the last entry of the previous one
+func parseAuditQuery(c *gin.Context) (dbModel.AuditQuery, error) {
+	query := dbModel.AuditQuery{Limit: global.DefaultAuditLogPageSize}
+	if value := c.Query("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit < 1 || limit > global.MaxAuditLogPageSize {
+			return query, global.ErrBadRequest
+		}
+		query.Limit = limit
+	}
+	if value := c.Query("before"); value != "" {
+		before, err := time.Parse(time.RFC3339Nano, value)
+		if err != nil {
+			return query, global.ErrBadRequest
+		}
+		query.Before = &before
+	}
+	if value := c.Query("beforeId"); value != "" {
+		beforeId, err := uuid.FromString(value)
+		if err != nil || query.Before == nil {
+			return query, global.ErrBadRequest
+		}
+		query.BeforeId = &beforeId
+	}
+	return query, nil
+}
+
+// GetAuditLog returns a page of the audit log entries, newest first. The admins of a single site only get the entries of their site
+func (r *Router) GetAuditLog(c *gin.Context) {
+	if !r.isAdmin(c) {
+		c.AbortWithStatusJSON(401, global.ErrUnauthorized.Error())
+		return
+	}
+	query, err := parseAuditQuery(c)
+	if err != nil {
+		c.AbortWithStatusJSON(400, global.ErrBadRequest.Error())
+		return
+	}
+	query.SiteId = r.adminSiteId(c)
+	db := *r.db
+	entries, err := db.GetAuditEntries(query)
+	if err != nil {
+		log.Println(err)
+		c.AbortWithStatusJSON(500, global.ErrInternalServerError.Error())
+		return
+	}
+	if entries == nil {
+		entries = make([]dbModel.AuditEntry, 0)
+	}
+	c.JSON(200, entries)
+}
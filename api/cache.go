@@ -0,0 +1,163 @@
+package api
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"log"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gofrs/uuid"
+	dbModel "github.com/vkuznecovas/mouthful/db/model"
+	"github.com/vkuznecovas/mouthful/global"
+)
+
+// cacheEntry represents a serialized set of comments for a thread along with its etag.
+// There is no Last-Modified, as the edits, deletions, approvals and pins of the comments change the thread without leaving a time to compare with
+type cacheEntry struct {
+	Body     []byte
+	ETag     string
+	ThreadId string
+	// Path is the path of the thread the entry was resolved to, which differs from the requested one for aliases and thread keys
+	Path string
+	// Locked tells if the readers can no longer comment on the thread
+	Locked bool
+	// Gzip and Brotli hold the precompressed variants of Body, if compression is enabled
+	Gzip   []byte
+	Brotli []byte
+}
+
+// newCacheEntry creates a cache entry for the given serialized comments
+func newCacheEntry(body []byte, comments []dbModel.Comment, locked bool) *cacheEntry {
+	// the locked state is sent as a header, so it has to be a part of the etag as well
+	hash := sha256.New()
+	hash.Write(body)
+	if locked {
+		hash.Write([]byte("locked"))
+	}
+	sum := hash.Sum(nil)
+	entry := cacheEntry{
+		Body:   body,
+		ETag:   `"` + hex.EncodeToString(sum[:16]) + `"`,
+		Locked: locked,
+	}
+	if len(comments) > 0 {
+		entry.ThreadId = comments[0].ThreadId.String()
+	}
+	return &entry
+}
+
+// precompress stores the compressed variants of the body, so that serving the entry from cache does not compress it again
+func (entry *cacheEntry) precompress(compressor *Compressor) error {
+	var err error
+	entry.Gzip, err = compressor.Compress(entry.Body, EncodingGzip)
+	if err != nil {
+		return err
+	}
+	entry.Brotli, err = compressor.Compress(entry.Body, EncodingBrotli)
+	return err
+}
+
+// commentsCacheKey returns the cache key for the comments of the given thread fetched with the given query. The queries with a since are not cached
+func commentsCacheKey(path string, query dbModel.CommentQuery) string {
+	return path + "|" + string(query.Sort)
+}
+
+// invalidateThreadCache removes the cached comments of the thread found at path, for all the queries and all the aliases it was requested through
+func (r *Router) invalidateThreadCache(path string) {
+	if r.cache == nil {
+		return
+	}
+	prefix := path + "|"
+	for key, item := range r.cache.Items() {
+		entry, ok := item.Object.(*cacheEntry)
+		if strings.HasPrefix(key, prefix) || (ok && entry.Path == path) {
+			r.cache.Delete(key)
+		}
+	}
+}
+
+// invalidateCommentCache removes the cached comments of the thread by id, for the changes made to one of its comments
+func (r *Router) invalidateCommentCache(threadId uuid.UUID) {
+	if r.cache == nil {
+		return
+	}
+	thread, err := (*r.db).GetThreadById(threadId)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	r.invalidateThreadCache(thread.Path)
+}
+
+// setValidatorHeaders sets the caching and validation headers for the given entry
+func (r *Router) setValidatorHeaders(c *gin.Context, entry *cacheEntry) {
+	header := c.Writer.Header()
+	header.Set("X-Thread-Locked", strconv.FormatBool(entry.Locked))
+	header.Set("ETag", entry.ETag)
+	cacheControl := global.DefaultCacheControl
+	if r.config.API.CacheHeaders.CacheControl != nil {
+		cacheControl = *r.config.API.CacheHeaders.CacheControl
+	}
+	if cacheControl != "" {
+		header.Set("Cache-Control", cacheControl)
+	}
+	if r.config.API.CacheHeaders.SurrogateKeys && entry.ThreadId != "" {
+		header.Set("Surrogate-Key", global.DefaultSurrogateKey+" "+ThreadSurrogateKey(entry.ThreadId))
+	}
+}
+
+// ThreadSurrogateKey returns the surrogate key a CDN can use to purge the responses of a single thread
+func ThreadSurrogateKey(threadId string) string {
+	return global.DefaultSurrogateKey + "-thread-" + threadId
+}
+
+// isNotModified checks the If-None-Match header of the request against the entry.
+// The etags of the compressed variants match as well, as they carry the same comments
+func isNotModified(c *gin.Context, entry *cacheEntry) bool {
+	for _, v := range strings.Split(c.GetHeader("If-None-Match"), ",") {
+		v = strings.TrimSpace(v)
+		// If-None-Match uses the weak comparison, so a weakened version of our etag matches as well
+		v = strings.TrimPrefix(v, "W/")
+		if v == "*" || v == entry.ETag || v == encodedETag(entry.ETag, EncodingGzip) || v == encodedETag(entry.ETag, EncodingBrotli) {
+			return true
+		}
+	}
+	return false
+}
+
+// respondWithEntry sends the entry, or a 304 if the client already has it
+func (r *Router) respondWithEntry(c *gin.Context, entry *cacheEntry) {
+	r.setValidatorHeaders(c, entry)
+	encoding := ""
+	header := c.Writer.Header()
+	if r.compressor != nil {
+		addVaryAcceptEncoding(header)
+		encoding = NegotiateEncoding(c.GetHeader("Accept-Encoding"))
+	}
+	if isNotModified(c, entry) {
+		// the etag of the variant the client would have gotten, the compression middleware leaves the 304s alone
+		header.Set("ETag", encodedETag(entry.ETag, encoding))
+		c.Status(304)
+		return
+	}
+	// the compression middleware leaves responses that already have a Content-Encoding alone, so the precompressed variants set their etag themselves
+	switch encoding {
+	case EncodingBrotli:
+		if entry.Brotli != nil {
+			header.Set("Content-Encoding", EncodingBrotli)
+			header.Set("ETag", encodedETag(entry.ETag, EncodingBrotli))
+			c.Data(200, "application/json; charset=utf-8", entry.Brotli)
+			return
+		}
+	case EncodingGzip:
+		if entry.Gzip != nil {
+			header.Set("Content-Encoding", EncodingGzip)
+			header.Set("ETag", encodedETag(entry.ETag, EncodingGzip))
+			c.Data(200, "application/json; charset=utf-8", entry.Gzip)
+			return
+		}
+	}
+	c.Data(200, "application/json; charset=utf-8", entry.Body)
+}
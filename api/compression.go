@@ -0,0 +1,250 @@
+package api
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+	"github.com/gin-gonic/gin"
+	"github.com/vkuznecovas/mouthful/config/model"
+	"github.com/vkuznecovas/mouthful/global"
+)
+
+// EncodingGzip is the content encoding name for gzip
+const EncodingGzip = "gzip"
+
+// EncodingBrotli is the content encoding name for brotli
+const EncodingBrotli = "br"
+
+// compressibleContentTypes lists the content type prefixes worth compressing
+var compressibleContentTypes = []string{
+	"text/html",
+	"text/css",
+	"text/plain",
+	"text/javascript",
+	"application/javascript",
+	"application/json",
+	"application/ld+json",
+	"image/svg+xml",
+}
+
+// Compressor compresses data with the configured compression levels
+type Compressor struct {
+	gzipLevel     int
+	brotliQuality int
+}
+
+// NewCompressor creates a compressor from the given compression config, returning an error if the levels are out of range
+func NewCompressor(config model.Compression) (*Compressor, error) {
+	gzipLevel := global.DefaultGzipLevel
+	if config.GzipLevel != nil {
+		gzipLevel = *config.GzipLevel
+	}
+	if gzipLevel < gzip.HuffmanOnly || gzipLevel > gzip.BestCompression {
+		return nil, fmt.Errorf("config.API.Compression.GzipLevel must be between %v and %v", gzip.HuffmanOnly, gzip.BestCompression)
+	}
+	brotliQuality := global.DefaultBrotliQuality
+	if config.BrotliQuality != nil {
+		brotliQuality = *config.BrotliQuality
+	}
+	if brotliQuality < brotli.BestSpeed || brotliQuality > brotli.BestCompression {
+		return nil, fmt.Errorf("config.API.Compression.BrotliQuality must be between %v and %v", brotli.BestSpeed, brotli.BestCompression)
+	}
+	return &Compressor{
+		gzipLevel:     gzipLevel,
+		brotliQuality: brotliQuality,
+	}, nil
+}
+
+// newWriter returns a compressing writer for the given encoding
+func (cmp *Compressor) newWriter(w io.Writer, encoding string) (io.WriteCloser, error) {
+	if encoding == EncodingBrotli {
+		return brotli.NewWriterLevel(w, cmp.brotliQuality), nil
+	}
+	return gzip.NewWriterLevel(w, cmp.gzipLevel)
+}
+
+// Compress compresses the input with the given encoding
+func (cmp *Compressor) Compress(input []byte, encoding string) ([]byte, error) {
+	var buffer bytes.Buffer
+	w, err := cmp.newWriter(&buffer, encoding)
+	if err != nil {
+		return nil, err
+	}
+	_, err = w.Write(input)
+	if err != nil {
+		return nil, err
+	}
+	err = w.Close()
+	if err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+// NegotiateEncoding picks the preferred content encoding the client accepts, returning an empty string if it does not accept any we support
+func NegotiateEncoding(acceptEncoding string) string {
+	qualities := make(map[string]float64)
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		fields := strings.Split(part, ";")
+		name := strings.ToLower(strings.TrimSpace(fields[0]))
+		if name == "" {
+			continue
+		}
+		quality := 1.0
+		for _, param := range fields[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				q, err := strconv.ParseFloat(strings.TrimPrefix(param, "q="), 64)
+				if err == nil {
+					quality = q
+				}
+			}
+		}
+		qualities[name] = quality
+	}
+	best := ""
+	bestQuality := 0.0
+	// brotli goes first, so it wins ties
+	for _, encoding := range [...]string{EncodingBrotli, EncodingGzip} {
+		quality, ok := qualities[encoding]
+		if !ok {
+			quality, ok = qualities["*"]
+		}
+		if ok && quality > bestQuality {
+			best = encoding
+			bestQuality = quality
+		}
+	}
+	return best
+}
+
+// addVaryAcceptEncoding marks the response as depending on the Accept-Encoding header, unless it already is
+func addVaryAcceptEncoding(header http.Header) {
+	for _, v := range header["Vary"] {
+		for _, field := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(field), "Accept-Encoding") {
+				return
+			}
+		}
+	}
+	header.Add("Vary", "Accept-Encoding")
+}
+
+// encodedETag returns the etag of the response body compressed with the given encoding. The compressed bodies differ from the identity one, so a strong etag can't be shared with them
+func encodedETag(etag string, encoding string) string {
+	if encoding == "" || !strings.HasPrefix(etag, `"`) || !strings.HasSuffix(etag, `"`) || len(etag) < 2 {
+		return etag
+	}
+	return strings.TrimSuffix(etag, `"`) + "-" + encoding + `"`
+}
+
+func isCompressible(contentType string) bool {
+	contentType = strings.ToLower(contentType)
+	for _, v := range compressibleContentTypes {
+		if strings.HasPrefix(contentType, v) {
+			return true
+		}
+	}
+	return false
+}
+
+// compressWriter compresses the response on the fly if the response turns out to be compressible
+type compressWriter struct {
+	gin.ResponseWriter
+	compressor *Compressor
+	encoding   string
+	writer     io.WriteCloser
+	decided    bool
+}
+
+// decide determines if the response is going to be compressed. It has to be called before the headers are sent
+func (w *compressWriter) decide() {
+	if w.decided {
+		return
+	}
+	w.decided = true
+	header := w.ResponseWriter.Header()
+	if !isCompressible(header.Get("Content-Type")) {
+		return
+	}
+	addVaryAcceptEncoding(header)
+	status := w.ResponseWriter.Status()
+	// already encoded responses, such as precompressed cache entries, and partial responses are left as they are
+	if w.encoding == "" || header.Get("Content-Encoding") != "" || status == 206 || status == 204 || status == 304 {
+		return
+	}
+	writer, err := w.compressor.newWriter(w.ResponseWriter, w.encoding)
+	if err != nil {
+		return
+	}
+	header.Set("Content-Encoding", w.encoding)
+	header.Del("Content-Length")
+	if etag := header.Get("ETag"); etag != "" {
+		header.Set("ETag", encodedETag(etag, w.encoding))
+	}
+	w.writer = writer
+}
+
+func (w *compressWriter) Write(data []byte) (int, error) {
+	w.decide()
+	if w.writer != nil {
+		return w.writer.Write(data)
+	}
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *compressWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+func (w *compressWriter) WriteHeaderNow() {
+	w.decide()
+	w.ResponseWriter.WriteHeaderNow()
+}
+
+func (w *compressWriter) Flush() {
+	w.decide()
+	if flusher, ok := w.writer.(interface{ Flush() error }); ok {
+		flusher.Flush()
+	}
+	w.ResponseWriter.Flush()
+}
+
+// close finishes the compressed stream, if any
+func (w *compressWriter) close() {
+	if w.writer != nil {
+		w.writer.Close()
+	}
+}
+
+// SetCompressor sets the compressor used to precompress the cached responses
+func (r *Router) SetCompressor(compressor *Compressor) {
+	r.compressor = compressor
+}
+
+// Compression returns a middleware compressing the compressible responses with the encoding negotiated with the client
+func Compression(compressor *Compressor) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.Request.Method == "HEAD" || c.GetHeader("Range") != "" {
+			c.Next()
+			return
+		}
+		w := &compressWriter{
+			ResponseWriter: c.Writer,
+			compressor:     compressor,
+			encoding:       NegotiateEncoding(c.GetHeader("Accept-Encoding")),
+		}
+		c.Writer = w
+		defer func() {
+			w.close()
+			c.Writer = w.ResponseWriter
+		}()
+		c.Next()
+	}
+}
@@ -0,0 +1,90 @@
+package api_test
+
+import (
+	"compress/gzip"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gin-contrib/static"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/vkuznecovas/mouthful/api"
+	configModel "github.com/vkuznecovas/mouthful/config/model"
+)
+
+func TestNegotiateEncoding(t *testing.T) {
+	assert.Equal(t, "", api.NegotiateEncoding(""))
+	assert.Equal(t, "", api.NegotiateEncoding("identity"))
+	assert.Equal(t, "gzip", api.NegotiateEncoding("gzip, deflate"))
+	assert.Equal(t, "br", api.NegotiateEncoding("gzip, deflate, br"))
+	assert.Equal(t, "gzip", api.NegotiateEncoding("br;q=0.5, gzip;q=0.8"))
+	assert.Equal(t, "gzip", api.NegotiateEncoding("br;q=0, gzip"))
+	assert.Equal(t, "br", api.NegotiateEncoding("*"))
+	assert.Equal(t, "", api.NegotiateEncoding("gzip;q=0"))
+}
+
+func TestNewCompressorInvalidLevels(t *testing.T) {
+	level := 10
+	_, err := api.NewCompressor(configModel.Compression{Enabled: true, GzipLevel: &level})
+	assert.NotNil(t, err)
+	quality := 12
+	_, err = api.NewCompressor(configModel.Compression{Enabled: true, BrotliQuality: &quality})
+	assert.NotNil(t, err)
+	_, err = api.NewCompressor(configModel.Compression{Enabled: true})
+	assert.Nil(t, err)
+}
+
+func TestCompressionStaticFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mouthful-static")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	script := strings.Repeat("console.log('mouthful');\n", 100)
+	err = ioutil.WriteFile(filepath.Join(dir, "client.js"), []byte(script), 0644)
+	assert.Nil(t, err)
+	err = ioutil.WriteFile(filepath.Join(dir, "image.png"), []byte("not really a png"), 0644)
+	assert.Nil(t, err)
+
+	compressor, err := api.NewCompressor(configModel.Compression{Enabled: true})
+	assert.Nil(t, err)
+	gin.SetMode(gin.ReleaseMode)
+	server := gin.New()
+	server.Use(api.Compression(compressor))
+	server.Use(static.Serve("/", static.LocalFile(dir, false)))
+
+	req := httptest.NewRequest("GET", "/client.js", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	w := httptest.NewRecorder()
+	server.ServeHTTP(w, req)
+	assert.Equal(t, 200, w.Code)
+	assert.Equal(t, "gzip", w.Header().Get("Content-Encoding"))
+	assert.Equal(t, "", w.Header().Get("Content-Length"))
+	reader, err := gzip.NewReader(w.Body)
+	assert.Nil(t, err)
+	body, err := ioutil.ReadAll(reader)
+	assert.Nil(t, err)
+	assert.Equal(t, script, string(body))
+
+	// partial content is served as is
+	req = httptest.NewRequest("GET", "/client.js", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	req.Header.Set("Range", "bytes=0-9")
+	w = httptest.NewRecorder()
+	server.ServeHTTP(w, req)
+	assert.Equal(t, 206, w.Code)
+	assert.Equal(t, "", w.Header().Get("Content-Encoding"))
+	assert.Equal(t, script[:10], w.Body.String())
+
+	// images are not worth compressing
+	req = httptest.NewRequest("GET", "/image.png", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	w = httptest.NewRecorder()
+	server.ServeHTTP(w, req)
+	assert.Equal(t, 200, w.Code)
+	assert.Equal(t, "", w.Header().Get("Content-Encoding"))
+	assert.Equal(t, "not really a png", w.Body.String())
+}
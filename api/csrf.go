@@ -0,0 +1,132 @@
+package api
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+	"github.com/vkuznecovas/mouthful/config/model"
+	"github.com/vkuznecovas/mouthful/global"
+)
+
+// CSRFHeader is the header the csrf token of the session has to be sent in with the state-changing admin requests
+const CSRFHeader = "X-CSRF-Token"
+
+// csrfTokenBytes is the amount of random bytes in a csrf token
+const csrfTokenBytes = 32
+
+// SessionOptions returns the options of the admin session cookie, or an error if the configured SameSite mode is unknown or unsafe
+func SessionOptions(moderation model.Moderation) (sessions.Options, error) {
+	options := sessions.Options{
+		MaxAge:   moderation.SessionDurationSeconds,
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   moderation.SessionCookie.Secure,
+	}
+	switch strings.ToLower(moderation.SessionCookie.SameSite) {
+	case "", "lax":
+		options.SameSite = http.SameSiteLaxMode
+	case "strict":
+		options.SameSite = http.SameSiteStrictMode
+	case "none":
+		// browsers drop SameSite=None cookies that are not secure
+		if !moderation.SessionCookie.Secure {
+			return options, fmt.Errorf("config.Moderation.SessionCookie.SameSite can only be none if config.Moderation.SessionCookie.Secure is set")
+		}
+		options.SameSite = http.SameSiteNoneMode
+	default:
+		return options, fmt.Errorf("config.Moderation.SessionCookie.SameSite has to be one of lax, strict or none, got %v", moderation.SessionCookie.SameSite)
+	}
+	return options, nil
+}
+
+// newCSRFToken generates a random csrf token
+func newCSRFToken() (string, error) {
+	token := make([]byte, csrfTokenBytes)
+	_, err := rand.Read(token)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(token), nil
+}
+
+// csrfToken returns the csrf token of the session, generating one if the session has none yet, like the sessions started before the tokens were introduced
+func (r *Router) csrfToken(c *gin.Context) (string, error) {
+	session := sessions.Default(c)
+	if token, ok := session.Get("csrfToken").(string); ok && token != "" {
+		return token, nil
+	}
+	token, err := newCSRFToken()
+	if err != nil {
+		return "", err
+	}
+	session.Set("csrfToken", token)
+	return token, session.Save()
+}
+
+// isAdminOrigin checks if the request could have come from the admin panel. That is the case for requests from the host mouthful is reached on, the oauth callback origin and the configured admin origins.
+// Requests with neither an Origin nor a Referer header don't come from a browser, so there is nothing to check
+func (r *Router) isAdminOrigin(c *gin.Context) bool {
+	origin := c.GetHeader("Origin")
+	if origin == "" {
+		origin = c.Request.Referer()
+		if origin == "" {
+			return true
+		}
+	}
+	return r.isAllowedAdminOrigin(origin, c.Request.Host)
+}
+
+// isAllowedAdminOrigin checks if the admin panel may be served from the origin, given the host mouthful is reached on
+func (r *Router) isAllowedAdminOrigin(origin string, host string) bool {
+	origin = NormalizeOrigin(origin)
+	if origin == "" {
+		return false
+	}
+	if strings.EqualFold(origin[strings.Index(origin, "://")+3:], host) {
+		return true
+	}
+	if r.config.Moderation.OAuthCallbackOrigin != nil && origin == NormalizeOrigin(*r.config.Moderation.OAuthCallbackOrigin) {
+		return true
+	}
+	if r.config.Moderation.AdminOrigins != nil {
+		for _, v := range *r.config.Moderation.AdminOrigins {
+			if origin == NormalizeOrigin(v) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// CSRFProtection guards the state-changing admin routes against cross-site request forgery.
+// The requests have to come from the admin panel and, if they are made on behalf of a logged in admin, carry the csrf token of the session in the X-CSRF-Token header.
+// Requests without a logged in admin are left for the handlers to turn away
+func (r *Router) CSRFProtection(c *gin.Context) {
+	switch c.Request.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		c.Next()
+		return
+	}
+	if !r.isAdminOrigin(c) {
+		c.AbortWithStatusJSON(403, global.ErrInvalidOrigin.Error())
+		return
+	}
+	// the browsers don't send the api tokens along on their own, so the requests carrying one can't be forged
+	if !r.isAdmin(c) || bearerToken(c) != "" {
+		c.Next()
+		return
+	}
+	token, ok := sessions.Default(c).Get("csrfToken").(string)
+	header := c.GetHeader(CSRFHeader)
+	if !ok || token == "" || subtle.ConstantTimeCompare([]byte(token), []byte(header)) != 1 {
+		c.AbortWithStatusJSON(403, global.ErrInvalidCSRFToken.Error())
+		return
+	}
+	c.Next()
+}
@@ -0,0 +1,58 @@
+package api
+
+import (
+	"sort"
+	"sync"
+)
+
+// keyedLocks serializes the operations by the ids they work on, so that a check and the change depending on it can't interleave with the ones on the same ids
+type keyedLocks struct {
+	mutex sync.Mutex
+	locks map[string]*keyedLock
+}
+
+// keyedLock is the lock of a single id, kept around as long as anything holds or waits for it
+type keyedLock struct {
+	mutex sync.Mutex
+	users int
+}
+
+// lock locks the given ids until the returned function is called. The ids are locked in order, so that the operations sharing some of them can't deadlock
+func (kl *keyedLocks) lock(ids []string) func() {
+	sorted := append([]string(nil), ids...)
+	sort.Strings(sorted)
+	keys := make([]string, 0, len(sorted))
+	locks := make([]*keyedLock, 0, len(sorted))
+	kl.mutex.Lock()
+	if kl.locks == nil {
+		kl.locks = make(map[string]*keyedLock)
+	}
+	for i, id := range sorted {
+		if i > 0 && id == sorted[i-1] {
+			continue
+		}
+		lock, ok := kl.locks[id]
+		if !ok {
+			lock = &keyedLock{}
+			kl.locks[id] = lock
+		}
+		lock.users++
+		keys = append(keys, id)
+		locks = append(locks, lock)
+	}
+	kl.mutex.Unlock()
+	for _, lock := range locks {
+		lock.mutex.Lock()
+	}
+	return func() {
+		kl.mutex.Lock()
+		defer kl.mutex.Unlock()
+		for i, lock := range locks {
+			lock.mutex.Unlock()
+			lock.users--
+			if lock.users == 0 {
+				delete(kl.locks, keys[i])
+			}
+		}
+	}
+}
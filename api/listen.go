@@ -0,0 +1,193 @@
+package api
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+
+	"github.com/vkuznecovas/mouthful/config/model"
+	"github.com/vkuznecovas/mouthful/global"
+)
+
+// systemdFirstFd is the first file descriptor systemd passes the sockets on
+const systemdFirstFd = 3
+
+// Listen returns the listeners the api is served on. When mouthful is socket activated by systemd, those are the sockets systemd passed.
+// Otherwise it's a single listener on the bind address and port, or on the unix socket if the bind address is unix:/path/to.sock
+func Listen(config model.API) ([]net.Listener, error) {
+	listeners, err := systemdListeners()
+	if err != nil || len(listeners) > 0 {
+		return listeners, err
+	}
+	address := ListenAddress(config)
+	if strings.HasPrefix(address, global.UnixSocketPrefix) {
+		listener, err := listenUnix(strings.TrimPrefix(address, global.UnixSocketPrefix), config.UnixSocket)
+		if err != nil {
+			return nil, err
+		}
+		return []net.Listener{listener}, nil
+	}
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, err
+	}
+	return []net.Listener{listener}, nil
+}
+
+// ListenAddress returns the address the api listens on, unless it's socket activated
+func ListenAddress(config model.API) string {
+	bindAddress := global.DefaultBindAddress
+	if config.BindAddress != nil {
+		bindAddress = *config.BindAddress
+	}
+	if strings.HasPrefix(bindAddress, global.UnixSocketPrefix) {
+		return bindAddress
+	}
+	port := global.DefaultPort
+	if config.Port != nil {
+		port = *config.Port
+	}
+	return fmt.Sprintf("%v:%v", bindAddress, port)
+}
+
+// systemdListeners returns the sockets passed by systemd, or none if mouthful was not socket activated.
+// The environment variables are cleared, so that they are not passed on to the child processes
+func systemdListeners() ([]net.Listener, error) {
+	pid := os.Getenv("LISTEN_PID")
+	if pid == "" {
+		return nil, nil
+	}
+	fds := os.Getenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+	if pid != strconv.Itoa(os.Getpid()) {
+		// meant for another process
+		return nil, nil
+	}
+	count, err := strconv.Atoi(fds)
+	if err != nil || count < 0 {
+		return nil, fmt.Errorf("LISTEN_FDS is not a valid amount of sockets: %v", fds)
+	}
+	listeners := make([]net.Listener, 0, count)
+	for i := 0; i < count; i++ {
+		fd := systemdFirstFd + i
+		file := os.NewFile(uintptr(fd), "LISTEN_FD_"+strconv.Itoa(fd))
+		// the listener works on a copy of the descriptor
+		listener, err := net.FileListener(file)
+		file.Close()
+		if err != nil {
+			for _, v := range listeners {
+				v.Close()
+			}
+			return nil, fmt.Errorf("Couldn't listen on the socket %v passed by systemd: %v", fd, err)
+		}
+		if listener.Addr().Network() == "unix" {
+			listener = unixListener{listener}
+		}
+		listeners = append(listeners, listener)
+	}
+	return listeners, nil
+}
+
+// unixListener reports the peers of the unix socket as the loopback address. Without an ip, they could not be told apart by the forwarded headers of the proxy in front, unlike the tcp ones
+type unixListener struct {
+	net.Listener
+}
+
+// Accept waits for the next connection on the unix socket
+func (l unixListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return unixConn{conn}, nil
+}
+
+// unixConn is a connection accepted on the unix socket
+type unixConn struct {
+	net.Conn
+}
+
+// RemoteAddr returns the loopback address, as the peer is on the same machine
+func (c unixConn) RemoteAddr() net.Addr {
+	return &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)}
+}
+
+// listenUnix listens on the unix socket at path with the configured mode and owner. A socket left behind by a previous run is removed first
+func listenUnix(path string, config model.UnixSocket) (net.Listener, error) {
+	if path == "" {
+		return nil, fmt.Errorf("config.API.BindAddress needs the path of the unix socket after %v", global.UnixSocketPrefix)
+	}
+	modeString := global.DefaultUnixSocketMode
+	if config.Mode != nil {
+		modeString = *config.Mode
+	}
+	mode, err := strconv.ParseUint(modeString, 8, 32)
+	if err != nil || mode > 0777 {
+		return nil, fmt.Errorf("config.API.UnixSocket.Mode is not a valid octal file mode: %v", modeString)
+	}
+	uid, gid, err := socketOwner(config)
+	if err != nil {
+		return nil, err
+	}
+	if info, err := os.Lstat(path); err == nil {
+		if info.Mode()&os.ModeSocket == 0 {
+			return nil, fmt.Errorf("Couldn't listen on %v, the file exists and is not a socket", path)
+		}
+		err = os.Remove(path)
+		if err != nil {
+			return nil, err
+		}
+	}
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	err = os.Chmod(path, os.FileMode(mode))
+	if err == nil && (uid != -1 || gid != -1) {
+		err = os.Lchown(path, uid, gid)
+	}
+	if err != nil {
+		listener.Close()
+		return nil, fmt.Errorf("Couldn't set the permissions of the unix socket %v: %v", path, err)
+	}
+	return unixListener{listener}, nil
+}
+
+// socketOwner returns the ids of the configured owner and group of the unix socket, which can be given as names or ids, or -1 for the ones left as is
+func socketOwner(config model.UnixSocket) (int, int, error) {
+	uid, gid := -1, -1
+	if config.Owner != nil && *config.Owner != "" {
+		id, err := strconv.Atoi(*config.Owner)
+		if err != nil {
+			owner, err := user.Lookup(*config.Owner)
+			if err != nil {
+				return uid, gid, fmt.Errorf("config.API.UnixSocket.Owner is not a known user: %v", err)
+			}
+			id, err = strconv.Atoi(owner.Uid)
+			if err != nil {
+				return uid, gid, fmt.Errorf("config.API.UnixSocket.Owner has no numeric id: %v", owner.Uid)
+			}
+		}
+		uid = id
+	}
+	if config.Group != nil && *config.Group != "" {
+		id, err := strconv.Atoi(*config.Group)
+		if err != nil {
+			group, err := user.LookupGroup(*config.Group)
+			if err != nil {
+				return uid, gid, fmt.Errorf("config.API.UnixSocket.Group is not a known group: %v", err)
+			}
+			id, err = strconv.Atoi(group.Gid)
+			if err != nil {
+				return uid, gid, fmt.Errorf("config.API.UnixSocket.Group has no numeric id: %v", group.Gid)
+			}
+		}
+		gid = id
+	}
+	return uid, gid, nil
+}
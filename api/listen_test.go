@@ -0,0 +1,112 @@
+package api_test
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/vkuznecovas/mouthful/api"
+	configModel "github.com/vkuznecovas/mouthful/config/model"
+)
+
+func TestListenAddress(t *testing.T) {
+	assert.Equal(t, "0.0.0.0:8080", api.ListenAddress(configModel.API{}))
+	bindAddress := "127.0.0.1"
+	port := 1234
+	assert.Equal(t, "127.0.0.1:1234", api.ListenAddress(configModel.API{BindAddress: &bindAddress, Port: &port}))
+	bindAddress = "unix:/run/mouthful.sock"
+	assert.Equal(t, "unix:/run/mouthful.sock", api.ListenAddress(configModel.API{BindAddress: &bindAddress, Port: &port}))
+}
+
+func TestListenUnixSocket(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mouthful-socket")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "mouthful.sock")
+	bindAddress := "unix:" + path
+	mode := "0640"
+	config := configModel.API{BindAddress: &bindAddress, UnixSocket: configModel.UnixSocket{Mode: &mode}}
+
+	// a socket left behind is replaced
+	stale, err := net.Listen("unix", path)
+	assert.Nil(t, err)
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+
+	listeners, err := api.Listen(config)
+	if !assert.Nil(t, err) || !assert.Len(t, listeners, 1) {
+		return
+	}
+	info, err := os.Stat(path)
+	assert.Nil(t, err)
+	assert.Equal(t, os.FileMode(0640), info.Mode().Perm())
+
+	remoteAddr := make(chan string, 1)
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		remoteAddr <- r.RemoteAddr
+	})}
+	go server.Serve(listeners[0])
+	client := http.Client{Transport: &http.Transport{DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+		return net.Dial("unix", path)
+	}}}
+	response, err := client.Get("http://mouthful/status")
+	if assert.Nil(t, err) {
+		response.Body.Close()
+		// the peers of the socket are seen as the loopback address, so the forwarded headers are used
+		ip, _, err := net.SplitHostPort(<-remoteAddr)
+		assert.Nil(t, err)
+		assert.Equal(t, "127.0.0.1", ip)
+	}
+	server.Close()
+	_, err = os.Stat(path)
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestListenUnixSocketInvalid(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mouthful-socket")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "mouthful.sock")
+	bindAddress := "unix:" + path
+	mode := "0999"
+	_, err = api.Listen(configModel.API{BindAddress: &bindAddress, UnixSocket: configModel.UnixSocket{Mode: &mode}})
+	assert.NotNil(t, err)
+	owner := "no-such-user-of-mouthful"
+	_, err = api.Listen(configModel.API{BindAddress: &bindAddress, UnixSocket: configModel.UnixSocket{Owner: &owner}})
+	assert.NotNil(t, err)
+	empty := "unix:"
+	_, err = api.Listen(configModel.API{BindAddress: &empty})
+	assert.NotNil(t, err)
+
+	// files that are not sockets are left alone
+	err = ioutil.WriteFile(path, []byte("data"), 0644)
+	assert.Nil(t, err)
+	_, err = api.Listen(configModel.API{BindAddress: &bindAddress})
+	assert.NotNil(t, err)
+	contents, err := ioutil.ReadFile(path)
+	assert.Nil(t, err)
+	assert.Equal(t, "data", string(contents))
+}
+
+func TestListenIgnoresActivationOfOtherProcesses(t *testing.T) {
+	os.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()+1))
+	os.Setenv("LISTEN_FDS", "1")
+	defer os.Unsetenv("LISTEN_PID")
+	defer os.Unsetenv("LISTEN_FDS")
+	bindAddress := "127.0.0.1"
+	port := 0
+	listeners, err := api.Listen(configModel.API{BindAddress: &bindAddress, Port: &port})
+	if assert.Nil(t, err) && assert.Len(t, listeners, 1) {
+		assert.Equal(t, "tcp", listeners[0].Addr().Network())
+		listeners[0].Close()
+	}
+	assert.Equal(t, "", os.Getenv("LISTEN_PID"))
+	assert.Equal(t, "", os.Getenv("LISTEN_FDS"))
+}
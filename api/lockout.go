@@ -0,0 +1,159 @@
+package api
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"log"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	dbModel "github.com/vkuznecovas/mouthful/db/model"
+	"github.com/vkuznecovas/mouthful/global"
+)
+
+// LoginFailureIPId returns the id the failed admin logins of the client with the given ip are recorded under
+func LoginFailureIPId(ip string) string {
+	return "ip:" + ip
+}
+
+// LoginFailureAccountId returns the id the failed admin logins of the given account are recorded under.
+// The account is admin for the admin password, and site: followed by the key of the site for the site admin passwords
+func LoginFailureAccountId(account string) string {
+	return "account:" + account
+}
+
+// passwordAccount returns the account the password login to the site by key logs in to, the empty key standing for the default site
+func passwordAccount(site string) string {
+	if site == "" {
+		return PasswordUserId
+	}
+	return "site:" + site
+}
+
+// passwordsEqual compares the given password with the expected one in constant time, so the response times don't tell how much of it was right.
+// An empty expected password never matches
+func passwordsEqual(password string, expected string) bool {
+	if expected == "" {
+		return false
+	}
+	// hashing both first keeps the comparison from returning early on a length mismatch
+	passwordHash := sha256.Sum256([]byte(password))
+	expectedHash := sha256.Sum256([]byte(expected))
+	return subtle.ConstantTimeCompare(passwordHash[:], expectedHash[:]) == 1
+}
+
+// loginThrottling holds the effective login throttling settings
+type loginThrottling struct {
+	baseDelay   time.Duration
+	maxDelay    time.Duration
+	maxFailures int
+	lockout     time.Duration
+}
+
+// loginThrottling returns the login throttling settings, falling back to the defaults for the ones not configured
+func (r *Router) loginThrottling() loginThrottling {
+	config := r.config.Moderation.LoginThrottling
+	orDefault := func(value *int, defaultValue int) int {
+		if value == nil {
+			return defaultValue
+		}
+		return *value
+	}
+	return loginThrottling{
+		baseDelay:   time.Duration(orDefault(config.BaseDelaySeconds, global.DefaultLoginBaseDelaySeconds)) * time.Second,
+		maxDelay:    time.Duration(orDefault(config.MaxDelaySeconds, global.DefaultLoginMaxDelaySeconds)) * time.Second,
+		maxFailures: orDefault(config.MaxFailures, global.DefaultLoginMaxFailures),
+		lockout:     time.Duration(orDefault(config.LockoutSeconds, global.DefaultLoginLockoutSeconds)) * time.Second,
+	}
+}
+
+// delay returns how long the logins get blocked for after the given amount of failures in a row, and whether that is a lockout
+func (lt loginThrottling) delay(failures int) (time.Duration, bool) {
+	if lt.maxFailures > 0 && failures >= lt.maxFailures {
+		return lt.lockout, true
+	}
+	delay := lt.baseDelay
+	for i := 1; i < failures && delay < lt.maxDelay; i++ {
+		delay *= 2
+	}
+	if delay > lt.maxDelay {
+		delay = lt.maxDelay
+	}
+	return delay, false
+}
+
+// loginBlockedFor returns how long the logins of the clients and accounts by id are still blocked for, 0 if none of them is
+func (r *Router) loginBlockedFor(ids []string) time.Duration {
+	db := *r.db
+	now := time.Now()
+	var result time.Duration
+	for _, id := range ids {
+		failure, err := db.GetLoginFailure(id)
+		if err != nil {
+			if err != global.ErrLoginFailureNotFound {
+				log.Println(err)
+			}
+			continue
+		}
+		if wait := failure.BlockedUntil.Sub(now); wait > result {
+			result = wait
+		}
+	}
+	return result
+}
+
+// recordLoginFailure records a failed login for the clients and accounts by id, blocking their logins for a while.
+// It returns the longest of the blocks, and whether any of them is a lockout
+func (r *Router) recordLoginFailure(ids []string) (time.Duration, bool) {
+	db := *r.db
+	throttling := r.loginThrottling()
+	now := time.Now().UTC()
+	var longest time.Duration
+	lockedOut := false
+	for _, id := range ids {
+		failure, err := db.GetLoginFailure(id)
+		if err != nil && err != global.ErrLoginFailureNotFound {
+			log.Println(err)
+			continue
+		}
+		// only the failures in a row count, so they are forgotten once there's been none for as long as a lockout lasts
+		if err != nil || (throttling.lockout > 0 && now.Sub(failure.LastFailureAt) > throttling.lockout) {
+			failure = dbModel.LoginFailure{Id: id}
+		}
+		failure.Failures++
+		failure.LastFailureAt = now
+		delay, lockout := throttling.delay(failure.Failures)
+		if blockedUntil := now.Add(delay); blockedUntil.After(failure.BlockedUntil) {
+			failure.BlockedUntil = blockedUntil
+		}
+		err = db.SaveLoginFailure(failure)
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+		if delay > longest {
+			longest = delay
+		}
+		lockedOut = lockedOut || lockout
+	}
+	return longest, lockedOut
+}
+
+// clearLoginFailures forgets the failed logins of the clients and accounts by id after a successful login
+func (r *Router) clearLoginFailures(ids []string) {
+	db := *r.db
+	for _, id := range ids {
+		err := db.DeleteLoginFailure(id)
+		if err != nil && err != global.ErrLoginFailureNotFound {
+			log.Println(err)
+		}
+	}
+}
+
+// abortWithTooManyLoginAttempts turns the login away, telling the client how long to wait in the Retry-After header
+func abortWithTooManyLoginAttempts(c *gin.Context, wait time.Duration) {
+	c.Header("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	c.AbortWithStatusJSON(429, global.ErrTooManyLoginAttempts.Error())
+}
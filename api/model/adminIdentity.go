@@ -0,0 +1,16 @@
+package model
+
+import "time"
+
+// AdminIdentity is a struct that represents the logged in admin, along with the site the admin is limited to and the server-side session or the api token, if any
+type AdminIdentity struct {
+	UserId    string     `json:"userId"`
+	Name      string     `json:"name,omitempty"`
+	Provider  string     `json:"provider"`
+	Site      string     `json:"site,omitempty"`
+	SiteId    string     `json:"siteId,omitempty"`
+	SessionId string     `json:"sessionId,omitempty"`
+	TokenId   string     `json:"tokenId,omitempty"`
+	Scopes    []string   `json:"scopes,omitempty"`
+	ExpiresAt *time.Time `json:"expiresAt,omitempty"`
+}
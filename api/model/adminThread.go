@@ -0,0 +1,15 @@
+package model
+
+import (
+	"time"
+
+	dbModel "github.com/vkuznecovas/mouthful/db/model"
+)
+
+// AdminThread is a thread as listed on the admin panel, along with its activity.
+// CommentCount includes the pending comments, but not the deleted ones
+type AdminThread struct {
+	dbModel.Thread
+	CommentCount int       `json:"CommentCount"`
+	LastActivity time.Time `json:"LastActivity"`
+}
@@ -0,0 +1,8 @@
+package model
+
+// APITokenBody is a struct that represents a request to create an api token. Tokens without ExpiresInDays never expire
+type APITokenBody struct {
+	Name          string   `json:"name"`
+	Scopes        []string `json:"scopes"`
+	ExpiresInDays int      `json:"expiresInDays,omitempty"`
+}
@@ -0,0 +1,6 @@
+package model
+
+// APITokenFlagBody is a struct that represents a request to revoke an api token
+type APITokenFlagBody struct {
+	Id string `json:"id"`
+}
@@ -0,0 +1,6 @@
+package model
+
+// CommentFlagBody is a struct that represents a request to pin, unpin, feature or unfeature a comment
+type CommentFlagBody struct {
+	CommentId string `json:"commentId"`
+}
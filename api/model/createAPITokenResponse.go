@@ -0,0 +1,9 @@
+package model
+
+import dbModel "github.com/vkuznecovas/mouthful/db/model"
+
+// CreateAPITokenResponse is the api token just created, along with the token itself, which is not shown again
+type CreateAPITokenResponse struct {
+	Token string `json:"token"`
+	dbModel.APIToken
+}
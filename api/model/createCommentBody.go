@@ -3,9 +3,16 @@ package model
 
 // CreateCommentBody is a struct that represents a create comment request
 type CreateCommentBody struct {
-	Path    string  `json:"path"`
-	Body    string  `json:"body"`
-	Author  string  `json:"author"`
-	Email   *string `json:"email,omitempty"`
-	ReplyTo *string `json:"replyTo,omitempty"`
+	Path string `json:"path" form:"path"`
+	// Thread is an optional explicit thread key, it takes precedence over the path when identifying the thread
+	Thread *string `json:"thread,omitempty" form:"thread"`
+	// Site is an optional site key, without it the site is found by the origin of the request
+	Site    *string `json:"site,omitempty" form:"site"`
+	Body    string  `json:"body" form:"body"`
+	Author  string  `json:"author" form:"author"`
+	Email   *string `json:"email,omitempty" form:"email"`
+	ReplyTo *string `json:"replyTo,omitempty" form:"replyTo"`
+	// Title and URL describe the page the comment was posted on, they are stored on the thread if it has none yet
+	Title *string `json:"title,omitempty" form:"title"`
+	URL   *string `json:"url,omitempty" form:"url"`
 }
@@ -4,8 +4,12 @@ package model
 type CreateCommentResponse struct {
 	Id      string  `json:"id"`
 	Path    string  `json:"path"`
+	Site    string  `json:"site,omitempty"`
 	Body    string  `json:"body"`
 	Author  string  `json:"author"`
 	Email   *string `json:"email,omitempty"`
 	ReplyTo *string `json:"replyTo,omitempty"`
+	Staff   bool    `json:"staff,omitempty"`
+	Title   string  `json:"title,omitempty"`
+	URL     string  `json:"url,omitempty"`
 }
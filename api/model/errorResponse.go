@@ -0,0 +1,13 @@
+package model
+
+// ErrorCodeOriginNotAllowed is the code of the comments rejected for the origin they were posted from
+const ErrorCodeOriginNotAllowed = "origin_not_allowed"
+
+// ErrorCodePathNotAllowed is the code of the comments rejected for the path of the thread they would create
+const ErrorCodePathNotAllowed = "path_not_allowed"
+
+// ErrorResponse is a struct that represents a rejected request, along with a code telling the reason
+type ErrorResponse struct {
+	Error string `json:"error"`
+	Code  string `json:"code"`
+}
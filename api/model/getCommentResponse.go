@@ -0,0 +1,12 @@
+package model
+
+import dbModel "github.com/vkuznecovas/mouthful/db/model"
+
+// GetCommentResponse is a struct that represents a single comment along with its parent, direct replies and the path and site of its thread
+type GetCommentResponse struct {
+	Path    string            `json:"path"`
+	Site    string            `json:"site,omitempty"`
+	Comment dbModel.Comment   `json:"comment"`
+	Parent  *dbModel.Comment  `json:"parent,omitempty"`
+	Replies []dbModel.Comment `json:"replies"`
+}
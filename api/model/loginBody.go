@@ -3,4 +3,6 @@ package model
 // LoginBody is a struct that represents a login request
 type LoginBody struct {
 	Password string `json:"password"`
+	// Site is the key of the site to log in to as its admin, leave it out to log in as the admin of the whole instance
+	Site string `json:"site,omitempty"`
 }
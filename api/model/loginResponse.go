@@ -0,0 +1,6 @@
+package model
+
+// LoginResponse is a struct that represents the response to a password login that still needs the second factor
+type LoginResponse struct {
+	TwoFactorRequired bool `json:"twoFactorRequired"`
+}
@@ -0,0 +1,7 @@
+package model
+
+// MergeThreadsBody is a struct that represents a request to merge the thread into the target thread
+type MergeThreadsBody struct {
+	ThreadId       string `json:"threadId"`
+	TargetThreadId string `json:"targetThreadId"`
+}
@@ -0,0 +1,6 @@
+package model
+
+// RecoveryCodesResponse is a struct that represents a new set of recovery codes. They are only ever shown once
+type RecoveryCodesResponse struct {
+	RecoveryCodes []string `json:"recoveryCodes"`
+}
@@ -0,0 +1,6 @@
+package model
+
+// SessionFlagBody is a struct that represents a request to revoke an admin session
+type SessionFlagBody struct {
+	SessionId string `json:"sessionId"`
+}
@@ -0,0 +1,14 @@
+package model
+
+// SiteBody is a struct that represents a request to create or update a site. The site id is only needed for updating, the key only for creating.
+// AdminPassword is stored as a hash, leaving it out keeps the current password and an empty one disables the password login of the site
+type SiteBody struct {
+	SiteId           string   `json:"siteId"`
+	Key              string   `json:"key"`
+	Name             string   `json:"name"`
+	Origins          []string `json:"origins"`
+	Moderation       *bool    `json:"moderation,omitempty"`
+	MaxCommentLength *int     `json:"maxCommentLength,omitempty"`
+	AdminUserIds     []string `json:"adminUserIds"`
+	AdminPassword    *string  `json:"adminPassword,omitempty"`
+}
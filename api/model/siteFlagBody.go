@@ -0,0 +1,6 @@
+package model
+
+// SiteFlagBody is a struct that represents a request targeting a single site
+type SiteFlagBody struct {
+	SiteId string `json:"siteId"`
+}
@@ -0,0 +1,9 @@
+package model
+
+// ThreadAliasBody is a struct that represents a request to create or delete a thread alias. The thread id is not needed for deleting.
+// Aliases always belong to the site of their thread, so the site key is only needed when deleting an alias of another site than the default one
+type ThreadAliasBody struct {
+	ThreadId string `json:"threadId"`
+	Path     string `json:"path"`
+	Site     string `json:"site,omitempty"`
+}
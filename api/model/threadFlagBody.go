@@ -0,0 +1,6 @@
+package model
+
+// ThreadFlagBody is a struct that represents a request to lock, unlock, archive or delete a thread
+type ThreadFlagBody struct {
+	ThreadId string `json:"threadId"`
+}
@@ -0,0 +1,11 @@
+package model
+
+// ThreadMetadataBody is a struct that represents a request to set the title, url and per-thread settings of a thread.
+// It replaces all of them, leaving Moderation or MaxCommentLength out makes the thread use the global settings again
+type ThreadMetadataBody struct {
+	ThreadId         string `json:"threadId"`
+	Title            string `json:"title"`
+	URL              string `json:"url"`
+	Moderation       *bool  `json:"moderation,omitempty"`
+	MaxCommentLength *int   `json:"maxCommentLength,omitempty"`
+}
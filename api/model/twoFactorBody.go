@@ -0,0 +1,7 @@
+package model
+
+// TwoFactorBody is a struct that represents a request carrying a second factor, either a totp code or one of the recovery codes
+type TwoFactorBody struct {
+	Code         string `json:"code,omitempty"`
+	RecoveryCode string `json:"recoveryCode,omitempty"`
+}
@@ -0,0 +1,8 @@
+package model
+
+// TwoFactorEnrollment is a struct that represents a new totp secret, to be added to an authenticator app and confirmed with a code.
+// URI is the otpauth uri of the secret, for the apps that scan qr codes
+type TwoFactorEnrollment struct {
+	Secret string `json:"secret"`
+	URI    string `json:"uri"`
+}
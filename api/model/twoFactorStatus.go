@@ -0,0 +1,9 @@
+package model
+
+// TwoFactorStatus is a struct that represents the two-factor authentication state of the logged in admin account.
+// Pending is set while a secret is waiting to be confirmed
+type TwoFactorStatus struct {
+	Enabled           bool `json:"enabled"`
+	Pending           bool `json:"pending"`
+	RecoveryCodesLeft int  `json:"recoveryCodesLeft"`
+}
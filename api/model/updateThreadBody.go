@@ -0,0 +1,7 @@
+package model
+
+// UpdateThreadBody is a struct that represents a request to rename a thread
+type UpdateThreadBody struct {
+	ThreadId string `json:"threadId"`
+	Path     string `json:"path"`
+}
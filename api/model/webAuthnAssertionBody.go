@@ -0,0 +1,16 @@
+package model
+
+// WebAuthnAssertionResponse is a struct that represents the response of the authenticator to a login, base64url encoded
+type WebAuthnAssertionResponse struct {
+	ClientDataJSON    string `json:"clientDataJSON"`
+	AuthenticatorData string `json:"authenticatorData"`
+	Signature         string `json:"signature"`
+	UserHandle        string `json:"userHandle,omitempty"`
+}
+
+// WebAuthnAssertionBody is a struct that represents a webauthn login, in the shape PublicKeyCredential.toJSON gives it
+type WebAuthnAssertionBody struct {
+	Id       string                    `json:"id"`
+	Type     string                    `json:"type"`
+	Response WebAuthnAssertionResponse `json:"response"`
+}
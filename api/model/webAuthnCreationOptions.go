@@ -0,0 +1,44 @@
+package model
+
+// WebAuthnRelyingParty is a struct that represents the site the webauthn credentials are created for
+type WebAuthnRelyingParty struct {
+	Id   string `json:"id,omitempty"`
+	Name string `json:"name"`
+}
+
+// WebAuthnUser is a struct that represents the admin a webauthn credential is created for. Id is the base64url encoded user handle
+type WebAuthnUser struct {
+	Id          string `json:"id"`
+	Name        string `json:"name"`
+	DisplayName string `json:"displayName"`
+}
+
+// WebAuthnCredentialParameter is a struct that represents a kind of credential accepted, by its COSE algorithm
+type WebAuthnCredentialParameter struct {
+	Type      string `json:"type"`
+	Algorithm int    `json:"alg"`
+}
+
+// WebAuthnCredentialDescriptor is a struct that represents an existing credential, by its base64url encoded id
+type WebAuthnCredentialDescriptor struct {
+	Type string `json:"type"`
+	Id   string `json:"id"`
+}
+
+// WebAuthnAuthenticatorSelection is a struct that represents the requirements on the authenticator the credential is created with
+type WebAuthnAuthenticatorSelection struct {
+	ResidentKey      string `json:"residentKey"`
+	UserVerification string `json:"userVerification"`
+}
+
+// WebAuthnCreationOptions is a struct that represents the options of a webauthn registration, to be passed as publicKey to navigator.credentials.create once the base64url encoded fields are decoded
+type WebAuthnCreationOptions struct {
+	Challenge              string                         `json:"challenge"`
+	RelyingParty           WebAuthnRelyingParty           `json:"rp"`
+	User                   WebAuthnUser                   `json:"user"`
+	Parameters             []WebAuthnCredentialParameter  `json:"pubKeyCredParams"`
+	Timeout                int                            `json:"timeout"`
+	Attestation            string                         `json:"attestation"`
+	ExcludeCredentials     []WebAuthnCredentialDescriptor `json:"excludeCredentials"`
+	AuthenticatorSelection WebAuthnAuthenticatorSelection `json:"authenticatorSelection"`
+}
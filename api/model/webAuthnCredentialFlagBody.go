@@ -0,0 +1,6 @@
+package model
+
+// WebAuthnCredentialFlagBody is a struct that represents a request to delete a webauthn credential
+type WebAuthnCredentialFlagBody struct {
+	Id string `json:"id"`
+}
@@ -0,0 +1,15 @@
+package model
+
+// WebAuthnAttestationResponse is a struct that represents the response of the authenticator to a registration, base64url encoded
+type WebAuthnAttestationResponse struct {
+	ClientDataJSON    string `json:"clientDataJSON"`
+	AttestationObject string `json:"attestationObject"`
+}
+
+// WebAuthnRegistrationBody is a struct that represents a newly created webauthn credential, in the shape PublicKeyCredential.toJSON gives it, along with the name the admin gives it
+type WebAuthnRegistrationBody struct {
+	Name     string                      `json:"name,omitempty"`
+	Id       string                      `json:"id"`
+	Type     string                      `json:"type"`
+	Response WebAuthnAttestationResponse `json:"response"`
+}
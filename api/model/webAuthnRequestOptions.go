@@ -0,0 +1,10 @@
+package model
+
+// WebAuthnRequestOptions is a struct that represents the options of a webauthn login, to be passed as publicKey to navigator.credentials.get once the challenge is decoded.
+// No credentials are listed, so the authenticator offers the passkeys it has for the relying party
+type WebAuthnRequestOptions struct {
+	Challenge        string `json:"challenge"`
+	RelyingPartyId   string `json:"rpId"`
+	Timeout          int    `json:"timeout"`
+	UserVerification string `json:"userVerification"`
+}
@@ -0,0 +1,98 @@
+package api
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/vkuznecovas/mouthful/config/model"
+)
+
+// indexFiles are the file names stripped from the end of the paths if StripIndex is enabled
+var indexFiles = []string{"index.html", "index.htm"}
+
+// pathRewrite is a compiled PathRewrite
+type pathRewrite struct {
+	pattern     *regexp.Regexp
+	replacement string
+}
+
+// PathNormalizer normalizes the page paths that identify the threads with the configured rules
+type PathNormalizer struct {
+	config   model.Paths
+	rewrites []pathRewrite
+}
+
+// NewPathNormalizer creates a path normalizer from the given paths config, returning an error if any of the rewrite patterns does not compile
+func NewPathNormalizer(config model.Paths) (*PathNormalizer, error) {
+	normalizer := PathNormalizer{config: config}
+	if config.Rewrites != nil {
+		for i, v := range *config.Rewrites {
+			pattern, err := regexp.Compile(v.Pattern)
+			if err != nil {
+				return nil, fmt.Errorf("config.API.Paths.Rewrites[%v].Pattern is not a valid regular expression: %v", i, err)
+			}
+			normalizer.rewrites = append(normalizer.rewrites, pathRewrite{pattern: pattern, replacement: v.Replacement})
+		}
+	}
+	return &normalizer, nil
+}
+
+// Normalize returns the path of the thread for the given page path or url.
+// The scheme and host of absolute urls are dropped, unless HostAware is enabled, in which case the host is kept as a //host prefix.
+// Query strings, fragments, case and index files are then dealt with as configured, slashes are added like NormalizePath does and, finally, the rewrites are applied in order.
+// With no rules configured, relative paths end up exactly as NormalizePath leaves them.
+func (n *PathNormalizer) Normalize(input string) string {
+	host := ""
+	if parsed, err := url.Parse(input); err == nil && parsed.Host != "" && (parsed.Scheme == "" || parsed.Scheme == "http" || parsed.Scheme == "https") {
+		host = strings.ToLower(parsed.Host)
+		input = strings.TrimPrefix(input, parsed.Scheme+":")
+		input = strings.TrimPrefix(input, "//"+parsed.Host)
+	}
+
+	path, suffix := input, ""
+	if i := strings.IndexAny(input, "?#"); i != -1 {
+		path, suffix = input[:i], input[i:]
+	}
+	if n.config.StripFragment {
+		if i := strings.Index(suffix, "#"); i != -1 {
+			suffix = suffix[:i]
+		}
+	}
+	if n.config.StripQuery && strings.HasPrefix(suffix, "?") {
+		suffix = ""
+		if i := strings.Index(input, "#"); i != -1 && !n.config.StripFragment {
+			suffix = input[i:]
+		}
+	}
+	if n.config.Lowercase {
+		path = strings.ToLower(path)
+	}
+	if n.config.StripIndex {
+		for _, v := range indexFiles {
+			if strings.HasSuffix(strings.ToLower(path), "/"+v) {
+				path = path[:len(path)-len(v)]
+				break
+			}
+		}
+	}
+
+	// the query string and fragment are passed to NormalizePath along with the path, as they always were
+	result := NormalizePath(path + suffix)
+	if n.config.HostAware && host != "" {
+		result = "//" + host + result
+	}
+	for _, v := range n.rewrites {
+		result = v.pattern.ReplaceAllString(result, v.replacement)
+	}
+	if !strings.HasPrefix(result, "/") {
+		result = "/" + result
+	}
+	return result
+}
+
+// SetPathNormalizer sets the normalizer used for the paths of the threads
+func (r *Router) SetPathNormalizer(normalizer *PathNormalizer) {
+	r.normalizer = normalizer
+}
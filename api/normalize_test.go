@@ -0,0 +1,65 @@
+package api_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/vkuznecovas/mouthful/api"
+	configModel "github.com/vkuznecovas/mouthful/config/model"
+)
+
+func TestPathNormalizerDefaults(t *testing.T) {
+	normalizer, err := api.NewPathNormalizer(configModel.Paths{})
+	assert.Nil(t, err)
+	for _, v := range []string{"/test/1/2/3", "test", "/test.html", "/post?id=1", "/Post/#comments", ""} {
+		assert.Equal(t, api.NormalizePath(v), normalizer.Normalize(v), v)
+	}
+	assert.Equal(t, "/post/", normalizer.Normalize("https://example.com/post"))
+	assert.Equal(t, "/post/", normalizer.Normalize("//example.com/post"))
+}
+
+func TestPathNormalizerRules(t *testing.T) {
+	normalizer, err := api.NewPathNormalizer(configModel.Paths{
+		StripQuery:    true,
+		StripFragment: true,
+		Lowercase:     true,
+		StripIndex:    true,
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, "/blog/post/", normalizer.Normalize("/Blog/Post?utm_source=feed#comments"))
+	assert.Equal(t, "/blog/post/", normalizer.Normalize("/blog/post/index.html"))
+	assert.Equal(t, "/blog/post/", normalizer.Normalize("/blog/post/INDEX.HTM"))
+	assert.Equal(t, "/file.html", normalizer.Normalize("/File.html?x=1"))
+
+	normalizer, err = api.NewPathNormalizer(configModel.Paths{StripQuery: true})
+	assert.Nil(t, err)
+	assert.Equal(t, "/post#comments/", normalizer.Normalize("/post?id=1#comments"))
+	normalizer, err = api.NewPathNormalizer(configModel.Paths{StripFragment: true})
+	assert.Nil(t, err)
+	assert.Equal(t, "/post?id=1/", normalizer.Normalize("/post?id=1#comments"))
+}
+
+func TestPathNormalizerHostAware(t *testing.T) {
+	normalizer, err := api.NewPathNormalizer(configModel.Paths{HostAware: true})
+	assert.Nil(t, err)
+	assert.Equal(t, "//example.com/post/", normalizer.Normalize("https://Example.com/post"))
+	assert.Equal(t, "//example.com:8080/post/", normalizer.Normalize("//example.com:8080/post"))
+	// relative paths have no host to keep
+	assert.Equal(t, "/post/", normalizer.Normalize("/post"))
+}
+
+func TestPathNormalizerRewrites(t *testing.T) {
+	rewrites := []configModel.PathRewrite{
+		{Pattern: `^//www\.`, Replacement: "//"},
+		{Pattern: `/amp/$`, Replacement: "/"},
+	}
+	normalizer, err := api.NewPathNormalizer(configModel.Paths{HostAware: true, Rewrites: &rewrites})
+	assert.Nil(t, err)
+	assert.Equal(t, "//example.com/post/", normalizer.Normalize("https://www.example.com/post/amp"))
+	assert.Equal(t, "/post/", normalizer.Normalize("/post/amp/"))
+
+	invalid := []configModel.PathRewrite{{Pattern: "(", Replacement: ""}}
+	_, err = api.NewPathNormalizer(configModel.Paths{Rewrites: &invalid})
+	assert.NotNil(t, err)
+}
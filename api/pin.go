@@ -0,0 +1,163 @@
+package api
+
+import (
+	"log"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gofrs/uuid"
+
+	"github.com/vkuznecovas/mouthful/api/model"
+	"github.com/vkuznecovas/mouthful/global"
+)
+
+// PinComment pins the comment to the top of its thread
+func (r *Router) PinComment(c *gin.Context) {
+	commentId, ok := r.bindCommentFlagBody(c)
+	if !ok {
+		return
+	}
+	db := *r.db
+	comment, err := db.GetComment(*commentId)
+	if err != nil {
+		r.abortWithCommentError(c, err)
+		return
+	}
+	if comment.Pinned {
+		c.AbortWithStatus(204)
+		return
+	}
+	if !comment.Confirmed || comment.DeletedAt != nil {
+		c.AbortWithStatusJSON(400, global.ErrCantPinHiddenComment.Error())
+		return
+	}
+	// the pins of the thread are counted and the new one set without the other pins of the thread in between
+	unlock := r.pinLocks.lock([]string{comment.ThreadId.String()})
+	defer unlock()
+	thread, err := db.GetThreadById(comment.ThreadId)
+	if err != nil {
+		r.abortWithCommentError(c, err)
+		return
+	}
+	comments, err := db.GetCommentsByThread(thread.Path)
+	if err != nil {
+		r.abortWithCommentError(c, err)
+		return
+	}
+	maxPins := global.DefaultMaxPinsPerThread
+	if r.config.Moderation.MaxPinsPerThread != nil {
+		maxPins = *r.config.Moderation.MaxPinsPerThread
+	}
+	pins := 0
+	for _, v := range comments {
+		if v.Pinned {
+			pins++
+		}
+	}
+	if pins >= maxPins {
+		c.AbortWithStatusJSON(409, global.ErrTooManyPinnedComments.Error())
+		return
+	}
+	err = db.SetCommentPinned(*commentId, true)
+	if err != nil {
+		r.abortWithCommentError(c, err)
+		return
+	}
+	r.invalidateThreadCache(thread.Path)
+	c.AbortWithStatus(204)
+}
+
+// UnpinComment unpins the comment
+func (r *Router) UnpinComment(c *gin.Context) {
+	commentId, ok := r.bindCommentFlagBody(c)
+	if !ok {
+		return
+	}
+	db := *r.db
+	err := db.SetCommentPinned(*commentId, false)
+	if err != nil {
+		r.abortWithCommentError(c, err)
+		return
+	}
+	r.invalidateFlaggedCommentCache(*commentId)
+	c.AbortWithStatus(204)
+}
+
+// FeatureComment marks the comment as featured, so it can be highlighted by the client
+func (r *Router) FeatureComment(c *gin.Context) {
+	commentId, ok := r.bindCommentFlagBody(c)
+	if !ok {
+		return
+	}
+	db := *r.db
+	err := db.SetCommentFeatured(*commentId, true)
+	if err != nil {
+		r.abortWithCommentError(c, err)
+		return
+	}
+	r.invalidateFlaggedCommentCache(*commentId)
+	c.AbortWithStatus(204)
+}
+
+// UnfeatureComment removes the featured mark from the comment
+func (r *Router) UnfeatureComment(c *gin.Context) {
+	commentId, ok := r.bindCommentFlagBody(c)
+	if !ok {
+		return
+	}
+	db := *r.db
+	err := db.SetCommentFeatured(*commentId, false)
+	if err != nil {
+		r.abortWithCommentError(c, err)
+		return
+	}
+	r.invalidateFlaggedCommentCache(*commentId)
+	c.AbortWithStatus(204)
+}
+
+// invalidateFlaggedCommentCache removes the cached comments of the thread of the comment, as the flags are sent along with them
+func (r *Router) invalidateFlaggedCommentCache(commentId uuid.UUID) {
+	if r.cache == nil {
+		return
+	}
+	comment, err := (*r.db).GetComment(commentId)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	r.invalidateCommentCache(comment.ThreadId)
+}
+
+// bindCommentFlagBody checks for admin rights over the comment and parses the comment id from the request body, aborting the request if either fails
+func (r *Router) bindCommentFlagBody(c *gin.Context) (*uuid.UUID, bool) {
+	if !r.isAdmin(c) {
+		c.AbortWithStatusJSON(401, global.ErrUnauthorized.Error())
+		return nil, false
+	}
+	var body model.CommentFlagBody
+	err := c.BindJSON(&body)
+	if err != nil {
+		log.Println(err)
+		c.AbortWithStatusJSON(400, global.ErrBadRequest.Error())
+		return nil, false
+	}
+	commentId, err := global.ParseUUIDFromString(body.CommentId)
+	if err != nil {
+		log.Println(err)
+		c.AbortWithStatusJSON(400, global.ErrBadRequest.Error())
+		return nil, false
+	}
+	if !r.canManageComment(c, *commentId) {
+		return nil, false
+	}
+	return commentId, true
+}
+
+// abortWithCommentError responds with 404 for missing comments and 500 for everything else
+func (r *Router) abortWithCommentError(c *gin.Context, err error) {
+	if err == global.ErrCommentNotFound {
+		c.AbortWithStatusJSON(404, global.ErrCommentNotFound.Error())
+		return
+	}
+	log.Println(err)
+	c.AbortWithStatusJSON(500, global.ErrInternalServerError.Error())
+}
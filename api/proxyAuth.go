@@ -0,0 +1,180 @@
+package api
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+	"github.com/vkuznecovas/mouthful/config/model"
+	dbModel "github.com/vkuznecovas/mouthful/db/model"
+	"github.com/vkuznecovas/mouthful/global"
+)
+
+// ProxyProvider is the provider of the admins logged in through the single sign-on proxy
+const ProxyProvider = "proxy"
+
+// proxyAuth vouches for the admins the single sign-on proxy in front of mouthful has logged in
+type proxyAuth struct {
+	trustedProxies []*net.IPNet
+	userHeader     string
+	nameHeader     string
+	groupsHeader   string
+	adminUsers     map[string]bool
+	adminGroups    map[string]bool
+}
+
+// parseTrustedProxy parses a trusted proxy given as an ip or a cidr range
+func parseTrustedProxy(value string) (*net.IPNet, error) {
+	if strings.Contains(value, "/") {
+		_, network, err := net.ParseCIDR(value)
+		return network, err
+	}
+	ip := net.ParseIP(value)
+	if ip == nil {
+		return nil, fmt.Errorf("%q is neither an ip nor a cidr range", value)
+	}
+	bits := 8 * net.IPv6len
+	if ip.To4() != nil {
+		ip = ip.To4()
+		bits = 8 * net.IPv4len
+	}
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}, nil
+}
+
+// newProxyAuth checks the proxy auth config, returning the proxy auth it describes
+func newProxyAuth(config model.ProxyAuth) (*proxyAuth, error) {
+	result := &proxyAuth{
+		userHeader:   config.UserHeader,
+		nameHeader:   config.NameHeader,
+		groupsHeader: config.GroupsHeader,
+		adminUsers:   make(map[string]bool),
+		adminGroups:  make(map[string]bool),
+	}
+	if result.userHeader == "" {
+		result.userHeader = global.DefaultProxyAuthUserHeader
+	}
+	if config.TrustedProxies == nil || len(*config.TrustedProxies) == 0 {
+		return nil, fmt.Errorf("config.Moderation.ProxyAuth.TrustedProxies has to list the proxies allowed to log the admins in")
+	}
+	for _, v := range *config.TrustedProxies {
+		network, err := parseTrustedProxy(strings.TrimSpace(v))
+		if err != nil {
+			return nil, fmt.Errorf("config.Moderation.ProxyAuth.TrustedProxies: %v", err.Error())
+		}
+		result.trustedProxies = append(result.trustedProxies, network)
+	}
+	if config.AdminUsers != nil {
+		for _, v := range *config.AdminUsers {
+			result.adminUsers[v] = true
+		}
+	}
+	if config.AdminGroups != nil {
+		if len(*config.AdminGroups) > 0 && config.GroupsHeader == "" {
+			return nil, fmt.Errorf("config.Moderation.ProxyAuth.GroupsHeader is needed for the AdminGroups")
+		}
+		for _, v := range *config.AdminGroups {
+			result.adminGroups[v] = true
+		}
+	}
+	if len(result.adminUsers) == 0 && len(result.adminGroups) == 0 {
+		return nil, fmt.Errorf("config.Moderation.ProxyAuth needs AdminUsers or AdminGroups, or nobody can log in through the proxy")
+	}
+	return result, nil
+}
+
+// isTrustedProxy checks if the request came straight from one of the trusted proxies. The forwarding headers are of no use here, as anybody can send them
+func (pa *proxyAuth) isTrustedProxy(request *http.Request) bool {
+	host, _, err := net.SplitHostPort(request.RemoteAddr)
+	if err != nil {
+		host = request.RemoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+	for _, v := range pa.trustedProxies {
+		if v.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// user returns the user the proxy sent along with the request, or an empty string if the request did not come from a trusted proxy
+func (pa *proxyAuth) user(request *http.Request) string {
+	if !pa.isTrustedProxy(request) {
+		return ""
+	}
+	return strings.TrimSpace(request.Header.Get(pa.userHeader))
+}
+
+// isAdmin checks if the user the proxy sent along with the request is an admin, either by name or by one of the groups
+func (pa *proxyAuth) isAdmin(request *http.Request, user string) bool {
+	if pa.adminUsers[user] {
+		return true
+	}
+	if pa.groupsHeader == "" {
+		return false
+	}
+	for _, header := range request.Header[http.CanonicalHeaderKey(pa.groupsHeader)] {
+		for _, group := range strings.Split(header, ",") {
+			if pa.adminGroups[strings.TrimSpace(group)] {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// identity returns the admin the proxy vouches for on the request, if any
+func (pa *proxyAuth) identity(request *http.Request) (adminIdentity, bool) {
+	user := pa.user(request)
+	if user == "" || !pa.isAdmin(request, user) {
+		return adminIdentity{}, false
+	}
+	identity := adminIdentity{userId: user, provider: ProxyProvider}
+	if pa.nameHeader != "" {
+		identity.name = strings.TrimSpace(request.Header.Get(pa.nameHeader))
+	}
+	return identity, true
+}
+
+// isProxySessionValid checks that the proxy still vouches for the admin of the session on the request.
+// The proxy sends the user along with every request, so the session ends as soon as the one on the proxy does, or the admin is taken off the lists
+func (r *Router) isProxySessionValid(c *gin.Context) bool {
+	if r.proxyAuth == nil {
+		return false
+	}
+	identity, ok := r.proxyAuth.identity(c.Request)
+	userId, _ := sessions.Default(c).Get("userId").(string)
+	return ok && identity.userId == userId
+}
+
+// ProxyLogin logs in the admin the single sign-on proxy vouches for
+func (r *Router) ProxyLogin(c *gin.Context) {
+	if !r.isAdminOrigin(c) {
+		c.AbortWithStatusJSON(403, global.ErrInvalidOrigin.Error())
+		return
+	}
+	identity, ok := r.proxyAuth.identity(c.Request)
+	if !ok {
+		if user := r.proxyAuth.user(c.Request); user != "" {
+			r.audit(c, dbModel.AuditLoginFailure, ProxyProvider+":"+user, "not an admin", nil)
+		} else {
+			log.Printf("proxy login from %v without a user from a trusted proxy\n", c.Request.RemoteAddr)
+		}
+		c.AbortWithStatusJSON(401, global.ErrUnauthorized.Error())
+		return
+	}
+	err := r.startSession(c, identity)
+	if err != nil {
+		log.Println(err)
+		c.AbortWithStatusJSON(500, global.ErrInternalServerError.Error())
+		return
+	}
+	c.AbortWithStatus(204)
+}
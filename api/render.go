@@ -0,0 +1,354 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"html/template"
+	"log"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/microcosm-cc/bluemonday"
+
+	"github.com/vkuznecovas/mouthful/api/model"
+	dbModel "github.com/vkuznecovas/mouthful/db/model"
+	"github.com/vkuznecovas/mouthful/global"
+)
+
+// renderComment is a comment as seen by the html templates
+type renderComment struct {
+	Id             string
+	Author         string
+	Body           template.HTML
+	CreatedAtISO   string
+	CreatedAtHuman string
+	Pinned         bool
+	Featured       bool
+	Staff          bool
+	Replies        []renderComment
+}
+
+// renderData is the data passed to the fragment and page templates
+type renderData struct {
+	Path             string
+	Thread           string
+	Site             string
+	Action           string
+	Redirect         string
+	Comments         []renderComment
+	CommentCount     int
+	Moderation       bool
+	Honeypot         bool
+	MaxCommentLength int
+	MaxAuthorLength  int
+	Locked           bool
+	JSONLD           template.JS
+}
+
+// renderFormData is the data passed to the form partial
+type renderFormData struct {
+	Path             string
+	Thread           string
+	Site             string
+	Action           string
+	Redirect         string
+	ReplyTo          string
+	FormId           string
+	Moderation       bool
+	Honeypot         bool
+	MaxCommentLength int
+	MaxAuthorLength  int
+}
+
+// renderErrorData is the data passed to the error template
+type renderErrorData struct {
+	Message string
+	Back    string
+}
+
+type jsonLDPerson struct {
+	Type string `json:"@type"`
+	Name string `json:"name"`
+}
+
+type jsonLDComment struct {
+	Type        string           `json:"@type"`
+	Id          string           `json:"@id"`
+	Author      jsonLDPerson     `json:"author"`
+	DateCreated string           `json:"dateCreated"`
+	Text        string           `json:"text"`
+	ParentItem  *jsonLDReference `json:"parentItem,omitempty"`
+}
+
+type jsonLDReference struct {
+	Id string `json:"@id"`
+}
+
+type jsonLDGraph struct {
+	Context string          `json:"@context"`
+	Graph   []jsonLDComment `json:"@graph"`
+}
+
+func newRenderFormData(data renderData, replyTo string) renderFormData {
+	formId := "new"
+	if replyTo != "" {
+		formId = replyTo
+	}
+	return renderFormData{
+		FormId:           formId,
+		Path:             data.Path,
+		Thread:           data.Thread,
+		Site:             data.Site,
+		Action:           data.Action,
+		Redirect:         data.Redirect,
+		ReplyTo:          replyTo,
+		Moderation:       data.Moderation,
+		Honeypot:         data.Honeypot,
+		MaxCommentLength: data.MaxCommentLength,
+		MaxAuthorLength:  data.MaxAuthorLength,
+	}
+}
+
+func toRenderComment(comment dbModel.Comment) renderComment {
+	return renderComment{
+		Id:             comment.Id.String(),
+		Author:         comment.Author,
+		Body:           template.HTML(comment.Body),
+		CreatedAtISO:   comment.CreatedAt.UTC().Format(time.RFC3339),
+		CreatedAtHuman: comment.CreatedAt.UTC().Format("January 2, 2006 15:04 MST"),
+		Pinned:         comment.Pinned,
+		Featured:       comment.Featured,
+		Staff:          comment.Staff,
+	}
+}
+
+// SetTemplates sets the html templates used for server-side rendering
+func (r *Router) SetTemplates(t *template.Template) {
+	r.templates = t
+}
+
+// publicURL returns the url mouthful is reachable at by the readers, without the trailing slash. GetServer makes sure it's set when render is enabled
+func (r *Router) publicURL() string {
+	return strings.TrimSuffix(*r.config.API.Render.PublicURL, "/")
+}
+
+// RenderComments renders the comments of the thread passed as query parameter thread or uri as html.
+// By default, a fragment suitable for embedding is returned. Passing format=page returns a full html page instead.
+func (r *Router) RenderComments(c *gin.Context) {
+	requested, site, ok := r.requestedThreadPath(c)
+	if !ok {
+		return
+	}
+	templateName := FragmentTemplateName
+	switch c.Query("format") {
+	case "", "fragment":
+	case "page":
+		templateName = PageTemplateName
+	default:
+		c.AbortWithStatusJSON(400, global.ErrBadRequest.Error())
+		return
+	}
+
+	path, err := r.resolveThreadPath(requested)
+	if err != nil {
+		log.Println(err)
+		c.AbortWithStatusJSON(500, global.ErrInternalServerError.Error())
+		return
+	}
+	db := *r.db
+	comments, err := db.GetCommentsByThread(path)
+	if err != nil && err != global.ErrThreadNotFound {
+		log.Println(err)
+		c.AbortWithStatusJSON(500, global.ErrInternalServerError.Error())
+		return
+	}
+
+	// the form posts the requested path, key and site, so that the comment follows the same resolution
+	data := renderData{
+		Path:         r.normalizer.Normalize(c.Query("uri")),
+		Thread:       c.Query("thread"),
+		Site:         siteKey(site),
+		Action:       r.publicURL() + "/v1/comments/form",
+		Redirect:     c.Query("redirect"),
+		Comments:     make([]renderComment, 0),
+		CommentCount: len(comments),
+		Moderation:   r.siteModeration(site),
+		Honeypot:     r.config.Honeypot,
+	}
+	if maxCommentLength := r.siteMaxCommentLength(site); maxCommentLength != nil {
+		data.MaxCommentLength = *maxCommentLength
+	}
+	data.MaxAuthorLength = global.DefaultAuthorLengthLimit
+	if r.config.Moderation.MaxAuthorLength != nil {
+		data.MaxAuthorLength = *r.config.Moderation.MaxAuthorLength
+	}
+	if len(comments) > 0 {
+		thread, err := db.GetThread(path)
+		if err != nil {
+			log.Println(err)
+			c.AbortWithStatusJSON(500, global.ErrInternalServerError.Error())
+			return
+		}
+		data.Locked = r.isThreadLocked(thread)
+	}
+
+	// we only allow a single layer of nesting, so replies always point to a top level comment
+	graph := jsonLDGraph{
+		Context: "https://schema.org",
+		Graph:   make([]jsonLDComment, 0, len(comments)),
+	}
+	strict := bluemonday.StrictPolicy()
+	topLevel := make(map[string]int)
+	for _, v := range comments {
+		ld := jsonLDComment{
+			Type:        "Comment",
+			Id:          "#mouthful-comment-" + v.Id.String(),
+			Author:      jsonLDPerson{Type: "Person", Name: v.Author},
+			DateCreated: v.CreatedAt.UTC().Format(time.RFC3339),
+			Text:        strings.TrimSpace(strict.Sanitize(v.Body)),
+		}
+		if v.ReplyTo == nil {
+			topLevel[v.Id.String()] = len(data.Comments)
+			data.Comments = append(data.Comments, toRenderComment(v))
+		} else {
+			ld.ParentItem = &jsonLDReference{Id: "#mouthful-comment-" + v.ReplyTo.String()}
+		}
+		graph.Graph = append(graph.Graph, ld)
+	}
+	for _, v := range comments {
+		if v.ReplyTo == nil {
+			continue
+		}
+		if i, ok := topLevel[v.ReplyTo.String()]; ok {
+			data.Comments[i].Replies = append(data.Comments[i].Replies, toRenderComment(v))
+		}
+	}
+	jsonLD, err := json.Marshal(graph)
+	if err != nil {
+		log.Println(err)
+		c.AbortWithStatusJSON(500, global.ErrInternalServerError.Error())
+		return
+	}
+	data.JSONLD = template.JS(jsonLD)
+
+	var buffer bytes.Buffer
+	err = r.templates.ExecuteTemplate(&buffer, templateName, data)
+	if err != nil {
+		log.Println(err)
+		c.AbortWithStatusJSON(500, global.ErrInternalServerError.Error())
+		return
+	}
+	c.Data(200, "text/html; charset=utf-8", buffer.Bytes())
+}
+
+// CreateCommentForm creates a comment from a form encoded CreateCommentBody and redirects the reader back to the page they came from
+func (r *Router) CreateCommentForm(c *gin.Context) {
+	var createCommentBody model.CreateCommentBody
+	err := c.ShouldBind(&createCommentBody)
+	back := r.formRedirectTarget(c, c.PostForm("redirect"), r.normalizer.Normalize(createCommentBody.Path), c.PostForm("thread"), c.PostForm("site"))
+	if err != nil {
+		log.Println(err)
+		r.renderFormError(c, 400, global.ErrBadRequest, back)
+		return
+	}
+	if createCommentBody.Thread != nil && *createCommentBody.Thread == "" {
+		createCommentBody.Thread = nil
+	}
+	if !r.verifyOrigin(c) {
+		r.renderFormError(c, 403, global.ErrPostOriginNotAllowed, back)
+		return
+	}
+	site, status, err := r.findSite(c, stringValue(createCommentBody.Site))
+	if err != nil {
+		r.renderFormError(c, status, err, back)
+		return
+	}
+	// forms always send all their fields, empty ones mean they were not filled in
+	if createCommentBody.Email != nil && *createCommentBody.Email == "" {
+		createCommentBody.Email = nil
+	}
+	if createCommentBody.ReplyTo != nil && *createCommentBody.ReplyTo == "" {
+		createCommentBody.ReplyTo = nil
+	}
+	if !r.acceptsThreadMetadata(c) {
+		createCommentBody.Title = nil
+		createCommentBody.URL = nil
+	}
+	response, confirmed, status, err := r.createComment(createCommentBody, site, false)
+	if err != nil {
+		r.renderFormError(c, status, err, back)
+		return
+	}
+	anchor := "#mouthful-comments"
+	if confirmed {
+		anchor = "#mouthful-comment-" + response.Id
+	}
+	c.Redirect(303, back+anchor)
+}
+
+func (r *Router) renderFormError(c *gin.Context, status int, err error, back string) {
+	var buffer bytes.Buffer
+	templateErr := r.templates.ExecuteTemplate(&buffer, ErrorTemplateName, renderErrorData{
+		Message: err.Error(),
+		Back:    back,
+	})
+	if templateErr != nil {
+		log.Println(templateErr)
+		c.AbortWithStatusJSON(status, err.Error())
+		return
+	}
+	c.Data(status, "text/html; charset=utf-8", buffer.Bytes())
+	c.Abort()
+}
+
+// formRedirectTarget determines where the reader should be sent after posting a form.
+// The requested target is only honoured if it is on the same host as the referer or one of the allowed cors origins, so that mouthful can't be used as an open redirect.
+// It falls back to the referer and, failing that, to the rendered page of the thread, identified by its key if it has one.
+func (r *Router) formRedirectTarget(c *gin.Context, requested string, path string, key string, site string) string {
+	var referer *url.URL
+	if c.Request.Referer() != "" {
+		parsed, err := url.Parse(c.Request.Referer())
+		if err == nil && (parsed.Scheme == "http" || parsed.Scheme == "https") {
+			parsed.Fragment = ""
+			referer = parsed
+		}
+	}
+	if requested != "" {
+		target, err := url.Parse(requested)
+		if err == nil && (target.Scheme == "http" || target.Scheme == "https") && r.isAllowedRedirect(target, referer) {
+			target.Fragment = ""
+			return target.String()
+		}
+	}
+	if referer != nil {
+		return referer.String()
+	}
+	target := r.publicURL() + "/v1/render?format=page&uri=" + url.QueryEscape(path)
+	if key != "" {
+		target = r.publicURL() + "/v1/render?format=page&thread=" + url.QueryEscape(key)
+	}
+	if site != "" {
+		target += "&site=" + url.QueryEscape(site)
+	}
+	return target
+}
+
+func (r *Router) isAllowedRedirect(target *url.URL, referer *url.URL) bool {
+	if referer != nil && referer.Host == target.Host {
+		return true
+	}
+	if r.isSiteRedirect(target) {
+		return true
+	}
+	if r.config.API.Cors.Enabled && r.config.API.Cors.AllowedOrigins != nil {
+		origin := target.Scheme + "://" + target.Host
+		for _, v := range *r.config.API.Cors.AllowedOrigins {
+			if strings.TrimSuffix(v, "/") == origin {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -4,8 +4,10 @@ package api
 import (
 	"bytes"
 	"encoding/json"
+	"html/template"
 	"log"
 	"net/http"
+	"time"
 
 	"github.com/gin-contrib/sessions"
 	"github.com/gin-gonic/gin"
@@ -14,6 +16,7 @@ import (
 	"github.com/patrickmn/go-cache"
 
 	"github.com/vkuznecovas/mouthful/api/model"
+	"github.com/vkuznecovas/mouthful/broker"
 	cfg "github.com/vkuznecovas/mouthful/config"
 	configModel "github.com/vkuznecovas/mouthful/config/model"
 	"github.com/vkuznecovas/mouthful/db/abstraction"
@@ -24,12 +27,31 @@ import (
 
 // Router handles all the different routes as well as stores our  config and db objects
 type Router struct {
-	db           *abstraction.Database
-	config       *configModel.Config
-	cache        *cache.Cache
-	clientConfig *configModel.ClientConfig
-	adminConfig  *configModel.AdminConfig
-	providers    map[string]*provider.Provider
+	db            *abstraction.Database
+	config        *configModel.Config
+	cache         *cache.Cache
+	clientConfig  *configModel.ClientConfig
+	adminConfig   *configModel.AdminConfig
+	providers     map[string]*provider.Provider
+	broker        broker.Broker
+	streamLimiter *connectionLimiter
+	templates     *template.Template
+	compressor    *Compressor
+	normalizer    *PathNormalizer
+	sites         *siteRegistry
+	verifier      *PostVerifier
+	// twoFactorCipher encrypts the totp secrets, it's only set with the two-factor authentication enabled
+	twoFactorCipher *secretCipher
+	// proxyAuth vouches for the admins logged in through the single sign-on proxy, it's only set with the proxy auth enabled
+	proxyAuth *proxyAuth
+	// securityHeaders are overridden by the embeddable routes and the admin panel
+	securityHeaders *SecurityHeaders
+	// loginLocks serialize the throttled logins by client and account
+	loginLocks keyedLocks
+	// pinLocks serialize the pins by thread, so the threads can't go over the pin limit
+	pinLocks keyedLocks
+	// webAuthnChallenges are the challenges of the webauthn ceremonies in progress
+	webAuthnChallenges webAuthnChallenges
 }
 
 // SetProviders sets the OAUTH providers for the router
@@ -37,15 +59,24 @@ func (r *Router) SetProviders(input map[string]*provider.Provider) {
 	r.providers = input
 }
 
-// OAuth initializes the OAuth flow by redirecting the user to the providers login page
+// OAuth initializes the OAuth flow by redirecting the user to the providers login page.
+// The site the admin logs in to can be picked with the site query parameter, which is kept in the session until the callback
 func (r *Router) OAuth(c *gin.Context) {
+	session := sessions.Default(c)
+	session.Set("oauthSite", c.Query("site"))
+	err := session.Save()
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
 	q := c.Request.URL.Query()
 	q.Add("provider", c.Param("provider"))
 	c.Request.URL.RawQuery = q.Encode()
 	gothic.BeginAuthHandler(c.Writer, c.Request)
 }
 
-// OAuthCallback handles the oauth callback which finishes the auth procedure. It checks for the admin flag for the user, and if found it will set the user as admin for the rest of the session
+// OAuthCallback handles the oauth callback which finishes the auth procedure. It checks for the admin flag for the user, and if found it will set the user as admin for the rest of the session.
+// Users that are only listed as admins of a site are limited to that site
 func (r *Router) OAuthCallback(c *gin.Context) {
 	q := c.Request.URL.Query()
 	provider := c.Param("provider")
@@ -56,11 +87,28 @@ func (r *Router) OAuthCallback(c *gin.Context) {
 		c.AbortWithError(http.StatusInternalServerError, err)
 		return
 	}
+	identity := adminIdentity{userId: user.UserID, name: user.NickName, provider: provider}
+	if identity.name == "" {
+		identity.name = user.Name
+	}
+	isAdmin := false
 	for _, v := range r.providers[provider].AdminUserIds {
 		if user.UserID == v {
-			session := sessions.Default(c)
-			session.Set("isAdmin", true)
-			session.Save()
+			isAdmin = true
+		}
+	}
+	if !isAdmin {
+		key, _ := sessions.Default(c).Get("oauthSite").(string)
+		if site := r.oauthSite(provider, user.UserID, key); site != nil {
+			identity.siteId = &site.Id
+			isAdmin = true
+		}
+	}
+	if isAdmin {
+		err = r.startSession(c, identity)
+		if err != nil {
+			c.AbortWithError(http.StatusInternalServerError, err)
+			return
 		}
 	}
 	c.Redirect(307, *r.config.Moderation.OAuthCallbackOrigin)
@@ -70,7 +118,9 @@ func (r *Router) OAuthCallback(c *gin.Context) {
 func New(db *abstraction.Database, config *configModel.Config, cache *cache.Cache) *Router {
 	clientConfig := cfg.TransformConfigToClientConfig(config)
 	adminConfig := cfg.TransformToAdminConfig(config)
-	r := Router{db: db, config: config, cache: cache, clientConfig: clientConfig, adminConfig: adminConfig}
+	// without rewrites, the normalizer can't fail
+	normalizer, _ := NewPathNormalizer(configModel.Paths{})
+	r := Router{db: db, config: config, cache: cache, clientConfig: clientConfig, adminConfig: adminConfig, normalizer: normalizer, sites: &siteRegistry{}}
 	return &r
 }
 
@@ -81,34 +131,66 @@ func (r *Router) Status(c *gin.Context) {
 	})
 }
 
-// GetClientConfig returns the client config portion
+// GetClientConfig returns the client config portion, with the moderation settings of the site the request is for
 func (r *Router) GetClientConfig(c *gin.Context) {
-	c.JSON(200, *r.clientConfig)
+	site, ok := r.resolveSite(c, c.Query("site"))
+	if !ok {
+		return
+	}
+	clientConfig := *r.clientConfig
+	if site != nil {
+		clientConfig.Moderation = r.siteModeration(site)
+		clientConfig.MaxCommentLength = r.siteMaxCommentLength(site)
+	}
+	c.JSON(200, clientConfig)
 }
 
 // GetAdminConfig returns the admin config portion
 func (r *Router) GetAdminConfig(c *gin.Context) {
-	c.JSON(200, *r.adminConfig)
+	adminConfig := *r.adminConfig
+	// the admin panel picks the csrf token up from here after the oauth login, or when it's reopened
+	if r.isAdmin(c) {
+		token, err := r.csrfToken(c)
+		if err != nil {
+			log.Println(err)
+			c.AbortWithStatusJSON(500, global.ErrInternalServerError.Error())
+			return
+		}
+		adminConfig.CSRFToken = token
+	}
+	c.JSON(200, adminConfig)
 }
 
-// GetComments returns the comments from thread that is passed as query parameter uri
+// GetComments returns the comments from thread that is passed as query parameter thread or uri
 func (r *Router) GetComments(c *gin.Context) {
-	path := c.Query("uri")
-	if path == "" {
-		c.AbortWithStatusJSON(400, global.ErrThreadNotFound.Error())
+	requested, _, ok := r.requestedThreadPath(c)
+	if !ok {
 		return
 	}
-	path = NormalizePath(path)
-	if r.cache != nil {
-		if cacheHit, found := r.cache.Get(path); found {
-			jsonString := cacheHit.(*[]byte)
+	query, err := parseCommentQuery(c)
+	if err != nil {
+		c.AbortWithStatusJSON(400, global.ErrBadRequest.Error())
+		return
+	}
+	cacheKey := commentsCacheKey(requested, query)
+	// every client asks for the comments since a time of its own, so those would only fill the cache up
+	cacheable := r.cache != nil && query.Since == nil
+	if cacheable {
+		if cacheHit, found := r.cache.Get(cacheKey); found {
+			entry := cacheHit.(*cacheEntry)
 			c.Writer.Header().Set("X-Cache", "HIT")
-			c.Data(200, "application/json; charset=utf-8", *jsonString)
+			r.respondWithEntry(c, entry)
 			return
 		}
 	}
+	path, err := r.resolveThreadPath(requested)
+	if err != nil {
+		log.Println(err)
+		c.AbortWithStatusJSON(500, global.ErrInternalServerError.Error())
+		return
+	}
 	db := *r.db
-	comments, err := db.GetCommentsByThread(path)
+	comments, err := db.QueryCommentsByThread(path, query)
 
 	if err != nil {
 		if err == global.ErrThreadNotFound {
@@ -119,18 +201,36 @@ func (r *Router) GetComments(c *gin.Context) {
 		c.AbortWithStatusJSON(500, global.ErrInternalServerError.Error())
 		return
 	}
+	// an incremental fetch coming back empty just means there is nothing new
+	if comments == nil && query.Since != nil {
+		comments = make([]dbModel.Comment, 0)
+	}
 	if comments != nil {
 		js, err := json.Marshal(comments)
 		if err != nil {
 			c.JSON(500, global.ErrInternalServerError.Error())
 			return
 		}
-		if r.cache != nil {
-			r.cache.Set(path, &js, cache.DefaultExpiration)
+		thread, err := db.GetThread(path)
+		if err != nil {
+			log.Println(err)
+			c.AbortWithStatusJSON(500, global.ErrInternalServerError.Error())
+			return
+		}
+		entry := newCacheEntry(js, comments, r.isThreadLocked(thread))
+		entry.Path = path
+		if cacheable {
+			if r.compressor != nil {
+				err = entry.precompress(r.compressor)
+				if err != nil {
+					log.Println(err)
+				}
+			}
+			r.cache.Set(cacheKey, entry, cache.DefaultExpiration)
 			c.Writer.Header().Set("X-Cache", "MISS")
 		}
-		if len(comments) > 0 {
-			c.Data(200, "application/json; charset=utf-8", js)
+		if len(comments) > 0 || query.Since != nil {
+			r.respondWithEntry(c, entry)
 		} else {
 			c.JSON(404, global.ErrThreadNotFound.Error())
 		}
@@ -139,33 +239,83 @@ func (r *Router) GetComments(c *gin.Context) {
 	c.AbortWithStatusJSON(404, global.ErrThreadNotFound.Error())
 }
 
-// GetAllThreads returns an array of threads
+// parseCommentQuery reads the sort and since query parameters
+func parseCommentQuery(c *gin.Context) (query dbModel.CommentQuery, err error) {
+	switch sort := dbModel.CommentSort(c.Query("sort")); sort {
+	case "":
+		query.Sort = dbModel.SortOldest
+	case dbModel.SortOldest, dbModel.SortNewest, dbModel.SortReplies:
+		query.Sort = sort
+	default:
+		return query, global.ErrBadRequest
+	}
+	if since := c.Query("since"); since != "" {
+		parsed, err := time.Parse(time.RFC3339, since)
+		if err != nil {
+			return query, err
+		}
+		query.Since = &parsed
+	}
+	return query, nil
+}
+
+// GetAllThreads returns an array of threads along with their comment count and last activity
 func (r *Router) GetAllThreads(c *gin.Context) {
 	if !r.isAdmin(c) {
 		c.AbortWithStatusJSON(401, global.ErrUnauthorized.Error())
 		return
 	}
 	db := *r.db
-	threads, err := db.GetAllThreads()
+	threads, err := r.manageableThreads(c)
 	if err != nil {
 		log.Println(err)
 		c.AbortWithStatusJSON(500, global.ErrInternalServerError.Error())
 		return
 	}
-	if threads == nil {
-		threads = make([]dbModel.Thread, 0)
+	var stats []dbModel.ThreadStats
+	if siteId := r.adminSiteId(c); siteId != nil {
+		stats, err = db.GetSiteThreadStats(*siteId)
+	} else {
+		stats, err = db.GetThreadStats()
+	}
+	if err != nil {
+		log.Println(err)
+		c.AbortWithStatusJSON(500, global.ErrInternalServerError.Error())
+		return
 	}
-	c.JSON(200, threads)
+	result := make([]model.AdminThread, len(threads))
+	index := make(map[uuid.UUID]int, len(threads))
+	for i, v := range threads {
+		result[i] = model.AdminThread{Thread: v, LastActivity: v.CreatedAt}
+		index[v.Id] = i
+	}
+	for _, v := range stats {
+		i, ok := index[v.ThreadId]
+		if !ok {
+			continue
+		}
+		result[i].CommentCount = v.CommentCount
+		if v.LastCommentAt.After(result[i].LastActivity) {
+			result[i].LastActivity = v.LastCommentAt
+		}
+	}
+	c.JSON(200, result)
 }
 
-// GetAllComments returns an array of comments
+// GetAllComments returns an array of comments. The admins of a single site only get the comments of their site
 func (r *Router) GetAllComments(c *gin.Context) {
 	if !r.isAdmin(c) {
 		c.AbortWithStatusJSON(401, global.ErrUnauthorized.Error())
 		return
 	}
 	db := *r.db
-	comments, err := db.GetAllComments()
+	var comments []dbModel.Comment
+	var err error
+	if siteId := r.adminSiteId(c); siteId != nil {
+		comments, err = db.GetSiteComments(*siteId)
+	} else {
+		comments, err = db.GetAllComments()
+	}
 	if err != nil {
 		log.Println(err)
 		c.AbortWithStatusJSON(500, global.ErrInternalServerError.Error())
@@ -177,6 +327,61 @@ func (r *Router) GetAllComments(c *gin.Context) {
 	c.JSON(200, comments)
 }
 
+// GetComment returns a single confirmed comment by id, along with its parent, direct replies and the path of its thread
+func (r *Router) GetComment(c *gin.Context) {
+	// anything that is not a valid id can't be a comment either
+	commentId, err := global.ParseUUIDFromString(c.Param("id"))
+	if err != nil {
+		c.AbortWithStatusJSON(404, global.ErrCommentNotFound.Error())
+		return
+	}
+	db := *r.db
+	comment, err := db.GetComment(*commentId)
+	if err != nil {
+		if err == global.ErrCommentNotFound {
+			c.AbortWithStatusJSON(404, global.ErrCommentNotFound.Error())
+			return
+		}
+		log.Println(err)
+		c.AbortWithStatusJSON(500, global.ErrInternalServerError.Error())
+		return
+	}
+	// pending and deleted comments look exactly like the ones that do not exist
+	if !comment.Confirmed || comment.DeletedAt != nil {
+		c.AbortWithStatusJSON(404, global.ErrCommentNotFound.Error())
+		return
+	}
+	thread, err := db.GetThreadById(comment.ThreadId)
+	if err != nil {
+		log.Println(err)
+		c.AbortWithStatusJSON(500, global.ErrInternalServerError.Error())
+		return
+	}
+	// GetCommentsByThread only returns the visible comments, so hidden parents and replies are left out
+	comments, err := db.GetCommentsByThread(thread.Path)
+	if err != nil {
+		log.Println(err)
+		c.AbortWithStatusJSON(500, global.ErrInternalServerError.Error())
+		return
+	}
+	site, path := SplitSitePath(thread.Path)
+	response := model.GetCommentResponse{
+		Path:    path,
+		Site:    site,
+		Comment: comment,
+		Replies: make([]dbModel.Comment, 0),
+	}
+	for i, v := range comments {
+		if comment.ReplyTo != nil && v.Id == *comment.ReplyTo {
+			response.Parent = &comments[i]
+		}
+		if v.ReplyTo != nil && *v.ReplyTo == comment.Id {
+			response.Replies = append(response.Replies, v)
+		}
+	}
+	c.JSON(200, response)
+}
+
 // CreateComment creates a comment from CreateCommentBody in JSON form
 func (r *Router) CreateComment(c *gin.Context) {
 	var createCommentBody model.CreateCommentBody
@@ -186,75 +391,208 @@ func (r *Router) CreateComment(c *gin.Context) {
 		c.AbortWithStatusJSON(400, global.ErrBadRequest.Error())
 		return
 	}
+	if !r.verifyOrigin(c) {
+		abortWithPostError(c, 403, global.ErrPostOriginNotAllowed)
+		return
+	}
+	site, ok := r.resolveSite(c, stringValue(createCommentBody.Site))
+	if !ok {
+		return
+	}
+	if !r.acceptsThreadMetadata(c) {
+		createCommentBody.Title = nil
+		createCommentBody.URL = nil
+	}
+	response, _, status, err := r.createComment(createCommentBody, site, false)
+	if err != nil {
+		abortWithPostError(c, status, err)
+		return
+	}
+	c.AbortWithStatusJSON(200, response)
+}
+
+// CreateStaffComment creates a comment from CreateCommentBody in JSON form on behalf of the site staff. The comment skips moderation and is marked as a staff comment.
+// The admins of a single site always post on their site, the others pick the site by its key
+func (r *Router) CreateStaffComment(c *gin.Context) {
+	if !r.isAdmin(c) {
+		c.AbortWithStatusJSON(401, global.ErrUnauthorized.Error())
+		return
+	}
+	var createCommentBody model.CreateCommentBody
+	err := c.BindJSON(&createCommentBody)
+	if err != nil {
+		log.Println(err)
+		c.AbortWithStatusJSON(400, global.ErrBadRequest.Error())
+		return
+	}
+	var site *dbModel.Site
+	if r.adminSiteId(c) != nil {
+		site = r.adminSite(c)
+		if site == nil {
+			c.AbortWithStatusJSON(404, global.ErrSiteNotFound.Error())
+			return
+		}
+	} else if key := stringValue(createCommentBody.Site); key != "" {
+		site = r.sites.byKey(key)
+		if site == nil {
+			c.AbortWithStatusJSON(404, global.ErrSiteNotFound.Error())
+			return
+		}
+	}
+	response, _, status, err := r.createComment(createCommentBody, site, true)
+	if err != nil {
+		c.AbortWithStatusJSON(status, err.Error())
+		return
+	}
+	c.AbortWithStatusJSON(200, response)
+}
+
+// isReservedAuthor checks if the author name matches one of the configured staff names
+func (r *Router) isReservedAuthor(author string) bool {
+	if r.config.Moderation.StaffNames == nil {
+		return false
+	}
+	normalized := NormalizeAuthorName(author)
+	for _, v := range *r.config.Moderation.StaffNames {
+		if normalized == NormalizeAuthorName(v) {
+			return true
+		}
+	}
+	return false
+}
+
+// createComment validates and stores the given comment on the site, nil standing for the default site, notifying the subscribers if needed.
+// On failure, it returns the status code and the error the client should receive.
+func (r *Router) createComment(createCommentBody model.CreateCommentBody, site *dbModel.Site, staff bool) (response model.CreateCommentResponse, confirmed bool, status int, err error) {
 	// uuid validation
 	var uid *uuid.UUID
 	if createCommentBody.ReplyTo != nil {
 		uid, err = global.ParseUUIDFromString(*createCommentBody.ReplyTo)
 		if err != nil {
-			c.AbortWithStatusJSON(400, global.ErrBadRequest.Error())
-			return
+			return response, false, 400, global.ErrBadRequest
 		}
 	}
 
+	pagePath := r.normalizer.Normalize(createCommentBody.Path)
+	path := pagePath
+	if createCommentBody.Thread != nil {
+		path, err = ThreadKeyPath(*createCommentBody.Thread)
+		if err != nil {
+			return response, false, 400, err
+		}
+	}
+	createCommentBody.Path, err = r.resolveThreadPath(SitePath(siteKey(site), path))
+	if err != nil {
+		log.Println(err)
+		return response, false, 500, global.ErrInternalServerError
+	}
+	thread, status, err := r.getThreadForComment(createCommentBody.Path, staff)
+	if err != nil {
+		return response, false, status, err
+	}
+	// the path of the page is checked even for explicit thread keys, as the page is what has to exist
+	if thread == nil && !staff && !r.verifyThreadPath(pagePath) {
+		return response, false, 403, global.ErrPathNotAllowed
+	}
+
 	// length validation
-	if r.config.Moderation.MaxCommentLength != nil {
-		if len(createCommentBody.Body) > *r.config.Moderation.MaxCommentLength {
-			c.AbortWithStatusJSON(400, global.ErrBadRequest.Error())
-			return
+	maxCommentLength := r.siteMaxCommentLength(site)
+	if thread != nil && thread.MaxCommentLength != nil {
+		maxCommentLength = thread.MaxCommentLength
+	}
+	if maxCommentLength != nil {
+		if len(createCommentBody.Body) > *maxCommentLength {
+			return response, false, 400, global.ErrBadRequest
 		}
 	}
 
 	// author length validation
 	if len(createCommentBody.Author) == 0 {
-		c.AbortWithStatusJSON(400, global.ErrBadRequest.Error())
-		return
+		return response, false, 400, global.ErrBadRequest
 	}
 	maxAuthorLength := global.DefaultAuthorLengthLimit
 	if r.config.Moderation.MaxAuthorLength != nil {
 		maxAuthorLength = *r.config.Moderation.MaxAuthorLength
 	}
+	if !staff && r.isReservedAuthor(createCommentBody.Author) {
+		return response, false, 403, global.ErrReservedAuthorName
+	}
 	createCommentBody.Author = ShortenAuthor(createCommentBody.Author, maxAuthorLength)
 
 	// body length validation
 	createCommentBody.Body = global.ParseAndSaniziteMarkdown(createCommentBody.Body)
 	if len(createCommentBody.Body) == 0 {
-		c.AbortWithStatusJSON(400, global.ErrBadRequest.Error())
-		return
+		return response, false, 400, global.ErrBadRequest
 	}
 
-	createCommentBody.Path = NormalizePath(createCommentBody.Path)
-	if r.config.Honeypot && createCommentBody.Email != nil {
-		c.AbortWithStatusJSON(200, model.CreateCommentResponse{
+	moderated := r.siteModeration(site)
+	if thread != nil && thread.Moderation != nil {
+		moderated = *thread.Moderation
+	}
+	confirmed = !moderated || staff
+	_, localPath := SplitSitePath(createCommentBody.Path)
+	if !staff && r.config.Honeypot && createCommentBody.Email != nil {
+		return model.CreateCommentResponse{
 			Id:      uuid.Must(uuid.NewV4()).String(),
-			Path:    createCommentBody.Path,
+			Path:    localPath,
+			Site:    siteKey(site),
 			Body:    createCommentBody.Body,
 			Author:  createCommentBody.Author,
 			Email:   createCommentBody.Email,
 			ReplyTo: createCommentBody.ReplyTo,
-		})
-		return
+		}, confirmed, 200, nil
 	}
 
 	db := *r.db
-	confirmed := !r.config.Moderation.Enabled
-	commentUID, err := db.CreateComment(createCommentBody.Body, createCommentBody.Author, createCommentBody.Path, confirmed, uid)
+	// new threads are created before the comment rather than along with it, so they belong to their site from the start
+	if thread == nil {
+		var siteId *uuid.UUID
+		if site != nil {
+			siteId = &site.Id
+		}
+		_, err = db.CreateThread(createCommentBody.Path, siteId)
+		if err != nil {
+			log.Println(err)
+			return response, false, 500, global.ErrInternalServerError
+		}
+	}
+	var commentUID *uuid.UUID
+	if staff {
+		commentUID, err = db.CreateStaffComment(createCommentBody.Body, createCommentBody.Author, createCommentBody.Path, uid)
+	} else {
+		commentUID, err = db.CreateComment(createCommentBody.Body, createCommentBody.Author, createCommentBody.Path, confirmed, uid)
+	}
 	if err != nil {
 		if err == global.ErrWrongReplyTo {
-			c.AbortWithStatusJSON(400, global.ErrWrongReplyTo.Error())
-			return
+			return response, false, 400, global.ErrWrongReplyTo
 		}
 		log.Println(err)
-		c.AbortWithStatusJSON(500, global.ErrInternalServerError.Error())
-		return
+		return response, false, 500, global.ErrInternalServerError
+	}
+	thread = r.storeThreadMetadata(createCommentBody, thread)
+
+	if confirmed && r.broker != nil {
+		comment, err := db.GetComment(*commentUID)
+		if err != nil {
+			log.Println(err)
+		} else {
+			r.publishComment(createCommentBody.Path, comment)
+		}
 	}
 
-	response := model.CreateCommentResponse{
+	response = model.CreateCommentResponse{
 		Id:      commentUID.String(),
-		Path:    createCommentBody.Path,
+		Path:    localPath,
+		Site:    siteKey(site),
 		Body:    createCommentBody.Body,
 		Author:  createCommentBody.Author,
 		Email:   createCommentBody.Email,
 		ReplyTo: createCommentBody.ReplyTo,
+		Staff:   staff,
+	}
+	if thread != nil {
+		response.Title = thread.Title
+		response.URL = thread.URL
 	}
 
 	if r.config.Notification.Webhook.Enabled {
@@ -282,7 +620,7 @@ func (r *Router) CreateComment(c *gin.Context) {
 		}(url, response)
 	}
 
-	c.AbortWithStatusJSON(200, response)
+	return response, confirmed, 200, nil
 }
 
 // UpdateComment updates the provided comment in body
@@ -308,6 +646,9 @@ func (r *Router) UpdateComment(c *gin.Context) {
 		c.AbortWithStatusJSON(400, global.ErrBadRequest)
 		return
 	}
+	if !r.canManageComment(c, *commentId) {
+		return
+	}
 	db := *r.db
 	comment, err := db.GetComment(*commentId)
 	if err != nil {
@@ -337,6 +678,20 @@ func (r *Router) UpdateComment(c *gin.Context) {
 		c.AbortWithStatusJSON(500, global.ErrInternalServerError.Error())
 		return
 	}
+	r.invalidateCommentCache(comment.ThreadId)
+
+	// the comment just became visible to the readers
+	if !comment.Confirmed && confirmed && comment.DeletedAt == nil && r.broker != nil {
+		comment.Body = body
+		comment.Author = author
+		comment.Confirmed = confirmed
+		thread, err := db.GetThreadById(comment.ThreadId)
+		if err != nil {
+			log.Println(err)
+		} else {
+			r.publishComment(thread.Path, comment)
+		}
+	}
 	c.AbortWithStatus(204)
 }
 
@@ -359,7 +714,16 @@ func (r *Router) DeleteComment(c *gin.Context) {
 		c.AbortWithStatusJSON(400, global.ErrBadRequest.Error())
 		return
 	}
+	if !r.canManageComment(c, *commentId) {
+		return
+	}
 	db := *r.db
+	// the thread is looked up first, as the comment is gone after a hard delete
+	comment, err := db.GetComment(*commentId)
+	if err != nil {
+		r.abortWithCommentError(c, err)
+		return
+	}
 
 	if deleteCommentBody.Hard {
 		err = db.HardDeleteComment(*commentId)
@@ -376,6 +740,7 @@ func (r *Router) DeleteComment(c *gin.Context) {
 		c.AbortWithStatusJSON(500, global.ErrInternalServerError.Error())
 		return
 	}
+	r.invalidateCommentCache(comment.ThreadId)
 	c.AbortWithStatus(204)
 }
 
@@ -398,6 +763,9 @@ func (r *Router) RestoreDeletedComment(c *gin.Context) {
 		c.AbortWithStatusJSON(400, global.ErrBadRequest.Error())
 		return
 	}
+	if !r.canManageComment(c, *commentId) {
+		return
+	}
 	db := *r.db
 	err = db.RestoreDeletedComment(*commentId)
 	if err != nil {
@@ -409,21 +777,44 @@ func (r *Router) RestoreDeletedComment(c *gin.Context) {
 		c.AbortWithStatusJSON(500, global.ErrInternalServerError.Error())
 		return
 	}
+	comment, err := db.GetComment(*commentId)
+	if err != nil {
+		log.Println(err)
+	} else {
+		r.invalidateCommentCache(comment.ThreadId)
+	}
 	c.AbortWithStatus(204)
 }
 
+// isAdmin checks if the request comes from a logged in admin, or carries an api token with the scope the route needs
 func (r *Router) isAdmin(c *gin.Context) bool {
+	// the token alone decides for the requests carrying one, whatever cookies come along
+	if bearerToken(c) != "" {
+		return r.isAPITokenAllowed(c)
+	}
 	session := sessions.Default(c)
 	isAdmin := session.Get("isAdmin")
 	isAdminParsed, ok := isAdmin.(bool)
-	if !ok {
+	if !ok || !isAdminParsed {
+		return false
+	}
+	if provider, _ := session.Get("provider").(string); provider == ProxyProvider && !r.isProxySessionValid(c) {
 		return false
 	}
-	return isAdminParsed
+	// the cookie alone is not enough once the sessions live on the server, as those can be revoked
+	if r.config.Moderation.ServerSideSessions {
+		return r.serverSession(c) != nil
+	}
+	return true
 }
 
-// Login logs the user in
+// Login logs the user in, either as the admin of the whole instance or, if a site key is given, as the admin of that site
 func (r *Router) Login(c *gin.Context) {
+	// a forged login would sign the admin in to the account of somebody else
+	if !r.isAdminOrigin(c) {
+		c.AbortWithStatusJSON(403, global.ErrInvalidOrigin.Error())
+		return
+	}
 	var loginBody model.LoginBody
 	err := c.BindJSON(&loginBody)
 
@@ -433,13 +824,70 @@ func (r *Router) Login(c *gin.Context) {
 		return
 	}
 
-	if loginBody.Password != r.config.Moderation.AdminPassword {
+	// the failed logins are throttled both by client and by account, as the clients are easy to change.
+	// Sites that don't exist have no account to lock out
+	account := passwordAccount(loginBody.Site)
+	ids := []string{LoginFailureIPId(c.ClientIP())}
+	var siteId *uuid.UUID
+	if loginBody.Site == "" {
+		ids = append(ids, LoginFailureAccountId(account))
+	} else if site := r.sites.byKey(loginBody.Site); site != nil {
+		ids = append(ids, LoginFailureAccountId(account))
+		siteId = &site.Id
+	}
+	// the check and the recording of the failure must not interleave with the other logins of the same client or account
+	unlock := r.loginLocks.lock(ids)
+	defer unlock()
+	if wait := r.loginBlockedFor(ids); wait > 0 {
+		abortWithTooManyLoginAttempts(c, wait)
+		return
+	}
+
+	identity := adminIdentity{userId: PasswordUserId, provider: PasswordProvider}
+	loggedIn := false
+	if loginBody.Site != "" {
+		site := r.siteAdminLogin(loginBody.Site, loginBody.Password)
+		if site != nil {
+			identity.siteId = &site.Id
+			loggedIn = true
+		}
+	} else if !r.config.Moderation.DisablePasswordLogin {
+		loggedIn = passwordsEqual(loginBody.Password, r.config.Moderation.AdminPassword)
+	}
+	if !loggedIn {
+		delay, lockedOut := r.recordLoginFailure(ids)
+		if lockedOut {
+			r.audit(c, dbModel.AuditLoginLockout, account, "locked out for "+delay.String(), siteId)
+		} else {
+			r.audit(c, dbModel.AuditLoginFailure, account, "blocked for "+delay.String(), siteId)
+		}
 		c.AbortWithStatusJSON(401, global.ErrBadRequest.Error())
 		return
 	}
+	// with a second factor, the failures are only forgotten once it passes too, so the password can't be used to reset the throttling of the codes
+	twoFactor, err := r.confirmedTwoFactor(account)
+	if err != nil {
+		log.Println(err)
+		c.AbortWithStatusJSON(500, global.ErrInternalServerError.Error())
+		return
+	}
+	if twoFactor != nil {
+		err = r.beginTwoFactorLogin(c, account, identity.siteId)
+		if err != nil {
+			log.Println(err)
+			c.AbortWithStatusJSON(500, global.ErrInternalServerError.Error())
+			return
+		}
+		c.JSON(200, model.LoginResponse{TwoFactorRequired: true})
+		return
+	}
+	r.clearLoginFailures(ids)
 
-	session := sessions.Default(c)
-	session.Set("isAdmin", true)
-	session.Save()
+	err = r.startSession(c, identity)
+	if err != nil {
+		log.Println(err)
+		c.AbortWithStatusJSON(500, global.ErrInternalServerError.Error())
+		return
+	}
 	c.AbortWithStatus(204)
 }
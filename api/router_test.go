@@ -2,19 +2,35 @@ package api_test
 
 import (
 	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/binary"
 	"encoding/json"
 	"fmt"
 	"io"
 	"io/ioutil"
+	"log"
+	"math/big"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
 	"os"
+	"path/filepath"
+	"strconv"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/andybalholm/brotli"
 	"github.com/gofrs/uuid"
+	"github.com/ugorji/go/codec"
 	"github.com/vkuznecovas/mouthful/global"
 
 	"github.com/vkuznecovas/mouthful/db/abstraction"
@@ -121,6 +137,103 @@ var testFunctions = [...]interface{}{
 	GetAdminConfig,
 	OauthPathsExist,
 	DeleteCommentHard,
+	StreamCommentsDisabled,
+	StreamCommentsBadQuery,
+	StreamCommentsNewThread,
+	StreamCommentsResumesFromLastEventId,
+	StreamCommentsPublishesApprovedComments,
+	RenderRequiresPublicURL,
+	RenderCommentsBadQuery,
+	RenderCommentsDisabled,
+	RenderCommentsEmptyThread,
+	RenderComments,
+	CreateCommentFormRedirectsBack,
+	CreateCommentFormModerated,
+	CreateCommentFormBadRequest,
+	GetCommentsConditional,
+	GetCommentsCacheHeaders,
+	GetCommentsCompressed,
+	GetCommentsPrecompressedCacheHit,
+	GetCommentBadId,
+	GetCommentNotFound,
+	GetCommentHidesPendingAndDeleted,
+	GetCommentWithContext,
+	GetCommentsBadSortAndSince,
+	GetCommentsSorted,
+	GetCommentsSince,
+	GetCommentsQueryIsPartOfCacheKey,
+	ModerateCommentInvalidatesCache,
+	PinCommentUnauthorized,
+	PinCommentNotFound,
+	PinCommentHidden,
+	PinCommentMovesItFirst,
+	PinCommentLimit,
+	PinCommentLimitConcurrent,
+	PinCommentInvalidatesCache,
+	FeatureComment,
+	CreateStaffCommentUnauthorized,
+	CreateStaffComment,
+	CreateCommentReservedStaffName,
+	LockThreadUnauthorizedAndNotFound,
+	LockThreadRejectsReaderComments,
+	ArchiveThreadRejectsStaffComments,
+	LockThreadInvalidatesCache,
+	AutoCloseThread,
+	ManageThreadsUnauthorized,
+	RenameThread,
+	GetAuditLogPages,
+	RenameThreadConflict,
+	MergeThreads,
+	DeleteThread,
+	CreateCommentStoresThreadMetadata,
+	CreateCommentIgnoresUnverifiedThreadMetadata,
+	UpdateThreadMetadata,
+	ThreadOverridesModeration,
+	ThreadOverridesMaxCommentLength,
+	GetAllThreadsActivity,
+	ThreadKeyIdentifiesThread,
+	ThreadKeyInvalid,
+	ThreadAliases,
+	ThreadAliasConflicts,
+	ThreadAliasFollowsMergeAndDelete,
+	NormalizedPathsShareThread,
+	InvalidPathRewrite,
+	ManageSites,
+	SitesSeparateThreads,
+	SiteSettingsOverrideConfig,
+	SiteAdminsAreScoped,
+	PostOriginVerification,
+	ThreadPathVerification,
+	MeAndLogout,
+	SessionSecretRotation,
+	ServerSideSessions,
+	CSRFProtection,
+	AdminOrigins,
+	SessionCookieOptions,
+	LoginThrottling,
+	LoginThrottlingConcurrent,
+	LoginThrottlingTrustedProxies,
+	TwoFactorLogin,
+	TwoFactorChecksThrottled,
+	TwoFactorConfig,
+	WebAuthnLogin,
+	WebAuthnChallengesPerClient,
+	WebAuthnConfig,
+	ProxyAuthLogin,
+	ProxyAuthConfig,
+	APITokens,
+	SecurityHeaders,
+	SecurityHeadersOverrides,
+	SecurityHeadersEmbed,
+	SecurityHeadersAdminPanel,
+}
+
+// csrfTokens keeps the csrf tokens the logins of the tests got, by the session cookie
+var csrfTokens = make(map[string]string)
+
+// csrfHeader returns the header carrying the csrf token of the session with the given cookies
+func csrfHeader(cookies gofight.H) gofight.H {
+	return gofight.H{api.CSRFHeader: csrfTokens[cookies["mouthful-session"]]}
 }
 
 func GetSessionCookie(db *abstraction.Database, r *gofight.RequestConfig) gofight.H {
@@ -133,6 +246,7 @@ func GetSessionCookie(db *abstraction.Database, r *gofight.RequestConfig) gofigh
 		SetDebug(debug).
 		Run(server, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
 			cookieValue = strings.TrimSuffix(strings.Split(strings.TrimLeft(r.HeaderMap["Set-Cookie"][0], cookiePrefix+"="), " ")[0], ";")
+			csrfTokens[cookieValue] = r.HeaderMap.Get(api.CSRFHeader)
 		})
 	return gofight.H{cookiePrefix: cookieValue}
 }
@@ -312,6 +426,7 @@ func DeleteCommentBadRequst(t *testing.T, testDB abstraction.Database) {
 	r.DELETE("/v1/admin/comments").
 		SetBody(string("sadasdasdasd")).
 		SetCookie(cookies).
+		SetHeader(csrfHeader(cookies)).
 		SetDebug(debug).
 		Run(server, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
 			assert.Equal(t, 400, r.Code)
@@ -331,6 +446,7 @@ func DeleteCommentNonExistant(t *testing.T, testDB abstraction.Database) {
 	r.DELETE("/v1/admin/comments").
 		SetBody(string(v)).
 		SetCookie(cookies).
+		SetHeader(csrfHeader(cookies)).
 		SetDebug(debug).
 		Run(server, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
 			assert.Equal(t, 404, r.Code)
@@ -378,6 +494,7 @@ func DeleteComment(t *testing.T, testDB abstraction.Database) {
 		SetBody(string(bodyBytes[:])).
 		SetDebug(debug).
 		SetCookie(cookies).
+		SetHeader(csrfHeader(cookies)).
 		Run(server, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
 			assert.Equal(t, "", r.Body.String())
 			assert.Equal(t, 204, r.Code)
@@ -404,6 +521,7 @@ func DeleteComment(t *testing.T, testDB abstraction.Database) {
 		SetBody(string(v)).
 		SetDebug(debug).
 		SetCookie(cookies).
+		SetHeader(csrfHeader(cookies)).
 		Run(server, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
 			assert.Equal(t, 204, r.Code)
 		})
@@ -455,6 +573,7 @@ func DeleteCommentHard(t *testing.T, testDB abstraction.Database) {
 		SetBody(string(bodyBytes[:])).
 		SetDebug(debug).
 		SetCookie(cookies).
+		SetHeader(csrfHeader(cookies)).
 		Run(server, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
 			assert.Equal(t, "", r.Body.String())
 			assert.Equal(t, 204, r.Code)
@@ -482,12 +601,14 @@ func DeleteCommentHard(t *testing.T, testDB abstraction.Database) {
 		SetBody(string(v)).
 		SetDebug(debug).
 		SetCookie(cookies).
+		SetHeader(csrfHeader(cookies)).
 		Run(server, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
 			assert.Equal(t, 204, r.Code)
 		})
 	r.GET("/v1/admin/comments/all").
 		SetDebug(debug).
 		SetCookie(cookies).
+		SetHeader(csrfHeader(cookies)).
 		Run(server, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
 			assert.Equal(t, 200, r.Code)
 			var comments []dbmodel.Comment
@@ -508,6 +629,7 @@ func UpdateCommentBadRequst(t *testing.T, testDB abstraction.Database) {
 		SetBody(string("sadasdasdasd")).
 		SetDebug(debug).
 		SetCookie(cookies).
+		SetHeader(csrfHeader(cookies)).
 		Run(server, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
 			assert.Equal(t, 400, r.Code)
 		})
@@ -552,6 +674,7 @@ func CreateComment(t *testing.T, testDB abstraction.Database) {
 		SetBody(string(bodyBytes[:])).
 		SetDebug(debug).
 		SetCookie(cookies).
+		SetHeader(csrfHeader(cookies)).
 		Run(server, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
 			assert.Equal(t, "", r.Body.String())
 			assert.Equal(t, 204, r.Code)
@@ -664,6 +787,7 @@ func CreateCommentReplyTo(t *testing.T, testDB abstraction.Database) {
 		SetBody(string(bodyBytes[:])).
 		SetDebug(debug).
 		SetCookie(cookies).
+		SetHeader(csrfHeader(cookies)).
 		Run(server, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
 			assert.Equal(t, "", r.Body.String())
 			assert.Equal(t, 204, r.Code)
@@ -702,6 +826,7 @@ func CreateCommentReplyTo(t *testing.T, testDB abstraction.Database) {
 		SetBody(string(bodyBytes[:])).
 		SetDebug(debug).
 		SetCookie(cookies).
+		SetHeader(csrfHeader(cookies)).
 		Run(server, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
 			assert.Equal(t, "", r.Body.String())
 			assert.Equal(t, 204, r.Code)
@@ -845,6 +970,7 @@ func GetThreadsEmpty(t *testing.T, testDB abstraction.Database) {
 	r.GET("/v1/admin/threads").
 		SetDebug(debug).
 		SetCookie(cookies).
+		SetHeader(csrfHeader(cookies)).
 		Run(server, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
 			assert.Equal(t, 200, r.Code)
 			assert.Equal(t, "[]", r.Body.String())
@@ -865,6 +991,7 @@ func GetCommentsEmpty(t *testing.T, testDB abstraction.Database) {
 	r.GET("/v1/admin/comments/all").
 		SetDebug(debug).
 		SetCookie(cookies).
+		SetHeader(csrfHeader(cookies)).
 		Run(server, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
 			assert.Equal(t, 200, r.Code)
 			assert.Equal(t, "[]", r.Body.String())
@@ -906,6 +1033,7 @@ func GetThreads(t *testing.T, testDB abstraction.Database) {
 		SetBody(string(bodyBytes[:])).
 		SetDebug(debug).
 		SetCookie(cookies).
+		SetHeader(csrfHeader(cookies)).
 		Run(server, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
 			assert.Equal(t, 200, r.Code)
 			var threads []dbmodel.Thread
@@ -947,6 +1075,7 @@ func GetComments(t *testing.T, testDB abstraction.Database) {
 		SetBody(string(bodyBytes[:])).
 		SetDebug(debug).
 		SetCookie(cookies).
+		SetHeader(csrfHeader(cookies)).
 		Run(server, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
 			assert.Equal(t, 200, r.Code)
 			var comments []dbmodel.Comment
@@ -1007,6 +1136,7 @@ func UpdateCommentNonExistant(t *testing.T, testDB abstraction.Database) {
 		SetBody(string(bodyBytes[:])).
 		SetDebug(debug).
 		SetCookie(cookies).
+		SetHeader(csrfHeader(cookies)).
 		Run(server, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
 			assert.Equal(t, 404, r.Code)
 		})
@@ -1051,6 +1181,7 @@ func UpdateCommentInvalidBody(t *testing.T, testDB abstraction.Database) {
 		SetBody(string(bodyBytes[:])).
 		SetDebug(debug).
 		SetCookie(cookies).
+		SetHeader(csrfHeader(cookies)).
 		Run(server, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
 			assert.Equal(t, 400, r.Code)
 		})
@@ -1100,6 +1231,7 @@ func UpdateComment(t *testing.T, testDB abstraction.Database) {
 		SetBody(string(bodyBytes[:])).
 		SetDebug(debug).
 		SetCookie(cookies).
+		SetHeader(csrfHeader(cookies)).
 		Run(server, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
 			assert.Equal(t, 204, r.Code)
 		})
@@ -1129,6 +1261,7 @@ func UpdateComment(t *testing.T, testDB abstraction.Database) {
 		SetBody(string(bodyBytes[:])).
 		SetDebug(debug).
 		SetCookie(cookies).
+		SetHeader(csrfHeader(cookies)).
 		Run(server, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
 			assert.Equal(t, 204, r.Code)
 		})
@@ -1158,6 +1291,7 @@ func UpdateComment(t *testing.T, testDB abstraction.Database) {
 		SetBody(string(bodyBytes[:])).
 		SetDebug(debug).
 		SetCookie(cookies).
+		SetHeader(csrfHeader(cookies)).
 		Run(server, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
 			assert.Equal(t, 204, r.Code)
 		})
@@ -1185,6 +1319,7 @@ func RestoreDeletedCommentBadRequst(t *testing.T, testDB abstraction.Database) {
 	r.POST("/v1/admin/comments/restore").
 		SetBody(string("sadasdasdasd")).
 		SetCookie(cookies).
+		SetHeader(csrfHeader(cookies)).
 		SetDebug(debug).
 		Run(server, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
 			assert.Equal(t, 400, r.Code)
@@ -1204,6 +1339,7 @@ func RestoreDeletedCommentNonExistant(t *testing.T, testDB abstraction.Database)
 	r.DELETE("/v1/admin/comments/restore").
 		SetBody(string(v)).
 		SetCookie(cookies).
+		SetHeader(csrfHeader(cookies)).
 		SetDebug(debug).
 		Run(server, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
 			assert.Equal(t, 404, r.Code)
@@ -1250,6 +1386,7 @@ func RestoreDeletedComment(t *testing.T, testDB abstraction.Database) {
 		SetBody(string(bodyBytes[:])).
 		SetDebug(debug).
 		SetCookie(cookies).
+		SetHeader(csrfHeader(cookies)).
 		Run(server, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
 			assert.Equal(t, "", r.Body.String())
 			assert.Equal(t, 204, r.Code)
@@ -1275,6 +1412,7 @@ func RestoreDeletedComment(t *testing.T, testDB abstraction.Database) {
 		SetBody(string(v)).
 		SetDebug(debug).
 		SetCookie(cookies).
+		SetHeader(csrfHeader(cookies)).
 		Run(server, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
 			assert.Equal(t, 204, r.Code)
 		})
@@ -1288,6 +1426,7 @@ func RestoreDeletedComment(t *testing.T, testDB abstraction.Database) {
 		SetBody(string(v)).
 		SetDebug(debug).
 		SetCookie(cookies).
+		SetHeader(csrfHeader(cookies)).
 		Run(server, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
 			assert.Equal(t, 204, r.Code)
 		})
@@ -1388,6 +1527,7 @@ func GetCommentsCache(t *testing.T, testDB abstraction.Database) {
 		SetBody(string(bodyBytes[:])).
 		SetDebug(debug).
 		SetCookie(cookies).
+		SetHeader(csrfHeader(cookies)).
 		Run(server, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
 			assert.Equal(t, "", r.Body.String())
 			assert.Equal(t, 204, r.Code)
@@ -1474,6 +1614,7 @@ func DeleteCommentBadUUID(t *testing.T, testDB abstraction.Database) {
 	r.DELETE("/v1/admin/comments").
 		SetBody(string(bodyJson)).
 		SetCookie(cookies).
+		SetHeader(csrfHeader(cookies)).
 		SetDebug(debug).
 		Run(server, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
 			assert.Equal(t, 400, r.Code)
@@ -1495,6 +1636,7 @@ func UpdateCommentBadUUID(t *testing.T, testDB abstraction.Database) {
 	r.PATCH("/v1/admin/comments").
 		SetBody(string(bodyJson)).
 		SetCookie(cookies).
+		SetHeader(csrfHeader(cookies)).
 		SetDebug(debug).
 		Run(server, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
 			assert.Equal(t, 400, r.Code)
@@ -1563,6 +1705,7 @@ func DeleteCommentDeletesReplyToComments(t *testing.T, testDB abstraction.Databa
 		SetBody(string(v)).
 		SetDebug(debug).
 		SetCookie(cookies).
+		SetHeader(csrfHeader(cookies)).
 		Run(server, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
 			assert.Equal(t, 204, r.Code)
 		})
@@ -1611,8 +1754,13 @@ func RateLimitingCommentCreation(t *testing.T, testDB abstraction.Database) {
 
 }
 
+// noLoginThrottling turns off the throttling of the failed logins
+var noLoginThrottling = configModel.LoginThrottling{BaseDelaySeconds: new(int), MaxFailures: new(int)}
+
 func RateLimitingLoginCreation(t *testing.T, testDB abstraction.Database) {
 	newConfig := config
+	// the failed logins would get throttled long before the rate limit is reached
+	newConfig.Moderation.LoginThrottling = noLoginThrottling
 	newConfig.API.RateLimiting.Enabled = true
 	newConfig.API.RateLimiting.PostsHour = 100
 	server, err := api.GetServer(&testDB, &newConfig)
@@ -1640,6 +1788,8 @@ func RateLimitingLoginCreation(t *testing.T, testDB abstraction.Database) {
 
 func RateLimitingDisabled(t *testing.T, testDB abstraction.Database) {
 	newConfig := config
+	// the failed logins would get throttled long before the rate limit is reached
+	newConfig.Moderation.LoginThrottling = noLoginThrottling
 	newConfig.API.RateLimiting.Enabled = false
 	newConfig.API.RateLimiting.PostsHour = 1000
 	server, err := api.GetServer(&testDB, &newConfig)
@@ -1690,6 +1840,7 @@ func GetCommentsWithPathNormalization(t *testing.T, testDB abstraction.Database)
 		SetBody(string(bodyBytes[:])).
 		SetDebug(debug).
 		SetCookie(cookies).
+		SetHeader(csrfHeader(cookies)).
 		Run(server, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
 			assert.Equal(t, 200, r.Code)
 			var threads []dbmodel.Thread
@@ -1840,6 +1991,7 @@ func CreateCommentReplyToAReply(t *testing.T, testDB abstraction.Database) {
 		SetBody(string(bodyBytes[:])).
 		SetDebug(debug).
 		SetCookie(cookies).
+		SetHeader(csrfHeader(cookies)).
 		Run(server, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
 			assert.Equal(t, "", r.Body.String())
 			assert.Equal(t, 204, r.Code)
@@ -1878,6 +2030,7 @@ func CreateCommentReplyToAReply(t *testing.T, testDB abstraction.Database) {
 		SetBody(string(bodyBytes[:])).
 		SetDebug(debug).
 		SetCookie(cookies).
+		SetHeader(csrfHeader(cookies)).
 		Run(server, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
 			assert.Equal(t, "", r.Body.String())
 			assert.Equal(t, 204, r.Code)
@@ -1916,6 +2069,7 @@ func CreateCommentReplyToAReply(t *testing.T, testDB abstraction.Database) {
 		SetBody(string(bodyBytes[:])).
 		SetDebug(debug).
 		SetCookie(cookies).
+		SetHeader(csrfHeader(cookies)).
 		Run(server, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
 			assert.Equal(t, "", r.Body.String())
 			assert.Equal(t, 204, r.Code)
@@ -1966,6 +2120,7 @@ func CreateCommentWithAuthorTooLongResultsInDefaultTruncation(t *testing.T, test
 		SetBody(string(bodyBytes[:])).
 		SetDebug(debug).
 		SetCookie(cookies).
+		SetHeader(csrfHeader(cookies)).
 		Run(server, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
 			assert.Equal(t, 200, r.Code)
 			var comments []dbmodel.Comment
@@ -2012,6 +2167,7 @@ func CreateCommentWithAuthorTooLongTrucatesAccodringToConfig(t *testing.T, testD
 		SetBody(string(bodyBytes[:])).
 		SetDebug(debug).
 		SetCookie(cookies).
+		SetHeader(csrfHeader(cookies)).
 		Run(server, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
 			assert.Equal(t, 200, r.Code)
 			var comments []dbmodel.Comment
@@ -2187,3 +2343,3710 @@ func OauthPathsExist(t *testing.T, testDB abstraction.Database) {
 			assert.Equal(t, 500, r.Code)
 		})
 }
+
+func streamComments(server http.Handler, uri string, lastEventId string) *httptest.ResponseRecorder {
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	req := httptest.NewRequest("GET", "/v1/comments/stream?uri="+url.QueryEscape(uri), nil).WithContext(ctx)
+	if lastEventId != "" {
+		req.Header.Set("Last-Event-ID", lastEventId)
+	}
+	w := httptest.NewRecorder()
+	server.ServeHTTP(w, req)
+	return w
+}
+
+func StreamCommentsDisabled(t *testing.T, testDB abstraction.Database) {
+	server, err := api.GetServer(&testDB, &config)
+	assert.Nil(t, err)
+	_, err = testDB.CreateThread("/stream/", nil)
+	assert.Nil(t, err)
+	w := streamComments(server, "/stream/", "")
+	assert.Equal(t, 404, w.Code)
+}
+
+func StreamCommentsBadQuery(t *testing.T, testDB abstraction.Database) {
+	configCopy := config
+	configCopy.API.Stream.Enabled = true
+	server, err := api.GetServer(&testDB, &configCopy)
+	assert.Nil(t, err)
+	w := streamComments(server, "", "")
+	assert.Equal(t, 400, w.Code)
+	_, err = testDB.CreateThread("/stream/", nil)
+	assert.Nil(t, err)
+	w = streamComments(server, "/stream/", "not-a-number")
+	assert.Equal(t, 400, w.Code)
+}
+
+func StreamCommentsNewThread(t *testing.T, testDB abstraction.Database) {
+	configCopy := config
+	configCopy.Moderation.Enabled = false
+	configCopy.API.Stream.Enabled = true
+	server, err := api.GetServer(&testDB, &configCopy)
+	assert.Nil(t, err)
+	commentBody := model.CreateCommentBody{
+		Path:   "/stream/new/",
+		Body:   "first",
+		Author: "author",
+	}
+	bodyBytes, err := json.Marshal(commentBody)
+	assert.Nil(t, err)
+	// the thread gets created by the first comment, posted while it's being streamed
+	posted := make(chan string)
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		var commentId string
+		gofight.New().POST("/v1/comments").
+			SetBody(string(bodyBytes[:])).
+			SetDebug(debug).
+			Run(server, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+				assert.Equal(t, 200, r.Code)
+				var parsedBody model.CreateCommentResponse
+				err := json.Unmarshal(r.Body.Bytes(), &parsedBody)
+				assert.Nil(t, err)
+				commentId = parsedBody.Id
+			})
+		posted <- commentId
+	}()
+	w := streamComments(server, "/stream/new/", "")
+	commentId := <-posted
+	assert.Equal(t, 200, w.Code)
+	assert.NotEqual(t, "", commentId)
+	assert.Contains(t, w.Body.String(), commentId)
+}
+
+func StreamCommentsResumesFromLastEventId(t *testing.T, testDB abstraction.Database) {
+	configCopy := config
+	configCopy.Moderation.Enabled = false
+	configCopy.API.Stream.Enabled = true
+	server, err := api.GetServer(&testDB, &configCopy)
+	assert.Nil(t, err)
+	r := gofight.New()
+	ids := make([]string, 0)
+	for _, body := range []string{"first", "second"} {
+		commentBody := model.CreateCommentBody{
+			Path:   "/stream/",
+			Body:   body,
+			Author: "author",
+		}
+		bodyBytes, err := json.Marshal(commentBody)
+		assert.Nil(t, err)
+		r.POST("/v1/comments").
+			SetBody(string(bodyBytes[:])).
+			SetDebug(debug).
+			Run(server, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+				assert.Equal(t, 200, r.Code)
+				var parsedBody model.CreateCommentResponse
+				err = json.Unmarshal(r.Body.Bytes(), &parsedBody)
+				assert.Nil(t, err)
+				ids = append(ids, parsedBody.Id)
+			})
+	}
+	w := streamComments(server, "/stream", "1")
+	assert.Equal(t, 200, w.Code)
+	assert.Equal(t, "text/event-stream", w.Header().Get("Content-Type"))
+	assert.NotContains(t, w.Body.String(), ids[0])
+	assert.Contains(t, w.Body.String(), "id: 2\nevent: comment\ndata: ")
+	assert.Contains(t, w.Body.String(), ids[1])
+
+	w = streamComments(server, "/stream", "")
+	assert.Equal(t, 200, w.Code)
+	assert.NotContains(t, w.Body.String(), "event: comment")
+}
+
+func StreamCommentsPublishesApprovedComments(t *testing.T, testDB abstraction.Database) {
+	configCopy := config
+	configCopy.API.Stream.Enabled = true
+	server, err := api.GetServer(&testDB, &configCopy)
+	assert.Nil(t, err)
+	r := gofight.New()
+	commentBody := model.CreateCommentBody{
+		Path:   "/stream/",
+		Body:   "body",
+		Author: "author",
+	}
+	bodyBytes, err := json.Marshal(commentBody)
+	assert.Nil(t, err)
+	var commentId string
+	r.POST("/v1/comments").
+		SetBody(string(bodyBytes[:])).
+		SetDebug(debug).
+		Run(server, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+			assert.Equal(t, 200, r.Code)
+			var parsedBody model.CreateCommentResponse
+			err = json.Unmarshal(r.Body.Bytes(), &parsedBody)
+			assert.Nil(t, err)
+			commentId = parsedBody.Id
+		})
+
+	// unconfirmed comments are not published
+	w := streamComments(server, "/stream/", "0")
+	assert.Equal(t, 200, w.Code)
+	assert.NotContains(t, w.Body.String(), commentId)
+
+	cookies := GetSessionCookie(&testDB, r)
+	confirmed := true
+	bodyBytes, err = json.Marshal(model.UpdateCommentBody{
+		CommentId: commentId,
+		Confirmed: &confirmed,
+	})
+	assert.Nil(t, err)
+	r.PATCH("/v1/admin/comments").
+		SetBody(string(bodyBytes[:])).
+		SetDebug(debug).
+		SetCookie(cookies).
+		SetHeader(csrfHeader(cookies)).
+		Run(server, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+			assert.Equal(t, 204, r.Code)
+		})
+	w = streamComments(server, "/stream/", "0")
+	assert.Equal(t, 200, w.Code)
+	assert.Contains(t, w.Body.String(), "id: 1\nevent: comment\ndata: ")
+	assert.Contains(t, w.Body.String(), commentId)
+
+	// updating an already confirmed comment does not publish it again
+	r.PATCH("/v1/admin/comments").
+		SetBody(string(bodyBytes[:])).
+		SetDebug(debug).
+		SetCookie(cookies).
+		SetHeader(csrfHeader(cookies)).
+		Run(server, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+			assert.Equal(t, 204, r.Code)
+		})
+	w = streamComments(server, "/stream/", "1")
+	assert.Equal(t, 200, w.Code)
+	assert.NotContains(t, w.Body.String(), "event: comment")
+}
+
+var renderPublicURL = "https://comments.example.com/"
+
+func RenderRequiresPublicURL(t *testing.T, testDB abstraction.Database) {
+	configCopy := config
+	configCopy.API.Render.Enabled = true
+	_, err := api.GetServer(&testDB, &configCopy)
+	assert.NotNil(t, err)
+	invalid := "comments.example.com"
+	configCopy.API.Render.PublicURL = &invalid
+	_, err = api.GetServer(&testDB, &configCopy)
+	assert.NotNil(t, err)
+}
+
+func RenderCommentsBadQuery(t *testing.T, testDB abstraction.Database) {
+	configCopy := config
+	configCopy.API.Render.Enabled = true
+	configCopy.API.Render.PublicURL = &renderPublicURL
+	server, err := api.GetServer(&testDB, &configCopy)
+	assert.Nil(t, err)
+	r := gofight.New()
+	r.GET("/v1/render").
+		SetDebug(debug).
+		Run(server, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+			assert.Equal(t, 400, r.Code)
+		})
+	r.GET("/v1/render?uri=/test/&format=pdf").
+		SetDebug(debug).
+		Run(server, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+			assert.Equal(t, 400, r.Code)
+		})
+}
+
+func RenderCommentsDisabled(t *testing.T, testDB abstraction.Database) {
+	server, err := api.GetServer(&testDB, &config)
+	assert.Nil(t, err)
+	r := gofight.New()
+	r.GET("/v1/render?uri=/test/").
+		SetDebug(debug).
+		Run(server, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+			assert.Equal(t, 404, r.Code)
+		})
+	r.POST("/v1/comments/form").
+		SetForm(gofight.H{"path": "/test/", "author": "author", "body": "body"}).
+		SetDebug(debug).
+		Run(server, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+			assert.Equal(t, 404, r.Code)
+		})
+}
+
+func RenderCommentsEmptyThread(t *testing.T, testDB abstraction.Database) {
+	configCopy := config
+	configCopy.API.Render.Enabled = true
+	publicURL := "https://comments.example.com/"
+	configCopy.API.Render.PublicURL = &publicURL
+	server, err := api.GetServer(&testDB, &configCopy)
+	assert.Nil(t, err)
+	r := gofight.New()
+	r.GET("/v1/render?uri=/test").
+		SetDebug(debug).
+		Run(server, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+			assert.Equal(t, 200, r.Code)
+			assert.Equal(t, "text/html; charset=utf-8", r.HeaderMap.Get("Content-Type"))
+			body := r.Body.String()
+			assert.Contains(t, body, "No comments yet.")
+			assert.Contains(t, body, `action="https://comments.example.com/v1/comments/form"`)
+			assert.Contains(t, body, `name="path" value="/test/"`)
+			assert.Contains(t, body, "Comments are moderated")
+			assert.NotContains(t, body, "<html")
+		})
+}
+
+func RenderComments(t *testing.T, testDB abstraction.Database) {
+	configCopy := config
+	configCopy.Moderation.Enabled = false
+	configCopy.API.Render.Enabled = true
+	configCopy.API.Render.PublicURL = &renderPublicURL
+	server, err := api.GetServer(&testDB, &configCopy)
+	assert.Nil(t, err)
+	parentId, err := testDB.CreateComment(global.ParseAndSaniziteMarkdown("**parent**"), "<script>alert(1)</script>", "/render/", true, nil)
+	assert.Nil(t, err)
+	replyId, err := testDB.CreateComment(global.ParseAndSaniziteMarkdown("reply"), "replier", "/render/", true, parentId)
+	assert.Nil(t, err)
+	unconfirmedId, err := testDB.CreateComment(global.ParseAndSaniziteMarkdown("unconfirmed"), "author", "/render/", false, nil)
+	assert.Nil(t, err)
+	r := gofight.New()
+	r.GET("/v1/render?uri=/render/").
+		SetDebug(debug).
+		Run(server, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+			assert.Equal(t, 200, r.Code)
+			body := r.Body.String()
+			assert.Contains(t, body, "Comments (2)")
+			assert.Contains(t, body, `id="mouthful-comment-`+parentId.String()+`" itemscope itemtype="https://schema.org/Comment"`)
+			assert.Contains(t, body, `id="mouthful-comment-`+replyId.String()+`"`)
+			assert.Contains(t, body, "<strong>parent</strong>")
+			assert.Contains(t, body, `name="replyTo" value="`+parentId.String()+`"`)
+			assert.NotContains(t, body, unconfirmedId.String())
+			assert.NotContains(t, body, "<script>alert(1)</script>")
+			assert.Contains(t, body, `<script type="application/ld+json">`)
+			assert.Contains(t, body, `"@context":"https://schema.org"`)
+			assert.Contains(t, body, `"parentItem":{"@id":"#mouthful-comment-`+parentId.String()+`"}`)
+			assert.NotContains(t, body, "Comments are moderated")
+		})
+	r.GET("/v1/render?uri=/render/&format=page").
+		SetDebug(debug).
+		Run(server, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+			assert.Equal(t, 200, r.Code)
+			body := r.Body.String()
+			assert.True(t, strings.HasPrefix(body, "<!DOCTYPE html>"))
+			assert.Contains(t, body, `id="mouthful-comment-`+parentId.String()+`"`)
+		})
+}
+
+func CreateCommentFormRedirectsBack(t *testing.T, testDB abstraction.Database) {
+	configCopy := config
+	configCopy.Moderation.Enabled = false
+	configCopy.API.Render.Enabled = true
+	configCopy.API.Render.PublicURL = &renderPublicURL
+	server, err := api.GetServer(&testDB, &configCopy)
+	assert.Nil(t, err)
+	r := gofight.New()
+	r.POST("/v1/comments/form").
+		SetForm(gofight.H{"path": "/form", "author": "author", "body": "body", "email": "", "replyTo": ""}).
+		SetHeader(gofight.H{"Referer": "https://blog.example.com/form/"}).
+		SetDebug(debug).
+		Run(server, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+			assert.Equal(t, 303, r.Code)
+			assert.True(t, strings.HasPrefix(r.HeaderMap.Get("Location"), "https://blog.example.com/form/#mouthful-comment-"))
+		})
+	comments, err := testDB.GetCommentsByThread("/form/")
+	assert.Nil(t, err)
+	assert.Len(t, comments, 1)
+	assert.Equal(t, "author", comments[0].Author)
+
+	// a redirect on a different host than the referer is ignored
+	r.POST("/v1/comments/form").
+		SetForm(gofight.H{"path": "/form", "author": "author", "body": "body", "redirect": "https://evil.example.org/"}).
+		SetHeader(gofight.H{"Referer": "https://blog.example.com/"}).
+		SetDebug(debug).
+		Run(server, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+			assert.Equal(t, 303, r.Code)
+			assert.True(t, strings.HasPrefix(r.HeaderMap.Get("Location"), "https://blog.example.com/#mouthful-comment-"))
+		})
+	r.POST("/v1/comments/form").
+		SetForm(gofight.H{"path": "/form", "author": "author", "body": "body", "redirect": "https://blog.example.com/form/?page=2"}).
+		SetHeader(gofight.H{"Referer": "https://blog.example.com/"}).
+		SetDebug(debug).
+		Run(server, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+			assert.Equal(t, 303, r.Code)
+			assert.True(t, strings.HasPrefix(r.HeaderMap.Get("Location"), "https://blog.example.com/form/?page=2#mouthful-comment-"))
+		})
+}
+
+func CreateCommentFormModerated(t *testing.T, testDB abstraction.Database) {
+	configCopy := config
+	configCopy.API.Render.Enabled = true
+	configCopy.API.Render.PublicURL = &renderPublicURL
+	server, err := api.GetServer(&testDB, &configCopy)
+	assert.Nil(t, err)
+	r := gofight.New()
+	r.POST("/v1/comments/form").
+		SetForm(gofight.H{"path": "/form/", "author": "author", "body": "body"}).
+		SetDebug(debug).
+		Run(server, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+			assert.Equal(t, 303, r.Code)
+			assert.Equal(t, "https://comments.example.com/v1/render?format=page&uri=%2Fform%2F#mouthful-comments", r.HeaderMap.Get("Location"))
+		})
+	comments, err := testDB.GetAllComments()
+	assert.Nil(t, err)
+	assert.Len(t, comments, 1)
+	assert.False(t, comments[0].Confirmed)
+}
+
+func CreateCommentFormBadRequest(t *testing.T, testDB abstraction.Database) {
+	configCopy := config
+	configCopy.API.Render.Enabled = true
+	configCopy.API.Render.PublicURL = &renderPublicURL
+	server, err := api.GetServer(&testDB, &configCopy)
+	assert.Nil(t, err)
+	r := gofight.New()
+	r.POST("/v1/comments/form").
+		SetForm(gofight.H{"path": "/form/", "author": "", "body": "body"}).
+		SetHeader(gofight.H{"Referer": "https://blog.example.com/form/"}).
+		SetDebug(debug).
+		Run(server, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+			assert.Equal(t, 400, r.Code)
+			assert.Equal(t, "text/html; charset=utf-8", r.HeaderMap.Get("Content-Type"))
+			assert.Contains(t, r.Body.String(), global.ErrBadRequest.Error())
+			assert.Contains(t, r.Body.String(), `href="https://blog.example.com/form/"`)
+		})
+	comments, err := testDB.GetAllComments()
+	assert.Nil(t, err)
+	assert.Len(t, comments, 0)
+}
+
+func GetCommentsConditional(t *testing.T, testDB abstraction.Database) {
+	server, err := api.GetServer(&testDB, &config)
+	assert.Nil(t, err)
+	_, err = testDB.CreateComment("body", "author", "/conditional/", true, nil)
+	assert.Nil(t, err)
+	r := gofight.New()
+	var etag string
+	r.GET("/v1/comments?uri=/conditional/").
+		SetDebug(debug).
+		Run(server, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+			assert.Equal(t, 200, r.Code)
+			etag = r.HeaderMap.Get("ETag")
+			assert.NotEmpty(t, etag)
+			assert.False(t, strings.HasPrefix(etag, "W/"))
+			// the edits of the comments would leave it stale
+			assert.Empty(t, r.HeaderMap.Get("Last-Modified"))
+			assert.Equal(t, "no-cache", r.HeaderMap.Get("Cache-Control"))
+			assert.Empty(t, r.HeaderMap.Get("Surrogate-Key"))
+		})
+	r.GET("/v1/comments?uri=/conditional/").
+		SetHeader(gofight.H{"If-None-Match": etag}).
+		SetDebug(debug).
+		Run(server, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+			assert.Equal(t, 304, r.Code)
+			assert.Empty(t, r.Body.String())
+			assert.Equal(t, etag, r.HeaderMap.Get("ETag"))
+		})
+	r.GET("/v1/comments?uri=/conditional/").
+		SetHeader(gofight.H{"If-None-Match": `"something-else", W/` + etag}).
+		SetDebug(debug).
+		Run(server, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+			assert.Equal(t, 304, r.Code)
+		})
+	r.GET("/v1/comments?uri=/conditional/").
+		SetHeader(gofight.H{"If-Modified-Since": time.Now().Add(time.Hour).UTC().Format(http.TimeFormat)}).
+		SetDebug(debug).
+		Run(server, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+			assert.Equal(t, 200, r.Code)
+		})
+
+	// a new comment changes the etag
+	anotherId, err := testDB.CreateComment("another body", "author", "/conditional/", true, nil)
+	assert.Nil(t, err)
+	r.GET("/v1/comments?uri=/conditional/").
+		SetHeader(gofight.H{"If-None-Match": etag}).
+		SetDebug(debug).
+		Run(server, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+			assert.Equal(t, 200, r.Code)
+			assert.NotEqual(t, etag, r.HeaderMap.Get("ETag"))
+			etag = r.HeaderMap.Get("ETag")
+			var comments []dbmodel.Comment
+			err = json.Unmarshal(r.Body.Bytes(), &comments)
+			assert.Nil(t, err)
+			assert.Len(t, comments, 2)
+		})
+
+	// and so does an edit, which leaves the creation times as they were
+	err = testDB.UpdateComment(*anotherId, "edited body", "author", true)
+	assert.Nil(t, err)
+	r.GET("/v1/comments?uri=/conditional/").
+		SetHeader(gofight.H{"If-None-Match": etag}).
+		SetDebug(debug).
+		Run(server, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+			assert.Equal(t, 200, r.Code)
+			assert.NotEqual(t, etag, r.HeaderMap.Get("ETag"))
+			assert.Contains(t, r.Body.String(), "edited body")
+		})
+}
+
+func GetCommentsCacheHeaders(t *testing.T, testDB abstraction.Database) {
+	configCopy := config
+	configCopy.API.Cache.Enabled = true
+	cacheControl := "public, max-age=0, s-maxage=86400"
+	configCopy.API.CacheHeaders = configModel.CacheHeaders{
+		CacheControl:  &cacheControl,
+		SurrogateKeys: true,
+	}
+	server, err := api.GetServer(&testDB, &configCopy)
+	assert.Nil(t, err)
+	_, err = testDB.CreateComment("body", "author", "/conditional/", true, nil)
+	assert.Nil(t, err)
+	thread, err := testDB.GetThread("/conditional/")
+	assert.Nil(t, err)
+	r := gofight.New()
+	var etag string
+	r.GET("/v1/comments?uri=/conditional/").
+		SetDebug(debug).
+		Run(server, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+			assert.Equal(t, 200, r.Code)
+			assert.Equal(t, "MISS", r.HeaderMap.Get("X-Cache"))
+			assert.Equal(t, cacheControl, r.HeaderMap.Get("Cache-Control"))
+			assert.Equal(t, "mouthful "+api.ThreadSurrogateKey(thread.Id.String()), r.HeaderMap.Get("Surrogate-Key"))
+			etag = r.HeaderMap.Get("ETag")
+		})
+	r.GET("/v1/comments?uri=/conditional/").
+		SetHeader(gofight.H{"If-None-Match": etag}).
+		SetDebug(debug).
+		Run(server, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+			assert.Equal(t, 304, r.Code)
+			assert.Equal(t, "HIT", r.HeaderMap.Get("X-Cache"))
+			assert.Equal(t, etag, r.HeaderMap.Get("ETag"))
+			assert.Equal(t, cacheControl, r.HeaderMap.Get("Cache-Control"))
+			assert.Equal(t, "mouthful "+api.ThreadSurrogateKey(thread.Id.String()), r.HeaderMap.Get("Surrogate-Key"))
+		})
+}
+
+func GetCommentsCompressed(t *testing.T, testDB abstraction.Database) {
+	configCopy := config
+	configCopy.API.Compression.Enabled = true
+	server, err := api.GetServer(&testDB, &configCopy)
+	assert.Nil(t, err)
+	_, err = testDB.CreateComment(strings.Repeat("body ", 100), "author", "/compressed/", true, nil)
+	assert.Nil(t, err)
+	r := gofight.New()
+	var gzipETag, identityETag string
+	r.GET("/v1/comments?uri=/compressed/").
+		SetHeader(gofight.H{"Accept-Encoding": "gzip"}).
+		SetDebug(debug).
+		Run(server, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+			assert.Equal(t, 200, r.Code)
+			assert.Equal(t, "gzip", r.HeaderMap.Get("Content-Encoding"))
+			assert.Equal(t, "Accept-Encoding", r.HeaderMap.Get("Vary"))
+			gzipETag = r.HeaderMap.Get("ETag")
+			assert.True(t, strings.HasSuffix(gzipETag, `-gzip"`))
+			reader, err := gzip.NewReader(r.Body)
+			assert.Nil(t, err)
+			body, err := ioutil.ReadAll(reader)
+			assert.Nil(t, err)
+			var comments []dbmodel.Comment
+			err = json.Unmarshal(body, &comments)
+			assert.Nil(t, err)
+			assert.Len(t, comments, 1)
+		})
+	r.GET("/v1/comments?uri=/compressed/").
+		SetHeader(gofight.H{"Accept-Encoding": "identity"}).
+		SetDebug(debug).
+		Run(server, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+			assert.Equal(t, 200, r.Code)
+			assert.Equal(t, "", r.HeaderMap.Get("Content-Encoding"))
+			identityETag = r.HeaderMap.Get("ETag")
+			assert.Equal(t, strings.TrimSuffix(gzipETag, `-gzip"`)+`"`, identityETag)
+			var comments []dbmodel.Comment
+			err = json.Unmarshal(r.Body.Bytes(), &comments)
+			assert.Nil(t, err)
+			assert.Len(t, comments, 1)
+		})
+
+	// either etag revalidates, the 304 carrying the one of the negotiated encoding
+	r.GET("/v1/comments?uri=/compressed/").
+		SetHeader(gofight.H{"Accept-Encoding": "gzip", "If-None-Match": identityETag}).
+		SetDebug(debug).
+		Run(server, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+			assert.Equal(t, 304, r.Code)
+			assert.Equal(t, gzipETag, r.HeaderMap.Get("ETag"))
+		})
+	r.GET("/v1/comments?uri=/compressed/").
+		SetHeader(gofight.H{"Accept-Encoding": "identity", "If-None-Match": gzipETag}).
+		SetDebug(debug).
+		Run(server, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+			assert.Equal(t, 304, r.Code)
+			assert.Equal(t, identityETag, r.HeaderMap.Get("ETag"))
+		})
+}
+
+func GetCommentsPrecompressedCacheHit(t *testing.T, testDB abstraction.Database) {
+	configCopy := config
+	configCopy.API.Cache.Enabled = true
+	configCopy.API.Compression.Enabled = true
+	server, err := api.GetServer(&testDB, &configCopy)
+	assert.Nil(t, err)
+	_, err = testDB.CreateComment("body", "author", "/precompressed/", true, nil)
+	assert.Nil(t, err)
+	r := gofight.New()
+	var plain []byte
+	r.GET("/v1/comments?uri=/precompressed/").
+		SetDebug(debug).
+		Run(server, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+			assert.Equal(t, 200, r.Code)
+			assert.Equal(t, "MISS", r.HeaderMap.Get("X-Cache"))
+			plain = r.Body.Bytes()
+		})
+	for _, encoding := range []string{"br", "gzip"} {
+		r.GET("/v1/comments?uri=/precompressed/").
+			SetHeader(gofight.H{"Accept-Encoding": encoding}).
+			SetDebug(debug).
+			Run(server, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+				assert.Equal(t, 200, r.Code)
+				assert.Equal(t, "HIT", r.HeaderMap.Get("X-Cache"))
+				assert.Equal(t, encoding, r.HeaderMap.Get("Content-Encoding"))
+				assert.True(t, strings.HasSuffix(r.HeaderMap.Get("ETag"), "-"+encoding+`"`))
+				assert.Equal(t, []string{"Accept-Encoding"}, r.HeaderMap["Vary"])
+				var reader io.Reader = brotli.NewReader(r.Body)
+				if encoding == "gzip" {
+					reader, err = gzip.NewReader(r.Body)
+					assert.Nil(t, err)
+				}
+				body, err := ioutil.ReadAll(reader)
+				assert.Nil(t, err)
+				assert.Equal(t, plain, body)
+			})
+	}
+}
+
+func GetCommentBadId(t *testing.T, testDB abstraction.Database) {
+	server, err := api.GetServer(&testDB, &config)
+	assert.Nil(t, err)
+	r := gofight.New()
+	r.GET("/v1/comments/not-a-uuid").
+		SetDebug(debug).
+		Run(server, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+			assert.Equal(t, 404, r.Code)
+			assert.Equal(t, `"`+global.ErrCommentNotFound.Error()+`"`, r.Body.String())
+		})
+}
+
+func GetCommentNotFound(t *testing.T, testDB abstraction.Database) {
+	server, err := api.GetServer(&testDB, &config)
+	assert.Nil(t, err)
+	r := gofight.New()
+	r.GET("/v1/comments/"+global.GetUUID().String()).
+		SetDebug(debug).
+		Run(server, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+			assert.Equal(t, 404, r.Code)
+			assert.Equal(t, `"`+global.ErrCommentNotFound.Error()+`"`, r.Body.String())
+		})
+}
+
+func GetCommentHidesPendingAndDeleted(t *testing.T, testDB abstraction.Database) {
+	server, err := api.GetServer(&testDB, &config)
+	assert.Nil(t, err)
+	pending, err := testDB.CreateComment("body", "author", "/permalink/", false, nil)
+	assert.Nil(t, err)
+	deleted, err := testDB.CreateComment("body", "author", "/permalink/", true, nil)
+	assert.Nil(t, err)
+	err = testDB.DeleteComment(*deleted)
+	assert.Nil(t, err)
+	r := gofight.New()
+	for _, id := range []string{pending.String(), deleted.String()} {
+		r.GET("/v1/comments/"+id).
+			SetDebug(debug).
+			Run(server, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+				assert.Equal(t, 404, r.Code)
+				assert.Equal(t, `"`+global.ErrCommentNotFound.Error()+`"`, r.Body.String())
+			})
+	}
+}
+
+func GetCommentWithContext(t *testing.T, testDB abstraction.Database) {
+	server, err := api.GetServer(&testDB, &config)
+	assert.Nil(t, err)
+	parent, err := testDB.CreateComment("parent", "author", "/permalink/", true, nil)
+	assert.Nil(t, err)
+	reply, err := testDB.CreateComment("reply", "author", "/permalink/", true, parent)
+	assert.Nil(t, err)
+	_, err = testDB.CreateComment("pending reply", "author", "/permalink/", false, parent)
+	assert.Nil(t, err)
+	_, err = testDB.CreateComment("unrelated", "author", "/permalink/", true, nil)
+	assert.Nil(t, err)
+	r := gofight.New()
+	r.GET("/v1/comments/"+parent.String()).
+		SetDebug(debug).
+		Run(server, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+			assert.Equal(t, 200, r.Code)
+			var response model.GetCommentResponse
+			err := json.Unmarshal(r.Body.Bytes(), &response)
+			assert.Nil(t, err)
+			assert.Equal(t, "/permalink/", response.Path)
+			assert.Equal(t, *parent, response.Comment.Id)
+			assert.Nil(t, response.Parent)
+			assert.Len(t, response.Replies, 1)
+			assert.Equal(t, *reply, response.Replies[0].Id)
+		})
+	r.GET("/v1/comments/"+reply.String()).
+		SetDebug(debug).
+		Run(server, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+			assert.Equal(t, 200, r.Code)
+			var response model.GetCommentResponse
+			err := json.Unmarshal(r.Body.Bytes(), &response)
+			assert.Nil(t, err)
+			assert.Equal(t, "/permalink/", response.Path)
+			assert.Equal(t, *reply, response.Comment.Id)
+			assert.NotNil(t, response.Parent)
+			assert.Equal(t, *parent, response.Parent.Id)
+			assert.Len(t, response.Replies, 0)
+		})
+}
+
+func getCommentBodies(t *testing.T, r gofight.HTTPResponse) []string {
+	var comments []dbmodel.Comment
+	err := json.Unmarshal(r.Body.Bytes(), &comments)
+	assert.Nil(t, err)
+	bodies := make([]string, 0, len(comments))
+	for _, v := range comments {
+		bodies = append(bodies, v.Body)
+	}
+	return bodies
+}
+
+func GetCommentsBadSortAndSince(t *testing.T, testDB abstraction.Database) {
+	server, err := api.GetServer(&testDB, &config)
+	assert.Nil(t, err)
+	_, err = testDB.CreateComment("body", "author", "/sorted/", true, nil)
+	assert.Nil(t, err)
+	r := gofight.New()
+	for _, query := range []string{"sort=top", "sort=random", "since=yesterday", "since=1500000000"} {
+		r.GET("/v1/comments?uri=/sorted/&"+query).
+			SetDebug(debug).
+			Run(server, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+				assert.Equal(t, 400, r.Code)
+			})
+	}
+}
+
+func GetCommentsSorted(t *testing.T, testDB abstraction.Database) {
+	server, err := api.GetServer(&testDB, &config)
+	assert.Nil(t, err)
+	_, err = testDB.CreateComment("first", "author", "/sorted/", true, nil)
+	assert.Nil(t, err)
+	second, err := testDB.CreateComment("second", "author", "/sorted/", true, nil)
+	assert.Nil(t, err)
+	_, err = testDB.CreateComment("reply", "author", "/sorted/", true, second)
+	assert.Nil(t, err)
+	expected := map[string][]string{
+		"":        {"first", "second", "reply"},
+		"oldest":  {"first", "second", "reply"},
+		"newest":  {"reply", "second", "first"},
+		"replies": {"second", "first", "reply"},
+	}
+	r := gofight.New()
+	for sort, bodies := range expected {
+		r.GET("/v1/comments?uri=/sorted/&sort="+sort).
+			SetDebug(debug).
+			Run(server, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+				assert.Equal(t, 200, r.Code)
+				assert.Equal(t, bodies, getCommentBodies(t, r))
+			})
+	}
+}
+
+func GetCommentsSince(t *testing.T, testDB abstraction.Database) {
+	server, err := api.GetServer(&testDB, &config)
+	assert.Nil(t, err)
+	_, err = testDB.CreateComment("first", "author", "/since/", true, nil)
+	assert.Nil(t, err)
+	_, err = testDB.CreateComment("second", "author", "/since/", true, nil)
+	assert.Nil(t, err)
+	comments, err := testDB.GetCommentsByThread("/since/")
+	assert.Nil(t, err)
+	r := gofight.New()
+	r.GET("/v1/comments?uri=/since/&since="+url.QueryEscape(comments[0].CreatedAt.Format(time.RFC3339Nano))).
+		SetDebug(debug).
+		Run(server, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+			assert.Equal(t, 200, r.Code)
+			assert.Equal(t, []string{"second"}, getCommentBodies(t, r))
+		})
+	// nothing new is not an error
+	r.GET("/v1/comments?uri=/since/&since="+url.QueryEscape(comments[1].CreatedAt.Format(time.RFC3339Nano))).
+		SetDebug(debug).
+		Run(server, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+			assert.Equal(t, 200, r.Code)
+			assert.Equal(t, "[]", r.Body.String())
+		})
+	r.GET("/v1/comments?uri=/nothing-here/&since="+url.QueryEscape(comments[1].CreatedAt.Format(time.RFC3339Nano))).
+		SetDebug(debug).
+		Run(server, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+			assert.Equal(t, 404, r.Code)
+		})
+}
+
+func GetCommentsQueryIsPartOfCacheKey(t *testing.T, testDB abstraction.Database) {
+	configCopy := config
+	configCopy.API.Cache.Enabled = true
+	server, err := api.GetServer(&testDB, &configCopy)
+	assert.Nil(t, err)
+	_, err = testDB.CreateComment("first", "author", "/cached-sort/", true, nil)
+	assert.Nil(t, err)
+	_, err = testDB.CreateComment("second", "author", "/cached-sort/", true, nil)
+	assert.Nil(t, err)
+	r := gofight.New()
+	r.GET("/v1/comments?uri=/cached-sort/").
+		SetDebug(debug).
+		Run(server, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+			assert.Equal(t, 200, r.Code)
+			assert.Equal(t, "MISS", r.HeaderMap.Get("X-Cache"))
+			assert.Equal(t, []string{"first", "second"}, getCommentBodies(t, r))
+		})
+	r.GET("/v1/comments?uri=/cached-sort/&sort=newest").
+		SetDebug(debug).
+		Run(server, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+			assert.Equal(t, 200, r.Code)
+			assert.Equal(t, "MISS", r.HeaderMap.Get("X-Cache"))
+			assert.Equal(t, []string{"second", "first"}, getCommentBodies(t, r))
+		})
+	r.GET("/v1/comments?uri=/cached-sort/&sort=oldest").
+		SetDebug(debug).
+		Run(server, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+			assert.Equal(t, 200, r.Code)
+			assert.Equal(t, "HIT", r.HeaderMap.Get("X-Cache"))
+			assert.Equal(t, []string{"first", "second"}, getCommentBodies(t, r))
+		})
+
+	// the incremental fetches are not cached at all
+	since := url.QueryEscape(time.Now().Add(-time.Hour).Format(time.RFC3339Nano))
+	for i := 0; i < 2; i++ {
+		r.GET("/v1/comments?uri=/cached-sort/&since="+since).
+			SetDebug(debug).
+			Run(server, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+				assert.Equal(t, 200, r.Code)
+				assert.Equal(t, "", r.HeaderMap.Get("X-Cache"))
+				assert.Equal(t, []string{"first", "second"}, getCommentBodies(t, r))
+			})
+	}
+}
+
+func ModerateCommentInvalidatesCache(t *testing.T, testDB abstraction.Database) {
+	configCopy := config
+	configCopy.API.Cache.Enabled = true
+	server, err := api.GetServer(&testDB, &configCopy)
+	assert.Nil(t, err)
+	cookies := GetSessionCookie(&testDB, gofight.New())
+	visible, err := testDB.CreateComment("visible", "author", "/moderated-cache/", true, nil)
+	assert.Nil(t, err)
+	pending, err := testDB.CreateComment("pending", "author", "/moderated-cache/", false, nil)
+	assert.Nil(t, err)
+	expectComments := func(cache string, bodies []string) {
+		gofight.New().GET("/v1/comments?uri=/moderated-cache/").
+			SetDebug(debug).
+			Run(server, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+				assert.Equal(t, 200, r.Code)
+				assert.Equal(t, cache, r.HeaderMap.Get("X-Cache"))
+				assert.Equal(t, bodies, getCommentBodies(t, r))
+			})
+	}
+	expectComments("MISS", []string{"visible"})
+	expectComments("HIT", []string{"visible"})
+
+	// approving, deleting and restoring a comment show up right away
+	confirmed := true
+	sendAdminRequest(t, server, cookies, csrfHeader(cookies), "PATCH", "/v1/admin/comments", model.UpdateCommentBody{CommentId: pending.String(), Confirmed: &confirmed}, 204)
+	expectComments("MISS", []string{"visible", "pending"})
+	sendAdminRequest(t, server, cookies, csrfHeader(cookies), "DELETE", "/v1/admin/comments", model.DeleteCommentBody{CommentId: visible.String()}, 204)
+	expectComments("MISS", []string{"pending"})
+	sendAdminRequest(t, server, cookies, csrfHeader(cookies), "POST", "/v1/admin/comments/restore", model.DeleteCommentBody{CommentId: visible.String()}, 204)
+	expectComments("MISS", []string{"visible", "pending"})
+	sendAdminRequest(t, server, cookies, csrfHeader(cookies), "DELETE", "/v1/admin/comments", model.DeleteCommentBody{CommentId: pending.String(), Hard: true}, 204)
+	expectComments("MISS", []string{"visible"})
+	sendAdminRequest(t, server, cookies, csrfHeader(cookies), "DELETE", "/v1/admin/comments", model.DeleteCommentBody{CommentId: pending.String(), Hard: true}, 404)
+}
+
+func setCommentFlag(t *testing.T, server http.Handler, r *gofight.RequestConfig, cookies gofight.H, action string, commentId string, expectedCode int) {
+	v, err := json.Marshal(model.CommentFlagBody{CommentId: commentId})
+	assert.Nil(t, err)
+	r.POST("/v1/admin/comments/"+action).
+		SetBody(string(v)).
+		SetCookie(cookies).
+		SetHeader(csrfHeader(cookies)).
+		SetDebug(debug).
+		Run(server, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+			assert.Equal(t, expectedCode, r.Code)
+		})
+}
+
+func PinCommentUnauthorized(t *testing.T, testDB abstraction.Database) {
+	server, err := api.GetServer(&testDB, &config)
+	assert.Nil(t, err)
+	uid, err := testDB.CreateComment("body", "author", "/pinned/", true, nil)
+	assert.Nil(t, err)
+	r := gofight.New()
+	for _, action := range []string{"pin", "unpin", "feature", "unfeature"} {
+		setCommentFlag(t, server, r, gofight.H{}, action, uid.String(), 401)
+	}
+}
+
+func PinCommentNotFound(t *testing.T, testDB abstraction.Database) {
+	server, err := api.GetServer(&testDB, &config)
+	assert.Nil(t, err)
+	r := gofight.New()
+	cookies := GetSessionCookie(&testDB, r)
+	for _, action := range []string{"pin", "unpin", "feature", "unfeature"} {
+		setCommentFlag(t, server, r, cookies, action, global.GetUUID().String(), 404)
+		setCommentFlag(t, server, r, cookies, action, "not-an-id", 400)
+	}
+}
+
+func PinCommentHidden(t *testing.T, testDB abstraction.Database) {
+	server, err := api.GetServer(&testDB, &config)
+	assert.Nil(t, err)
+	pending, err := testDB.CreateComment("body", "author", "/pinned/", false, nil)
+	assert.Nil(t, err)
+	r := gofight.New()
+	cookies := GetSessionCookie(&testDB, r)
+	setCommentFlag(t, server, r, cookies, "pin", pending.String(), 400)
+}
+
+func PinCommentMovesItFirst(t *testing.T, testDB abstraction.Database) {
+	server, err := api.GetServer(&testDB, &config)
+	assert.Nil(t, err)
+	_, err = testDB.CreateComment("first", "author", "/pinned/", true, nil)
+	assert.Nil(t, err)
+	second, err := testDB.CreateComment("second", "author", "/pinned/", true, nil)
+	assert.Nil(t, err)
+	r := gofight.New()
+	cookies := GetSessionCookie(&testDB, r)
+	setCommentFlag(t, server, r, cookies, "pin", second.String(), 204)
+	r.GET("/v1/comments?uri=/pinned/").
+		SetDebug(debug).
+		Run(server, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+			assert.Equal(t, 200, r.Code)
+			assert.Equal(t, []string{"second", "first"}, getCommentBodies(t, r))
+		})
+	setCommentFlag(t, server, r, cookies, "unpin", second.String(), 204)
+	r.GET("/v1/comments?uri=/pinned/").
+		SetDebug(debug).
+		Run(server, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+			assert.Equal(t, 200, r.Code)
+			assert.Equal(t, []string{"first", "second"}, getCommentBodies(t, r))
+		})
+}
+
+func PinCommentLimit(t *testing.T, testDB abstraction.Database) {
+	configCopy := config
+	maxPins := 1
+	configCopy.Moderation.MaxPinsPerThread = &maxPins
+	server, err := api.GetServer(&testDB, &configCopy)
+	assert.Nil(t, err)
+	first, err := testDB.CreateComment("first", "author", "/pinned/", true, nil)
+	assert.Nil(t, err)
+	second, err := testDB.CreateComment("second", "author", "/pinned/", true, nil)
+	assert.Nil(t, err)
+	other, err := testDB.CreateComment("other", "author", "/pinned-elsewhere/", true, nil)
+	assert.Nil(t, err)
+	r := gofight.New()
+	cookies := GetSessionCookie(&testDB, r)
+	setCommentFlag(t, server, r, cookies, "pin", first.String(), 204)
+	// pinning again does not count towards the limit
+	setCommentFlag(t, server, r, cookies, "pin", first.String(), 204)
+	setCommentFlag(t, server, r, cookies, "pin", second.String(), 409)
+	// the limit is per thread
+	setCommentFlag(t, server, r, cookies, "pin", other.String(), 204)
+	setCommentFlag(t, server, r, cookies, "unpin", first.String(), 204)
+	setCommentFlag(t, server, r, cookies, "pin", second.String(), 204)
+}
+
+func PinCommentLimitConcurrent(t *testing.T, testDB abstraction.Database) {
+	configCopy := config
+	maxPins := 1
+	configCopy.Moderation.MaxPinsPerThread = &maxPins
+	server, err := api.GetServer(&testDB, &configCopy)
+	assert.Nil(t, err)
+	cookies := GetSessionCookie(&testDB, gofight.New())
+	ids := make([]string, 0)
+	for i := 0; i < 5; i++ {
+		uid, err := testDB.CreateComment("body", "author", "/pinned-at-once/", true, nil)
+		assert.Nil(t, err)
+		ids = append(ids, uid.String())
+	}
+
+	// the pins sent at once can't all get past the limit
+	codes := make(chan int, len(ids))
+	start := make(chan struct{})
+	var wg sync.WaitGroup
+	for _, id := range ids {
+		wg.Add(1)
+		go func(id string) {
+			defer wg.Done()
+			v, err := json.Marshal(model.CommentFlagBody{CommentId: id})
+			assert.Nil(t, err)
+			<-start
+			gofight.New().POST("/v1/admin/comments/pin").
+				SetBody(string(v)).
+				SetCookie(cookies).
+				SetHeader(csrfHeader(cookies)).
+				SetDebug(debug).
+				Run(server, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+					codes <- r.Code
+				})
+		}(id)
+	}
+	close(start)
+	wg.Wait()
+	close(codes)
+	counts := make(map[int]int)
+	for code := range codes {
+		counts[code]++
+	}
+	assert.Equal(t, 1, counts[204])
+	assert.Equal(t, len(ids)-1, counts[409])
+}
+
+func PinCommentInvalidatesCache(t *testing.T, testDB abstraction.Database) {
+	configCopy := config
+	configCopy.API.Cache.Enabled = true
+	server, err := api.GetServer(&testDB, &configCopy)
+	assert.Nil(t, err)
+	_, err = testDB.CreateComment("first", "author", "/pinned-cache/", true, nil)
+	assert.Nil(t, err)
+	second, err := testDB.CreateComment("second", "author", "/pinned-cache/", true, nil)
+	assert.Nil(t, err)
+	r := gofight.New()
+	cookies := GetSessionCookie(&testDB, r)
+	expectComments := func(cache string, bodies []string, featured bool) {
+		gofight.New().GET("/v1/comments?uri=/pinned-cache/").
+			SetDebug(debug).
+			Run(server, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+				assert.Equal(t, 200, r.Code)
+				assert.Equal(t, cache, r.HeaderMap.Get("X-Cache"))
+				assert.Equal(t, bodies, getCommentBodies(t, r))
+				var comments []dbmodel.Comment
+				err := json.Unmarshal(r.Body.Bytes(), &comments)
+				assert.Nil(t, err)
+				assert.Equal(t, featured, comments[0].Featured)
+			})
+	}
+	expectComments("MISS", []string{"first", "second"}, false)
+	expectComments("HIT", []string{"first", "second"}, false)
+
+	// the pins and the featured flags show up right away
+	setCommentFlag(t, server, r, cookies, "pin", second.String(), 204)
+	expectComments("MISS", []string{"second", "first"}, false)
+	setCommentFlag(t, server, r, cookies, "feature", second.String(), 204)
+	expectComments("MISS", []string{"second", "first"}, true)
+	setCommentFlag(t, server, r, cookies, "unfeature", second.String(), 204)
+	expectComments("MISS", []string{"second", "first"}, false)
+	setCommentFlag(t, server, r, cookies, "unpin", second.String(), 204)
+	expectComments("MISS", []string{"first", "second"}, false)
+}
+
+func FeatureComment(t *testing.T, testDB abstraction.Database) {
+	server, err := api.GetServer(&testDB, &config)
+	assert.Nil(t, err)
+	uid, err := testDB.CreateComment("body", "author", "/featured/", true, nil)
+	assert.Nil(t, err)
+	r := gofight.New()
+	cookies := GetSessionCookie(&testDB, r)
+	setCommentFlag(t, server, r, cookies, "feature", uid.String(), 204)
+	comment, err := testDB.GetComment(*uid)
+	assert.Nil(t, err)
+	assert.True(t, comment.Featured)
+	assert.False(t, comment.Pinned)
+	setCommentFlag(t, server, r, cookies, "unfeature", uid.String(), 204)
+	comment, err = testDB.GetComment(*uid)
+	assert.Nil(t, err)
+	assert.False(t, comment.Featured)
+}
+
+func CreateStaffCommentUnauthorized(t *testing.T, testDB abstraction.Database) {
+	server, err := api.GetServer(&testDB, &config)
+	assert.Nil(t, err)
+	bodyBytes, err := json.Marshal(model.CreateCommentBody{Path: "/staff/", Body: "body", Author: "Site Owner"})
+	assert.Nil(t, err)
+	r := gofight.New()
+	r.POST("/v1/admin/comments").
+		SetBody(string(bodyBytes)).
+		SetDebug(debug).
+		Run(server, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+			assert.Equal(t, 401, r.Code)
+		})
+}
+
+func CreateStaffComment(t *testing.T, testDB abstraction.Database) {
+	configCopy := config
+	staffNames := []string{"Site Owner"}
+	configCopy.Moderation.StaffNames = &staffNames
+	configCopy.Honeypot = true
+	server, err := api.GetServer(&testDB, &configCopy)
+	assert.Nil(t, err)
+	email := "not a bot"
+	bodyBytes, err := json.Marshal(model.CreateCommentBody{Path: "/staff/", Body: "body", Author: "Site Owner", Email: &email})
+	assert.Nil(t, err)
+	r := gofight.New()
+	cookies := GetSessionCookie(&testDB, r)
+	var commentId string
+	r.POST("/v1/admin/comments").
+		SetBody(string(bodyBytes)).
+		SetCookie(cookies).
+		SetHeader(csrfHeader(cookies)).
+		SetDebug(debug).
+		Run(server, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+			assert.Equal(t, 200, r.Code)
+			var response model.CreateCommentResponse
+			err := json.Unmarshal(r.Body.Bytes(), &response)
+			assert.Nil(t, err)
+			assert.True(t, response.Staff)
+			assert.Equal(t, "Site Owner", response.Author)
+			commentId = response.Id
+		})
+	// moderation is enabled, but staff comments show up right away
+	r.GET("/v1/comments?uri=/staff/").
+		SetDebug(debug).
+		Run(server, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+			assert.Equal(t, 200, r.Code)
+			var comments []dbmodel.Comment
+			err := json.Unmarshal(r.Body.Bytes(), &comments)
+			assert.Nil(t, err)
+			assert.Len(t, comments, 1)
+			assert.Equal(t, commentId, comments[0].Id.String())
+			assert.True(t, comments[0].Staff)
+		})
+}
+
+func CreateCommentReservedStaffName(t *testing.T, testDB abstraction.Database) {
+	configCopy := config
+	staffNames := []string{"Site Owner"}
+	configCopy.Moderation.StaffNames = &staffNames
+	server, err := api.GetServer(&testDB, &configCopy)
+	assert.Nil(t, err)
+	r := gofight.New()
+	for _, author := range []string{"Site Owner", "site owner", "SiteOwner", " Site.Owner "} {
+		bodyBytes, err := json.Marshal(model.CreateCommentBody{Path: "/staff/", Body: "body", Author: author})
+		assert.Nil(t, err)
+		r.POST("/v1/comments").
+			SetBody(string(bodyBytes)).
+			SetDebug(debug).
+			Run(server, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+				assert.Equal(t, 403, r.Code)
+				assert.Equal(t, `"`+global.ErrReservedAuthorName.Error()+`"`, r.Body.String())
+			})
+	}
+	bodyBytes, err := json.Marshal(model.CreateCommentBody{Path: "/staff/", Body: "body", Author: "Site Owner Fan"})
+	assert.Nil(t, err)
+	r.POST("/v1/comments").
+		SetBody(string(bodyBytes)).
+		SetDebug(debug).
+		Run(server, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+			assert.Equal(t, 200, r.Code)
+			var response model.CreateCommentResponse
+			err := json.Unmarshal(r.Body.Bytes(), &response)
+			assert.Nil(t, err)
+			assert.False(t, response.Staff)
+		})
+}
+
+func setThreadState(t *testing.T, server http.Handler, r *gofight.RequestConfig, cookies gofight.H, action string, threadId string, expectedCode int) {
+	v, err := json.Marshal(model.ThreadFlagBody{ThreadId: threadId})
+	assert.Nil(t, err)
+	r.POST("/v1/admin/threads/"+action).
+		SetBody(string(v)).
+		SetCookie(cookies).
+		SetHeader(csrfHeader(cookies)).
+		SetDebug(debug).
+		Run(server, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+			assert.Equal(t, expectedCode, r.Code)
+		})
+}
+
+func postComment(t *testing.T, server http.Handler, r *gofight.RequestConfig, cookies gofight.H, route string, path string, expectedCode int) {
+	bodyBytes, err := json.Marshal(model.CreateCommentBody{Path: path, Body: "body", Author: "author"})
+	assert.Nil(t, err)
+	r.POST(route).
+		SetBody(string(bodyBytes)).
+		SetCookie(cookies).
+		SetHeader(csrfHeader(cookies)).
+		SetDebug(debug).
+		Run(server, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+			assert.Equal(t, expectedCode, r.Code)
+		})
+}
+
+func getThreadLockedHeader(t *testing.T, server http.Handler, r *gofight.RequestConfig, path string) string {
+	header := ""
+	r.GET("/v1/comments?uri="+path).
+		SetDebug(debug).
+		Run(server, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+			assert.Equal(t, 200, r.Code)
+			header = r.HeaderMap.Get("X-Thread-Locked")
+		})
+	return header
+}
+
+func LockThreadUnauthorizedAndNotFound(t *testing.T, testDB abstraction.Database) {
+	server, err := api.GetServer(&testDB, &config)
+	assert.Nil(t, err)
+	uid, err := testDB.CreateThread("/locked/", nil)
+	assert.Nil(t, err)
+	r := gofight.New()
+	for _, action := range []string{"lock", "unlock", "archive"} {
+		setThreadState(t, server, r, gofight.H{}, action, uid.String(), 401)
+	}
+	cookies := GetSessionCookie(&testDB, r)
+	for _, action := range []string{"lock", "unlock", "archive"} {
+		setThreadState(t, server, r, cookies, action, global.GetUUID().String(), 404)
+		setThreadState(t, server, r, cookies, action, "not-an-id", 400)
+	}
+}
+
+func LockThreadRejectsReaderComments(t *testing.T, testDB abstraction.Database) {
+	server, err := api.GetServer(&testDB, &config)
+	assert.Nil(t, err)
+	_, err = testDB.CreateComment("body", "author", "/locked/", true, nil)
+	assert.Nil(t, err)
+	thread, err := testDB.GetThread("/locked/")
+	assert.Nil(t, err)
+	r := gofight.New()
+	cookies := GetSessionCookie(&testDB, r)
+	assert.Equal(t, "false", getThreadLockedHeader(t, server, r, "/locked/"))
+	setThreadState(t, server, r, cookies, "lock", thread.Id.String(), 204)
+	assert.Equal(t, "true", getThreadLockedHeader(t, server, r, "/locked/"))
+	postComment(t, server, r, gofight.H{}, "/v1/comments", "/locked/", 403)
+	// the staff can still reply in locked threads
+	postComment(t, server, r, cookies, "/v1/admin/comments", "/locked/", 200)
+	// other threads are not affected
+	postComment(t, server, r, gofight.H{}, "/v1/comments", "/open/", 200)
+	setThreadState(t, server, r, cookies, "unlock", thread.Id.String(), 204)
+	assert.Equal(t, "false", getThreadLockedHeader(t, server, r, "/locked/"))
+	postComment(t, server, r, gofight.H{}, "/v1/comments", "/locked/", 200)
+}
+
+func ArchiveThreadRejectsStaffComments(t *testing.T, testDB abstraction.Database) {
+	configCopy := config
+	configCopy.API.Render.Enabled = true
+	configCopy.API.Render.PublicURL = &renderPublicURL
+	server, err := api.GetServer(&testDB, &configCopy)
+	assert.Nil(t, err)
+	_, err = testDB.CreateComment("body", "author", "/archived/", true, nil)
+	assert.Nil(t, err)
+	thread, err := testDB.GetThread("/archived/")
+	assert.Nil(t, err)
+	r := gofight.New()
+	cookies := GetSessionCookie(&testDB, r)
+	setThreadState(t, server, r, cookies, "archive", thread.Id.String(), 204)
+	assert.Equal(t, "true", getThreadLockedHeader(t, server, r, "/archived/"))
+	postComment(t, server, r, gofight.H{}, "/v1/comments", "/archived/", 403)
+	postComment(t, server, r, cookies, "/v1/admin/comments", "/archived/", 403)
+	r.GET("/v1/render?uri=/archived/").
+		SetDebug(debug).
+		Run(server, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+			assert.Equal(t, 200, r.Code)
+			assert.Contains(t, r.Body.String(), "Comments are closed.")
+			assert.NotContains(t, r.Body.String(), "<form")
+		})
+}
+
+func LockThreadInvalidatesCache(t *testing.T, testDB abstraction.Database) {
+	configCopy := config
+	configCopy.API.Cache.Enabled = true
+	server, err := api.GetServer(&testDB, &configCopy)
+	assert.Nil(t, err)
+	_, err = testDB.CreateComment("body", "author", "/locked/", true, nil)
+	assert.Nil(t, err)
+	thread, err := testDB.GetThread("/locked/")
+	assert.Nil(t, err)
+	r := gofight.New()
+	cookies := GetSessionCookie(&testDB, r)
+	etag := ""
+	r.GET("/v1/comments?uri=/locked/").
+		SetDebug(debug).
+		Run(server, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+			assert.Equal(t, 200, r.Code)
+			assert.Equal(t, "false", r.HeaderMap.Get("X-Thread-Locked"))
+			etag = r.HeaderMap.Get("ETag")
+		})
+	setThreadState(t, server, r, cookies, "lock", thread.Id.String(), 204)
+	r.GET("/v1/comments?uri=/locked/").
+		SetDebug(debug).
+		SetHeader(gofight.H{"If-None-Match": etag}).
+		Run(server, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+			assert.Equal(t, 200, r.Code)
+			assert.Equal(t, "MISS", r.HeaderMap.Get("X-Cache"))
+			assert.Equal(t, "true", r.HeaderMap.Get("X-Thread-Locked"))
+			assert.NotEqual(t, etag, r.HeaderMap.Get("ETag"))
+		})
+}
+
+func AutoCloseThread(t *testing.T, testDB abstraction.Database) {
+	configCopy := config
+	days := 30
+	configCopy.Moderation.AutoCloseAfterDays = &days
+	server, err := api.GetServer(&testDB, &configCopy)
+	assert.Nil(t, err)
+	old := dbmodel.Thread{Id: global.GetUUID(), Path: "/old/", CreatedAt: time.Now().Add(-31 * 24 * time.Hour), State: dbmodel.ThreadOpen}
+	comment := dbmodel.Comment{Id: global.GetUUID(), ThreadId: old.Id, Body: "body", Author: "author", Confirmed: true, CreatedAt: old.CreatedAt}
+	lines := make([]string, 0)
+	for _, v := range []interface{}{dbmodel.DataDump{ThreadCount: 1, CommentCount: 1}, old, comment} {
+		line, err := json.Marshal(v)
+		assert.Nil(t, err)
+		lines = append(lines, string(line))
+	}
+	f, err := ioutil.TempFile("", "mouthful_autoclose")
+	assert.Nil(t, err)
+	defer os.Remove(f.Name())
+	_, err = f.WriteString(strings.Join(lines, "\n"))
+	assert.Nil(t, err)
+	f.Close()
+	err = testDB.ImportData(f.Name())
+	assert.Nil(t, err)
+
+	r := gofight.New()
+	cookies := GetSessionCookie(&testDB, r)
+	assert.Equal(t, "true", getThreadLockedHeader(t, server, r, "/old/"))
+	postComment(t, server, r, gofight.H{}, "/v1/comments", "/old/", 403)
+	postComment(t, server, r, cookies, "/v1/admin/comments", "/old/", 200)
+	// new threads are open
+	postComment(t, server, r, gofight.H{}, "/v1/comments", "/new/", 200)
+	postComment(t, server, r, gofight.H{}, "/v1/comments", "/new/", 200)
+}
+
+func sendThreadRequest(t *testing.T, server http.Handler, r *gofight.RequestConfig, cookies gofight.H, method string, route string, body interface{}, expectedCode int) {
+	v, err := json.Marshal(body)
+	assert.Nil(t, err)
+	request := r.PATCH
+	switch method {
+	case "GET":
+		request = r.GET
+	case "POST":
+		request = r.POST
+	case "PUT":
+		request = r.PUT
+	case "DELETE":
+		request = r.DELETE
+	}
+	request(route).
+		SetBody(string(v)).
+		SetCookie(cookies).
+		SetHeader(csrfHeader(cookies)).
+		SetDebug(debug).
+		Run(server, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+			assert.Equal(t, expectedCode, r.Code)
+		})
+}
+
+func getAuditLog(t *testing.T, server http.Handler, r *gofight.RequestConfig, cookies gofight.H) []dbmodel.AuditEntry {
+	var entries []dbmodel.AuditEntry
+	r.GET("/v1/admin/audit").
+		SetCookie(cookies).
+		SetHeader(csrfHeader(cookies)).
+		SetDebug(debug).
+		Run(server, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+			assert.Equal(t, 200, r.Code)
+			err := json.Unmarshal(r.Body.Bytes(), &entries)
+			assert.Nil(t, err)
+		})
+	return entries
+}
+
+func ManageThreadsUnauthorized(t *testing.T, testDB abstraction.Database) {
+	server, err := api.GetServer(&testDB, &config)
+	assert.Nil(t, err)
+	uid, err := testDB.CreateThread("/managed/", nil)
+	assert.Nil(t, err)
+	r := gofight.New()
+	sendThreadRequest(t, server, r, gofight.H{}, "PATCH", "/v1/admin/threads", model.UpdateThreadBody{ThreadId: uid.String(), Path: "/new/"}, 401)
+	sendThreadRequest(t, server, r, gofight.H{}, "POST", "/v1/admin/threads/merge", model.MergeThreadsBody{ThreadId: uid.String(), TargetThreadId: uid.String()}, 401)
+	sendThreadRequest(t, server, r, gofight.H{}, "DELETE", "/v1/admin/threads", model.ThreadFlagBody{ThreadId: uid.String()}, 401)
+	r.GET("/v1/admin/audit").
+		SetDebug(debug).
+		Run(server, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+			assert.Equal(t, 401, r.Code)
+		})
+	_, err = testDB.GetThreadById(*uid)
+	assert.Nil(t, err)
+}
+
+func RenameThread(t *testing.T, testDB abstraction.Database) {
+	configCopy := config
+	configCopy.API.Cache.Enabled = true
+	server, err := api.GetServer(&testDB, &configCopy)
+	assert.Nil(t, err)
+	_, err = testDB.CreateComment("body", "author", "/old-slug/", true, nil)
+	assert.Nil(t, err)
+	thread, err := testDB.GetThread("/old-slug/")
+	assert.Nil(t, err)
+	r := gofight.New()
+	cookies := GetSessionCookie(&testDB, r)
+	// warm up the cache for the old path
+	r.GET("/v1/comments?uri=/old-slug/").
+		SetDebug(debug).
+		Run(server, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+			assert.Equal(t, 200, r.Code)
+		})
+	sendThreadRequest(t, server, r, cookies, "PATCH", "/v1/admin/threads", model.UpdateThreadBody{ThreadId: thread.Id.String(), Path: ""}, 400)
+	sendThreadRequest(t, server, r, cookies, "PATCH", "/v1/admin/threads", model.UpdateThreadBody{ThreadId: "not-an-id", Path: "/new-slug"}, 400)
+	sendThreadRequest(t, server, r, cookies, "PATCH", "/v1/admin/threads", model.UpdateThreadBody{ThreadId: global.GetUUID().String(), Path: "/new-slug"}, 404)
+	sendThreadRequest(t, server, r, cookies, "PATCH", "/v1/admin/threads", model.UpdateThreadBody{ThreadId: thread.Id.String(), Path: "/new-slug"}, 204)
+	r.GET("/v1/comments?uri=/old-slug/").
+		SetDebug(debug).
+		Run(server, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+			assert.Equal(t, 404, r.Code)
+		})
+	r.GET("/v1/comments?uri=/new-slug/").
+		SetDebug(debug).
+		Run(server, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+			assert.Equal(t, 200, r.Code)
+			assert.Equal(t, []string{"body"}, getCommentBodies(t, r))
+		})
+	entries := getAuditLog(t, server, r, cookies)
+	assert.Len(t, entries, 1)
+	assert.Equal(t, dbmodel.AuditThreadRename, entries[0].Action)
+	assert.Equal(t, thread.Id.String(), entries[0].Subject)
+	assert.Equal(t, "/old-slug/ -> /new-slug/", entries[0].Details)
+	assert.Equal(t, api.PasswordProvider+":"+api.PasswordUserId, entries[0].Actor)
+}
+
+func GetAuditLogPages(t *testing.T, testDB abstraction.Database) {
+	server, err := api.GetServer(&testDB, &config)
+	assert.Nil(t, err)
+	for i := 0; i < 3; i++ {
+		err = testDB.CreateAuditEntry(dbmodel.AuditThreadRename, "subject", strconv.Itoa(i), "password:admin", "127.0.0.1", nil)
+		assert.Nil(t, err)
+	}
+	r := gofight.New()
+	cookies := GetSessionCookie(&testDB, r)
+	getPage := func(query string, expectedCode int) []dbmodel.AuditEntry {
+		var entries []dbmodel.AuditEntry
+		r.GET("/v1/admin/audit"+query).
+			SetCookie(cookies).
+			SetDebug(debug).
+			Run(server, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+				assert.Equal(t, expectedCode, r.Code)
+				if r.Code == 200 {
+					err := json.Unmarshal(r.Body.Bytes(), &entries)
+					assert.Nil(t, err)
+				}
+			})
+		return entries
+	}
+	entries := getPage("?limit=2", 200)
+	assert.Len(t, entries, 2)
+	assert.Equal(t, "2", entries[0].Details)
+	last := entries[1]
+	entries = getPage("?limit=2&before="+url.QueryEscape(last.CreatedAt.Format(time.RFC3339Nano))+"&beforeId="+last.Id.String(), 200)
+	assert.Len(t, entries, 1)
+	assert.Equal(t, "0", entries[0].Details)
+	assert.Len(t, getPage("", 200), 3)
+
+	for _, query := range []string{"?limit=0", "?limit=1001", "?limit=many", "?before=yesterday", "?beforeId=" + last.Id.String(), "?before=" + url.QueryEscape(last.CreatedAt.Format(time.RFC3339Nano)) + "&beforeId=not-an-id"} {
+		getPage(query, 400)
+	}
+}
+
+func RenameThreadConflict(t *testing.T, testDB abstraction.Database) {
+	server, err := api.GetServer(&testDB, &config)
+	assert.Nil(t, err)
+	uid, err := testDB.CreateThread("/first/", nil)
+	assert.Nil(t, err)
+	_, err = testDB.CreateThread("/second/", nil)
+	assert.Nil(t, err)
+	r := gofight.New()
+	cookies := GetSessionCookie(&testDB, r)
+	sendThreadRequest(t, server, r, cookies, "PATCH", "/v1/admin/threads", model.UpdateThreadBody{ThreadId: uid.String(), Path: "/second/"}, 409)
+	assert.Len(t, getAuditLog(t, server, r, cookies), 0)
+}
+
+func MergeThreads(t *testing.T, testDB abstraction.Database) {
+	server, err := api.GetServer(&testDB, &config)
+	assert.Nil(t, err)
+	_, err = testDB.CreateComment("source", "author", "/source/", true, nil)
+	assert.Nil(t, err)
+	_, err = testDB.CreateComment("target", "author", "/target/", true, nil)
+	assert.Nil(t, err)
+	source, err := testDB.GetThread("/source/")
+	assert.Nil(t, err)
+	target, err := testDB.GetThread("/target/")
+	assert.Nil(t, err)
+	r := gofight.New()
+	cookies := GetSessionCookie(&testDB, r)
+	sendThreadRequest(t, server, r, cookies, "POST", "/v1/admin/threads/merge", model.MergeThreadsBody{ThreadId: source.Id.String(), TargetThreadId: source.Id.String()}, 400)
+	sendThreadRequest(t, server, r, cookies, "POST", "/v1/admin/threads/merge", model.MergeThreadsBody{ThreadId: source.Id.String(), TargetThreadId: global.GetUUID().String()}, 404)
+	sendThreadRequest(t, server, r, cookies, "POST", "/v1/admin/threads/merge", model.MergeThreadsBody{ThreadId: source.Id.String(), TargetThreadId: target.Id.String()}, 204)
+	r.GET("/v1/comments?uri=/target/").
+		SetDebug(debug).
+		Run(server, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+			assert.Equal(t, 200, r.Code)
+			assert.ElementsMatch(t, []string{"source", "target"}, getCommentBodies(t, r))
+		})
+	r.GET("/v1/comments?uri=/source/").
+		SetDebug(debug).
+		Run(server, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+			assert.Equal(t, 404, r.Code)
+		})
+	entries := getAuditLog(t, server, r, cookies)
+	assert.Len(t, entries, 1)
+	assert.Equal(t, dbmodel.AuditThreadMerge, entries[0].Action)
+	assert.Equal(t, source.Id.String(), entries[0].Subject)
+}
+
+func DeleteThread(t *testing.T, testDB abstraction.Database) {
+	server, err := api.GetServer(&testDB, &config)
+	assert.Nil(t, err)
+	_, err = testDB.CreateComment("body", "author", "/deleted/", true, nil)
+	assert.Nil(t, err)
+	thread, err := testDB.GetThread("/deleted/")
+	assert.Nil(t, err)
+	r := gofight.New()
+	cookies := GetSessionCookie(&testDB, r)
+	sendThreadRequest(t, server, r, cookies, "DELETE", "/v1/admin/threads", model.ThreadFlagBody{ThreadId: thread.Id.String()}, 204)
+	sendThreadRequest(t, server, r, cookies, "DELETE", "/v1/admin/threads", model.ThreadFlagBody{ThreadId: thread.Id.String()}, 404)
+	r.GET("/v1/comments?uri=/deleted/").
+		SetDebug(debug).
+		Run(server, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+			assert.Equal(t, 404, r.Code)
+		})
+	comments, err := testDB.GetAllComments()
+	assert.Nil(t, err)
+	assert.Len(t, comments, 0)
+	entries := getAuditLog(t, server, r, cookies)
+	assert.Len(t, entries, 1)
+	assert.Equal(t, dbmodel.AuditThreadDelete, entries[0].Action)
+	assert.Equal(t, "/deleted/", entries[0].Details)
+}
+
+func CreateCommentStoresThreadMetadata(t *testing.T, testDB abstraction.Database) {
+	configCopy := config
+	configCopy.API.Verification = configModel.Verification{CheckOrigin: true, AllowedOrigins: &[]string{"https://example.com", "https://other.com"}}
+	server, err := api.GetServer(&testDB, &configCopy)
+	assert.Nil(t, err)
+	r := gofight.New()
+	post := func(origin string, title string, url string) (response model.CreateCommentResponse) {
+		bodyBytes, err := json.Marshal(model.CreateCommentBody{Path: "/titled/", Body: "body", Author: "author", Title: &title, URL: &url})
+		assert.Nil(t, err)
+		r.POST("/v1/comments").
+			SetBody(string(bodyBytes)).
+			SetHeader(gofight.H{"Origin": origin}).
+			SetDebug(debug).
+			Run(server, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+				assert.Equal(t, 200, r.Code)
+				err := json.Unmarshal(r.Body.Bytes(), &response)
+				assert.Nil(t, err)
+			})
+		return response
+	}
+	// invalid urls are ignored
+	response := post("https://example.com", "  A post  ", "javascript:alert(1)")
+	assert.Equal(t, "A post", response.Title)
+	assert.Equal(t, "", response.URL)
+	// the first title stays, but the missing url gets filled in
+	response = post("https://example.com", "Another title", "https://example.com/titled/")
+	assert.Equal(t, "A post", response.Title)
+	assert.Equal(t, "https://example.com/titled/", response.URL)
+	thread, err := testDB.GetThread("/titled/")
+	assert.Nil(t, err)
+	assert.Equal(t, "A post", thread.Title)
+	assert.Equal(t, "https://example.com/titled/", thread.URL)
+}
+
+func CreateCommentIgnoresUnverifiedThreadMetadata(t *testing.T, testDB abstraction.Database) {
+	// without the origin check, anybody can post, but the title and url are not taken from them
+	server, err := api.GetServer(&testDB, &config)
+	assert.Nil(t, err)
+	r := gofight.New()
+	title := "Spam"
+	url := "https://spam.com/"
+	post := func(route string, cookies gofight.H, headers gofight.H) model.CreateCommentResponse {
+		var response model.CreateCommentResponse
+		bodyBytes, err := json.Marshal(model.CreateCommentBody{Path: "/untitled/", Body: "body", Author: "author", Title: &title, URL: &url})
+		assert.Nil(t, err)
+		r.POST(route).
+			SetBody(string(bodyBytes)).
+			SetCookie(cookies).
+			SetHeader(headers).
+			SetDebug(debug).
+			Run(server, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+				assert.Equal(t, 200, r.Code)
+				err := json.Unmarshal(r.Body.Bytes(), &response)
+				assert.Nil(t, err)
+			})
+		return response
+	}
+	response := post("/v1/comments", gofight.H{}, gofight.H{"Origin": "https://spam.com"})
+	assert.Equal(t, "", response.Title)
+	assert.Equal(t, "", response.URL)
+	thread, err := testDB.GetThread("/untitled/")
+	assert.Nil(t, err)
+	assert.Equal(t, "", thread.Title)
+
+	// the staff posts are trusted
+	title = "A post"
+	url = "https://example.com/untitled/"
+	cookies := GetSessionCookie(&testDB, gofight.New())
+	response = post("/v1/admin/comments", cookies, csrfHeader(cookies))
+	assert.Equal(t, "A post", response.Title)
+	assert.Equal(t, "https://example.com/untitled/", response.URL)
+
+	// and the admins can change them later
+	sendThreadRequest(t, server, gofight.New(), cookies, "PUT", "/v1/admin/threads/metadata", model.ThreadMetadataBody{ThreadId: thread.Id.String(), Title: "Renamed", URL: url}, 204)
+	thread, err = testDB.GetThread("/untitled/")
+	assert.Nil(t, err)
+	assert.Equal(t, "Renamed", thread.Title)
+}
+
+func UpdateThreadMetadata(t *testing.T, testDB abstraction.Database) {
+	server, err := api.GetServer(&testDB, &config)
+	assert.Nil(t, err)
+	uid, err := testDB.CreateThread("/metadata/", nil)
+	assert.Nil(t, err)
+	r := gofight.New()
+	moderation := false
+	maxCommentLength := 10
+	body := model.ThreadMetadataBody{ThreadId: uid.String(), Title: "Title", URL: "https://example.com/metadata/", Moderation: &moderation, MaxCommentLength: &maxCommentLength}
+	sendThreadRequest(t, server, r, gofight.H{}, "PUT", "/v1/admin/threads/metadata", body, 401)
+	cookies := GetSessionCookie(&testDB, r)
+	sendThreadRequest(t, server, r, cookies, "PUT", "/v1/admin/threads/metadata", model.ThreadMetadataBody{ThreadId: global.GetUUID().String()}, 404)
+	sendThreadRequest(t, server, r, cookies, "PUT", "/v1/admin/threads/metadata", model.ThreadMetadataBody{ThreadId: uid.String(), URL: "not a url"}, 400)
+	negative := -1
+	sendThreadRequest(t, server, r, cookies, "PUT", "/v1/admin/threads/metadata", model.ThreadMetadataBody{ThreadId: uid.String(), MaxCommentLength: &negative}, 400)
+	sendThreadRequest(t, server, r, cookies, "PUT", "/v1/admin/threads/metadata", body, 204)
+	thread, err := testDB.GetThreadById(*uid)
+	assert.Nil(t, err)
+	assert.Equal(t, "Title", thread.Title)
+	assert.Equal(t, "https://example.com/metadata/", thread.URL)
+	assert.False(t, *thread.Moderation)
+	assert.Equal(t, 10, *thread.MaxCommentLength)
+	// leaving the settings out resets them to the global ones
+	sendThreadRequest(t, server, r, cookies, "PUT", "/v1/admin/threads/metadata", model.ThreadMetadataBody{ThreadId: uid.String(), Title: "Title"}, 204)
+	thread, err = testDB.GetThreadById(*uid)
+	assert.Nil(t, err)
+	assert.Equal(t, "Title", thread.Title)
+	assert.Equal(t, "", thread.URL)
+	assert.Nil(t, thread.Moderation)
+	assert.Nil(t, thread.MaxCommentLength)
+	entries := getAuditLog(t, server, r, cookies)
+	assert.Len(t, entries, 2)
+	assert.Equal(t, dbmodel.AuditThreadUpdate, entries[0].Action)
+}
+
+func ThreadOverridesModeration(t *testing.T, testDB abstraction.Database) {
+	// moderation is enabled in the test config
+	server, err := api.GetServer(&testDB, &config)
+	assert.Nil(t, err)
+	uid, err := testDB.CreateThread("/unmoderated/", nil)
+	assert.Nil(t, err)
+	thread, err := testDB.GetThreadById(*uid)
+	assert.Nil(t, err)
+	moderation := false
+	thread.Moderation = &moderation
+	err = testDB.UpdateThread(thread)
+	assert.Nil(t, err)
+	r := gofight.New()
+	postComment(t, server, r, gofight.H{}, "/v1/comments", "/unmoderated/", 200)
+	postComment(t, server, r, gofight.H{}, "/v1/comments", "/moderated/", 200)
+	r.GET("/v1/comments?uri=/unmoderated/").
+		SetDebug(debug).
+		Run(server, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+			assert.Equal(t, 200, r.Code)
+			assert.Equal(t, []string{"<p>body</p>\n"}, getCommentBodies(t, r))
+		})
+	r.GET("/v1/comments?uri=/moderated/").
+		SetDebug(debug).
+		Run(server, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+			assert.Equal(t, 404, r.Code)
+		})
+}
+
+func ThreadOverridesMaxCommentLength(t *testing.T, testDB abstraction.Database) {
+	server, err := api.GetServer(&testDB, &config)
+	assert.Nil(t, err)
+	uid, err := testDB.CreateThread("/short/", nil)
+	assert.Nil(t, err)
+	thread, err := testDB.GetThreadById(*uid)
+	assert.Nil(t, err)
+	maxCommentLength := 3
+	thread.MaxCommentLength = &maxCommentLength
+	err = testDB.UpdateThread(thread)
+	assert.Nil(t, err)
+	r := gofight.New()
+	postComment(t, server, r, gofight.H{}, "/v1/comments", "/short/", 400)
+	postComment(t, server, r, gofight.H{}, "/v1/comments", "/long/", 200)
+}
+
+func GetAllThreadsActivity(t *testing.T, testDB abstraction.Database) {
+	server, err := api.GetServer(&testDB, &config)
+	assert.Nil(t, err)
+	_, err = testDB.CreateComment("first", "author", "/active/", true, nil)
+	assert.Nil(t, err)
+	pendingId, err := testDB.CreateComment("pending", "author", "/active/", false, nil)
+	assert.Nil(t, err)
+	pending, err := testDB.GetComment(*pendingId)
+	assert.Nil(t, err)
+	deleted, err := testDB.CreateComment("deleted", "author", "/active/", true, nil)
+	assert.Nil(t, err)
+	err = testDB.DeleteComment(*deleted)
+	assert.Nil(t, err)
+	_, err = testDB.CreateThread("/empty/", nil)
+	assert.Nil(t, err)
+	r := gofight.New()
+	cookies := GetSessionCookie(&testDB, r)
+	r.GET("/v1/admin/threads").
+		SetCookie(cookies).
+		SetHeader(csrfHeader(cookies)).
+		SetDebug(debug).
+		Run(server, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+			assert.Equal(t, 200, r.Code)
+			var threads []model.AdminThread
+			err := json.Unmarshal(r.Body.Bytes(), &threads)
+			assert.Nil(t, err)
+			assert.Len(t, threads, 2)
+			for _, v := range threads {
+				switch v.Path {
+				case "/active/":
+					// the deleted comment is left out of both the count and the activity
+					assert.Equal(t, 2, v.CommentCount)
+					assert.Equal(t, pending.CreatedAt.Unix(), v.LastActivity.Unix())
+				case "/empty/":
+					assert.Equal(t, 0, v.CommentCount)
+					assert.Equal(t, v.CreatedAt.Unix(), v.LastActivity.Unix())
+				default:
+					t.Errorf("unexpected thread %v", v.Path)
+				}
+			}
+		})
+}
+
+func ThreadKeyIdentifiesThread(t *testing.T, testDB abstraction.Database) {
+	configCopy := config
+	configCopy.Moderation.Enabled = false
+	server, err := api.GetServer(&testDB, &configCopy)
+	assert.Nil(t, err)
+	r := gofight.New()
+	key := "post-42"
+	for _, path := range []string{"/2018/01/post/", "/post-renamed/"} {
+		bodyBytes, err := json.Marshal(model.CreateCommentBody{Path: path, Thread: &key, Body: path, Author: "author"})
+		assert.Nil(t, err)
+		r.POST("/v1/comments").
+			SetBody(string(bodyBytes)).
+			SetDebug(debug).
+			Run(server, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+				assert.Equal(t, 200, r.Code)
+				var response model.CreateCommentResponse
+				err := json.Unmarshal(r.Body.Bytes(), &response)
+				assert.Nil(t, err)
+				assert.Equal(t, api.ThreadKeyPrefix+key, response.Path)
+			})
+	}
+	r.GET("/v1/comments?uri=/somewhere-else/&thread="+key).
+		SetDebug(debug).
+		Run(server, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+			assert.Equal(t, 200, r.Code)
+			assert.ElementsMatch(t, []string{"<p>/2018/01/post/</p>\n", "<p>/post-renamed/</p>\n"}, getCommentBodies(t, r))
+		})
+	// the page paths did not get threads of their own
+	r.GET("/v1/comments?uri=/2018/01/post/").
+		SetDebug(debug).
+		Run(server, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+			assert.Equal(t, 404, r.Code)
+		})
+	threads, err := testDB.GetAllThreads()
+	assert.Nil(t, err)
+	assert.Len(t, threads, 1)
+}
+
+func ThreadKeyInvalid(t *testing.T, testDB abstraction.Database) {
+	server, err := api.GetServer(&testDB, &config)
+	assert.Nil(t, err)
+	r := gofight.New()
+	key := "not a key"
+	bodyBytes, err := json.Marshal(model.CreateCommentBody{Path: "/page/", Thread: &key, Body: "body", Author: "author"})
+	assert.Nil(t, err)
+	r.POST("/v1/comments").
+		SetBody(string(bodyBytes)).
+		SetDebug(debug).
+		Run(server, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+			assert.Equal(t, 400, r.Code)
+		})
+	r.GET("/v1/comments?thread="+url.QueryEscape(key)).
+		SetDebug(debug).
+		Run(server, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+			assert.Equal(t, 400, r.Code)
+		})
+	threads, err := testDB.GetAllThreads()
+	assert.Nil(t, err)
+	assert.Len(t, threads, 0)
+}
+
+func getThreadAliases(t *testing.T, server http.Handler, r *gofight.RequestConfig, cookies gofight.H) []dbmodel.ThreadAlias {
+	var aliases []dbmodel.ThreadAlias
+	r.GET("/v1/admin/threads/aliases").
+		SetCookie(cookies).
+		SetHeader(csrfHeader(cookies)).
+		SetDebug(debug).
+		Run(server, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+			assert.Equal(t, 200, r.Code)
+			err := json.Unmarshal(r.Body.Bytes(), &aliases)
+			assert.Nil(t, err)
+		})
+	return aliases
+}
+
+func ThreadAliases(t *testing.T, testDB abstraction.Database) {
+	configCopy := config
+	configCopy.API.Cache.Enabled = true
+	server, err := api.GetServer(&testDB, &configCopy)
+	assert.Nil(t, err)
+	_, err = testDB.CreateComment("original", "author", "/post/", true, nil)
+	assert.Nil(t, err)
+	thread, err := testDB.GetThread("/post/")
+	assert.Nil(t, err)
+	moderation := false
+	thread.Moderation = &moderation
+	err = testDB.UpdateThread(thread)
+	assert.Nil(t, err)
+	r := gofight.New()
+	cookies := GetSessionCookie(&testDB, r)
+	sendThreadRequest(t, server, r, gofight.H{}, "POST", "/v1/admin/threads/aliases", model.ThreadAliasBody{ThreadId: thread.Id.String(), Path: "/amp/post/"}, 401)
+	sendThreadRequest(t, server, r, cookies, "POST", "/v1/admin/threads/aliases", model.ThreadAliasBody{ThreadId: thread.Id.String(), Path: ""}, 400)
+	sendThreadRequest(t, server, r, cookies, "POST", "/v1/admin/threads/aliases", model.ThreadAliasBody{ThreadId: global.GetUUID().String(), Path: "/amp/post/"}, 404)
+	sendThreadRequest(t, server, r, cookies, "POST", "/v1/admin/threads/aliases", model.ThreadAliasBody{ThreadId: thread.Id.String(), Path: "/amp/post"}, 204)
+	aliases := getThreadAliases(t, server, r, cookies)
+	assert.Len(t, aliases, 1)
+	assert.Equal(t, "/amp/post/", aliases[0].Path)
+	assert.Equal(t, thread.Id, aliases[0].ThreadId)
+
+	// comments posted through the alias end up in the aliased thread
+	postComment(t, server, r, gofight.H{}, "/v1/comments", "/amp/post/", 200)
+	r.GET("/v1/comments?uri=/amp/post/").
+		SetDebug(debug).
+		Run(server, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+			assert.Equal(t, 200, r.Code)
+			assert.ElementsMatch(t, []string{"original", "<p>body</p>\n"}, getCommentBodies(t, r))
+		})
+	// locking the thread invalidates the cached responses of the alias as well
+	setThreadState(t, server, r, cookies, "lock", thread.Id.String(), 204)
+	assert.Equal(t, "true", getThreadLockedHeader(t, server, r, "/amp/post/"))
+
+	sendThreadRequest(t, server, r, cookies, "DELETE", "/v1/admin/threads/aliases", model.ThreadAliasBody{Path: "/amp/post/"}, 204)
+	sendThreadRequest(t, server, r, cookies, "DELETE", "/v1/admin/threads/aliases", model.ThreadAliasBody{Path: "/amp/post/"}, 404)
+	r.GET("/v1/comments?uri=/amp/post/").
+		SetDebug(debug).
+		Run(server, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+			assert.Equal(t, 404, r.Code)
+		})
+	assert.Len(t, getThreadAliases(t, server, r, cookies), 0)
+	entries := getAuditLog(t, server, r, cookies)
+	assert.Len(t, entries, 2)
+	assert.Equal(t, dbmodel.AuditThreadAliasDelete, entries[0].Action)
+	assert.Equal(t, dbmodel.AuditThreadAliasCreate, entries[1].Action)
+	assert.Equal(t, "/amp/post/ -> /post/", entries[1].Details)
+}
+
+func ThreadAliasConflicts(t *testing.T, testDB abstraction.Database) {
+	server, err := api.GetServer(&testDB, &config)
+	assert.Nil(t, err)
+	first, err := testDB.CreateThread("/first/", nil)
+	assert.Nil(t, err)
+	second, err := testDB.CreateThread("/second/", nil)
+	assert.Nil(t, err)
+	r := gofight.New()
+	cookies := GetSessionCookie(&testDB, r)
+	// paths with threads of their own can't be aliased
+	sendThreadRequest(t, server, r, cookies, "POST", "/v1/admin/threads/aliases", model.ThreadAliasBody{ThreadId: first.String(), Path: "/second/"}, 409)
+	sendThreadRequest(t, server, r, cookies, "POST", "/v1/admin/threads/aliases", model.ThreadAliasBody{ThreadId: first.String(), Path: "/alias/"}, 204)
+	sendThreadRequest(t, server, r, cookies, "POST", "/v1/admin/threads/aliases", model.ThreadAliasBody{ThreadId: second.String(), Path: "/alias/"}, 409)
+	// nor can threads be renamed to an aliased path
+	sendThreadRequest(t, server, r, cookies, "PATCH", "/v1/admin/threads", model.UpdateThreadBody{ThreadId: second.String(), Path: "/alias/"}, 409)
+}
+
+func ThreadAliasFollowsMergeAndDelete(t *testing.T, testDB abstraction.Database) {
+	server, err := api.GetServer(&testDB, &config)
+	assert.Nil(t, err)
+	_, err = testDB.CreateComment("source", "author", "/source/", true, nil)
+	assert.Nil(t, err)
+	_, err = testDB.CreateComment("target", "author", "/target/", true, nil)
+	assert.Nil(t, err)
+	source, err := testDB.GetThread("/source/")
+	assert.Nil(t, err)
+	target, err := testDB.GetThread("/target/")
+	assert.Nil(t, err)
+	r := gofight.New()
+	cookies := GetSessionCookie(&testDB, r)
+	sendThreadRequest(t, server, r, cookies, "POST", "/v1/admin/threads/aliases", model.ThreadAliasBody{ThreadId: source.Id.String(), Path: "/source-alias/"}, 204)
+	sendThreadRequest(t, server, r, cookies, "POST", "/v1/admin/threads/merge", model.MergeThreadsBody{ThreadId: source.Id.String(), TargetThreadId: target.Id.String()}, 204)
+	r.GET("/v1/comments?uri=/source-alias/").
+		SetDebug(debug).
+		Run(server, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+			assert.Equal(t, 200, r.Code)
+			assert.ElementsMatch(t, []string{"source", "target"}, getCommentBodies(t, r))
+		})
+	sendThreadRequest(t, server, r, cookies, "DELETE", "/v1/admin/threads", model.ThreadFlagBody{ThreadId: target.Id.String()}, 204)
+	assert.Len(t, getThreadAliases(t, server, r, cookies), 0)
+}
+
+func NormalizedPathsShareThread(t *testing.T, testDB abstraction.Database) {
+	configCopy := config
+	configCopy.Moderation.Enabled = false
+	configCopy.API.Paths = configModel.Paths{StripQuery: true, StripFragment: true, Lowercase: true, StripIndex: true}
+	server, err := api.GetServer(&testDB, &configCopy)
+	assert.Nil(t, err)
+	r := gofight.New()
+	postComment(t, server, r, gofight.H{}, "/v1/comments", "/Blog/Post/index.html?utm_source=feed", 200)
+	postComment(t, server, r, gofight.H{}, "/v1/comments", "https://example.com/blog/post#comments", 200)
+	r.GET("/v1/comments?uri="+url.QueryEscape("/BLOG/post?page=2")).
+		SetDebug(debug).
+		Run(server, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+			assert.Equal(t, 200, r.Code)
+			assert.Len(t, getCommentBodies(t, r), 2)
+		})
+	threads, err := testDB.GetAllThreads()
+	assert.Nil(t, err)
+	assert.Len(t, threads, 1)
+	assert.Equal(t, "/blog/post/", threads[0].Path)
+}
+
+func InvalidPathRewrite(t *testing.T, testDB abstraction.Database) {
+	configCopy := config
+	rewrites := []configModel.PathRewrite{{Pattern: "(", Replacement: ""}}
+	configCopy.API.Paths.Rewrites = &rewrites
+	_, err := api.GetServer(&testDB, &configCopy)
+	assert.NotNil(t, err)
+}
+
+func createSite(t *testing.T, server http.Handler, r *gofight.RequestConfig, cookies gofight.H, body model.SiteBody) string {
+	v, err := json.Marshal(body)
+	assert.Nil(t, err)
+	id := ""
+	r.POST("/v1/admin/sites").
+		SetBody(string(v)).
+		SetCookie(cookies).
+		SetHeader(csrfHeader(cookies)).
+		SetDebug(debug).
+		Run(server, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+			assert.Equal(t, 200, r.Code)
+			var response map[string]string
+			err := json.Unmarshal(r.Body.Bytes(), &response)
+			assert.Nil(t, err)
+			id = response["id"]
+		})
+	return id
+}
+
+func getSiteSessionCookie(t *testing.T, server http.Handler, r *gofight.RequestConfig, site string, password string) gofight.H {
+	cookiePrefix := "mouthful-session"
+	cookieValue := ""
+	v, err := json.Marshal(model.LoginBody{Password: password, Site: site})
+	assert.Nil(t, err)
+	r.POST("/v1/admin/login").
+		SetBody(string(v)).
+		SetDebug(debug).
+		Run(server, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+			assert.Equal(t, 204, r.Code)
+			cookieValue = strings.TrimSuffix(strings.Split(strings.TrimLeft(r.HeaderMap["Set-Cookie"][0], cookiePrefix+"="), " ")[0], ";")
+			csrfTokens[cookieValue] = r.HeaderMap.Get(api.CSRFHeader)
+		})
+	return gofight.H{cookiePrefix: cookieValue}
+}
+
+func postSiteComment(t *testing.T, server http.Handler, r *gofight.RequestConfig, origin string, site string, path string, expectedCode int) {
+	body := model.CreateCommentBody{Path: path, Body: "body", Author: "author"}
+	if site != "" {
+		body.Site = &site
+	}
+	bodyBytes, err := json.Marshal(body)
+	assert.Nil(t, err)
+	r.POST("/v1/comments").
+		SetBody(string(bodyBytes)).
+		SetHeader(gofight.H{"Origin": origin}).
+		SetDebug(debug).
+		Run(server, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+			assert.Equal(t, expectedCode, r.Code)
+		})
+}
+
+func ManageSites(t *testing.T, testDB abstraction.Database) {
+	server, err := api.GetServer(&testDB, &config)
+	assert.Nil(t, err)
+	r := gofight.New()
+	sendThreadRequest(t, server, r, gofight.H{}, "POST", "/v1/admin/sites", model.SiteBody{Key: "blog"}, 401)
+	cookies := GetSessionCookie(&testDB, r)
+	password := "blogpassword"
+	id := createSite(t, server, r, cookies, model.SiteBody{Key: "blog", Name: "Blog", Origins: []string{"https://Blog.example.com/"}, AdminPassword: &password})
+	sendThreadRequest(t, server, r, cookies, "POST", "/v1/admin/sites", model.SiteBody{Key: "blog"}, 409)
+	sendThreadRequest(t, server, r, cookies, "POST", "/v1/admin/sites", model.SiteBody{Key: "Not a key"}, 400)
+	sendThreadRequest(t, server, r, cookies, "POST", "/v1/admin/sites", model.SiteBody{Key: "docs", Origins: []string{"docs.example.com"}}, 400)
+	// the admin ids need their provider
+	sendThreadRequest(t, server, r, cookies, "POST", "/v1/admin/sites", model.SiteBody{Key: "docs", AdminUserIds: []string{"12345"}}, 400)
+	sendThreadRequest(t, server, r, cookies, "POST", "/v1/admin/sites", model.SiteBody{Key: "docs", AdminUserIds: []string{"github:"}}, 400)
+
+	r.GET("/v1/admin/sites").
+		SetCookie(cookies).
+		SetHeader(csrfHeader(cookies)).
+		SetDebug(debug).
+		Run(server, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+			assert.Equal(t, 200, r.Code)
+			// the password hash never leaves the server
+			assert.NotContains(t, r.Body.String(), "AdminPasswordHash")
+			var sites []dbmodel.Site
+			err := json.Unmarshal(r.Body.Bytes(), &sites)
+			assert.Nil(t, err)
+			assert.Len(t, sites, 1)
+			assert.Equal(t, "blog", sites[0].Key)
+			assert.Equal(t, dbmodel.StringList{"https://blog.example.com"}, sites[0].Origins)
+		})
+	siteId, err := uuid.FromString(id)
+	assert.Nil(t, err)
+	site, err := testDB.GetSite(siteId)
+	assert.Nil(t, err)
+	assert.NotEqual(t, "", site.AdminPasswordHash)
+	assert.NotEqual(t, password, site.AdminPasswordHash)
+
+	moderation := false
+	sendThreadRequest(t, server, r, cookies, "PATCH", "/v1/admin/sites", model.SiteBody{SiteId: id, Name: "The blog", Moderation: &moderation, AdminUserIds: []string{" github:12345 ", ""}}, 204)
+	sendThreadRequest(t, server, r, cookies, "PATCH", "/v1/admin/sites", model.SiteBody{SiteId: global.GetUUID().String()}, 404)
+	site, err = testDB.GetSite(siteId)
+	assert.Nil(t, err)
+	assert.Equal(t, "The blog", site.Name)
+	assert.Equal(t, "blog", site.Key)
+	assert.False(t, *site.Moderation)
+	assert.Len(t, site.Origins, 0)
+	assert.Equal(t, dbmodel.StringList{"github:12345"}, site.AdminUserIds)
+	assert.True(t, site.HasAdmin("github", "12345"))
+	assert.False(t, site.HasAdmin("gitlab", "12345"))
+	// leaving the password out keeps it
+	assert.NotEqual(t, "", site.AdminPasswordHash)
+
+	postSiteComment(t, server, r, "", "blog", "/post/", 200)
+	sendThreadRequest(t, server, r, cookies, "DELETE", "/v1/admin/sites", model.SiteFlagBody{SiteId: id}, 409)
+	thread, err := testDB.GetThread("site:blog:/post/")
+	assert.Nil(t, err)
+	sendThreadRequest(t, server, r, cookies, "DELETE", "/v1/admin/threads", model.ThreadFlagBody{ThreadId: thread.Id.String()}, 204)
+	sendThreadRequest(t, server, r, cookies, "DELETE", "/v1/admin/sites", model.SiteFlagBody{SiteId: id}, 204)
+	sendThreadRequest(t, server, r, cookies, "DELETE", "/v1/admin/sites", model.SiteFlagBody{SiteId: id}, 404)
+	postSiteComment(t, server, r, "", "blog", "/post/", 404)
+
+	actions := make([]string, 0)
+	for _, v := range getAuditLog(t, server, r, cookies) {
+		actions = append(actions, v.Action)
+	}
+	assert.Equal(t, []string{dbmodel.AuditSiteDelete, dbmodel.AuditThreadDelete, dbmodel.AuditSiteUpdate, dbmodel.AuditSiteCreate}, actions)
+}
+
+func SitesSeparateThreads(t *testing.T, testDB abstraction.Database) {
+	configCopy := config
+	configCopy.Moderation.Enabled = false
+	server, err := api.GetServer(&testDB, &configCopy)
+	assert.Nil(t, err)
+	r := gofight.New()
+	_, err = testDB.CreateSite(dbmodel.Site{Key: "blog", Origins: dbmodel.StringList{"https://blog.example.com"}})
+	assert.Nil(t, err)
+	_, err = testDB.CreateSite(dbmodel.Site{Key: "docs"})
+	assert.Nil(t, err)
+	// the sites are loaded when the server starts
+	server, err = api.GetServer(&testDB, &configCopy)
+	assert.Nil(t, err)
+
+	postSiteComment(t, server, r, "https://blog.example.com", "", "/post/", 200)
+	postSiteComment(t, server, r, "https://blog.example.com", "blog", "/post/", 200)
+	postSiteComment(t, server, r, "https://example.com", "", "/post/", 200)
+	postSiteComment(t, server, r, "https://example.com", "docs", "/post/", 200)
+	postSiteComment(t, server, r, "https://example.com", "blog", "/post/", 403)
+	postSiteComment(t, server, r, "https://example.com", "missing", "/post/", 404)
+
+	threads, err := testDB.GetAllThreads()
+	assert.Nil(t, err)
+	paths := make([]string, 0, len(threads))
+	for _, v := range threads {
+		paths = append(paths, v.Path)
+		if v.Path == "/post/" {
+			assert.Nil(t, v.SiteId)
+		} else {
+			assert.NotNil(t, v.SiteId)
+		}
+	}
+	assert.ElementsMatch(t, []string{"site:blog:/post/", "site:docs:/post/", "/post/"}, paths)
+
+	r.GET("/v1/comments?uri=/post/").
+		SetHeader(gofight.H{"Origin": "https://blog.example.com"}).
+		SetDebug(debug).
+		Run(server, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+			assert.Equal(t, 200, r.Code)
+			assert.Len(t, getCommentBodies(t, r), 2)
+			assert.Contains(t, r.HeaderMap.Get("Vary"), "Origin")
+		})
+	r.GET("/v1/comments?uri=/post/&site=docs").
+		SetDebug(debug).
+		Run(server, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+			assert.Equal(t, 200, r.Code)
+			assert.Len(t, getCommentBodies(t, r), 1)
+		})
+	r.GET("/v1/comments?uri=/post/&site=blog").
+		SetHeader(gofight.H{"Referer": "https://example.com/post/"}).
+		SetDebug(debug).
+		Run(server, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+			assert.Equal(t, 403, r.Code)
+		})
+	r.GET("/v1/comments?uri=/post/").
+		SetDebug(debug).
+		Run(server, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+			assert.Equal(t, 200, r.Code)
+			assert.Len(t, getCommentBodies(t, r), 1)
+		})
+}
+
+func SiteSettingsOverrideConfig(t *testing.T, testDB abstraction.Database) {
+	// moderation is enabled in the test config
+	moderation := false
+	maxLength := 5
+	_, err := testDB.CreateSite(dbmodel.Site{Key: "blog", Origins: dbmodel.StringList{"https://blog.example.com"}, Moderation: &moderation, MaxCommentLength: &maxLength})
+	assert.Nil(t, err)
+	server, err := api.GetServer(&testDB, &config)
+	assert.Nil(t, err)
+	r := gofight.New()
+	r.GET("/v1/client/config").
+		SetHeader(gofight.H{"Origin": "https://blog.example.com"}).
+		SetDebug(debug).
+		Run(server, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+			assert.Equal(t, 200, r.Code)
+			var clientConfig configModel.ClientConfig
+			err := json.Unmarshal(r.Body.Bytes(), &clientConfig)
+			assert.Nil(t, err)
+			assert.False(t, clientConfig.Moderation)
+			assert.Equal(t, 5, *clientConfig.MaxCommentLength)
+		})
+	postSiteComment(t, server, r, "https://blog.example.com", "", "/post/", 200)
+	postSiteComment(t, server, r, "", "", "/post/", 200)
+	r.GET("/v1/comments?uri=/post/&site=blog").
+		SetDebug(debug).
+		Run(server, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+			assert.Equal(t, 200, r.Code)
+			assert.Len(t, getCommentBodies(t, r), 1)
+		})
+	// the default site is still moderated
+	r.GET("/v1/comments?uri=/post/").
+		SetDebug(debug).
+		Run(server, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+			assert.Equal(t, 404, r.Code)
+		})
+
+	bodyBytes, err := json.Marshal(model.CreateCommentBody{Path: "/post/", Body: "too long", Author: "author"})
+	assert.Nil(t, err)
+	r.POST("/v1/comments").
+		SetBody(string(bodyBytes)).
+		SetHeader(gofight.H{"Origin": "https://blog.example.com"}).
+		SetDebug(debug).
+		Run(server, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+			assert.Equal(t, 400, r.Code)
+		})
+}
+
+func SiteAdminsAreScoped(t *testing.T, testDB abstraction.Database) {
+	configCopy := config
+	configCopy.Moderation.LoginThrottling = noLoginThrottling
+	server, err := api.GetServer(&testDB, &configCopy)
+	assert.Nil(t, err)
+	r := gofight.New()
+	cookies := GetSessionCookie(&testDB, r)
+	password := "blogpassword"
+	blogId := createSite(t, server, r, cookies, model.SiteBody{Key: "blog", AdminPassword: &password})
+	createSite(t, server, r, cookies, model.SiteBody{Key: "docs"})
+
+	v, err := json.Marshal(model.LoginBody{Password: "wrong", Site: "blog"})
+	assert.Nil(t, err)
+	r.POST("/v1/admin/login").
+		SetBody(string(v)).
+		SetDebug(debug).
+		Run(server, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+			assert.Equal(t, 401, r.Code)
+		})
+	// sites without a password can't be logged in to
+	v, err = json.Marshal(model.LoginBody{Password: "", Site: "docs"})
+	assert.Nil(t, err)
+	r.POST("/v1/admin/login").
+		SetBody(string(v)).
+		SetDebug(debug).
+		Run(server, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+			assert.Equal(t, 401, r.Code)
+		})
+	siteCookies := getSiteSessionCookie(t, server, r, "blog", password)
+
+	postSiteComment(t, server, r, "", "blog", "/post/", 200)
+	postSiteComment(t, server, r, "", "docs", "/post/", 200)
+	postSiteComment(t, server, r, "", "", "/post/", 200)
+	blogThread, err := testDB.GetThread("site:blog:/post/")
+	assert.Nil(t, err)
+	docsThread, err := testDB.GetThread("site:docs:/post/")
+	assert.Nil(t, err)
+	defaultThread, err := testDB.GetThread("/post/")
+	assert.Nil(t, err)
+
+	r.GET("/v1/admin/threads").
+		SetCookie(siteCookies).
+		SetHeader(csrfHeader(siteCookies)).
+		SetDebug(debug).
+		Run(server, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+			assert.Equal(t, 200, r.Code)
+			var threads []model.AdminThread
+			err := json.Unmarshal(r.Body.Bytes(), &threads)
+			assert.Nil(t, err)
+			assert.Len(t, threads, 1)
+			assert.Equal(t, blogThread.Id, threads[0].Id)
+		})
+	r.GET("/v1/admin/comments/all").
+		SetCookie(siteCookies).
+		SetHeader(csrfHeader(siteCookies)).
+		SetDebug(debug).
+		Run(server, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+			assert.Equal(t, 200, r.Code)
+			var comments []dbmodel.Comment
+			err := json.Unmarshal(r.Body.Bytes(), &comments)
+			assert.Nil(t, err)
+			assert.Len(t, comments, 1)
+			assert.Equal(t, blogThread.Id, comments[0].ThreadId)
+		})
+	r.GET("/v1/admin/sites").
+		SetCookie(siteCookies).
+		SetHeader(csrfHeader(siteCookies)).
+		SetDebug(debug).
+		Run(server, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+			assert.Equal(t, 200, r.Code)
+			var sites []dbmodel.Site
+			err := json.Unmarshal(r.Body.Bytes(), &sites)
+			assert.Nil(t, err)
+			assert.Len(t, sites, 1)
+			assert.Equal(t, "blog", sites[0].Key)
+		})
+
+	setThreadState(t, server, r, siteCookies, "lock", docsThread.Id.String(), 404)
+	setThreadState(t, server, r, siteCookies, "lock", defaultThread.Id.String(), 404)
+	setThreadState(t, server, r, siteCookies, "lock", blogThread.Id.String(), 204)
+	sendThreadRequest(t, server, r, siteCookies, "POST", "/v1/admin/threads/merge", model.MergeThreadsBody{ThreadId: blogThread.Id.String(), TargetThreadId: defaultThread.Id.String()}, 404)
+	sendThreadRequest(t, server, r, cookies, "POST", "/v1/admin/threads/merge", model.MergeThreadsBody{ThreadId: blogThread.Id.String(), TargetThreadId: defaultThread.Id.String()}, 400)
+	sendThreadRequest(t, server, r, siteCookies, "PATCH", "/v1/admin/threads", model.UpdateThreadBody{ThreadId: blogThread.Id.String(), Path: "/moved/"}, 204)
+	_, err = testDB.GetThread("site:blog:/moved/")
+	assert.Nil(t, err)
+	sendThreadRequest(t, server, r, siteCookies, "POST", "/v1/admin/threads/aliases", model.ThreadAliasBody{ThreadId: blogThread.Id.String(), Path: "/post/"}, 204)
+	alias, err := testDB.GetThreadAlias("site:blog:/post/")
+	assert.Nil(t, err)
+	assert.Equal(t, blogThread.Id, alias.ThreadId)
+	sendThreadRequest(t, server, r, siteCookies, "DELETE", "/v1/admin/threads/aliases", model.ThreadAliasBody{Path: "/post/"}, 204)
+	sendThreadRequest(t, server, r, siteCookies, "POST", "/v1/admin/sites", model.SiteBody{Key: "other"}, 401)
+	sendThreadRequest(t, server, r, siteCookies, "DELETE", "/v1/admin/sites", model.SiteFlagBody{SiteId: blogId}, 401)
+
+	comments, err := testDB.GetCommentsByThread("/post/")
+	assert.Nil(t, err)
+	// the default site is moderated, so the comment is only visible to the admins
+	allComments, err := testDB.GetAllComments()
+	assert.Nil(t, err)
+	assert.Len(t, comments, 0)
+	for _, v := range allComments {
+		if v.ThreadId == defaultThread.Id {
+			sendThreadRequest(t, server, r, siteCookies, "DELETE", "/v1/admin/comments", model.DeleteCommentBody{CommentId: v.Id.String()}, 404)
+			sendThreadRequest(t, server, r, siteCookies, "PATCH", "/v1/admin/comments", model.UpdateCommentBody{CommentId: v.Id.String(), Body: &password}, 404)
+		}
+	}
+
+	// staff comments of the site admins always go to their site
+	postComment(t, server, r, siteCookies, "/v1/admin/comments", "/staff/", 200)
+	staffThread, err := testDB.GetThread("site:blog:/staff/")
+	assert.Nil(t, err)
+	assert.Equal(t, blogThread.SiteId, staffThread.SiteId)
+
+	for _, v := range getAuditLog(t, server, r, siteCookies) {
+		assert.NotNil(t, v.SiteId)
+		assert.Equal(t, *blogThread.SiteId, *v.SiteId)
+	}
+	// the failed logins to both sites are audited too
+	assert.Len(t, getAuditLog(t, server, r, cookies), 7)
+}
+
+func getPostErrorCode(t *testing.T, server http.Handler, r *gofight.RequestConfig, headers gofight.H, path string, expectedCode int) string {
+	bodyBytes, err := json.Marshal(model.CreateCommentBody{Path: path, Body: "body", Author: "author"})
+	assert.Nil(t, err)
+	code := ""
+	r.POST("/v1/comments").
+		SetBody(string(bodyBytes)).
+		SetHeader(headers).
+		SetDebug(debug).
+		Run(server, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+			assert.Equal(t, expectedCode, r.Code)
+			if r.Code != 200 {
+				var response model.ErrorResponse
+				err := json.Unmarshal(r.Body.Bytes(), &response)
+				assert.Nil(t, err)
+				code = response.Code
+			}
+		})
+	return code
+}
+
+func PostOriginVerification(t *testing.T, testDB abstraction.Database) {
+	_, err := testDB.CreateSite(dbmodel.Site{Key: "blog", Origins: dbmodel.StringList{"https://blog.example.com"}})
+	assert.Nil(t, err)
+	configCopy := config
+	configCopy.API.Verification = configModel.Verification{CheckOrigin: true, AllowedOrigins: &[]string{"https://Example.com/"}}
+	server, err := api.GetServer(&testDB, &configCopy)
+	assert.Nil(t, err)
+	r := gofight.New()
+
+	assert.Equal(t, model.ErrorCodeOriginNotAllowed, getPostErrorCode(t, server, r, gofight.H{}, "/post/", 403))
+	assert.Equal(t, model.ErrorCodeOriginNotAllowed, getPostErrorCode(t, server, r, gofight.H{"Origin": "https://evil.example.com"}, "/post/", 403))
+	assert.Equal(t, model.ErrorCodeOriginNotAllowed, getPostErrorCode(t, server, r, gofight.H{"Origin": "null"}, "/post/", 403))
+	getPostErrorCode(t, server, r, gofight.H{"Origin": "https://example.com"}, "/post/", 200)
+	getPostErrorCode(t, server, r, gofight.H{"Referer": "https://example.com/post/"}, "/post/", 200)
+	// the origins of the sites are allowed as well
+	getPostErrorCode(t, server, r, gofight.H{"Origin": "https://blog.example.com"}, "/post/", 200)
+	// reading is never limited
+	r.GET("/v1/comments?uri=/post/").
+		SetDebug(debug).
+		Run(server, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+			assert.NotEqual(t, 403, r.Code)
+		})
+
+	configCopy.API.Verification = configModel.Verification{CheckOrigin: true, AllowedOrigins: &[]string{"example.com"}}
+	_, err = api.GetServer(&testDB, &configCopy)
+	assert.NotNil(t, err)
+}
+
+func ThreadPathVerification(t *testing.T, testDB abstraction.Database) {
+	dir, err := ioutil.TempDir("", "mouthful-sitemap")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	sitemap := filepath.Join(dir, "sitemap.xml")
+	err = ioutil.WriteFile(sitemap, []byte(`<?xml version="1.0" encoding="UTF-8"?>
+<urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+	<url><loc>https://example.com/about</loc></url>
+	<url><loc>https://example.com/contact/</loc></url>
+</urlset>`), 0644)
+	assert.Nil(t, err)
+	configCopy := config
+	configCopy.API.Verification = configModel.Verification{PathPatterns: &[]string{"^/blog/[a-z0-9-]+/$"}, SitemapFile: &sitemap}
+	server, err := api.GetServer(&testDB, &configCopy)
+	assert.Nil(t, err)
+	r := gofight.New()
+
+	getPostErrorCode(t, server, r, gofight.H{}, "/blog/my-post/", 200)
+	getPostErrorCode(t, server, r, gofight.H{}, "/about/", 200)
+	getPostErrorCode(t, server, r, gofight.H{}, "https://example.com/contact", 200)
+	assert.Equal(t, model.ErrorCodePathNotAllowed, getPostErrorCode(t, server, r, gofight.H{}, "/blog/my-post/junk/", 403))
+	assert.Equal(t, model.ErrorCodePathNotAllowed, getPostErrorCode(t, server, r, gofight.H{}, "/made-up/", 403))
+	_, err = testDB.GetThread("/made-up/")
+	assert.Equal(t, global.ErrThreadNotFound, err)
+
+	// the sitemap is reread when it changes
+	err = ioutil.WriteFile(sitemap, []byte("/made-up/\n"), 0644)
+	assert.Nil(t, err)
+	later := time.Now().Add(time.Minute)
+	err = os.Chtimes(sitemap, later, later)
+	assert.Nil(t, err)
+	getPostErrorCode(t, server, r, gofight.H{}, "/made-up/", 200)
+
+	// threads that already exist can always be posted to, and the staff can start new ones anywhere
+	err = ioutil.WriteFile(sitemap, []byte(""), 0644)
+	assert.Nil(t, err)
+	later = later.Add(time.Minute)
+	err = os.Chtimes(sitemap, later, later)
+	assert.Nil(t, err)
+	getPostErrorCode(t, server, r, gofight.H{}, "/made-up/", 200)
+	getPostErrorCode(t, server, r, gofight.H{}, "/new/", 403)
+	cookies := GetSessionCookie(&testDB, r)
+	postComment(t, server, r, cookies, "/v1/admin/comments", "/new/", 200)
+
+	missing := filepath.Join(dir, "missing.xml")
+	configCopy.API.Verification = configModel.Verification{SitemapFile: &missing}
+	_, err = api.GetServer(&testDB, &configCopy)
+	assert.NotNil(t, err)
+}
+
+func getMe(t *testing.T, server http.Handler, r *gofight.RequestConfig, cookies gofight.H, expectedCode int) model.AdminIdentity {
+	var identity model.AdminIdentity
+	r.GET("/v1/admin/me").
+		SetCookie(cookies).
+		SetHeader(csrfHeader(cookies)).
+		SetDebug(debug).
+		Run(server, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+			assert.Equal(t, expectedCode, r.Code)
+			if r.Code == 200 {
+				err := json.Unmarshal(r.Body.Bytes(), &identity)
+				assert.Nil(t, err)
+			}
+		})
+	return identity
+}
+
+func logout(t *testing.T, server http.Handler, r *gofight.RequestConfig, cookies gofight.H) {
+	r.POST("/v1/admin/logout").
+		SetCookie(cookies).
+		SetHeader(csrfHeader(cookies)).
+		SetDebug(debug).
+		Run(server, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+			assert.Equal(t, 204, r.Code)
+			// the cookie gets dropped by the browser
+			assert.Contains(t, r.HeaderMap.Get("Set-Cookie"), "Max-Age=0")
+		})
+}
+
+func MeAndLogout(t *testing.T, testDB abstraction.Database) {
+	server, err := api.GetServer(&testDB, &config)
+	assert.Nil(t, err)
+	r := gofight.New()
+	getMe(t, server, r, gofight.H{}, 401)
+	cookies := GetSessionCookie(&testDB, r)
+	identity := getMe(t, server, r, cookies, 200)
+	assert.Equal(t, api.PasswordUserId, identity.UserId)
+	assert.Equal(t, api.PasswordProvider, identity.Provider)
+	assert.Equal(t, "", identity.Site)
+	assert.Equal(t, "", identity.SessionId)
+	assert.Nil(t, identity.ExpiresAt)
+
+	password := "blogpassword"
+	createSite(t, server, r, cookies, model.SiteBody{Key: "blog", AdminPassword: &password})
+	siteCookies := getSiteSessionCookie(t, server, r, "blog", password)
+	identity = getMe(t, server, r, siteCookies, 200)
+	assert.Equal(t, api.PasswordProvider, identity.Provider)
+	assert.Equal(t, "blog", identity.Site)
+	assert.NotEqual(t, "", identity.SiteId)
+
+	logout(t, server, r, siteCookies)
+	sendThreadRequest(t, server, gofight.New(), gofight.H{}, "POST", "/v1/admin/logout", nil, 204)
+	// there are no server-side sessions to revoke
+	sendThreadRequest(t, server, r, cookies, "GET", "/v1/admin/sessions", nil, 404)
+}
+
+func SessionSecretRotation(t *testing.T, testDB abstraction.Database) {
+	oldSecret := strings.Repeat("a", 32)
+	newSecret := strings.Repeat("b", 32)
+	configCopy := config
+	configCopy.Moderation.SessionSecret = oldSecret
+	server, err := api.GetServer(&testDB, &configCopy)
+	assert.Nil(t, err)
+	r := gofight.New()
+	cookies := getSiteSessionCookie(t, server, r, "", adminPassword)
+	getMe(t, server, r, cookies, 200)
+
+	// sessions signed with the admin password are no longer valid once there is a secret
+	server, err = api.GetServer(&testDB, &configCopy)
+	assert.Nil(t, err)
+	getMe(t, server, r, GetSessionCookie(&testDB, r), 401)
+
+	configCopy.Moderation.SessionSecret = newSecret
+	configCopy.Moderation.OldSessionSecrets = &[]string{oldSecret}
+	server, err = api.GetServer(&testDB, &configCopy)
+	assert.Nil(t, err)
+	getMe(t, server, r, cookies, 200)
+	newCookies := getSiteSessionCookie(t, server, r, "", adminPassword)
+	getMe(t, server, r, newCookies, 200)
+
+	configCopy.Moderation.OldSessionSecrets = nil
+	server, err = api.GetServer(&testDB, &configCopy)
+	assert.Nil(t, err)
+	getMe(t, server, r, cookies, 401)
+	getMe(t, server, r, newCookies, 200)
+
+	// changing the password does not log anybody out
+	configCopy.Moderation.AdminPassword = "another password"
+	server, err = api.GetServer(&testDB, &configCopy)
+	assert.Nil(t, err)
+	getMe(t, server, r, newCookies, 200)
+
+	configCopy.Moderation.SessionSecret = "short"
+	_, err = api.GetServer(&testDB, &configCopy)
+	assert.NotNil(t, err)
+}
+
+func ServerSideSessions(t *testing.T, testDB abstraction.Database) {
+	configCopy := config
+	configCopy.Moderation.ServerSideSessions = true
+	server, err := api.GetServer(&testDB, &configCopy)
+	assert.Nil(t, err)
+	r := gofight.New()
+	first := getSiteSessionCookie(t, server, r, "", adminPassword)
+	second := getSiteSessionCookie(t, server, r, "", adminPassword)
+	identity := getMe(t, server, r, first, 200)
+	assert.NotEqual(t, "", identity.SessionId)
+	assert.NotNil(t, identity.ExpiresAt)
+	assert.True(t, identity.ExpiresAt.After(time.Now()))
+
+	var sessions []dbmodel.AdminSession
+	r.GET("/v1/admin/sessions").
+		SetCookie(first).
+		SetHeader(csrfHeader(first)).
+		SetDebug(debug).
+		Run(server, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+			assert.Equal(t, 200, r.Code)
+			err := json.Unmarshal(r.Body.Bytes(), &sessions)
+			assert.Nil(t, err)
+		})
+	assert.Len(t, sessions, 2)
+	for _, v := range sessions {
+		assert.Equal(t, api.PasswordUserId, v.UserId)
+		assert.Equal(t, api.PasswordProvider, v.Provider)
+	}
+
+	sendThreadRequest(t, server, r, first, "DELETE", "/v1/admin/sessions", model.SessionFlagBody{SessionId: global.GetUUID().String()}, 404)
+	sendThreadRequest(t, server, r, first, "DELETE", "/v1/admin/sessions", model.SessionFlagBody{SessionId: "not-an-id"}, 400)
+	secondIdentity := getMe(t, server, r, second, 200)
+	sendThreadRequest(t, server, r, first, "DELETE", "/v1/admin/sessions", model.SessionFlagBody{SessionId: secondIdentity.SessionId}, 204)
+	// the cookie of a revoked session is still validly signed, but no longer good for anything
+	getMe(t, server, r, second, 401)
+	sendThreadRequest(t, server, r, second, "GET", "/v1/admin/threads", nil, 401)
+	getMe(t, server, r, first, 200)
+	audit := getAuditLog(t, server, r, first)
+	assert.Len(t, audit, 1)
+	assert.Equal(t, dbmodel.AuditSessionRevoke, audit[0].Action)
+	assert.Equal(t, secondIdentity.SessionId, audit[0].Subject)
+
+	logout(t, server, r, first)
+	getMe(t, server, r, first, 401)
+	_, err = testDB.GetAdminSession(uuid.FromStringOrNil(identity.SessionId))
+	assert.Equal(t, global.ErrSessionNotFound, err)
+
+	// site admins only see the sessions of their site
+	cookies := getSiteSessionCookie(t, server, r, "", adminPassword)
+	password := "blogpassword"
+	createSite(t, server, r, cookies, model.SiteBody{Key: "blog", AdminPassword: &password})
+	// logging in replaces the session the request carries, so the site admin logs in from elsewhere
+	siteCookies := getSiteSessionCookie(t, server, gofight.New(), "blog", password)
+	r.GET("/v1/admin/sessions").
+		SetCookie(siteCookies).
+		SetHeader(csrfHeader(siteCookies)).
+		SetDebug(debug).
+		Run(server, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+			assert.Equal(t, 200, r.Code)
+			err := json.Unmarshal(r.Body.Bytes(), &sessions)
+			assert.Nil(t, err)
+		})
+	assert.Len(t, sessions, 1)
+	assert.NotNil(t, sessions[0].SiteId)
+	sendThreadRequest(t, server, r, siteCookies, "DELETE", "/v1/admin/sessions", model.SessionFlagBody{SessionId: getMe(t, server, r, cookies, 200).SessionId}, 404)
+	getMe(t, server, r, cookies, 200)
+}
+
+func sendAdminRequest(t *testing.T, server http.Handler, cookies gofight.H, headers gofight.H, method string, route string, body interface{}, expectedCode int) string {
+	v, err := json.Marshal(body)
+	assert.Nil(t, err)
+	// a new request config, so no headers are left over from the previous requests
+	r := gofight.New()
+	request := r.POST
+	switch method {
+	case "PATCH":
+		request = r.PATCH
+	case "DELETE":
+		request = r.DELETE
+	}
+	responseBody := ""
+	request(route).
+		SetBody(string(v)).
+		SetCookie(cookies).
+		SetHeader(headers).
+		SetDebug(debug).
+		Run(server, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+			assert.Equal(t, expectedCode, r.Code)
+			responseBody = r.Body.String()
+		})
+	return responseBody
+}
+
+func getAdminConfigCSRFToken(t *testing.T, server http.Handler, cookies gofight.H) string {
+	var adminConfig configModel.AdminConfig
+	gofight.New().GET("/v1/admin/config").
+		SetCookie(cookies).
+		SetDebug(debug).
+		Run(server, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+			assert.Equal(t, 200, r.Code)
+			err := json.Unmarshal(r.Body.Bytes(), &adminConfig)
+			assert.Nil(t, err)
+		})
+	return adminConfig.CSRFToken
+}
+
+func CSRFProtection(t *testing.T, testDB abstraction.Database) {
+	server, err := api.GetServer(&testDB, &config)
+	assert.Nil(t, err)
+	cookies := GetSessionCookie(&testDB, gofight.New())
+	token := csrfHeader(cookies)[api.CSRFHeader]
+	assert.NotEqual(t, "", token)
+	body := model.ThreadFlagBody{ThreadId: "not-an-id"}
+
+	response := sendAdminRequest(t, server, cookies, gofight.H{}, "POST", "/v1/admin/threads/lock", body, 403)
+	assert.Contains(t, response, global.ErrInvalidCSRFToken.Error())
+	sendAdminRequest(t, server, cookies, gofight.H{api.CSRFHeader: "wrong"}, "POST", "/v1/admin/threads/lock", body, 403)
+	sendAdminRequest(t, server, cookies, gofight.H{api.CSRFHeader: token}, "POST", "/v1/admin/threads/lock", body, 400)
+	// the token of another session is no good either
+	otherCookies := GetSessionCookie(&testDB, gofight.New())
+	sendAdminRequest(t, server, otherCookies, gofight.H{api.CSRFHeader: token}, "POST", "/v1/admin/threads/lock", body, 403)
+	// without a session there's nothing to protect
+	sendAdminRequest(t, server, gofight.H{}, gofight.H{}, "POST", "/v1/admin/threads/lock", body, 401)
+
+	// the admin panel gets the token from the config
+	assert.Equal(t, token, getAdminConfigCSRFToken(t, server, cookies))
+	assert.Equal(t, "", getAdminConfigCSRFToken(t, server, gofight.H{}))
+
+	// requests from other origins are turned away, token or not
+	response = sendAdminRequest(t, server, cookies, gofight.H{api.CSRFHeader: token, "Origin": "https://evil.example"}, "POST", "/v1/admin/threads/lock", body, 403)
+	assert.Contains(t, response, global.ErrInvalidOrigin.Error())
+	sendAdminRequest(t, server, cookies, gofight.H{api.CSRFHeader: token, "Referer": "https://evil.example/admin"}, "DELETE", "/v1/admin/comments", body, 403)
+	sendAdminRequest(t, server, gofight.H{}, gofight.H{"Origin": "https://evil.example"}, "POST", "/v1/admin/login", model.LoginBody{Password: adminPassword}, 403)
+	sendAdminRequest(t, server, gofight.H{}, gofight.H{"Origin": "null"}, "POST", "/v1/admin/login", model.LoginBody{Password: adminPassword}, 403)
+	// the admin panel is served from the host mouthful runs on
+	request := httptest.NewRequest("POST", "/v1/admin/login", strings.NewReader(fmt.Sprintf(`{"password": "%v"}`, adminPassword)))
+	request.Header.Set("Origin", "http://"+request.Host)
+	recorder := httptest.NewRecorder()
+	server.ServeHTTP(recorder, request)
+	assert.Equal(t, 204, recorder.Code)
+}
+
+func AdminOrigins(t *testing.T, testDB abstraction.Database) {
+	configCopy := config
+	configCopy.Moderation.AdminOrigins = &[]string{"https://admin.example/"}
+	server, err := api.GetServer(&testDB, &configCopy)
+	assert.Nil(t, err)
+	cookies := GetSessionCookie(&testDB, gofight.New())
+	token := csrfHeader(cookies)[api.CSRFHeader]
+	body := model.ThreadFlagBody{ThreadId: "not-an-id"}
+	sendAdminRequest(t, server, cookies, gofight.H{api.CSRFHeader: token, "Origin": "https://admin.example"}, "POST", "/v1/admin/threads/lock", body, 400)
+	sendAdminRequest(t, server, cookies, gofight.H{api.CSRFHeader: token, "Origin": "http://admin.example"}, "POST", "/v1/admin/threads/lock", body, 403)
+	sendAdminRequest(t, server, gofight.H{}, gofight.H{"Origin": "https://admin.example"}, "POST", "/v1/admin/login", model.LoginBody{Password: adminPassword}, 204)
+}
+
+func SessionCookieOptions(t *testing.T, testDB abstraction.Database) {
+	setCookie := func(server http.Handler) string {
+		result := ""
+		gofight.New().POST("/v1/admin/login").
+			SetBody(fmt.Sprintf(`{"password": "%v"}`, adminPassword)).
+			SetDebug(debug).
+			Run(server, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+				assert.Equal(t, 204, r.Code)
+				result = r.HeaderMap.Get("Set-Cookie")
+			})
+		return result
+	}
+	configCopy := config
+	server, err := api.GetServer(&testDB, &configCopy)
+	assert.Nil(t, err)
+	cookie := setCookie(server)
+	assert.Contains(t, cookie, "HttpOnly")
+	assert.Contains(t, cookie, "SameSite=Lax")
+	assert.NotContains(t, cookie, "Secure")
+
+	configCopy.Moderation.SessionCookie = configModel.SessionCookie{SameSite: "strict", Secure: true}
+	server, err = api.GetServer(&testDB, &configCopy)
+	assert.Nil(t, err)
+	cookie = setCookie(server)
+	assert.Contains(t, cookie, "SameSite=Strict")
+	assert.Contains(t, cookie, "Secure")
+
+	configCopy.Moderation.SessionCookie = configModel.SessionCookie{SameSite: "none", Secure: true}
+	server, err = api.GetServer(&testDB, &configCopy)
+	assert.Nil(t, err)
+	assert.Contains(t, setCookie(server), "SameSite=None")
+
+	configCopy.Moderation.SessionCookie = configModel.SessionCookie{SameSite: "none"}
+	_, err = api.GetServer(&testDB, &configCopy)
+	assert.NotNil(t, err)
+
+	configCopy.Moderation.SessionCookie = configModel.SessionCookie{SameSite: "sometimes", Secure: true}
+	_, err = api.GetServer(&testDB, &configCopy)
+	assert.NotNil(t, err)
+}
+
+func loginFrom(t *testing.T, server http.Handler, ip string, body model.LoginBody, expectedCode int) *httptest.ResponseRecorder {
+	v, err := json.Marshal(body)
+	assert.Nil(t, err)
+	request := httptest.NewRequest("POST", "/v1/admin/login", bytes.NewReader(v))
+	request.RemoteAddr = ip + ":1234"
+	recorder := httptest.NewRecorder()
+	server.ServeHTTP(recorder, request)
+	assert.Equal(t, expectedCode, recorder.Code)
+	return recorder
+}
+
+func LoginThrottling(t *testing.T, testDB abstraction.Database) {
+	configCopy := config
+	maxFailures := 3
+	configCopy.Moderation.LoginThrottling = configModel.LoginThrottling{MaxFailures: &maxFailures}
+	server, err := api.GetServer(&testDB, &configCopy)
+	assert.Nil(t, err)
+	password := "blogpassword"
+	createSite(t, server, gofight.New(), GetSessionCookie(&testDB, gofight.New()), model.SiteBody{Key: "blog", AdminPassword: &password})
+	wrong := model.LoginBody{Password: "wrong"}
+	right := model.LoginBody{Password: adminPassword}
+
+	// every failure blocks the next login for a while, even with the right password
+	loginFrom(t, server, "10.0.0.1", wrong, 401)
+	response := loginFrom(t, server, "10.0.0.1", right, 429)
+	assert.Equal(t, "1", response.Header().Get("Retry-After"))
+	failure, err := testDB.GetLoginFailure(api.LoginFailureIPId("10.0.0.1"))
+	assert.Nil(t, err)
+	assert.Equal(t, 1, failure.Failures)
+	assert.WithinDuration(t, time.Now().Add(time.Second), failure.BlockedUntil, time.Second)
+	failure, err = testDB.GetLoginFailure(api.LoginFailureAccountId("admin"))
+	assert.Nil(t, err)
+	assert.Equal(t, 1, failure.Failures)
+
+	// the delay doubles with each failure in a row
+	unblock := func() {
+		failures, err := testDB.GetLoginFailures()
+		assert.Nil(t, err)
+		for _, v := range failures {
+			v.BlockedUntil = time.Now().Add(-time.Second)
+			err = testDB.SaveLoginFailure(v)
+			assert.Nil(t, err)
+		}
+	}
+	unblock()
+	loginFrom(t, server, "10.0.0.1", wrong, 401)
+	response = loginFrom(t, server, "10.0.0.1", right, 429)
+	assert.Equal(t, "2", response.Header().Get("Retry-After"))
+
+	// a successful login forgets the failures
+	unblock()
+	loginFrom(t, server, "10.0.0.1", right, 204)
+	_, err = testDB.GetLoginFailure(api.LoginFailureIPId("10.0.0.1"))
+	assert.Equal(t, global.ErrLoginFailureNotFound, err)
+	_, err = testDB.GetLoginFailure(api.LoginFailureAccountId("admin"))
+	assert.Equal(t, global.ErrLoginFailureNotFound, err)
+
+	// too many failures in a row lock the account out, whichever client they come from
+	for i, ip := range []string{"10.0.0.1", "10.0.0.2", "10.0.0.3"} {
+		if i > 0 {
+			unblock()
+		}
+		loginFrom(t, server, ip, wrong, 401)
+	}
+	response = loginFrom(t, server, "10.0.0.4", right, 429)
+	assert.Equal(t, strconv.Itoa(global.DefaultLoginLockoutSeconds), response.Header().Get("Retry-After"))
+	// the accounts of the sites are separate
+	loginFrom(t, server, "10.0.0.5", model.LoginBody{Password: password, Site: "blog"}, 204)
+
+	entries, err := testDB.GetAuditEntries(dbmodel.AuditQuery{})
+	assert.Nil(t, err)
+	actions := make(map[string]int)
+	for _, v := range entries {
+		actions[v.Action]++
+		if v.Action == dbmodel.AuditLoginLockout {
+			assert.Equal(t, "admin", v.Subject)
+			assert.Equal(t, "", v.Actor)
+			assert.Equal(t, "10.0.0.3", v.IP)
+		}
+	}
+	assert.Equal(t, 4, actions[dbmodel.AuditLoginFailure])
+	assert.Equal(t, 1, actions[dbmodel.AuditLoginLockout])
+
+	// the admin password can't be empty, even with the password login disabled
+	configCopy.Moderation.AdminPassword = ""
+	configCopy.Moderation.DisablePasswordLogin = true
+	configCopy.Moderation.SessionSecret = strings.Repeat("a", 32)
+	configCopy.Moderation.OAauthProviders = &someFakeOauthProviders
+	configCopy.Moderation.OAuthCallbackOrigin = &fakeOrigin
+	configCopy.Moderation.LoginThrottling = noLoginThrottling
+	server, err = api.GetServer(&testDB, &configCopy)
+	assert.Nil(t, err)
+	err = testDB.DeleteLoginFailures()
+	assert.Nil(t, err)
+	loginFrom(t, server, "10.0.0.6", model.LoginBody{Password: ""}, 401)
+
+	configCopy.Moderation.LoginThrottling = configModel.LoginThrottling{MaxFailures: new(int)}
+	*configCopy.Moderation.LoginThrottling.MaxFailures = -1
+	_, err = api.GetServer(&testDB, &configCopy)
+	assert.NotNil(t, err)
+}
+
+func LoginThrottlingConcurrent(t *testing.T, testDB abstraction.Database) {
+	server, err := api.GetServer(&testDB, &config)
+	assert.Nil(t, err)
+	v, err := json.Marshal(model.LoginBody{Password: "wrong"})
+	assert.Nil(t, err)
+
+	// the attempts sent at once can't all get past the check before the first failure is recorded
+	attempts := 10
+	codes := make(chan int, attempts)
+	start := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < attempts; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			request := httptest.NewRequest("POST", "/v1/admin/login", bytes.NewReader(v))
+			request.RemoteAddr = "10.0.0.1:1234"
+			recorder := httptest.NewRecorder()
+			server.ServeHTTP(recorder, request)
+			codes <- recorder.Code
+		}()
+	}
+	close(start)
+	wg.Wait()
+	close(codes)
+	counts := make(map[int]int)
+	for code := range codes {
+		counts[code]++
+	}
+	assert.Equal(t, 1, counts[401])
+	assert.Equal(t, attempts-1, counts[429])
+	failure, err := testDB.GetLoginFailure(api.LoginFailureIPId("10.0.0.1"))
+	assert.Nil(t, err)
+	assert.Equal(t, 1, failure.Failures)
+}
+
+func LoginThrottlingTrustedProxies(t *testing.T, testDB abstraction.Database) {
+	configCopy := config
+	// no delay, so that the failures keep being recorded
+	configCopy.Moderation.LoginThrottling = configModel.LoginThrottling{BaseDelaySeconds: new(int)}
+	loginForwarded := func(server http.Handler, ip string, forwardedFor string) {
+		v, err := json.Marshal(model.LoginBody{Password: "wrong"})
+		assert.Nil(t, err)
+		request := httptest.NewRequest("POST", "/v1/admin/login", bytes.NewReader(v))
+		request.RemoteAddr = ip + ":1234"
+		request.Header.Set("X-Forwarded-For", forwardedFor)
+		server.ServeHTTP(httptest.NewRecorder(), request)
+	}
+
+	// the clients can't pick the ip they get throttled by, but the proxies that aren't trusted get a warning logged, once
+	server, err := api.GetServer(&testDB, &configCopy)
+	assert.Nil(t, err)
+	var logged bytes.Buffer
+	log.SetOutput(&logged)
+	loginForwarded(server, "10.0.0.1", "10.0.0.9")
+	loginForwarded(server, "10.0.0.1", "10.0.0.9")
+	log.SetOutput(os.Stderr)
+	assert.Equal(t, 1, strings.Count(logged.String(), "add it to config.API.TrustedProxies"))
+	assert.Contains(t, logged.String(), "10.0.0.1")
+	_, err = testDB.GetLoginFailure(api.LoginFailureIPId("10.0.0.9"))
+	assert.Equal(t, global.ErrLoginFailureNotFound, err)
+	_, err = testDB.GetLoginFailure(api.LoginFailureIPId("10.0.0.1"))
+	assert.Nil(t, err)
+
+	// unless they come through one of the trusted proxies
+	configCopy.API.TrustedProxies = &[]string{"10.0.1.0/24"}
+	server, err = api.GetServer(&testDB, &configCopy)
+	assert.Nil(t, err)
+	logged.Reset()
+	log.SetOutput(&logged)
+	loginForwarded(server, "10.0.1.1", "10.0.0.9")
+	log.SetOutput(os.Stderr)
+	assert.NotContains(t, logged.String(), "TrustedProxies")
+	_, err = testDB.GetLoginFailure(api.LoginFailureIPId("10.0.0.9"))
+	assert.Nil(t, err)
+	_, err = testDB.GetLoginFailure(api.LoginFailureIPId("10.0.1.1"))
+	assert.Equal(t, global.ErrLoginFailureNotFound, err)
+
+	configCopy.API.TrustedProxies = &[]string{"10.0.1.300"}
+	_, err = api.GetServer(&testDB, &configCopy)
+	assert.NotNil(t, err)
+}
+
+func passwordLogin(t *testing.T, server http.Handler, body interface{}, route string, cookies gofight.H, expectedCode int) (gofight.H, string) {
+	v, err := json.Marshal(body)
+	assert.Nil(t, err)
+	result := gofight.H{}
+	responseBody := ""
+	gofight.New().POST(route).
+		SetBody(string(v)).
+		SetCookie(cookies).
+		SetDebug(debug).
+		Run(server, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+			assert.Equal(t, expectedCode, r.Code)
+			responseBody = r.Body.String()
+			for _, v := range r.HeaderMap["Set-Cookie"] {
+				if strings.HasPrefix(v, "mouthful-session=") {
+					value := strings.TrimSuffix(strings.Split(strings.TrimPrefix(v, "mouthful-session="), " ")[0], ";")
+					result["mouthful-session"] = value
+					csrfTokens[value] = r.HeaderMap.Get(api.CSRFHeader)
+				}
+			}
+		})
+	return result, responseBody
+}
+
+func getTwoFactorStatus(t *testing.T, server http.Handler, cookies gofight.H, expectedCode int) model.TwoFactorStatus {
+	var status model.TwoFactorStatus
+	gofight.New().GET("/v1/admin/2fa").
+		SetCookie(cookies).
+		SetDebug(debug).
+		Run(server, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+			assert.Equal(t, expectedCode, r.Code)
+			if r.Code == 200 {
+				err := json.Unmarshal(r.Body.Bytes(), &status)
+				assert.Nil(t, err)
+			}
+		})
+	return status
+}
+
+func TwoFactorLogin(t *testing.T, testDB abstraction.Database) {
+	configCopy := config
+	configCopy.Moderation.TwoFactor = configModel.TwoFactor{Enabled: true, EncryptionKey: strings.Repeat("k", 32)}
+	configCopy.Moderation.LoginThrottling = noLoginThrottling
+	server, err := api.GetServer(&testDB, &configCopy)
+	assert.Nil(t, err)
+	login := model.LoginBody{Password: adminPassword}
+
+	// without a second factor set up, the password is enough
+	cookies, _ := passwordLogin(t, server, login, "/v1/admin/login", gofight.H{}, 204)
+	assert.Equal(t, model.TwoFactorStatus{}, getTwoFactorStatus(t, server, cookies, 200))
+	getTwoFactorStatus(t, server, gofight.H{}, 401)
+
+	response := sendAdminRequest(t, server, cookies, csrfHeader(cookies), "POST", "/v1/admin/2fa", nil, 200)
+	var enrollment model.TwoFactorEnrollment
+	err = json.Unmarshal([]byte(response), &enrollment)
+	assert.Nil(t, err)
+	assert.True(t, strings.HasPrefix(enrollment.URI, "otpauth://totp/mouthful:admin?"))
+	assert.Contains(t, enrollment.URI, "secret="+enrollment.Secret)
+	// the secret is only stored encrypted
+	stored, err := testDB.GetTwoFactor("admin")
+	assert.Nil(t, err)
+	assert.NotContains(t, stored.Secret, enrollment.Secret)
+	assert.Equal(t, model.TwoFactorStatus{Pending: true}, getTwoFactorStatus(t, server, cookies, 200))
+	// an unconfirmed secret is not asked for
+	passwordLogin(t, server, login, "/v1/admin/login", gofight.H{}, 204)
+
+	sendAdminRequest(t, server, cookies, csrfHeader(cookies), "POST", "/v1/admin/2fa/confirm", model.TwoFactorBody{Code: "000000"}, 400)
+	code, err := api.TOTPCode(enrollment.Secret, time.Now())
+	assert.Nil(t, err)
+	response = sendAdminRequest(t, server, cookies, csrfHeader(cookies), "POST", "/v1/admin/2fa/confirm", model.TwoFactorBody{Code: code}, 200)
+	var recovery model.RecoveryCodesResponse
+	err = json.Unmarshal([]byte(response), &recovery)
+	assert.Nil(t, err)
+	assert.Len(t, recovery.RecoveryCodes, 10)
+	assert.Equal(t, model.TwoFactorStatus{Enabled: true, RecoveryCodesLeft: 10}, getTwoFactorStatus(t, server, cookies, 200))
+	sendAdminRequest(t, server, cookies, csrfHeader(cookies), "POST", "/v1/admin/2fa", nil, 409)
+
+	// the password alone no longer makes an admin
+	pending, response := passwordLogin(t, server, login, "/v1/admin/login", gofight.H{}, 200)
+	assert.JSONEq(t, `{"twoFactorRequired": true}`, response)
+	getMe(t, server, gofight.New(), pending, 401)
+	sendAdminRequest(t, server, pending, gofight.H{}, "POST", "/v1/admin/threads/lock", model.ThreadFlagBody{ThreadId: "not-an-id"}, 401)
+	// the code used for the confirmation can't be used again, and neither can made up ones
+	passwordLogin(t, server, model.TwoFactorBody{Code: code}, "/v1/admin/login/2fa", pending, 401)
+	passwordLogin(t, server, model.TwoFactorBody{Code: "123456"}, "/v1/admin/login/2fa", pending, 401)
+	passwordLogin(t, server, model.TwoFactorBody{RecoveryCode: "aaaaa-bbbbb"}, "/v1/admin/login/2fa", pending, 401)
+	// there's nothing to complete without the password
+	passwordLogin(t, server, model.TwoFactorBody{RecoveryCode: recovery.RecoveryCodes[0]}, "/v1/admin/login/2fa", gofight.H{}, 401)
+
+	nextCode, err := api.TOTPCode(enrollment.Secret, time.Now().Add(30*time.Second))
+	assert.Nil(t, err)
+	admin, _ := passwordLogin(t, server, model.TwoFactorBody{Code: nextCode}, "/v1/admin/login/2fa", pending, 204)
+	identity := getMe(t, server, gofight.New(), admin, 200)
+	assert.Equal(t, api.PasswordProvider, identity.Provider)
+
+	// the recovery codes work once each
+	pending, _ = passwordLogin(t, server, login, "/v1/admin/login", gofight.H{}, 200)
+	admin, _ = passwordLogin(t, server, model.TwoFactorBody{RecoveryCode: strings.ToUpper(recovery.RecoveryCodes[0])}, "/v1/admin/login/2fa", pending, 204)
+	getMe(t, server, gofight.New(), admin, 200)
+	pending, _ = passwordLogin(t, server, login, "/v1/admin/login", gofight.H{}, 200)
+	passwordLogin(t, server, model.TwoFactorBody{RecoveryCode: recovery.RecoveryCodes[0]}, "/v1/admin/login/2fa", pending, 401)
+	assert.Equal(t, 9, getTwoFactorStatus(t, server, admin, 200).RecoveryCodesLeft)
+
+	response = sendAdminRequest(t, server, admin, csrfHeader(admin), "POST", "/v1/admin/2fa/recovery-codes", model.TwoFactorBody{RecoveryCode: recovery.RecoveryCodes[1]}, 200)
+	var regenerated model.RecoveryCodesResponse
+	err = json.Unmarshal([]byte(response), &regenerated)
+	assert.Nil(t, err)
+	assert.Len(t, regenerated.RecoveryCodes, 10)
+	sendAdminRequest(t, server, admin, csrfHeader(admin), "POST", "/v1/admin/2fa/recovery-codes", model.TwoFactorBody{RecoveryCode: recovery.RecoveryCodes[2]}, 400)
+
+	// turning it off takes a code as well
+	sendAdminRequest(t, server, admin, csrfHeader(admin), "DELETE", "/v1/admin/2fa", model.TwoFactorBody{Code: "000000"}, 400)
+	sendAdminRequest(t, server, admin, csrfHeader(admin), "DELETE", "/v1/admin/2fa", model.TwoFactorBody{RecoveryCode: regenerated.RecoveryCodes[0]}, 204)
+	sendAdminRequest(t, server, admin, csrfHeader(admin), "DELETE", "/v1/admin/2fa", model.TwoFactorBody{}, 404)
+	passwordLogin(t, server, login, "/v1/admin/login", gofight.H{}, 204)
+
+	entries, err := testDB.GetAuditEntries(dbmodel.AuditQuery{})
+	assert.Nil(t, err)
+	actions := make(map[string]int)
+	for _, v := range entries {
+		actions[v.Action]++
+	}
+	assert.Equal(t, 1, actions[dbmodel.AuditTwoFactorEnable])
+	assert.Equal(t, 1, actions[dbmodel.AuditTwoFactorRecoveryCodeUse])
+	assert.Equal(t, 1, actions[dbmodel.AuditTwoFactorRecoveryCodes])
+	assert.Equal(t, 1, actions[dbmodel.AuditTwoFactorDisable])
+	assert.Equal(t, 6, actions[dbmodel.AuditLoginFailure])
+}
+
+func TwoFactorChecksThrottled(t *testing.T, testDB abstraction.Database) {
+	configCopy := config
+	configCopy.Moderation.TwoFactor = configModel.TwoFactor{Enabled: true, EncryptionKey: strings.Repeat("k", 32)}
+	baseDelay := 60
+	configCopy.Moderation.LoginThrottling = configModel.LoginThrottling{BaseDelaySeconds: &baseDelay}
+	server, err := api.GetServer(&testDB, &configCopy)
+	assert.Nil(t, err)
+	admin, _ := passwordLogin(t, server, model.LoginBody{Password: adminPassword}, "/v1/admin/login", gofight.H{}, 204)
+	response := sendAdminRequest(t, server, admin, csrfHeader(admin), "POST", "/v1/admin/2fa", nil, 200)
+	var enrollment model.TwoFactorEnrollment
+	err = json.Unmarshal([]byte(response), &enrollment)
+	assert.Nil(t, err)
+	code, err := api.TOTPCode(enrollment.Secret, time.Now())
+	assert.Nil(t, err)
+	response = sendAdminRequest(t, server, admin, csrfHeader(admin), "POST", "/v1/admin/2fa/confirm", model.TwoFactorBody{Code: code}, 200)
+	var recovery model.RecoveryCodesResponse
+	err = json.Unmarshal([]byte(response), &recovery)
+	assert.Nil(t, err)
+
+	// the wrong codes of a logged in admin block the checks like the ones of the logins do, so a stolen session can't guess them
+	sendAdminRequest(t, server, admin, csrfHeader(admin), "DELETE", "/v1/admin/2fa", model.TwoFactorBody{Code: "000000"}, 400)
+	_, err = testDB.GetLoginFailure(api.LoginFailureAccountId("admin"))
+	assert.Nil(t, err)
+	sendAdminRequest(t, server, admin, csrfHeader(admin), "DELETE", "/v1/admin/2fa", model.TwoFactorBody{RecoveryCode: recovery.RecoveryCodes[0]}, 429)
+	sendAdminRequest(t, server, admin, csrfHeader(admin), "POST", "/v1/admin/2fa/recovery-codes", model.TwoFactorBody{RecoveryCode: recovery.RecoveryCodes[0]}, 429)
+	assert.True(t, getTwoFactorStatus(t, server, admin, 200).Enabled)
+
+	err = testDB.DeleteLoginFailures()
+	assert.Nil(t, err)
+	sendAdminRequest(t, server, admin, csrfHeader(admin), "DELETE", "/v1/admin/2fa", model.TwoFactorBody{RecoveryCode: recovery.RecoveryCodes[0]}, 204)
+	entries, err := testDB.GetAuditEntries(dbmodel.AuditQuery{})
+	assert.Nil(t, err)
+	failures := 0
+	for _, v := range entries {
+		if v.Action == dbmodel.AuditLoginFailure {
+			failures++
+		}
+	}
+	assert.Equal(t, 1, failures)
+}
+
+func TwoFactorConfig(t *testing.T, testDB abstraction.Database) {
+	configCopy := config
+	configCopy.Moderation.TwoFactor = configModel.TwoFactor{Enabled: true, EncryptionKey: "short"}
+	_, err := api.GetServer(&testDB, &configCopy)
+	assert.NotNil(t, err)
+
+	// the routes are only there with the two-factor authentication enabled
+	server, err := api.GetServer(&testDB, &config)
+	assert.Nil(t, err)
+	cookies := GetSessionCookie(&testDB, gofight.New())
+	getTwoFactorStatus(t, server, cookies, 404)
+	sendAdminRequest(t, server, gofight.H{}, gofight.H{}, "POST", "/v1/admin/login/2fa", model.TwoFactorBody{Code: "123456"}, 404)
+}
+
+// softAuthenticator is a webauthn authenticator in software, answering the ceremonies with a P-256 key
+type softAuthenticator struct {
+	key     *ecdsa.PrivateKey
+	id      []byte
+	counter uint32
+	origin  string
+	rpId    string
+	flags   byte
+}
+
+func newSoftAuthenticator(t *testing.T, origin string, rpId string) *softAuthenticator {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(t, err)
+	id := make([]byte, 16)
+	_, err = rand.Read(id)
+	assert.Nil(t, err)
+	// user present and user verified
+	return &softAuthenticator{key: key, id: id, origin: origin, rpId: rpId, flags: 0x05}
+}
+
+func (a *softAuthenticator) clientData(t *testing.T, ceremony string, challenge string) []byte {
+	data, err := json.Marshal(map[string]interface{}{"type": ceremony, "challenge": challenge, "origin": a.origin, "crossOrigin": false})
+	assert.Nil(t, err)
+	return data
+}
+
+func (a *softAuthenticator) authData(t *testing.T, attested bool) []byte {
+	rpIdHash := sha256.Sum256([]byte(a.rpId))
+	data := append([]byte{}, rpIdHash[:]...)
+	flags := a.flags
+	if attested {
+		flags |= 0x40
+	}
+	data = append(data, flags)
+	counter := make([]byte, 4)
+	binary.BigEndian.PutUint32(counter, a.counter)
+	data = append(data, counter...)
+	if !attested {
+		return data
+	}
+	data = append(data, make([]byte, 16)...)
+	idLength := make([]byte, 2)
+	binary.BigEndian.PutUint16(idLength, uint16(len(a.id)))
+	data = append(data, idLength...)
+	data = append(data, a.id...)
+	var publicKey []byte
+	x, y := a.key.PublicKey.X.Bytes(), a.key.PublicKey.Y.Bytes()
+	x, y = append(make([]byte, 32-len(x)), x...), append(make([]byte, 32-len(y)), y...)
+	err := codec.NewEncoderBytes(&publicKey, &codec.CborHandle{}).Encode(map[int]interface{}{1: 2, 3: -7, -1: 1, -2: x, -3: y})
+	assert.Nil(t, err)
+	return append(data, publicKey...)
+}
+
+func (a *softAuthenticator) credentialId() string {
+	return base64.RawURLEncoding.EncodeToString(a.id)
+}
+
+func (a *softAuthenticator) register(t *testing.T, options model.WebAuthnCreationOptions, name string) model.WebAuthnRegistrationBody {
+	var attestation []byte
+	err := codec.NewEncoderBytes(&attestation, &codec.CborHandle{}).Encode(map[string]interface{}{"fmt": "none", "attStmt": map[string]interface{}{}, "authData": a.authData(t, true)})
+	assert.Nil(t, err)
+	return model.WebAuthnRegistrationBody{
+		Name: name,
+		Id:   a.credentialId(),
+		Type: "public-key",
+		Response: model.WebAuthnAttestationResponse{
+			ClientDataJSON:    base64.RawURLEncoding.EncodeToString(a.clientData(t, "webauthn.create", options.Challenge)),
+			AttestationObject: base64.RawURLEncoding.EncodeToString(attestation),
+		},
+	}
+}
+
+func (a *softAuthenticator) login(t *testing.T, options model.WebAuthnRequestOptions) model.WebAuthnAssertionBody {
+	a.counter++
+	authData := a.authData(t, false)
+	clientData := a.clientData(t, "webauthn.get", options.Challenge)
+	clientDataHash := sha256.Sum256(clientData)
+	digest := sha256.Sum256(append(append([]byte{}, authData...), clientDataHash[:]...))
+	r, s, err := ecdsa.Sign(rand.Reader, a.key, digest[:])
+	assert.Nil(t, err)
+	signature, err := asn1.Marshal(struct{ R, S *big.Int }{r, s})
+	assert.Nil(t, err)
+	return model.WebAuthnAssertionBody{
+		Id:   a.credentialId(),
+		Type: "public-key",
+		Response: model.WebAuthnAssertionResponse{
+			ClientDataJSON:    base64.RawURLEncoding.EncodeToString(clientData),
+			AuthenticatorData: base64.RawURLEncoding.EncodeToString(authData),
+			Signature:         base64.RawURLEncoding.EncodeToString(signature),
+		},
+	}
+}
+
+// webAuthnRequest posts the body, returning the cookies of the session afterwards, as the ceremonies keep their challenges in it, along with the response body
+func webAuthnRequest(t *testing.T, server http.Handler, cookies gofight.H, route string, body interface{}, expectedCode int) (gofight.H, string) {
+	v, err := json.Marshal(body)
+	assert.Nil(t, err)
+	result := gofight.H{}
+	for k, v := range cookies {
+		result[k] = v
+	}
+	responseBody := ""
+	gofight.New().POST(route).
+		SetBody(string(v)).
+		SetCookie(cookies).
+		SetHeader(csrfHeader(cookies)).
+		SetDebug(debug).
+		Run(server, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+			assert.Equal(t, expectedCode, r.Code)
+			responseBody = r.Body.String()
+			for _, v := range r.HeaderMap["Set-Cookie"] {
+				if strings.HasPrefix(v, "mouthful-session=") {
+					value := strings.TrimSuffix(strings.Split(strings.TrimPrefix(v, "mouthful-session="), " ")[0], ";")
+					token := r.HeaderMap.Get(api.CSRFHeader)
+					if token == "" {
+						token = csrfTokens[cookies["mouthful-session"]]
+					}
+					result["mouthful-session"] = value
+					csrfTokens[value] = token
+				}
+			}
+		})
+	return result, responseBody
+}
+
+func beginWebAuthnRegistration(t *testing.T, server http.Handler, cookies gofight.H) (gofight.H, model.WebAuthnCreationOptions) {
+	var options model.WebAuthnCreationOptions
+	cookies, response := webAuthnRequest(t, server, cookies, "/v1/admin/webauthn/register/begin", nil, 200)
+	err := json.Unmarshal([]byte(response), &options)
+	assert.Nil(t, err)
+	return cookies, options
+}
+
+func beginWebAuthnLogin(t *testing.T, server http.Handler) (gofight.H, model.WebAuthnRequestOptions) {
+	var options model.WebAuthnRequestOptions
+	cookies, response := webAuthnRequest(t, server, gofight.H{}, "/v1/admin/webauthn/login/begin", nil, 200)
+	err := json.Unmarshal([]byte(response), &options)
+	assert.Nil(t, err)
+	return cookies, options
+}
+
+func WebAuthnChallengesPerClient(t *testing.T, testDB abstraction.Database) {
+	configCopy := config
+	configCopy.Moderation.WebAuthn = configModel.WebAuthn{Enabled: true, RPID: "example.com", RPName: "Example"}
+	configCopy.API.TrustedProxies = &[]string{"10.0.1.1"}
+	server, err := api.GetServer(&testDB, &configCopy)
+	assert.Nil(t, err)
+	beginLogin := func(forwardedFor string, cookie string) (int, string) {
+		request := httptest.NewRequest("POST", "/v1/admin/webauthn/login/begin", nil)
+		request.RemoteAddr = "10.0.1.1:1234"
+		request.Header.Set("X-Forwarded-For", forwardedFor)
+		if cookie != "" {
+			request.Header.Set("Cookie", cookie)
+		}
+		recorder := httptest.NewRecorder()
+		server.ServeHTTP(recorder, request)
+		for _, v := range recorder.HeaderMap["Set-Cookie"] {
+			if strings.HasPrefix(v, "mouthful-session=") {
+				cookie = strings.Split(v, ";")[0]
+			}
+		}
+		return recorder.Code, cookie
+	}
+
+	// a client dropping the session cookie can only keep a few challenges waiting, and the other clients can still log in
+	for i := 0; i < global.MaxWebAuthnChallengesPerClient; i++ {
+		code, _ := beginLogin("10.0.0.9", "")
+		assert.Equal(t, 200, code)
+	}
+	code, _ := beginLogin("10.0.0.9", "")
+	assert.Equal(t, 429, code)
+	code, _ = beginLogin("10.0.0.8", "")
+	assert.Equal(t, 200, code)
+
+	// beginning again with the same session replaces its challenge
+	_, cookie := beginLogin("10.0.0.7", "")
+	for i := 0; i < global.MaxWebAuthnChallengesPerClient*2; i++ {
+		code, cookie = beginLogin("10.0.0.7", cookie)
+		assert.Equal(t, 200, code)
+	}
+}
+
+func WebAuthnLogin(t *testing.T, testDB abstraction.Database) {
+	configCopy := config
+	configCopy.Moderation.WebAuthn = configModel.WebAuthn{Enabled: true, RPID: "example.com", RPName: "Example"}
+	configCopy.Moderation.AdminOrigins = &[]string{"https://admin.example.com"}
+	configCopy.Moderation.LoginThrottling = noLoginThrottling
+	server, err := api.GetServer(&testDB, &configCopy)
+	assert.Nil(t, err)
+	authenticator := newSoftAuthenticator(t, "https://admin.example.com", "example.com")
+
+	gofight.New().GET("/v1/admin/config").
+		SetDebug(debug).
+		Run(server, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+			assert.Equal(t, 200, r.Code)
+			assert.Contains(t, r.Body.String(), `"webauthn":true`)
+		})
+	// only admins register credentials
+	webAuthnRequest(t, server, gofight.H{}, "/v1/admin/webauthn/register/begin", nil, 401)
+	webAuthnRequest(t, server, gofight.H{}, "/v1/admin/webauthn/register/finish", authenticator.register(t, model.WebAuthnCreationOptions{}, "laptop"), 401)
+
+	admin, _ := passwordLogin(t, server, model.LoginBody{Password: adminPassword}, "/v1/admin/login", gofight.H{}, 204)
+	cookies, options := beginWebAuthnRegistration(t, server, admin)
+	assert.NotEmpty(t, options.Challenge)
+	assert.Equal(t, "example.com", options.RelyingParty.Id)
+	assert.Equal(t, "Example", options.RelyingParty.Name)
+	assert.Equal(t, "admin", options.User.Name)
+	assert.NotEmpty(t, options.User.Id)
+	assert.Equal(t, "required", options.AuthenticatorSelection.UserVerification)
+	assert.Len(t, options.ExcludeCredentials, 0)
+	assert.Equal(t, -7, options.Parameters[0].Algorithm)
+
+	// the response has to come from the admin panel and answer the challenge
+	evil := *authenticator
+	evil.origin = "https://admin.evil.com"
+	webAuthnRequest(t, server, cookies, "/v1/admin/webauthn/register/finish", evil.register(t, options, "laptop"), 400)
+	cookies, options = beginWebAuthnRegistration(t, server, admin)
+	webAuthnRequest(t, server, cookies, "/v1/admin/webauthn/register/finish", authenticator.register(t, model.WebAuthnCreationOptions{Challenge: "wrong"}, "laptop"), 400)
+	// the challenges are only answered once
+	webAuthnRequest(t, server, admin, "/v1/admin/webauthn/register/finish", authenticator.register(t, options, "laptop"), 400)
+	cookies, options = beginWebAuthnRegistration(t, server, admin)
+	unverified := *authenticator
+	unverified.flags = 0x01
+	webAuthnRequest(t, server, cookies, "/v1/admin/webauthn/register/finish", unverified.register(t, options, "laptop"), 400)
+
+	cookies, options = beginWebAuthnRegistration(t, server, admin)
+	_, response := webAuthnRequest(t, server, cookies, "/v1/admin/webauthn/register/finish", authenticator.register(t, options, "laptop"), 200)
+	// nor can the cookie with the challenge in it be replayed
+	other := newSoftAuthenticator(t, "https://admin.example.com", "example.com")
+	webAuthnRequest(t, server, cookies, "/v1/admin/webauthn/register/finish", other.register(t, options, "phone"), 400)
+	var credential dbmodel.WebAuthnCredential
+	err = json.Unmarshal([]byte(response), &credential)
+	assert.Nil(t, err)
+	assert.Equal(t, authenticator.credentialId(), credential.Id)
+	assert.Equal(t, "laptop", credential.Name)
+	assert.Equal(t, api.PasswordProvider, credential.Provider)
+	assert.NotContains(t, response, "PublicKey")
+
+	cookies, options = beginWebAuthnRegistration(t, server, admin)
+	assert.Len(t, options.ExcludeCredentials, 1)
+	webAuthnRequest(t, server, cookies, "/v1/admin/webauthn/register/finish", authenticator.register(t, options, "laptop"), 409)
+	var credentials []dbmodel.WebAuthnCredential
+	gofight.New().GET("/v1/admin/webauthn/credentials").
+		SetCookie(admin).
+		SetDebug(debug).
+		Run(server, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+			assert.Equal(t, 200, r.Code)
+			err := json.Unmarshal(r.Body.Bytes(), &credentials)
+			assert.Nil(t, err)
+		})
+	assert.Len(t, credentials, 1)
+
+	// logging in with the credential makes an admin of the one who registered it
+	pending, loginOptions := beginWebAuthnLogin(t, server)
+	assert.Equal(t, "example.com", loginOptions.RelyingPartyId)
+	getMe(t, server, gofight.New(), pending, 401)
+	assertion := authenticator.login(t, loginOptions)
+	loggedIn, _ := webAuthnRequest(t, server, pending, "/v1/admin/webauthn/login/finish", assertion, 204)
+	identity := getMe(t, server, gofight.New(), loggedIn, 200)
+	assert.Equal(t, api.PasswordProvider, identity.Provider)
+	assert.Equal(t, api.PasswordUserId, identity.UserId)
+	stored, err := testDB.GetWebAuthnCredential(authenticator.credentialId())
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), stored.SignCount)
+	assert.NotNil(t, stored.LastUsedAt)
+
+	// the cookie with the challenge still in it can be sent again, but the challenge is gone, even with a fresh signature
+	webAuthnRequest(t, server, pending, "/v1/admin/webauthn/login/finish", assertion, 401)
+	webAuthnRequest(t, server, pending, "/v1/admin/webauthn/login/finish", authenticator.login(t, loginOptions), 401)
+	webAuthnRequest(t, server, gofight.H{}, "/v1/admin/webauthn/login/finish", authenticator.login(t, loginOptions), 401)
+	pending, loginOptions = beginWebAuthnLogin(t, server)
+	forged := authenticator.login(t, loginOptions)
+	forged.Response.Signature = authenticator.login(t, model.WebAuthnRequestOptions{Challenge: "other"}).Response.Signature
+	webAuthnRequest(t, server, pending, "/v1/admin/webauthn/login/finish", forged, 401)
+	pending, loginOptions = beginWebAuthnLogin(t, server)
+	stranger := newSoftAuthenticator(t, "https://admin.example.com", "example.com")
+	webAuthnRequest(t, server, pending, "/v1/admin/webauthn/login/finish", stranger.login(t, loginOptions), 401)
+	pending, loginOptions = beginWebAuthnLogin(t, server)
+	webAuthnRequest(t, server, pending, "/v1/admin/webauthn/login/finish", unverified.login(t, loginOptions), 401)
+	pending, loginOptions = beginWebAuthnLogin(t, server)
+	otherRp := *authenticator
+	otherRp.rpId = "evil.com"
+	webAuthnRequest(t, server, pending, "/v1/admin/webauthn/login/finish", otherRp.login(t, loginOptions), 401)
+	pending, loginOptions = beginWebAuthnLogin(t, server)
+	loggedIn, _ = webAuthnRequest(t, server, pending, "/v1/admin/webauthn/login/finish", authenticator.login(t, loginOptions), 204)
+
+	sendAdminRequest(t, server, loggedIn, csrfHeader(loggedIn), "DELETE", "/v1/admin/webauthn/credentials", model.WebAuthnCredentialFlagBody{Id: "unknown"}, 404)
+	sendAdminRequest(t, server, loggedIn, csrfHeader(loggedIn), "DELETE", "/v1/admin/webauthn/credentials", model.WebAuthnCredentialFlagBody{Id: authenticator.credentialId()}, 204)
+	pending, loginOptions = beginWebAuthnLogin(t, server)
+	webAuthnRequest(t, server, pending, "/v1/admin/webauthn/login/finish", authenticator.login(t, loginOptions), 401)
+
+	entries, err := testDB.GetAuditEntries(dbmodel.AuditQuery{})
+	assert.Nil(t, err)
+	actions := make(map[string]int)
+	for _, v := range entries {
+		actions[v.Action]++
+	}
+	assert.Equal(t, 1, actions[dbmodel.AuditWebAuthnRegister])
+	assert.Equal(t, 1, actions[dbmodel.AuditWebAuthnDelete])
+	// the finishes without a challenge are turned away before they count
+	assert.Equal(t, 5, actions[dbmodel.AuditLoginFailure])
+}
+
+func WebAuthnConfig(t *testing.T, testDB abstraction.Database) {
+	configCopy := config
+	configCopy.Moderation.WebAuthn = configModel.WebAuthn{Enabled: true}
+	_, err := api.GetServer(&testDB, &configCopy)
+	assert.NotNil(t, err)
+	configCopy.Moderation.WebAuthn = configModel.WebAuthn{Enabled: true, RPID: "https://example.com"}
+	_, err = api.GetServer(&testDB, &configCopy)
+	assert.NotNil(t, err)
+
+	// the routes are only there with webauthn enabled
+	server, err := api.GetServer(&testDB, &config)
+	assert.Nil(t, err)
+	webAuthnRequest(t, server, gofight.H{}, "/v1/admin/webauthn/login/begin", nil, 404)
+}
+
+func proxyRequest(t *testing.T, server http.Handler, method string, route string, ip string, headers gofight.H, cookies gofight.H, expectedCode int) (gofight.H, string) {
+	request := httptest.NewRequest(method, route, nil)
+	request.RemoteAddr = ip + ":1234"
+	for k, v := range headers {
+		request.Header.Set(k, v)
+	}
+	for k, v := range cookies {
+		request.AddCookie(&http.Cookie{Name: k, Value: v})
+	}
+	recorder := httptest.NewRecorder()
+	server.ServeHTTP(recorder, request)
+	assert.Equal(t, expectedCode, recorder.Code)
+	result := gofight.H{}
+	for _, v := range recorder.Result().Cookies() {
+		if v.Name == "mouthful-session" {
+			result[v.Name] = v.Value
+		}
+	}
+	return result, recorder.Body.String()
+}
+
+func ProxyAuthLogin(t *testing.T, testDB abstraction.Database) {
+	configCopy := config
+	configCopy.Moderation.ProxyAuth = configModel.ProxyAuth{
+		Enabled:        true,
+		NameHeader:     "Remote-Name",
+		GroupsHeader:   "Remote-Groups",
+		TrustedProxies: &[]string{"10.0.0.1", "192.168.1.0/24"},
+		AdminUsers:     &[]string{"alice"},
+		AdminGroups:    &[]string{"moderators"},
+	}
+	server, err := api.GetServer(&testDB, &configCopy)
+	assert.Nil(t, err)
+	alice := gofight.H{"Remote-User": "alice", "Remote-Name": "Alice"}
+
+	// the headers are only trusted straight from the proxies, forwarded or not
+	proxyRequest(t, server, "POST", "/v1/admin/login/proxy", "10.0.0.2", alice, gofight.H{}, 401)
+	spoofed := gofight.H{"Remote-User": "alice", "X-Forwarded-For": "10.0.0.1"}
+	proxyRequest(t, server, "POST", "/v1/admin/login/proxy", "10.0.0.2", spoofed, gofight.H{}, 401)
+	proxyRequest(t, server, "POST", "/v1/admin/login/proxy", "10.0.0.1", gofight.H{}, gofight.H{}, 401)
+
+	cookies, _ := proxyRequest(t, server, "POST", "/v1/admin/login/proxy", "10.0.0.1", alice, gofight.H{}, 204)
+	_, body := proxyRequest(t, server, "GET", "/v1/admin/me", "10.0.0.1", alice, cookies, 200)
+	var identity model.AdminIdentity
+	err = json.Unmarshal([]byte(body), &identity)
+	assert.Nil(t, err)
+	assert.Equal(t, "alice", identity.UserId)
+	assert.Equal(t, "Alice", identity.Name)
+	assert.Equal(t, api.ProxyProvider, identity.Provider)
+
+	// the session only lasts as long as the proxy vouches for the admin of it
+	proxyRequest(t, server, "GET", "/v1/admin/me", "10.0.0.1", gofight.H{}, cookies, 401)
+	proxyRequest(t, server, "GET", "/v1/admin/me", "10.0.0.2", alice, cookies, 401)
+	proxyRequest(t, server, "GET", "/v1/admin/me", "10.0.0.1", gofight.H{"Remote-User": "bob", "Remote-Groups": "moderators"}, cookies, 401)
+
+	// the members of the admin groups are admins too
+	bob := gofight.H{"Remote-User": "bob", "Remote-Groups": "users, moderators"}
+	cookies, _ = proxyRequest(t, server, "POST", "/v1/admin/login/proxy", "192.168.1.20", bob, gofight.H{}, 204)
+	proxyRequest(t, server, "GET", "/v1/admin/me", "192.168.1.20", bob, cookies, 200)
+
+	// the rest of the users of the proxy are not
+	carol := gofight.H{"Remote-User": "carol", "Remote-Groups": "users"}
+	proxyRequest(t, server, "POST", "/v1/admin/login/proxy", "10.0.0.1", carol, gofight.H{}, 401)
+	proxyRequest(t, server, "POST", "/v1/admin/login/proxy", "10.0.0.1", gofight.H{"Remote-User": "alice", "Origin": "https://evil.example"}, gofight.H{}, 403)
+
+	entries, err := testDB.GetAuditEntries(dbmodel.AuditQuery{})
+	assert.Nil(t, err)
+	failures := 0
+	for _, v := range entries {
+		if v.Action == dbmodel.AuditLoginFailure {
+			failures++
+			assert.Equal(t, api.ProxyProvider+":carol", v.Subject)
+		}
+	}
+	assert.Equal(t, 1, failures)
+
+	// the password login is still there alongside
+	loginFrom(t, server, "10.0.0.3", model.LoginBody{Password: adminPassword}, 204)
+}
+
+func ProxyAuthConfig(t *testing.T, testDB abstraction.Database) {
+	configCopy := config
+	configCopy.Moderation.ProxyAuth = configModel.ProxyAuth{Enabled: true, AdminUsers: &[]string{"alice"}}
+	_, err := api.GetServer(&testDB, &configCopy)
+	assert.NotNil(t, err)
+	configCopy.Moderation.ProxyAuth.TrustedProxies = &[]string{"10.0.0.300"}
+	_, err = api.GetServer(&testDB, &configCopy)
+	assert.NotNil(t, err)
+	configCopy.Moderation.ProxyAuth.TrustedProxies = &[]string{"10.0.0.1"}
+	configCopy.Moderation.ProxyAuth.AdminUsers = nil
+	_, err = api.GetServer(&testDB, &configCopy)
+	assert.NotNil(t, err)
+	configCopy.Moderation.ProxyAuth.AdminGroups = &[]string{"moderators"}
+	_, err = api.GetServer(&testDB, &configCopy)
+	assert.NotNil(t, err)
+
+	// the route is only there with the proxy auth enabled
+	server, err := api.GetServer(&testDB, &config)
+	assert.Nil(t, err)
+	proxyRequest(t, server, "POST", "/v1/admin/login/proxy", "10.0.0.1", gofight.H{"Remote-User": "alice"}, gofight.H{}, 404)
+}
+
+func apiTokenRequest(t *testing.T, server http.Handler, token string, cookies gofight.H, method string, route string, body interface{}, expectedCode int) string {
+	var reader io.Reader
+	if body != nil {
+		v, err := json.Marshal(body)
+		assert.Nil(t, err)
+		reader = bytes.NewReader(v)
+	}
+	request := httptest.NewRequest(method, route, reader)
+	if token != "" {
+		request.Header.Set("Authorization", "Bearer "+token)
+	}
+	for k, v := range cookies {
+		request.AddCookie(&http.Cookie{Name: k, Value: v})
+	}
+	recorder := httptest.NewRecorder()
+	server.ServeHTTP(recorder, request)
+	assert.Equal(t, expectedCode, recorder.Code)
+	return recorder.Body.String()
+}
+
+func createAPIToken(t *testing.T, server http.Handler, cookies gofight.H, body model.APITokenBody) model.CreateAPITokenResponse {
+	var created model.CreateAPITokenResponse
+	v, err := json.Marshal(body)
+	assert.Nil(t, err)
+	gofight.New().POST("/v1/admin/tokens").
+		SetBody(string(v)).
+		SetCookie(cookies).
+		SetHeader(csrfHeader(cookies)).
+		SetDebug(debug).
+		Run(server, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+			assert.Equal(t, 200, r.Code)
+			err := json.Unmarshal(r.Body.Bytes(), &created)
+			assert.Nil(t, err)
+		})
+	return created
+}
+
+func APITokens(t *testing.T, testDB abstraction.Database) {
+	server, err := api.GetServer(&testDB, &config)
+	assert.Nil(t, err)
+	cookies := GetSessionCookie(&testDB, gofight.New())
+	postComment(t, server, gofight.New(), gofight.H{}, "/v1/comments", "/tokens/", 200)
+	threads, err := testDB.GetAllThreads()
+	assert.Nil(t, err)
+	lock := model.ThreadFlagBody{ThreadId: threads[0].Id.String()}
+
+	// the tokens need a name that fits and known scopes
+	sendThreadRequest(t, server, gofight.New(), cookies, "POST", "/v1/admin/tokens", model.APITokenBody{Name: "script"}, 400)
+	sendThreadRequest(t, server, gofight.New(), cookies, "POST", "/v1/admin/tokens", model.APITokenBody{Name: "script", Scopes: []string{"read", "everything"}}, 400)
+	sendThreadRequest(t, server, gofight.New(), cookies, "POST", "/v1/admin/tokens", model.APITokenBody{Name: strings.Repeat("a", global.MaxAPITokenNameLength+1), Scopes: []string{"read"}}, 400)
+	sendThreadRequest(t, server, gofight.New(), cookies, "POST", "/v1/admin/tokens", model.APITokenBody{Name: "script", Scopes: []string{"read"}, ExpiresInDays: -1}, 400)
+
+	reader := createAPIToken(t, server, cookies, model.APITokenBody{Name: "reader", Scopes: []string{"read"}})
+	assert.True(t, strings.HasPrefix(reader.Token, "mouthful_"))
+	assert.Equal(t, api.APITokenId(reader.Token), reader.Id)
+	assert.Equal(t, "read", reader.Scopes)
+	assert.Equal(t, api.PasswordUserId, reader.UserId)
+	assert.Nil(t, reader.ExpiresAt)
+	moderator := createAPIToken(t, server, cookies, model.APITokenBody{Name: "moderator", Scopes: []string{"moderate", "read"}, ExpiresInDays: 30})
+	assert.Equal(t, "read,moderate", moderator.Scopes)
+	assert.NotNil(t, moderator.ExpiresAt)
+
+	// the token acts as the admin who created it, within its scopes
+	var identity model.AdminIdentity
+	err = json.Unmarshal([]byte(apiTokenRequest(t, server, reader.Token, gofight.H{}, "GET", "/v1/admin/me", nil, 200)), &identity)
+	assert.Nil(t, err)
+	assert.Equal(t, api.PasswordUserId, identity.UserId)
+	assert.Equal(t, api.PasswordProvider, identity.Provider)
+	assert.Equal(t, reader.Id, identity.TokenId)
+	assert.Equal(t, []string{"read"}, identity.Scopes)
+	apiTokenRequest(t, server, reader.Token, gofight.H{}, "GET", "/v1/admin/threads", nil, 200)
+	apiTokenRequest(t, server, reader.Token, gofight.H{}, "POST", "/v1/admin/threads/lock", lock, 401)
+	apiTokenRequest(t, server, moderator.Token, gofight.H{}, "POST", "/v1/admin/threads/lock", lock, 204)
+	// the actions of the tokens are audited as their admin, along with the token
+	apiTokenRequest(t, server, moderator.Token, gofight.H{}, "POST", "/v1/admin/threads/aliases", model.ThreadAliasBody{ThreadId: threads[0].Id.String(), Path: "/tokens-alias/"}, 204)
+	audited, err := testDB.GetAuditEntries(dbmodel.AuditQuery{Limit: 1})
+	assert.Nil(t, err)
+	assert.Equal(t, dbmodel.AuditThreadAliasCreate, audited[0].Action)
+	assert.Equal(t, api.PasswordProvider+":"+api.PasswordUserId+" token:"+moderator.Id, audited[0].Actor)
+	apiTokenRequest(t, server, moderator.Token, gofight.H{}, "POST", "/v1/admin/sites", model.SiteBody{Key: "blog"}, 401)
+	// the token alone decides, whatever the session, and the tokens can't manage the tokens
+	apiTokenRequest(t, server, "mouthful_wrong", cookies, "GET", "/v1/admin/threads", nil, 401)
+	apiTokenRequest(t, server, moderator.Token, gofight.H{}, "POST", "/v1/admin/tokens", model.APITokenBody{Name: "more", Scopes: []string{"settings"}}, 401)
+	apiTokenRequest(t, server, moderator.Token, gofight.H{}, "GET", "/v1/admin/tokens", nil, 401)
+	apiTokenRequest(t, server, moderator.Token, gofight.H{}, "GET", "/v1/admin/config", nil, 200)
+
+	stored, err := testDB.GetAPIToken(moderator.Id)
+	assert.Nil(t, err)
+	assert.NotNil(t, stored.LastUsedAt)
+
+	// the admins list and revoke the tokens
+	var tokens []dbmodel.APIToken
+	gofight.New().GET("/v1/admin/tokens").
+		SetCookie(cookies).
+		SetDebug(debug).
+		Run(server, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+			assert.Equal(t, 200, r.Code)
+			err := json.Unmarshal(r.Body.Bytes(), &tokens)
+			assert.Nil(t, err)
+			assert.NotContains(t, r.Body.String(), reader.Token)
+		})
+	assert.Len(t, tokens, 2)
+	sendThreadRequest(t, server, gofight.New(), cookies, "DELETE", "/v1/admin/tokens", model.APITokenFlagBody{Id: reader.Id}, 204)
+	sendThreadRequest(t, server, gofight.New(), cookies, "DELETE", "/v1/admin/tokens", model.APITokenFlagBody{Id: reader.Id}, 404)
+	apiTokenRequest(t, server, reader.Token, gofight.H{}, "GET", "/v1/admin/threads", nil, 401)
+
+	// the expired tokens are turned away
+	expiresAt := time.Now().UTC().Add(-time.Minute)
+	stored.ExpiresAt = &expiresAt
+	err = testDB.SaveAPIToken(stored)
+	assert.Nil(t, err)
+	apiTokenRequest(t, server, moderator.Token, gofight.H{}, "GET", "/v1/admin/threads", nil, 401)
+
+	// the tokens of the site admins are limited to their site, and go away with the admin password of the site
+	password := "blogpassword"
+	blogId := createSite(t, server, gofight.New(), cookies, model.SiteBody{Key: "blog", AdminPassword: &password})
+	createSite(t, server, gofight.New(), cookies, model.SiteBody{Key: "shop"})
+	siteToken := createAPIToken(t, server, getSiteSessionCookie(t, server, gofight.New(), "blog", password), model.APITokenBody{Name: "blog", Scopes: []string{"read", "settings"}})
+	assert.NotNil(t, siteToken.SiteId)
+	var sites []dbmodel.Site
+	err = json.Unmarshal([]byte(apiTokenRequest(t, server, siteToken.Token, gofight.H{}, "GET", "/v1/admin/sites", nil, 200)), &sites)
+	assert.Nil(t, err)
+	assert.Len(t, sites, 1)
+	assert.Equal(t, "blog", sites[0].Key)
+	apiTokenRequest(t, server, siteToken.Token, gofight.H{}, "POST", "/v1/admin/sites", model.SiteBody{Key: "other"}, 401)
+	empty := ""
+	sendThreadRequest(t, server, gofight.New(), cookies, "PATCH", "/v1/admin/sites", model.SiteBody{SiteId: blogId, Key: "blog", AdminPassword: &empty}, 204)
+	apiTokenRequest(t, server, siteToken.Token, gofight.H{}, "GET", "/v1/admin/sites", nil, 401)
+
+	entries, err := testDB.GetAuditEntries(dbmodel.AuditQuery{})
+	assert.Nil(t, err)
+	actions := make(map[string]int)
+	for _, v := range entries {
+		actions[v.Action]++
+	}
+	assert.Equal(t, 3, actions[dbmodel.AuditAPITokenCreate])
+	assert.Equal(t, 1, actions[dbmodel.AuditAPITokenRevoke])
+}
+
+func SecurityHeaders(t *testing.T, testDB abstraction.Database) {
+	server, err := api.GetServer(&testDB, &config)
+	assert.Nil(t, err)
+	r := gofight.New()
+	r.GET("/status").
+		SetDebug(debug).
+		Run(server, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+			assert.Equal(t, 200, r.Code)
+			assert.Equal(t, "nosniff", r.HeaderMap.Get("X-Content-Type-Options"))
+			assert.Equal(t, "DENY", r.HeaderMap.Get("X-Frame-Options"))
+			assert.Equal(t, global.DefaultContentSecurityPolicy+"; frame-ancestors 'none'", r.HeaderMap.Get("Content-Security-Policy"))
+			assert.Equal(t, global.DefaultReferrerPolicy, r.HeaderMap.Get("Referrer-Policy"))
+			assert.Equal(t, global.DefaultPermissionsPolicy, r.HeaderMap.Get("Permissions-Policy"))
+			assert.Equal(t, "", r.HeaderMap.Get("Strict-Transport-Security"))
+		})
+	// the clients can't claim to have come over https themselves
+	response := httpsProxiedRequest(server, "10.0.1.1", "/v1/comments?uri=/test/")
+	assert.Equal(t, 404, response.Code)
+	assert.Equal(t, "", response.HeaderMap.Get("Strict-Transport-Security"))
+
+	configCopy := config
+	configCopy.API.TrustedProxies = &[]string{"10.0.1.1"}
+	server, err = api.GetServer(&testDB, &configCopy)
+	assert.Nil(t, err)
+	response = httpsProxiedRequest(server, "10.0.1.1", "/v1/comments?uri=/test/")
+	assert.Equal(t, 404, response.Code)
+	assert.Equal(t, "nosniff", response.HeaderMap.Get("X-Content-Type-Options"))
+	assert.Equal(t, "max-age=31536000", response.HeaderMap.Get("Strict-Transport-Security"))
+	response = httpsProxiedRequest(server, "10.0.0.1", "/v1/comments?uri=/test/")
+	assert.Equal(t, "", response.HeaderMap.Get("Strict-Transport-Security"))
+}
+
+// httpsProxiedRequest sends a GET request as if a proxy at the ip had received it over https
+func httpsProxiedRequest(server http.Handler, ip string, route string) *httptest.ResponseRecorder {
+	request := httptest.NewRequest("GET", route, nil)
+	request.RemoteAddr = ip + ":1234"
+	request.Header.Set("X-Forwarded-Proto", "https")
+	recorder := httptest.NewRecorder()
+	server.ServeHTTP(recorder, request)
+	return recorder
+}
+
+func SecurityHeadersOverrides(t *testing.T, testDB abstraction.Database) {
+	configCopy := config
+	empty := ""
+	policy := "default-src 'self'; frame-ancestors 'self'"
+	maxAge := 600
+	configCopy.API.SecurityHeaders = configModel.SecurityHeaders{
+		ContentSecurityPolicy: &policy,
+		ReferrerPolicy:        &empty,
+		PermissionsPolicy:     &empty,
+		HSTSMaxAgeInSeconds:   &maxAge,
+		HSTSIncludeSubdomains: true,
+	}
+	configCopy.API.TrustedProxies = &[]string{"10.0.1.1"}
+	server, err := api.GetServer(&testDB, &configCopy)
+	assert.Nil(t, err)
+	response := httpsProxiedRequest(server, "10.0.1.1", "/status")
+	assert.Equal(t, 200, response.Code)
+	assert.Equal(t, policy, response.HeaderMap.Get("Content-Security-Policy"))
+	assert.NotContains(t, response.HeaderMap, "Referrer-Policy")
+	assert.NotContains(t, response.HeaderMap, "Permissions-Policy")
+	assert.Equal(t, "max-age=600; includeSubDomains", response.HeaderMap.Get("Strict-Transport-Security"))
+
+	maxAge = -1
+	_, err = api.GetServer(&testDB, &configCopy)
+	assert.NotNil(t, err)
+	maxAge = 0
+	configCopy.API.SecurityHeaders.FrameAncestors = &[]string{"https://a.example.com; script-src *"}
+	_, err = api.GetServer(&testDB, &configCopy)
+	assert.NotNil(t, err)
+	configCopy.API.SecurityHeaders.FrameAncestors = nil
+	server, err = api.GetServer(&testDB, &configCopy)
+	assert.Nil(t, err)
+	response = httpsProxiedRequest(server, "10.0.1.1", "/status")
+	assert.Equal(t, 200, response.Code)
+	assert.NotContains(t, response.HeaderMap, "Strict-Transport-Security")
+}
+
+func SecurityHeadersEmbed(t *testing.T, testDB abstraction.Database) {
+	configCopy := config
+	configCopy.API.Render.Enabled = true
+	publicURL := "https://comments.example.com/mouthful/"
+	configCopy.API.Render.PublicURL = &publicURL
+	configCopy.API.Cors = configModel.Cors{Enabled: true, AllowedOrigins: &[]string{"https://www.example.com/"}}
+	server, err := api.GetServer(&testDB, &configCopy)
+	assert.Nil(t, err)
+	r := gofight.New()
+	cookies := GetSessionCookie(&testDB, r)
+	createSite(t, server, r, cookies, model.SiteBody{Key: "blog", Origins: []string{"https://blog.example.com"}})
+	r.GET("/v1/render?uri=/test/").
+		SetDebug(debug).
+		Run(server, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+			assert.Equal(t, 200, r.Code)
+			assert.NotContains(t, r.HeaderMap, "X-Frame-Options")
+			assert.Equal(t, "nosniff", r.HeaderMap.Get("X-Content-Type-Options"))
+			assert.Equal(t, global.DefaultEmbedContentSecurityPolicy+" https://comments.example.com; frame-ancestors 'self' https://www.example.com https://blog.example.com", r.HeaderMap.Get("Content-Security-Policy"))
+		})
+	r.GET("/v1/comments?uri=/test/").
+		SetDebug(debug).
+		Run(server, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+			assert.Equal(t, "DENY", r.HeaderMap.Get("X-Frame-Options"))
+		})
+
+	policy := "default-src 'none'"
+	configCopy.API.SecurityHeaders = configModel.SecurityHeaders{
+		EmbedContentSecurityPolicy: &policy,
+		FrameAncestors:             &[]string{"https://*.example.org"},
+	}
+	server, err = api.GetServer(&testDB, &configCopy)
+	assert.Nil(t, err)
+	r.GET("/v1/render?uri=/test/").
+		SetDebug(debug).
+		Run(server, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+			assert.Equal(t, 200, r.Code)
+			assert.Equal(t, "default-src 'none'; frame-ancestors https://*.example.org", r.HeaderMap.Get("Content-Security-Policy"))
+		})
+	configCopy.API.SecurityHeaders.FrameAncestors = &[]string{}
+	server, err = api.GetServer(&testDB, &configCopy)
+	assert.Nil(t, err)
+	r.GET("/v1/render?uri=/test/").
+		SetDebug(debug).
+		Run(server, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+			assert.Equal(t, "default-src 'none'; frame-ancestors 'none'", r.HeaderMap.Get("Content-Security-Policy"))
+		})
+}
+
+func SecurityHeadersAdminPanel(t *testing.T, testDB abstraction.Database) {
+	err := os.MkdirAll(global.StaticPath, 0755)
+	assert.Nil(t, err)
+	defer os.Remove(global.StaticPath)
+	index := filepath.Join(global.StaticPath, "index.html")
+	err = ioutil.WriteFile(index, []byte(`<html><head><script>window.x=1</script></head><body><script defer src="/bundle.js"></script></body></html>`), 0644)
+	assert.Nil(t, err)
+	defer os.Remove(index)
+
+	server, err := api.GetServer(&testDB, &config)
+	assert.Nil(t, err)
+	r := gofight.New()
+	nonces := make([]string, 0)
+	for _, route := range []string{"/", "/index.html", "/"} {
+		r.GET(route).
+			SetDebug(debug).
+			Run(server, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+				assert.Equal(t, 200, r.Code)
+				assert.Equal(t, "no-store", r.HeaderMap.Get("Cache-Control"))
+				policy := r.HeaderMap.Get("Content-Security-Policy")
+				start := strings.Index(policy, "'nonce-")
+				if !assert.NotEqual(t, -1, start) {
+					return
+				}
+				nonce := policy[start+len("'nonce-"):]
+				nonce = nonce[:strings.Index(nonce, "'")]
+				assert.Contains(t, policy, "script-src 'self' 'nonce-"+nonce+"';")
+				assert.Contains(t, policy, "frame-ancestors 'none'")
+				assert.Equal(t, 2, strings.Count(r.Body.String(), `<script nonce="`+nonce+`"`))
+				nonces = append(nonces, nonce)
+			})
+	}
+	assert.Len(t, nonces, 3)
+	if len(nonces) == 3 {
+		assert.NotEqual(t, nonces[0], nonces[2])
+	}
+
+	configCopy := config
+	configCopy.Moderation.Enabled = false
+	server, err = api.GetServer(&testDB, &configCopy)
+	assert.Nil(t, err)
+	r.GET("/").
+		SetDebug(debug).
+		Run(server, func(r gofight.HTTPResponse, rq gofight.HTTPRequest) {
+			assert.Equal(t, 404, r.Code)
+			assert.NotContains(t, r.HeaderMap.Get("Content-Security-Policy"), "nonce")
+		})
+}
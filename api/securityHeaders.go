@@ -0,0 +1,238 @@
+package api
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/vkuznecovas/mouthful/config/model"
+	"github.com/vkuznecovas/mouthful/global"
+)
+
+// scriptTagPattern matches the opening of the script tags the admin panel nonce is added to
+var scriptTagPattern = regexp.MustCompile(`(?i)<script\b`)
+
+// SecurityHeaders holds the values of the security headers sent with the responses. Empty values leave the header out
+type SecurityHeaders struct {
+	contentSecurityPolicy      string
+	embedContentSecurityPolicy string
+	referrerPolicy             string
+	permissionsPolicy          string
+	strictTransportSecurity    string
+	// frameAncestors replace the allowed origins as the ones allowed to frame the embeddable routes when set
+	frameAncestors []string
+	// corsOrigins are allowed to frame the embeddable routes, along with the origins of the sites, unless the frame ancestors are configured
+	corsOrigins []string
+}
+
+// NewSecurityHeaders creates the security headers from the given config, falling back to the defaults for the headers left out.
+// The origins of the cors config and the url the rendered comments are posted to are allowed by the defaults of the embeddable routes.
+// It returns an error if any of the frame ancestors is invalid or the hsts max age is negative.
+func NewSecurityHeaders(config model.SecurityHeaders, corsOrigins []string, renderURL *string) (*SecurityHeaders, error) {
+	headers := SecurityHeaders{
+		contentSecurityPolicy:      global.DefaultContentSecurityPolicy,
+		embedContentSecurityPolicy: global.DefaultEmbedContentSecurityPolicy,
+		referrerPolicy:             global.DefaultReferrerPolicy,
+		permissionsPolicy:          global.DefaultPermissionsPolicy,
+	}
+	if config.ContentSecurityPolicy != nil {
+		headers.contentSecurityPolicy = *config.ContentSecurityPolicy
+	}
+	if config.EmbedContentSecurityPolicy != nil {
+		headers.embedContentSecurityPolicy = *config.EmbedContentSecurityPolicy
+	} else if renderURL != nil {
+		// the rendered form posts to the public url, which might not be the origin the comments are rendered at. form-action is the last directive of the default
+		if origin := NormalizeOrigin(*renderURL); origin != "" {
+			headers.embedContentSecurityPolicy += " " + origin
+		}
+	}
+	if config.ReferrerPolicy != nil {
+		headers.referrerPolicy = *config.ReferrerPolicy
+	}
+	if config.PermissionsPolicy != nil {
+		headers.permissionsPolicy = *config.PermissionsPolicy
+	}
+	if config.FrameAncestors != nil {
+		headers.frameAncestors = make([]string, 0, len(*config.FrameAncestors))
+		for i, v := range *config.FrameAncestors {
+			if v == "" || strings.ContainsAny(v, " \t\r\n;,") {
+				return nil, fmt.Errorf("config.API.SecurityHeaders.FrameAncestors[%v] is not a valid source: %v", i, v)
+			}
+			headers.frameAncestors = append(headers.frameAncestors, v)
+		}
+	}
+	for _, v := range corsOrigins {
+		if origin := NormalizeOrigin(v); origin != "" {
+			headers.corsOrigins = append(headers.corsOrigins, origin)
+		}
+	}
+	maxAge := global.DefaultHSTSMaxAgeInSeconds
+	if config.HSTSMaxAgeInSeconds != nil {
+		maxAge = *config.HSTSMaxAgeInSeconds
+	}
+	if maxAge < 0 {
+		return nil, fmt.Errorf("config.API.SecurityHeaders.HSTSMaxAgeInSeconds can't be negative")
+	}
+	if maxAge > 0 {
+		headers.strictTransportSecurity = "max-age=" + strconv.Itoa(maxAge)
+		if config.HSTSIncludeSubdomains {
+			headers.strictTransportSecurity += "; includeSubDomains"
+		}
+	}
+	return &headers, nil
+}
+
+// SetSecurityHeaders sets the security headers the embeddable routes and the admin panel override
+func (r *Router) SetSecurityHeaders(headers *SecurityHeaders) {
+	r.securityHeaders = headers
+}
+
+// isSecureRequest checks if the request came over tls, either directly or through a trusted proxy terminating it.
+// The X-Forwarded-Proto header is only taken from the trusted proxies, as anybody else can send it
+func isSecureRequest(c *gin.Context) bool {
+	if c.Request.TLS != nil {
+		return true
+	}
+	if _, trusted := c.RemoteIP(); !trusted {
+		return false
+	}
+	return c.GetHeader("X-Forwarded-Proto") == "https"
+}
+
+// setHeader sets the response header, unless the value is empty
+func setHeader(c *gin.Context, name string, value string) {
+	if value != "" {
+		c.Header(name, value)
+	}
+}
+
+// withFrameAncestors adds the frame-ancestors directive allowing the given sources to the policy, unless it has one already or is left out
+func withFrameAncestors(policy string, sources []string) string {
+	if policy == "" || strings.Contains(strings.ToLower(policy), "frame-ancestors") {
+		return policy
+	}
+	return strings.TrimSuffix(strings.TrimSpace(policy), ";") + "; frame-ancestors " + strings.Join(sources, " ")
+}
+
+// withScriptNonce allows the scripts carrying the nonce in the policy. The nonce goes into script-src, or, if there is none, a script-src copied from default-src
+func withScriptNonce(policy string, nonce string) string {
+	source := "'nonce-" + nonce + "'"
+	directives := strings.Split(policy, ";")
+	defaultSources := ""
+	for i, v := range directives {
+		fields := strings.Fields(v)
+		if len(fields) == 0 {
+			continue
+		}
+		switch strings.ToLower(fields[0]) {
+		case "script-src":
+			directives[i] = strings.TrimSpace(v) + " " + source
+			return strings.Join(directives, ";")
+		case "default-src":
+			defaultSources = strings.Join(fields[1:], " ")
+		}
+	}
+	if defaultSources == "" {
+		// nothing limits the scripts
+		return policy
+	}
+	return strings.TrimSuffix(strings.TrimSpace(policy), ";") + "; script-src " + strings.Replace(defaultSources, "'none'", "", 1) + " " + source
+}
+
+// Security sets the security headers on every response. The pages can't be framed, apart from the embeddable routes overriding it
+func Security(headers *SecurityHeaders) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Header("X-Content-Type-Options", "nosniff")
+		c.Header("X-Frame-Options", "DENY")
+		setHeader(c, "Content-Security-Policy", withFrameAncestors(headers.contentSecurityPolicy, []string{"'none'"}))
+		setHeader(c, "Referrer-Policy", headers.referrerPolicy)
+		setHeader(c, "Permissions-Policy", headers.permissionsPolicy)
+		if isSecureRequest(c) {
+			setHeader(c, "Strict-Transport-Security", headers.strictTransportSecurity)
+		}
+		c.Next()
+	}
+}
+
+// embedFrameAncestors returns the sources allowed to frame the embeddable routes, which are the configured frame ancestors or, by default, mouthful itself, the cors origins and the origins of the sites
+func (r *Router) embedFrameAncestors() []string {
+	if r.securityHeaders.frameAncestors != nil {
+		if len(r.securityHeaders.frameAncestors) == 0 {
+			return []string{"'none'"}
+		}
+		return r.securityHeaders.frameAncestors
+	}
+	sources := []string{"'self'"}
+	seen := make(map[string]bool)
+	for _, v := range r.securityHeaders.corsOrigins {
+		if !seen[v] {
+			seen[v] = true
+			sources = append(sources, v)
+		}
+	}
+	for _, site := range r.sites.all() {
+		for _, v := range site.Origins {
+			if !seen[v] {
+				seen[v] = true
+				sources = append(sources, v)
+			}
+		}
+	}
+	return sources
+}
+
+// EmbedSecurityHeaders overrides the security headers of the embeddable routes, letting the allowed origins frame them
+func (r *Router) EmbedSecurityHeaders(c *gin.Context) {
+	if r.securityHeaders == nil {
+		c.Next()
+		return
+	}
+	c.Writer.Header().Del("X-Frame-Options")
+	c.Writer.Header().Del("Content-Security-Policy")
+	setHeader(c, "Content-Security-Policy", withFrameAncestors(r.securityHeaders.embedContentSecurityPolicy, r.embedFrameAncestors()))
+	c.Next()
+}
+
+// AdminPanel serves the index.html of the admin panel with a fresh nonce on its script tags, allowed by the content security policy of the response.
+// The rest of the requests are left to the static files
+func (r *Router) AdminPanel(c *gin.Context) {
+	if r.securityHeaders == nil || r.securityHeaders.contentSecurityPolicy == "" || (c.Request.Method != "GET" && c.Request.Method != "HEAD") {
+		c.Next()
+		return
+	}
+	if c.Request.URL.Path != "/" && c.Request.URL.Path != "/index.html" {
+		c.Next()
+		return
+	}
+	html, err := ioutil.ReadFile(path.Join(global.StaticPath, "index.html"))
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Println(err)
+		}
+		c.Next()
+		return
+	}
+	random := make([]byte, 16)
+	_, err = rand.Read(random)
+	if err != nil {
+		log.Println(err)
+		c.AbortWithStatusJSON(500, global.ErrInternalServerError.Error())
+		return
+	}
+	nonce := base64.StdEncoding.EncodeToString(random)
+	policy := withScriptNonce(r.securityHeaders.contentSecurityPolicy, nonce)
+	c.Header("Content-Security-Policy", withFrameAncestors(policy, []string{"'none'"}))
+	// the nonce changes on every response, so it can't be cached
+	c.Header("Cache-Control", "no-store")
+	html = scriptTagPattern.ReplaceAll(html, []byte(`<script nonce="`+nonce+`"`))
+	c.Data(200, "text/html; charset=utf-8", html)
+	c.Abort()
+}
@@ -1,8 +1,12 @@
 package api
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"fmt"
+	"log"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/gin-contrib/cors"
@@ -16,6 +20,7 @@ import (
 	"github.com/ulule/limiter"
 	mgin "github.com/ulule/limiter/drivers/middleware/gin"
 	memoryLimiterStore "github.com/ulule/limiter/drivers/store/memory"
+	"github.com/vkuznecovas/mouthful/broker"
 	"github.com/vkuznecovas/mouthful/config/model"
 	"github.com/vkuznecovas/mouthful/db/abstraction"
 	"github.com/vkuznecovas/mouthful/global"
@@ -23,6 +28,23 @@ import (
 	"github.com/vkuznecovas/mouthful/oauth/provider"
 )
 
+// processSessionSecret is the secret the admin sessions are signed with when the config has neither a session secret nor an admin password, generated once per process
+var processSessionSecret struct {
+	once   sync.Once
+	secret string
+	err    error
+}
+
+// randomSessionSecret returns the session secret of the process, generating it on the first call
+func randomSessionSecret() (string, error) {
+	processSessionSecret.once.Do(func() {
+		random := make([]byte, global.MinSessionSecretLength)
+		_, processSessionSecret.err = rand.Read(random)
+		processSessionSecret.secret = hex.EncodeToString(random)
+	})
+	return processSessionSecret.secret, processSessionSecret.err
+}
+
 // CheckModerationVariables checks to see if the required moderation flags have been set in the config or not
 func CheckModerationVariables(config *model.Config) error {
 	if config.Moderation.AdminPassword == "" && !config.Moderation.DisablePasswordLogin {
@@ -45,14 +67,52 @@ func CheckModerationVariables(config *model.Config) error {
 		}
 	}
 
-	// check if password is disabled but no OAUTH providers are enabled
+	// check if password is disabled but no OAUTH providers are enabled, nor is the proxy auth
 	if config.Moderation.DisablePasswordLogin == true {
 		err := fmt.Errorf("You have moderation enabled with no enabled OAUTH providers or admin password functionality. You will not be able to login on the admin panel. Please check your configuration")
-		if !hasEnabledAuthProviders {
+		if !hasEnabledAuthProviders && !config.Moderation.ProxyAuth.Enabled {
+			return err
+		}
+	}
+
+	// the admin password is only used to sign the sessions if there's no session secret. Without either, like with only the oauth logins, a random secret does
+	if config.Moderation.SessionSecret == "" && config.Moderation.AdminPassword == "" {
+		_, err := randomSessionSecret()
+		if err != nil {
+			return err
+		}
+		log.Println("config.Moderation.SessionSecret is not defined in config, the admin sessions are signed with a random secret. They end whenever mouthful restarts, and can't be shared between several instances")
+	}
+	if config.Moderation.SessionSecret != "" && len(config.Moderation.SessionSecret) < global.MinSessionSecretLength {
+		return fmt.Errorf("config.Moderation.SessionSecret has to be at least %v characters long", global.MinSessionSecretLength)
+	}
+
+	if config.Moderation.TwoFactor.Enabled && len(config.Moderation.TwoFactor.EncryptionKey) < global.MinTwoFactorEncryptionKeyLength {
+		return fmt.Errorf("config.Moderation.TwoFactor.EncryptionKey has to be at least %v characters long", global.MinTwoFactorEncryptionKeyLength)
+	}
+
+	// the relying party id is a domain, without a scheme, port or path
+	if config.Moderation.WebAuthn.Enabled {
+		rpId := config.Moderation.WebAuthn.RPID
+		if rpId == "" || strings.ContainsAny(rpId, ":/?# ") {
+			return fmt.Errorf("config.Moderation.WebAuthn.RPID has to be the domain of the admin panel, got %q", rpId)
+		}
+	}
+
+	if config.Moderation.ProxyAuth.Enabled {
+		_, err := newProxyAuth(config.Moderation.ProxyAuth)
+		if err != nil {
 			return err
 		}
 	}
 
+	throttling := config.Moderation.LoginThrottling
+	for name, value := range map[string]*int{"BaseDelaySeconds": throttling.BaseDelaySeconds, "MaxDelaySeconds": throttling.MaxDelaySeconds, "MaxFailures": throttling.MaxFailures, "LockoutSeconds": throttling.LockoutSeconds} {
+		if value != nil && *value < 0 {
+			return fmt.Errorf("config.Moderation.LoginThrottling.%v can't be negative", name)
+		}
+	}
+
 	// if we have providers, we do need the origin specified as well
 	if hasEnabledAuthProviders {
 		if config.Moderation.OAuthCallbackOrigin == nil || *config.Moderation.OAuthCallbackOrigin == "" {
@@ -67,6 +127,45 @@ func CheckModerationVariables(config *model.Config) error {
 	return nil
 }
 
+// SessionKeys returns the keys the admin sessions are signed with. The session secret signs the new sessions, while the old secrets are only used to verify the existing ones, so the secret can be rotated without logging everybody out.
+// Configs without a session secret fall back to the admin password, as they always did, or to the random secret of the process if there's no password either
+func SessionKeys(moderation model.Moderation) [][]byte {
+	secret := moderation.SessionSecret
+	if secret == "" {
+		secret = moderation.AdminPassword
+	}
+	if secret == "" {
+		// CheckModerationVariables has made sure it could be generated
+		secret, _ = randomSessionSecret()
+	}
+	// the keys come in hash and encryption key pairs, the sessions are only signed
+	keys := [][]byte{[]byte(secret), nil}
+	if moderation.OldSessionSecrets != nil {
+		for _, v := range *moderation.OldSessionSecrets {
+			if v != "" {
+				keys = append(keys, []byte(v), nil)
+			}
+		}
+	}
+	return keys
+}
+
+// warnUntrustedProxy logs a warning the first time a request carries the forwarding headers of a proxy that is not in config.API.TrustedProxies.
+// Those headers are ignored, so the clients behind the proxy all share its ip for the rate limits and login lockouts until it is trusted
+func warnUntrustedProxy() gin.HandlerFunc {
+	var once sync.Once
+	return func(c *gin.Context) {
+		if c.GetHeader("X-Forwarded-For") == "" && c.GetHeader("X-Real-IP") == "" {
+			return
+		}
+		if ip, trusted := c.RemoteIP(); ip != nil && !trusted {
+			once.Do(func() {
+				log.Printf("WARNING: ignoring the forwarding headers of the requests from %v, add it to config.API.TrustedProxies if it is your reverse proxy. Until then, all the clients behind it share its ip for the rate limits and login lockouts\n", ip)
+			})
+		}
+	}
+}
+
 // GetServer returns an instance of the mouthful server
 func GetServer(db *abstraction.Database, config *model.Config) (*gin.Engine, error) {
 	if config.API.Debug {
@@ -81,14 +180,16 @@ func GetServer(db *abstraction.Database, config *model.Config) (*gin.Engine, err
 		r.Use(gin.Logger())
 	}
 	r.ForwardedByClientIP = true
-	if config.API.Cors.Enabled {
-		corsConfig := cors.DefaultConfig()
-		corsConfig.AllowOrigins = *config.API.Cors.AllowedOrigins
-		corsConfig.AllowMethods = []string{"PUT", "PATCH", "GET", "DELETE", "HEAD", "OPTIONS", "POST"}
-		r.Use(cors.New(corsConfig))
-	} else {
-		r.Use(cors.Default())
+	// gin trusts the forwarding headers from anyone unless told otherwise, which would let the clients pick the ip the rate limits and lockouts apply to
+	var trustedProxies []string
+	if config.API.TrustedProxies != nil {
+		trustedProxies = *config.API.TrustedProxies
+	}
+	err := r.SetTrustedProxies(trustedProxies)
+	if err != nil {
+		return nil, fmt.Errorf("config.API.TrustedProxies has an invalid ip or cidr range: %v", err)
 	}
+	r.Use(warnUntrustedProxy())
 
 	var cacheInstance *cache.Cache
 	if config.API.Cache.Enabled {
@@ -97,6 +198,28 @@ func GetServer(db *abstraction.Database, config *model.Config) (*gin.Engine, err
 		cacheInstance = cache.New(expiry, interval)
 	}
 
+	router := New(db, config, cacheInstance)
+	err = router.loadSites()
+	if err != nil {
+		return nil, err
+	}
+
+	if config.API.Cors.Enabled {
+		corsConfig := cors.DefaultConfig()
+		corsConfig.AllowOrigins = *config.API.Cors.AllowedOrigins
+		// the origins of the sites are allowed on top of the configured ones
+		corsConfig.AllowOriginFunc = router.isSiteOrigin
+		corsConfig.AllowMethods = []string{"PUT", "PATCH", "GET", "DELETE", "HEAD", "OPTIONS", "POST"}
+		corsConfig.ExposeHeaders = []string{"X-Thread-Locked"}
+		r.Use(cors.New(corsConfig))
+	} else {
+		// same as cors.Default, but the client needs to read the thread state header
+		corsConfig := cors.DefaultConfig()
+		corsConfig.AllowAllOrigins = true
+		corsConfig.ExposeHeaders = []string{"X-Thread-Locked"}
+		r.Use(cors.New(corsConfig))
+	}
+
 	var limitMiddleware *gin.HandlerFunc
 	if config.API.RateLimiting.Enabled {
 		limit, err := limiter.NewRateFromFormatted(fmt.Sprintf("%v-H", config.API.RateLimiting.PostsHour))
@@ -107,9 +230,50 @@ func GetServer(db *abstraction.Database, config *model.Config) (*gin.Engine, err
 		limitMiddleware = &newInstance
 	}
 
-	router := New(db, config, cacheInstance)
+	normalizer, err := NewPathNormalizer(config.API.Paths)
+	if err != nil {
+		return nil, err
+	}
+	router.SetPathNormalizer(normalizer)
+
+	corsOrigins := make([]string, 0)
+	if config.API.Cors.Enabled && config.API.Cors.AllowedOrigins != nil {
+		corsOrigins = *config.API.Cors.AllowedOrigins
+	}
+	verifier, err := NewPostVerifier(config.API.Verification, corsOrigins, normalizer)
+	if err != nil {
+		return nil, err
+	}
+	router.SetPostVerifier(verifier)
+
+	var renderURL *string
+	if config.API.Render.Enabled {
+		// the host and the forwarded headers of the requests can't be trusted to build the urls the readers get sent to
+		if config.API.Render.PublicURL == nil || NormalizeOrigin(*config.API.Render.PublicURL) == "" {
+			return nil, fmt.Errorf("config.API.Render.PublicURL has to be the http(s) url readers reach mouthful at when render is enabled")
+		}
+		renderURL = config.API.Render.PublicURL
+	}
+	securityHeaders, err := NewSecurityHeaders(config.API.SecurityHeaders, corsOrigins, renderURL)
+	if err != nil {
+		return nil, err
+	}
+	router.SetSecurityHeaders(securityHeaders)
+	r.Use(Security(securityHeaders))
+
+	// registered before the static files, so that client.js gets compressed as well
+	if config.API.Compression.Enabled {
+		compressor, err := NewCompressor(config.API.Compression)
+		if err != nil {
+			return nil, err
+		}
+		router.SetCompressor(compressor)
+		r.Use(Compression(compressor))
+	}
 
 	if config.Moderation.Enabled {
+		// the admin panel gets the nonce for its scripts, the rest is served as is
+		r.Use(router.AdminPanel)
 		fs := static.LocalFile(global.StaticPath, true)
 		r.Use(static.Serve("/", fs))
 	} else {
@@ -125,6 +289,16 @@ func GetServer(db *abstraction.Database, config *model.Config) (*gin.Engine, err
 	v1 := r.Group("/v1")
 	v1.GET("/client/config", router.GetClientConfig)
 	v1.GET("/comments", router.GetComments)
+	v1.GET("/comments/:id", router.GetComment)
+
+	if config.API.Stream.Enabled {
+		bufferSize := global.DefaultStreamBufferSize
+		if config.API.Stream.BufferSize > 0 {
+			bufferSize = config.API.Stream.BufferSize
+		}
+		router.SetBroker(broker.NewMemoryBroker(bufferSize))
+		v1.GET("/comments/stream", router.StreamComments)
+	}
 
 	if limitMiddleware != nil {
 		v1.POST("/comments", *limitMiddleware, router.CreateComment)
@@ -132,30 +306,110 @@ func GetServer(db *abstraction.Database, config *model.Config) (*gin.Engine, err
 		v1.POST("/comments", router.CreateComment)
 	}
 
+	if config.API.Render.Enabled {
+		templates, err := LoadTemplates(config.API.Render.TemplateDirectory)
+		if err != nil {
+			return nil, err
+		}
+		router.SetTemplates(templates)
+		v1.GET("/render", router.EmbedSecurityHeaders, router.RenderComments)
+		if limitMiddleware != nil {
+			v1.POST("/comments/form", *limitMiddleware, router.CreateCommentForm)
+		} else {
+			v1.POST("/comments/form", router.CreateCommentForm)
+		}
+	}
+
 	if config.Moderation.Enabled {
 		err := CheckModerationVariables(config)
 		if err != nil {
 			return nil, err
 		}
-		store := cookie.NewStore([]byte(config.Moderation.AdminPassword))
-		store.Options(sessions.Options{
-			MaxAge: int(time.Second * time.Duration(config.Moderation.SessionDurationSeconds)), //30min
-			Path:   "/",
-		})
+		store := cookie.NewStore(SessionKeys(config.Moderation)...)
+		sessionOptions, err := SessionOptions(config.Moderation)
+		if err != nil {
+			return nil, err
+		}
+		store.Options(sessionOptions)
 		r.Use(sessions.Sessions("mouthful", store))
-		v1.GET("/admin/config", router.GetAdminConfig)
-		v1.PATCH("/admin/comments", sessions.Sessions(global.DefaultSessionName, store), router.UpdateComment)
-		v1.DELETE("/admin/comments", sessions.Sessions(global.DefaultSessionName, store), router.DeleteComment)
+		v1.GET("/admin/config", sessions.Sessions(global.DefaultSessionName, store), router.GetAdminConfig)
+		v1.GET("/admin/me", sessions.Sessions(global.DefaultSessionName, store), router.GetMe)
+		v1.POST("/admin/logout", sessions.Sessions(global.DefaultSessionName, store), router.CSRFProtection, router.Logout)
+		if config.Moderation.ServerSideSessions {
+			v1.GET("/admin/sessions", sessions.Sessions(global.DefaultSessionName, store), router.GetAdminSessions)
+			v1.DELETE("/admin/sessions", sessions.Sessions(global.DefaultSessionName, store), router.CSRFProtection, router.RevokeAdminSession)
+		}
+		v1.POST("/admin/comments", sessions.Sessions(global.DefaultSessionName, store), router.CSRFProtection, router.CreateStaffComment)
+		v1.PATCH("/admin/comments", sessions.Sessions(global.DefaultSessionName, store), router.CSRFProtection, router.UpdateComment)
+		v1.DELETE("/admin/comments", sessions.Sessions(global.DefaultSessionName, store), router.CSRFProtection, router.DeleteComment)
 
 		if limitMiddleware != nil {
 			v1.POST("/admin/login", *limitMiddleware, sessions.Sessions(global.DefaultSessionName, store), router.Login)
 		} else {
 			v1.POST("/admin/login", sessions.Sessions(global.DefaultSessionName, store), router.Login)
 		}
+		if config.Moderation.TwoFactor.Enabled {
+			err = router.setTwoFactorKey(config.Moderation.TwoFactor.EncryptionKey)
+			if err != nil {
+				return nil, err
+			}
+			if limitMiddleware != nil {
+				v1.POST("/admin/login/2fa", *limitMiddleware, sessions.Sessions(global.DefaultSessionName, store), router.LoginTwoFactor)
+			} else {
+				v1.POST("/admin/login/2fa", sessions.Sessions(global.DefaultSessionName, store), router.LoginTwoFactor)
+			}
+			v1.GET("/admin/2fa", sessions.Sessions(global.DefaultSessionName, store), router.GetTwoFactor)
+			v1.POST("/admin/2fa", sessions.Sessions(global.DefaultSessionName, store), router.CSRFProtection, router.EnrollTwoFactor)
+			v1.DELETE("/admin/2fa", sessions.Sessions(global.DefaultSessionName, store), router.CSRFProtection, router.DisableTwoFactor)
+			v1.POST("/admin/2fa/confirm", sessions.Sessions(global.DefaultSessionName, store), router.CSRFProtection, router.ConfirmTwoFactor)
+			v1.POST("/admin/2fa/recovery-codes", sessions.Sessions(global.DefaultSessionName, store), router.CSRFProtection, router.RegenerateRecoveryCodes)
+		}
+		if config.Moderation.ProxyAuth.Enabled {
+			router.proxyAuth, err = newProxyAuth(config.Moderation.ProxyAuth)
+			if err != nil {
+				return nil, err
+			}
+			v1.POST("/admin/login/proxy", sessions.Sessions(global.DefaultSessionName, store), router.ProxyLogin)
+		}
+		if config.Moderation.WebAuthn.Enabled {
+			if limitMiddleware != nil {
+				v1.POST("/admin/webauthn/login/begin", *limitMiddleware, sessions.Sessions(global.DefaultSessionName, store), router.BeginWebAuthnLogin)
+				v1.POST("/admin/webauthn/login/finish", *limitMiddleware, sessions.Sessions(global.DefaultSessionName, store), router.FinishWebAuthnLogin)
+			} else {
+				v1.POST("/admin/webauthn/login/begin", sessions.Sessions(global.DefaultSessionName, store), router.BeginWebAuthnLogin)
+				v1.POST("/admin/webauthn/login/finish", sessions.Sessions(global.DefaultSessionName, store), router.FinishWebAuthnLogin)
+			}
+			v1.POST("/admin/webauthn/register/begin", sessions.Sessions(global.DefaultSessionName, store), router.CSRFProtection, router.BeginWebAuthnRegistration)
+			v1.POST("/admin/webauthn/register/finish", sessions.Sessions(global.DefaultSessionName, store), router.CSRFProtection, router.FinishWebAuthnRegistration)
+			v1.GET("/admin/webauthn/credentials", sessions.Sessions(global.DefaultSessionName, store), router.GetWebAuthnCredentials)
+			v1.DELETE("/admin/webauthn/credentials", sessions.Sessions(global.DefaultSessionName, store), router.CSRFProtection, router.DeleteWebAuthnCredential)
+		}
 
-		v1.POST("/admin/comments/restore", sessions.Sessions(global.DefaultSessionName, store), router.RestoreDeletedComment)
+		v1.POST("/admin/comments/restore", sessions.Sessions(global.DefaultSessionName, store), router.CSRFProtection, router.RestoreDeletedComment)
+		v1.POST("/admin/comments/pin", sessions.Sessions(global.DefaultSessionName, store), router.CSRFProtection, router.PinComment)
+		v1.POST("/admin/comments/unpin", sessions.Sessions(global.DefaultSessionName, store), router.CSRFProtection, router.UnpinComment)
+		v1.POST("/admin/comments/feature", sessions.Sessions(global.DefaultSessionName, store), router.CSRFProtection, router.FeatureComment)
+		v1.POST("/admin/comments/unfeature", sessions.Sessions(global.DefaultSessionName, store), router.CSRFProtection, router.UnfeatureComment)
 		v1.GET("/admin/threads", sessions.Sessions(global.DefaultSessionName, store), router.GetAllThreads)
+		v1.POST("/admin/threads/lock", sessions.Sessions(global.DefaultSessionName, store), router.CSRFProtection, router.LockThread)
+		v1.POST("/admin/threads/unlock", sessions.Sessions(global.DefaultSessionName, store), router.CSRFProtection, router.UnlockThread)
+		v1.POST("/admin/threads/archive", sessions.Sessions(global.DefaultSessionName, store), router.CSRFProtection, router.ArchiveThread)
+		v1.PATCH("/admin/threads", sessions.Sessions(global.DefaultSessionName, store), router.CSRFProtection, router.RenameThread)
+		v1.DELETE("/admin/threads", sessions.Sessions(global.DefaultSessionName, store), router.CSRFProtection, router.DeleteThread)
+		v1.POST("/admin/threads/merge", sessions.Sessions(global.DefaultSessionName, store), router.CSRFProtection, router.MergeThreads)
+		v1.PUT("/admin/threads/metadata", sessions.Sessions(global.DefaultSessionName, store), router.CSRFProtection, router.UpdateThreadMetadata)
+		v1.GET("/admin/threads/aliases", sessions.Sessions(global.DefaultSessionName, store), router.GetThreadAliases)
+		v1.POST("/admin/threads/aliases", sessions.Sessions(global.DefaultSessionName, store), router.CSRFProtection, router.CreateThreadAlias)
+		v1.DELETE("/admin/threads/aliases", sessions.Sessions(global.DefaultSessionName, store), router.CSRFProtection, router.DeleteThreadAlias)
+		v1.GET("/admin/audit", sessions.Sessions(global.DefaultSessionName, store), router.GetAuditLog)
 		v1.GET("/admin/comments/all", sessions.Sessions(global.DefaultSessionName, store), router.GetAllComments)
+		v1.GET("/admin/sites", sessions.Sessions(global.DefaultSessionName, store), router.GetSites)
+		v1.POST("/admin/sites", sessions.Sessions(global.DefaultSessionName, store), router.CSRFProtection, router.CreateSite)
+		v1.PATCH("/admin/sites", sessions.Sessions(global.DefaultSessionName, store), router.CSRFProtection, router.UpdateSite)
+		v1.DELETE("/admin/sites", sessions.Sessions(global.DefaultSessionName, store), router.CSRFProtection, router.DeleteSite)
+		v1.GET("/admin/tokens", sessions.Sessions(global.DefaultSessionName, store), router.GetAPITokens)
+		v1.POST("/admin/tokens", sessions.Sessions(global.DefaultSessionName, store), router.CSRFProtection, router.CreateAPIToken)
+		v1.DELETE("/admin/tokens", sessions.Sessions(global.DefaultSessionName, store), router.CSRFProtection, router.RevokeAPIToken)
 
 		if config.Moderation.OAauthProviders != nil {
 			gothic.Store = store
@@ -1,6 +1,7 @@
 package api_test
 
 import (
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -117,10 +118,56 @@ func TestCheckModerationVariablesEmptyPasswordDisabledNoError(t *testing.T) {
 	configCopy := serverTestConfig
 	configCopy.Moderation.AdminPassword = ""
 	configCopy.Moderation.DisablePasswordLogin = true
+	configCopy.Moderation.SessionSecret = strings.Repeat("s", 32)
 	err := api.CheckModerationVariables(&configCopy)
 	assert.Nil(t, err)
 }
 
+func TestCheckModerationVariablesEmptyPasswordNoSessionSecret(t *testing.T) {
+	configCopy := serverTestConfig
+	configCopy.Moderation.AdminPassword = ""
+	configCopy.Moderation.DisablePasswordLogin = true
+	err := api.CheckModerationVariables(&configCopy)
+	assert.Nil(t, err)
+	// the sessions are signed with a random secret, the same for the whole process
+	keys := api.SessionKeys(configCopy.Moderation)
+	assert.Len(t, keys[0], 64)
+	assert.Equal(t, keys, api.SessionKeys(configCopy.Moderation))
+}
+
+func TestCheckModerationVariablesPasswordDisabledWithProxyAuth(t *testing.T) {
+	configCopy := serverTestConfig
+	configCopy.Moderation.DisablePasswordLogin = true
+	configCopy.Moderation.SessionSecret = strings.Repeat("s", 32)
+	configCopy.Moderation.OAauthProviders = nil
+	configCopy.Moderation.ProxyAuth = configModel.ProxyAuth{Enabled: true, TrustedProxies: &[]string{"127.0.0.1"}, AdminUsers: &[]string{"alice"}}
+	err := api.CheckModerationVariables(&configCopy)
+	assert.Nil(t, err)
+}
+
+func TestCheckModerationVariablesProxyAuthNoTrustedProxies(t *testing.T) {
+	configCopy := serverTestConfig
+	configCopy.Moderation.ProxyAuth = configModel.ProxyAuth{Enabled: true, AdminUsers: &[]string{"alice"}}
+	err := api.CheckModerationVariables(&configCopy)
+	assert.NotNil(t, err)
+	configCopy.Moderation.ProxyAuth.TrustedProxies = &[]string{"10.0.0.0/33"}
+	err = api.CheckModerationVariables(&configCopy)
+	assert.NotNil(t, err)
+}
+
+func TestCheckModerationVariablesProxyAuthNoAdmins(t *testing.T) {
+	configCopy := serverTestConfig
+	configCopy.Moderation.ProxyAuth = configModel.ProxyAuth{Enabled: true, TrustedProxies: &[]string{"127.0.0.1"}}
+	err := api.CheckModerationVariables(&configCopy)
+	assert.NotNil(t, err)
+	configCopy.Moderation.ProxyAuth.AdminGroups = &[]string{"moderators"}
+	err = api.CheckModerationVariables(&configCopy)
+	assert.NotNil(t, err)
+	configCopy.Moderation.ProxyAuth.GroupsHeader = "Remote-Groups"
+	err = api.CheckModerationVariables(&configCopy)
+	assert.Nil(t, err)
+}
+
 func TestOriginGetsSuffixed(t *testing.T) {
 	configCopy := serverTestConfig
 	origin := "http://some.origin"
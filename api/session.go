@@ -0,0 +1,246 @@
+package api
+
+import (
+	"log"
+	"strings"
+	"time"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+	"github.com/gofrs/uuid"
+	"github.com/vkuznecovas/mouthful/api/model"
+	dbModel "github.com/vkuznecovas/mouthful/db/model"
+	"github.com/vkuznecovas/mouthful/global"
+)
+
+// PasswordProvider is the provider of the admins logged in with a password
+const PasswordProvider = "password"
+
+// PasswordUserId is the user id of the admins logged in with a password
+const PasswordUserId = "admin"
+
+// adminSessionContextKey is the key the server-side session of the request is kept under in the gin context, so it's only fetched once per request
+const adminSessionContextKey = "mouthful-admin-session"
+
+// adminIdentity identifies the admin being logged in
+type adminIdentity struct {
+	userId   string
+	name     string
+	provider string
+	siteId   *uuid.UUID
+}
+
+// sessionDuration returns how long the admin sessions last
+func (r *Router) sessionDuration() time.Duration {
+	seconds := global.DefaultSessionDurationSeconds
+	if r.config.Moderation.SessionDurationSeconds > 0 {
+		seconds = r.config.Moderation.SessionDurationSeconds
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+// startSession logs the admin in, replacing whatever session the request had. The csrf token of the new session is sent in the X-CSRF-Token header.
+// With the server-side sessions enabled, the session is stored in the database as well, and the cookie only carries its id
+func (r *Router) startSession(c *gin.Context, identity adminIdentity) error {
+	session := sessions.Default(c)
+	if r.config.Moderation.ServerSideSessions {
+		db := *r.db
+		if previous := sessionId(session); previous != nil {
+			err := db.DeleteAdminSession(*previous)
+			if err != nil && err != global.ErrSessionNotFound {
+				log.Println(err)
+			}
+		}
+		err := db.DeleteExpiredAdminSessions()
+		if err != nil {
+			log.Println(err)
+		}
+	}
+	session.Clear()
+	session.Set("isAdmin", true)
+	session.Set("userId", identity.userId)
+	session.Set("userName", identity.name)
+	session.Set("provider", identity.provider)
+	if identity.siteId != nil {
+		session.Set("siteId", identity.siteId.String())
+	}
+	token, err := newCSRFToken()
+	if err != nil {
+		return err
+	}
+	session.Set("csrfToken", token)
+	c.Header(CSRFHeader, token)
+	if r.config.Moderation.ServerSideSessions {
+		now := time.Now().UTC()
+		stored := dbModel.AdminSession{
+			Id:        global.GetUUID(),
+			UserId:    identity.userId,
+			Name:      identity.name,
+			Provider:  identity.provider,
+			SiteId:    identity.siteId,
+			CreatedAt: now,
+			ExpiresAt: now.Add(r.sessionDuration()),
+		}
+		err = (*r.db).CreateAdminSession(stored)
+		if err != nil {
+			return err
+		}
+		session.Set("sessionId", stored.Id.String())
+	}
+	return session.Save()
+}
+
+// sessionId returns the id of the server-side session the cookie points to, if any
+func sessionId(session sessions.Session) *uuid.UUID {
+	id, ok := session.Get("sessionId").(string)
+	if !ok || id == "" {
+		return nil
+	}
+	parsed, err := global.ParseUUIDFromString(id)
+	if err != nil {
+		return nil
+	}
+	return parsed
+}
+
+// serverSession returns the server-side session of the request, or nil if it has none, or it was revoked or has expired
+func (r *Router) serverSession(c *gin.Context) *dbModel.AdminSession {
+	if cached, ok := c.Get(adminSessionContextKey); ok {
+		return cached.(*dbModel.AdminSession)
+	}
+	var result *dbModel.AdminSession
+	if id := sessionId(sessions.Default(c)); id != nil {
+		stored, err := (*r.db).GetAdminSession(*id)
+		if err != nil {
+			if err != global.ErrSessionNotFound {
+				log.Println(err)
+			}
+		} else if stored.ExpiresAt.After(time.Now()) {
+			result = &stored
+		}
+	}
+	c.Set(adminSessionContextKey, result)
+	return result
+}
+
+// Logout logs the admin out, revoking the server-side session if there is one
+func (r *Router) Logout(c *gin.Context) {
+	session := sessions.Default(c)
+	if r.config.Moderation.ServerSideSessions {
+		if id := sessionId(session); id != nil {
+			err := (*r.db).DeleteAdminSession(*id)
+			if err != nil && err != global.ErrSessionNotFound {
+				log.Println(err)
+				c.AbortWithStatusJSON(500, global.ErrInternalServerError.Error())
+				return
+			}
+		}
+	}
+	session.Clear()
+	options, err := SessionOptions(r.config.Moderation)
+	if err != nil {
+		log.Println(err)
+	}
+	options.MaxAge = -1
+	session.Options(options)
+	err = session.Save()
+	if err != nil {
+		log.Println(err)
+	}
+	c.AbortWithStatus(204)
+}
+
+// GetMe returns who the logged in admin is
+func (r *Router) GetMe(c *gin.Context) {
+	if !r.isAdmin(c) {
+		c.AbortWithStatusJSON(401, global.ErrUnauthorized.Error())
+		return
+	}
+	admin := r.sessionAdminIdentity(c)
+	identity := model.AdminIdentity{UserId: admin.userId, Name: admin.name, Provider: admin.provider}
+	if siteId := admin.siteId; siteId != nil {
+		identity.SiteId = siteId.String()
+		if site := r.adminSite(c); site != nil {
+			identity.Site = site.Key
+		}
+	}
+	if stored := r.serverSession(c); stored != nil {
+		identity.SessionId = stored.Id.String()
+		identity.ExpiresAt = &stored.ExpiresAt
+	}
+	if token := r.requestAPIToken(c); token != nil {
+		identity.TokenId = token.Id
+		identity.Scopes = strings.Split(token.Scopes, ",")
+		identity.ExpiresAt = token.ExpiresAt
+	}
+	c.JSON(200, identity)
+}
+
+// GetAdminSessions returns the server-side sessions that have not expired yet. The admins of a single site only get the sessions of their site
+func (r *Router) GetAdminSessions(c *gin.Context) {
+	if !r.isAdmin(c) {
+		c.AbortWithStatusJSON(401, global.ErrUnauthorized.Error())
+		return
+	}
+	stored, err := (*r.db).GetAdminSessions()
+	if err != nil {
+		log.Println(err)
+		c.AbortWithStatusJSON(500, global.ErrInternalServerError.Error())
+		return
+	}
+	now := time.Now()
+	siteId := r.adminSiteId(c)
+	result := make([]dbModel.AdminSession, 0, len(stored))
+	for _, v := range stored {
+		if v.ExpiresAt.Before(now) {
+			continue
+		}
+		if siteId != nil && (v.SiteId == nil || *v.SiteId != *siteId) {
+			continue
+		}
+		result = append(result, v)
+	}
+	c.JSON(200, result)
+}
+
+// RevokeAdminSession deletes a server-side session, logging its admin out. The admins of a single site can only revoke the sessions of their site
+func (r *Router) RevokeAdminSession(c *gin.Context) {
+	if !r.isAdmin(c) {
+		c.AbortWithStatusJSON(401, global.ErrUnauthorized.Error())
+		return
+	}
+	var body model.SessionFlagBody
+	err := c.BindJSON(&body)
+	if err != nil {
+		log.Println(err)
+		c.AbortWithStatusJSON(400, global.ErrBadRequest.Error())
+		return
+	}
+	id, err := global.ParseUUIDFromString(body.SessionId)
+	if err != nil {
+		log.Println(err)
+		c.AbortWithStatusJSON(400, global.ErrBadRequest.Error())
+		return
+	}
+	db := *r.db
+	stored, err := db.GetAdminSession(*id)
+	if err == nil {
+		if siteId := r.adminSiteId(c); siteId != nil && (stored.SiteId == nil || *stored.SiteId != *siteId) {
+			err = global.ErrSessionNotFound
+		}
+	}
+	if err == nil {
+		err = db.DeleteAdminSession(*id)
+	}
+	if err != nil {
+		if err == global.ErrSessionNotFound {
+			c.AbortWithStatusJSON(404, global.ErrSessionNotFound.Error())
+			return
+		}
+		log.Println(err)
+		c.AbortWithStatusJSON(500, global.ErrInternalServerError.Error())
+		return
+	}
+	r.audit(c, dbModel.AuditSessionRevoke, id.String(), stored.Provider+":"+stored.UserId, stored.SiteId)
+	c.AbortWithStatus(204)
+}
@@ -0,0 +1,470 @@
+package api
+
+import (
+	"log"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+	"github.com/gofrs/uuid"
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/vkuznecovas/mouthful/api/model"
+	"github.com/vkuznecovas/mouthful/db/abstraction"
+	dbModel "github.com/vkuznecovas/mouthful/db/model"
+	"github.com/vkuznecovas/mouthful/global"
+)
+
+// siteRegistry keeps the sites in memory, so that resolving the site of a request does not hit the database
+type siteRegistry struct {
+	mutex sync.RWMutex
+	sites []dbModel.Site
+}
+
+// load replaces the known sites with the ones stored in the database
+func (sr *siteRegistry) load(db abstraction.Database) error {
+	sites, err := db.GetSites()
+	if err != nil {
+		return err
+	}
+	sr.mutex.Lock()
+	defer sr.mutex.Unlock()
+	sr.sites = sites
+	return nil
+}
+
+// all returns the known sites, ordered by key
+func (sr *siteRegistry) all() []dbModel.Site {
+	sr.mutex.RLock()
+	defer sr.mutex.RUnlock()
+	return sr.sites
+}
+
+func (sr *siteRegistry) find(match func(site dbModel.Site) bool) *dbModel.Site {
+	sr.mutex.RLock()
+	defer sr.mutex.RUnlock()
+	for i := range sr.sites {
+		if match(sr.sites[i]) {
+			site := sr.sites[i]
+			return &site
+		}
+	}
+	return nil
+}
+
+func (sr *siteRegistry) byKey(key string) *dbModel.Site {
+	return sr.find(func(site dbModel.Site) bool { return site.Key == key })
+}
+
+func (sr *siteRegistry) byId(id uuid.UUID) *dbModel.Site {
+	return sr.find(func(site dbModel.Site) bool { return site.Id == id })
+}
+
+func (sr *siteRegistry) byOrigin(origin string) *dbModel.Site {
+	return sr.find(func(site dbModel.Site) bool { return site.HasOrigin(origin) })
+}
+
+// isSiteOrigin checks if the origin belongs to any of the sites. It is used to let the sites through cors
+func (r *Router) isSiteOrigin(origin string) bool {
+	return r.sites.byOrigin(NormalizeOrigin(origin)) != nil
+}
+
+// loadSites reloads the sites from the database. Sites changed by other instances sharing the database are only seen after a reload
+func (r *Router) loadSites() error {
+	return r.sites.load(*r.db)
+}
+
+// requestOrigin returns the origin the request came from, taken from the Origin header or, failing that, the referer
+func requestOrigin(c *gin.Context) string {
+	origin := c.GetHeader("Origin")
+	if origin == "" || origin == "null" {
+		origin = c.Request.Referer()
+	}
+	return NormalizeOrigin(origin)
+}
+
+// findSite returns the site the public request is for, or nil for the default site, along with the status and error to respond with if the site can't be used.
+// An explicit site key takes precedence, in which case the request must come from one of the origins of the site, if the site limits them.
+// Otherwise the site is found by the origin of the request, falling back to the default site.
+func (r *Router) findSite(c *gin.Context, key string) (*dbModel.Site, int, error) {
+	if len(r.sites.all()) == 0 {
+		if key != "" {
+			return nil, 404, global.ErrSiteNotFound
+		}
+		return nil, 0, nil
+	}
+	// the same url serves different sites depending on where it is requested from
+	c.Writer.Header().Add("Vary", "Origin")
+	origin := requestOrigin(c)
+	if key != "" {
+		site := r.sites.byKey(key)
+		if site == nil {
+			return nil, 404, global.ErrSiteNotFound
+		}
+		if origin != "" && len(site.Origins) > 0 && !site.HasOrigin(origin) {
+			return nil, 403, global.ErrOriginNotAllowed
+		}
+		return site, 0, nil
+	}
+	if origin != "" {
+		return r.sites.byOrigin(origin), 0, nil
+	}
+	return nil, 0, nil
+}
+
+// resolveSite is findSite for the json routes, aborting the request if the site can't be used
+func (r *Router) resolveSite(c *gin.Context, key string) (*dbModel.Site, bool) {
+	site, status, err := r.findSite(c, key)
+	if err != nil {
+		c.AbortWithStatusJSON(status, err.Error())
+		return nil, false
+	}
+	return site, true
+}
+
+// siteKey returns the key of the site, which is empty for the default site
+func siteKey(site *dbModel.Site) string {
+	if site == nil {
+		return ""
+	}
+	return site.Key
+}
+
+// siteModeration returns whether the comments on the site are moderated, before the per-thread settings are applied
+func (r *Router) siteModeration(site *dbModel.Site) bool {
+	if site != nil && site.Moderation != nil {
+		return *site.Moderation
+	}
+	return r.config.Moderation.Enabled
+}
+
+// siteMaxCommentLength returns the comment length limit of the site, before the per-thread settings are applied
+func (r *Router) siteMaxCommentLength(site *dbModel.Site) *int {
+	if site != nil && site.MaxCommentLength != nil {
+		return site.MaxCommentLength
+	}
+	return r.config.Moderation.MaxCommentLength
+}
+
+// adminSiteId returns the id of the site the logged in admin is limited to, or nil for the admins of the whole instance
+func (r *Router) adminSiteId(c *gin.Context) *uuid.UUID {
+	if bearerToken(c) != "" {
+		if token := r.requestAPIToken(c); token != nil {
+			return token.SiteId
+		}
+		nobody := uuid.Nil
+		return &nobody
+	}
+	session := sessions.Default(c)
+	siteId, ok := session.Get("siteId").(string)
+	if !ok || siteId == "" {
+		return nil
+	}
+	parsed, err := global.ParseUUIDFromString(siteId)
+	if err != nil {
+		// a broken session limits the admin to a site that does not exist rather than to none
+		nobody := uuid.Nil
+		return &nobody
+	}
+	return parsed
+}
+
+// adminSite returns the site the logged in admin is limited to, or nil for the admins of the whole instance and for deleted sites
+func (r *Router) adminSite(c *gin.Context) *dbModel.Site {
+	siteId := r.adminSiteId(c)
+	if siteId == nil {
+		return nil
+	}
+	return r.sites.byId(*siteId)
+}
+
+// canManageSite checks if the logged in admin may manage the threads and comments of the site by id, nil standing for the default site
+func (r *Router) canManageSite(c *gin.Context, siteId *uuid.UUID) bool {
+	adminSiteId := r.adminSiteId(c)
+	if adminSiteId == nil {
+		return true
+	}
+	return siteId != nil && *siteId == *adminSiteId
+}
+
+// canManageThread checks if the logged in admin may manage the thread, aborting the request as if the thread did not exist otherwise
+func (r *Router) canManageThread(c *gin.Context, thread dbModel.Thread) bool {
+	if !r.canManageSite(c, thread.SiteId) {
+		c.AbortWithStatusJSON(404, global.ErrThreadNotFound.Error())
+		return false
+	}
+	return true
+}
+
+// canManageComment checks if the logged in admin may manage the comment by id, aborting the request as if the comment did not exist otherwise
+func (r *Router) canManageComment(c *gin.Context, commentId uuid.UUID) bool {
+	if r.adminSiteId(c) == nil {
+		return true
+	}
+	db := *r.db
+	comment, err := db.GetComment(commentId)
+	if err != nil {
+		r.abortWithCommentError(c, err)
+		return false
+	}
+	thread, err := db.GetThreadById(comment.ThreadId)
+	if err != nil {
+		r.abortWithCommentError(c, err)
+		return false
+	}
+	if !r.canManageSite(c, thread.SiteId) {
+		c.AbortWithStatusJSON(404, global.ErrCommentNotFound.Error())
+		return false
+	}
+	return true
+}
+
+// manageableThreads returns the threads the logged in admin may manage
+func (r *Router) manageableThreads(c *gin.Context) ([]dbModel.Thread, error) {
+	db := *r.db
+	if siteId := r.adminSiteId(c); siteId != nil {
+		return db.GetSiteThreads(*siteId)
+	}
+	return db.GetAllThreads()
+}
+
+// isInstanceAdmin checks if the logged in admin manages the whole instance rather than a single site, aborting the request otherwise
+func (r *Router) isInstanceAdmin(c *gin.Context) bool {
+	if !r.isAdmin(c) || r.adminSiteId(c) != nil {
+		c.AbortWithStatusJSON(401, global.ErrUnauthorized.Error())
+		return false
+	}
+	return true
+}
+
+// siteAdminLogin returns the site by key if the password matches the admin password of the site, nil otherwise
+func (r *Router) siteAdminLogin(key string, password string) *dbModel.Site {
+	site := r.sites.byKey(key)
+	if site == nil || site.AdminPasswordHash == "" {
+		return nil
+	}
+	if bcrypt.CompareHashAndPassword([]byte(site.AdminPasswordHash), []byte(password)) != nil {
+		return nil
+	}
+	return site
+}
+
+// oauthSite returns the site the oauth user is logging in to. Without a site key, the user is only let into a site if it's the only one listing them, as picking one would be a guess
+func (r *Router) oauthSite(provider, userId, key string) *dbModel.Site {
+	if key != "" {
+		site := r.sites.byKey(key)
+		if site == nil || !site.HasAdmin(provider, userId) {
+			return nil
+		}
+		return site
+	}
+	var found *dbModel.Site
+	for _, v := range r.sites.all() {
+		if v.HasAdmin(provider, userId) {
+			if found != nil {
+				return nil
+			}
+			site := v
+			found = &site
+		}
+	}
+	return found
+}
+
+// GetSites returns the sites. The admins of a single site only get their own
+func (r *Router) GetSites(c *gin.Context) {
+	if !r.isAdmin(c) {
+		c.AbortWithStatusJSON(401, global.ErrUnauthorized.Error())
+		return
+	}
+	sites := make([]dbModel.Site, 0)
+	for _, v := range r.sites.all() {
+		if r.canManageSite(c, &v.Id) {
+			sites = append(sites, v)
+		}
+	}
+	c.JSON(200, sites)
+}
+
+// CreateSite adds a site to the instance
+func (r *Router) CreateSite(c *gin.Context) {
+	var body model.SiteBody
+	if !r.bindSiteBody(c, &body) {
+		return
+	}
+	err := ValidateSiteKey(body.Key)
+	if err != nil {
+		c.AbortWithStatusJSON(400, err.Error())
+		return
+	}
+	site := dbModel.Site{Key: body.Key}
+	if !applySiteBody(c, &site, body) {
+		return
+	}
+	db := *r.db
+	siteId, err := db.CreateSite(site)
+	if err != nil {
+		r.abortWithSiteError(c, err)
+		return
+	}
+	r.reloadSites()
+	r.audit(c, dbModel.AuditSiteCreate, siteId.String(), site.Key, siteId)
+	c.JSON(200, gin.H{"id": siteId.String()})
+}
+
+// UpdateSite replaces the name, origins, admins and moderation settings of the site
+func (r *Router) UpdateSite(c *gin.Context) {
+	var body model.SiteBody
+	if !r.bindSiteBody(c, &body) {
+		return
+	}
+	siteId, err := global.ParseUUIDFromString(body.SiteId)
+	if err != nil {
+		c.AbortWithStatusJSON(400, global.ErrBadRequest.Error())
+		return
+	}
+	db := *r.db
+	site, err := db.GetSite(*siteId)
+	if err != nil {
+		r.abortWithSiteError(c, err)
+		return
+	}
+	if !applySiteBody(c, &site, body) {
+		return
+	}
+	err = db.UpdateSite(site)
+	if err != nil {
+		r.abortWithSiteError(c, err)
+		return
+	}
+	r.reloadSites()
+	r.audit(c, dbModel.AuditSiteUpdate, site.Id.String(), site.Key, &site.Id)
+	c.AbortWithStatus(204)
+}
+
+// DeleteSite deletes the site. Its threads have to be deleted or merged first
+func (r *Router) DeleteSite(c *gin.Context) {
+	var body model.SiteFlagBody
+	if !r.bindSiteBody(c, &body) {
+		return
+	}
+	siteId, err := global.ParseUUIDFromString(body.SiteId)
+	if err != nil {
+		c.AbortWithStatusJSON(400, global.ErrBadRequest.Error())
+		return
+	}
+	db := *r.db
+	site, err := db.GetSite(*siteId)
+	if err != nil {
+		r.abortWithSiteError(c, err)
+		return
+	}
+	err = db.DeleteSite(*siteId)
+	if err != nil {
+		r.abortWithSiteError(c, err)
+		return
+	}
+	r.reloadSites()
+	r.audit(c, dbModel.AuditSiteDelete, site.Id.String(), site.Key, &site.Id)
+	c.AbortWithStatus(204)
+}
+
+// reloadSites picks up the site changes made by the admin. The change has already been stored by then, so failures are only logged
+func (r *Router) reloadSites() {
+	err := r.loadSites()
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+// bindSiteBody checks that the admin manages the whole instance and binds the json request body, aborting the request if either fails
+func (r *Router) bindSiteBody(c *gin.Context, body interface{}) bool {
+	if !r.isInstanceAdmin(c) {
+		return false
+	}
+	err := c.BindJSON(body)
+	if err != nil {
+		log.Println(err)
+		c.AbortWithStatusJSON(400, global.ErrBadRequest.Error())
+		return false
+	}
+	return true
+}
+
+// applySiteBody validates the request body and copies it onto the site, aborting the request if it is not valid
+func applySiteBody(c *gin.Context, site *dbModel.Site, body model.SiteBody) bool {
+	if body.MaxCommentLength != nil && *body.MaxCommentLength < 0 {
+		c.AbortWithStatusJSON(400, global.ErrBadRequest.Error())
+		return false
+	}
+	origins := make(dbModel.StringList, 0, len(body.Origins))
+	for _, v := range body.Origins {
+		origin := NormalizeOrigin(v)
+		if origin == "" {
+			c.AbortWithStatusJSON(400, global.ErrBadRequest.Error())
+			return false
+		}
+		origins = append(origins, origin)
+	}
+	adminUserIds := make(dbModel.StringList, 0, len(body.AdminUserIds))
+	for _, v := range body.AdminUserIds {
+		// the ids are stored one per line, as provider:id
+		v = strings.TrimSpace(v)
+		if v == "" {
+			continue
+		}
+		i := strings.Index(v, ":")
+		if i <= 0 || i == len(v)-1 {
+			c.AbortWithStatusJSON(400, global.ErrBadRequest.Error())
+			return false
+		}
+		adminUserIds = append(adminUserIds, v)
+	}
+	if body.AdminPassword != nil {
+		site.AdminPasswordHash = ""
+		if *body.AdminPassword != "" {
+			hash, err := bcrypt.GenerateFromPassword([]byte(*body.AdminPassword), bcrypt.DefaultCost)
+			if err != nil {
+				log.Println(err)
+				c.AbortWithStatusJSON(500, global.ErrInternalServerError.Error())
+				return false
+			}
+			site.AdminPasswordHash = string(hash)
+		}
+	}
+	site.Name = strings.TrimSpace(body.Name)
+	site.Origins = origins
+	site.Moderation = body.Moderation
+	site.MaxCommentLength = body.MaxCommentLength
+	site.AdminUserIds = adminUserIds
+	return true
+}
+
+// abortWithSiteError responds with 404 for missing sites, 409 for key conflicts and sites that still have threads and 500 for everything else
+func (r *Router) abortWithSiteError(c *gin.Context, err error) {
+	switch err {
+	case global.ErrSiteNotFound:
+		c.AbortWithStatusJSON(404, err.Error())
+		return
+	case global.ErrSiteAlreadyExists, global.ErrSiteHasThreads:
+		c.AbortWithStatusJSON(409, err.Error())
+		return
+	}
+	log.Println(err)
+	c.AbortWithStatusJSON(500, global.ErrInternalServerError.Error())
+}
+
+// isSiteRedirect checks if the redirect target is on one of the origins of the sites
+func (r *Router) isSiteRedirect(target *url.URL) bool {
+	return r.sites.byOrigin(NormalizeOrigin(target.String())) != nil
+}
+
+// stringValue returns the value of the optional string, or an empty string if it is not set
+func stringValue(input *string) string {
+	if input == nil {
+		return ""
+	}
+	return *input
+}
@@ -0,0 +1,157 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/vkuznecovas/mouthful/broker"
+	dbModel "github.com/vkuznecovas/mouthful/db/model"
+	"github.com/vkuznecovas/mouthful/global"
+)
+
+// connectionLimiter keeps track of open connections per ip
+type connectionLimiter struct {
+	mutex       sync.Mutex
+	limit       int
+	connections map[string]int
+}
+
+func newConnectionLimiter(limit int) *connectionLimiter {
+	return &connectionLimiter{
+		limit:       limit,
+		connections: make(map[string]int),
+	}
+}
+
+// acquire reserves a connection for the given ip, returns false if the ip is over the limit
+func (cl *connectionLimiter) acquire(ip string) bool {
+	cl.mutex.Lock()
+	defer cl.mutex.Unlock()
+	if cl.connections[ip] >= cl.limit {
+		return false
+	}
+	cl.connections[ip]++
+	return true
+}
+
+// release frees up a connection for the given ip
+func (cl *connectionLimiter) release(ip string) {
+	cl.mutex.Lock()
+	defer cl.mutex.Unlock()
+	cl.connections[ip]--
+	if cl.connections[ip] <= 0 {
+		delete(cl.connections, ip)
+	}
+}
+
+// SetBroker sets the broker the router publishes comment events to
+func (r *Router) SetBroker(b broker.Broker) {
+	r.broker = b
+	limit := global.DefaultStreamMaxConnectionsPerIP
+	if r.config.API.Stream.MaxConnectionsPerIP > 0 {
+		limit = r.config.API.Stream.MaxConnectionsPerIP
+	}
+	r.streamLimiter = newConnectionLimiter(limit)
+}
+
+// publishComment notifies the subscribers of the thread at path about a newly visible comment of it.
+// The topics are the resolved thread paths rather than the thread ids, so that the readers of a thread that doesn't exist yet get its first comment
+func (r *Router) publishComment(path string, comment dbModel.Comment) {
+	if r.broker == nil {
+		return
+	}
+	data, err := json.Marshal(comment)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	_, err = r.broker.Publish(path, data)
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+// StreamComments streams the newly visible comments for the thread passed as query parameter thread or uri as server-sent events.
+// The thread doesn't have to exist yet, its first comment gets streamed as well
+func (r *Router) StreamComments(c *gin.Context) {
+	requested, _, ok := r.requestedThreadPath(c)
+	if !ok {
+		return
+	}
+
+	// EventSource sends the id of the last received event when reconnecting, the query parameter is there for polyfills that can't set headers
+	var lastEventId *uint64
+	lastEventIdString := c.GetHeader("Last-Event-ID")
+	if lastEventIdString == "" {
+		lastEventIdString = c.Query("lastEventId")
+	}
+	if lastEventIdString != "" {
+		parsed, err := strconv.ParseUint(lastEventIdString, 10, 64)
+		if err != nil {
+			c.AbortWithStatusJSON(400, global.ErrBadRequest.Error())
+			return
+		}
+		lastEventId = &parsed
+	}
+
+	path, err := r.resolveThreadPath(requested)
+	if err != nil {
+		log.Println(err)
+		c.AbortWithStatusJSON(500, global.ErrInternalServerError.Error())
+		return
+	}
+
+	ip := c.ClientIP()
+	if !r.streamLimiter.acquire(ip) {
+		c.AbortWithStatusJSON(429, global.ErrTooManyConnections.Error())
+		return
+	}
+	defer r.streamLimiter.release(ip)
+
+	subscription, err := r.broker.Subscribe(path, lastEventId)
+	if err != nil {
+		log.Println(err)
+		c.AbortWithStatusJSON(500, global.ErrInternalServerError.Error())
+		return
+	}
+	defer subscription.Close()
+
+	heartbeatSeconds := global.DefaultStreamHeartbeatSeconds
+	if r.config.API.Stream.HeartbeatSeconds > 0 {
+		heartbeatSeconds = r.config.API.Stream.HeartbeatSeconds
+	}
+	heartbeat := time.NewTicker(time.Duration(heartbeatSeconds) * time.Second)
+	defer heartbeat.Stop()
+
+	header := c.Writer.Header()
+	header.Set("Content-Type", "text/event-stream")
+	header.Set("Cache-Control", "no-cache")
+	header.Set("Connection", "keep-alive")
+	// disables response buffering in nginx
+	header.Set("X-Accel-Buffering", "no")
+	c.Status(200)
+	fmt.Fprintf(c.Writer, "retry: %d\n\n", heartbeatSeconds*1000)
+	c.Writer.Flush()
+
+	for {
+		select {
+		case <-c.Request.Context().Done():
+			return
+		case event, ok := <-subscription.Events():
+			if !ok {
+				// the broker dropped us, the client will reconnect with the last event id it received
+				return
+			}
+			fmt.Fprintf(c.Writer, "id: %d\nevent: comment\ndata: %s\n\n", event.Id, event.Data)
+			c.Writer.Flush()
+		case <-heartbeat.C:
+			fmt.Fprint(c.Writer, ": heartbeat\n\n")
+			c.Writer.Flush()
+		}
+	}
+}
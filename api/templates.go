@@ -0,0 +1,162 @@
+package api
+
+import (
+	"html/template"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// FragmentTemplateName is the name of the template rendering the comment thread fragment
+const FragmentTemplateName = "fragment.html"
+
+// PageTemplateName is the name of the template rendering a full html page around the fragment, suitable for iframes
+const PageTemplateName = "page.html"
+
+// ErrorTemplateName is the name of the template rendering errors for the form based comment posting
+const ErrorTemplateName = "error.html"
+
+const defaultFragmentTemplate = `<section class="mouthful-thread" id="mouthful-comments" aria-labelledby="mouthful-comments-heading">
+	<h2 id="mouthful-comments-heading">Comments ({{.CommentCount}})</h2>
+	{{- if .Comments}}
+	<ol class="mouthful-comments">
+		{{- range .Comments}}
+		<li>
+			{{template "comment" .}}
+			{{- if .Replies}}
+			<ol class="mouthful-replies" aria-label="Replies to {{.Author}}">
+				{{- range .Replies}}
+				<li>{{template "comment" .}}</li>
+				{{- end}}
+			</ol>
+			{{- end}}
+			{{- if not $.Locked}}
+			<details class="mouthful-reply">
+				<summary>Reply to {{.Author}}</summary>
+				{{template "form" (formData $ .Id)}}
+			</details>
+			{{- end}}
+		</li>
+		{{- end}}
+	</ol>
+	{{- else}}
+	<p class="mouthful-empty">No comments yet.</p>
+	{{- end}}
+	{{- if .Locked}}
+	<p class="mouthful-locked">Comments are closed.</p>
+	{{- else}}
+	<h3 id="mouthful-form-heading">Leave a comment</h3>
+	{{template "form" (formData . "")}}
+	{{- end}}
+	<script type="application/ld+json">{{.JSONLD}}</script>
+</section>`
+
+// defaultPartials are the building blocks of the default fragment, they can be reused or redefined by the overriding templates
+const defaultPartials = `{{define "comment"}}<article class="mouthful-comment{{if .Pinned}} mouthful-pinned{{end}}{{if .Featured}} mouthful-featured{{end}}" id="mouthful-comment-{{.Id}}" itemscope itemtype="https://schema.org/Comment">
+	<header>
+		<span class="mouthful-author" itemprop="author" itemscope itemtype="https://schema.org/Person"><span itemprop="name">{{.Author}}</span></span>{{if .Staff}} <span class="mouthful-staff-badge">Staff</span>{{end}}
+		<time class="mouthful-date" itemprop="dateCreated" datetime="{{.CreatedAtISO}}">{{.CreatedAtHuman}}</time>
+	</header>
+	<div class="mouthful-body" itemprop="text">{{.Body}}</div>
+</article>{{end}}
+{{define "form"}}<form class="mouthful-form" method="post" action="{{.Action}}">
+	<input type="hidden" name="path" value="{{.Path}}">
+	{{- if .Thread}}
+	<input type="hidden" name="thread" value="{{.Thread}}">
+	{{- end}}
+	{{- if .Site}}
+	<input type="hidden" name="site" value="{{.Site}}">
+	{{- end}}
+	{{- if .ReplyTo}}
+	<input type="hidden" name="replyTo" value="{{.ReplyTo}}">
+	{{- end}}
+	{{- if .Redirect}}
+	<input type="hidden" name="redirect" value="{{.Redirect}}">
+	{{- end}}
+	<p>
+		<label for="mouthful-author-{{.FormId}}">Name</label>
+		<input type="text" id="mouthful-author-{{.FormId}}" name="author" required{{if .MaxAuthorLength}} maxlength="{{.MaxAuthorLength}}"{{end}}>
+	</p>
+	{{- if .Honeypot}}
+	<p style="display:none" aria-hidden="true">
+		<label for="mouthful-email-{{.FormId}}">Leave this field empty</label>
+		<input type="text" id="mouthful-email-{{.FormId}}" name="email" tabindex="-1" autocomplete="off">
+	</p>
+	{{- end}}
+	<p>
+		<label for="mouthful-body-{{.FormId}}">Comment</label>
+		<textarea id="mouthful-body-{{.FormId}}" name="body" rows="5" required{{if .MaxCommentLength}} maxlength="{{.MaxCommentLength}}"{{end}}></textarea>
+	</p>
+	{{- if .Moderation}}
+	<p class="mouthful-moderation-notice">Comments are moderated and will appear once approved.</p>
+	{{- end}}
+	<p><button type="submit">Post comment</button></p>
+</form>{{end}}`
+
+const defaultPageTemplate = `<!DOCTYPE html>
+<html lang="en">
+<head>
+	<meta charset="utf-8">
+	<meta name="viewport" content="width=device-width, initial-scale=1">
+	<meta name="robots" content="noindex">
+	<title>Comments</title>
+</head>
+<body>
+{{template "fragment.html" .}}
+</body>
+</html>`
+
+const defaultErrorTemplate = `<!DOCTYPE html>
+<html lang="en">
+<head>
+	<meta charset="utf-8">
+	<meta name="viewport" content="width=device-width, initial-scale=1">
+	<meta name="robots" content="noindex">
+	<title>Your comment could not be posted</title>
+</head>
+<body>
+	<main>
+		<h1>Your comment could not be posted</h1>
+		<p role="alert">{{.Message}}</p>
+		{{- if .Back}}
+		<p><a href="{{.Back}}">Go back</a></p>
+		{{- end}}
+	</main>
+</body>
+</html>`
+
+// LoadTemplates parses the default templates, overriding them with the ones found in the given directory.
+// The directory can contain any of fragment.html, page.html and error.html. Templates that are not found are left as defaults.
+// The overriding templates can use the "comment" and "form" partials of the default fragment.
+func LoadTemplates(directory *string) (*template.Template, error) {
+	templates := template.New("").Funcs(template.FuncMap{
+		"formData": newRenderFormData,
+	})
+	_, err := templates.Parse(defaultPartials)
+	if err != nil {
+		return nil, err
+	}
+	defaults := map[string]string{
+		FragmentTemplateName: defaultFragmentTemplate,
+		PageTemplateName:     defaultPageTemplate,
+		ErrorTemplateName:    defaultErrorTemplate,
+	}
+	for _, name := range [...]string{FragmentTemplateName, PageTemplateName, ErrorTemplateName} {
+		contents := defaults[name]
+		if directory != nil && *directory != "" {
+			b, err := ioutil.ReadFile(filepath.Join(*directory, name))
+			if err != nil {
+				if !os.IsNotExist(err) {
+					return nil, err
+				}
+			} else {
+				contents = string(b)
+			}
+		}
+		_, err = templates.New(name).Parse(contents)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return templates, nil
+}
@@ -0,0 +1,48 @@
+package api_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/vkuznecovas/mouthful/api"
+)
+
+func TestLoadTemplatesDefaults(t *testing.T) {
+	templates, err := api.LoadTemplates(nil)
+	assert.Nil(t, err)
+	for _, name := range []string{api.FragmentTemplateName, api.PageTemplateName, api.ErrorTemplateName} {
+		assert.NotNil(t, templates.Lookup(name))
+	}
+}
+
+func TestLoadTemplatesOverridesFromDirectory(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mouthful-templates")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	err = ioutil.WriteFile(filepath.Join(dir, api.ErrorTemplateName), []byte(`<p class="custom">{{.Message}}</p>`), 0644)
+	assert.Nil(t, err)
+	templates, err := api.LoadTemplates(&dir)
+	assert.Nil(t, err)
+	var buffer bytes.Buffer
+	err = templates.ExecuteTemplate(&buffer, api.ErrorTemplateName, map[string]string{"Message": "<oops>"})
+	assert.Nil(t, err)
+	assert.Equal(t, `<p class="custom">&lt;oops&gt;</p>`, buffer.String())
+	// the templates that were not overridden keep their defaults
+	assert.NotNil(t, templates.Lookup(api.FragmentTemplateName))
+	assert.NotNil(t, templates.Lookup("form"))
+}
+
+func TestLoadTemplatesInvalidTemplate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mouthful-templates")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	err = ioutil.WriteFile(filepath.Join(dir, api.FragmentTemplateName), []byte(`{{.Unclosed`), 0644)
+	assert.Nil(t, err)
+	_, err = api.LoadTemplates(&dir)
+	assert.NotNil(t, err)
+}
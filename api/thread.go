@@ -0,0 +1,476 @@
+package api
+
+import (
+	"log"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gofrs/uuid"
+
+	"github.com/vkuznecovas/mouthful/api/model"
+	dbModel "github.com/vkuznecovas/mouthful/db/model"
+	"github.com/vkuznecovas/mouthful/global"
+)
+
+// isThreadLocked checks if the readers can no longer comment on the thread, either because it was locked or archived by the admin or because it is older than the configured auto close period
+func (r *Router) isThreadLocked(thread dbModel.Thread) bool {
+	if thread.State == dbModel.ThreadLocked || thread.State == dbModel.ThreadArchived {
+		return true
+	}
+	autoClose := r.config.Moderation.AutoCloseAfterDays
+	if autoClose != nil && *autoClose > 0 {
+		return time.Since(thread.CreatedAt) > time.Duration(*autoClose)*24*time.Hour
+	}
+	return false
+}
+
+// requestedThreadPath returns the path of the thread requested by the thread or uri query parameters along with the site it belongs to, aborting the request if neither is valid.
+// The explicit thread key takes precedence over the uri. The site is picked by the site query parameter or the origin of the request
+func (r *Router) requestedThreadPath(c *gin.Context) (string, *dbModel.Site, bool) {
+	var path string
+	if key := c.Query("thread"); key != "" {
+		keyPath, err := ThreadKeyPath(key)
+		if err != nil {
+			c.AbortWithStatusJSON(400, err.Error())
+			return "", nil, false
+		}
+		path = keyPath
+	} else {
+		if c.Query("uri") == "" {
+			c.AbortWithStatusJSON(400, global.ErrThreadNotFound.Error())
+			return "", nil, false
+		}
+		path = r.normalizer.Normalize(c.Query("uri"))
+	}
+	site, ok := r.resolveSite(c, c.Query("site"))
+	if !ok {
+		return "", nil, false
+	}
+	return SitePath(siteKey(site), path), site, true
+}
+
+// resolveThreadPath returns the path of the thread the given path is an alias of, or the path itself if it is not an alias
+func (r *Router) resolveThreadPath(path string) (string, error) {
+	if _, sitePath := SplitSitePath(path); strings.HasPrefix(sitePath, ThreadKeyPrefix) {
+		return path, nil
+	}
+	db := *r.db
+	alias, err := db.GetThreadAlias(path)
+	if err != nil {
+		if err == global.ErrThreadAliasNotFound {
+			return path, nil
+		}
+		return "", err
+	}
+	thread, err := db.GetThreadById(alias.ThreadId)
+	if err != nil {
+		return "", err
+	}
+	return thread.Path, nil
+}
+
+// getThreadForComment returns the thread found at path, along with the status and error to respond with if a comment can't be posted to it.
+// Threads that do not exist yet always accept comments, as they get created along with the comment, in which case the thread is nil.
+func (r *Router) getThreadForComment(path string, staff bool) (*dbModel.Thread, int, error) {
+	db := *r.db
+	thread, err := db.GetThread(path)
+	if err != nil {
+		if err == global.ErrThreadNotFound {
+			return nil, 0, nil
+		}
+		log.Println(err)
+		return nil, 500, global.ErrInternalServerError
+	}
+	if thread.State == dbModel.ThreadArchived {
+		return nil, 403, global.ErrThreadArchived
+	}
+	if !staff && r.isThreadLocked(thread) {
+		return nil, 403, global.ErrThreadLocked
+	}
+	return &thread, 0, nil
+}
+
+// storeThreadMetadata stores the page title and url sent along with the comment on its thread, unless the thread already has them.
+// The callers leave them out unless they come from an admin or a verified origin, and the admins change them with UpdateThreadMetadata.
+// It returns the thread as stored, or the given thread if it could not be fetched.
+func (r *Router) storeThreadMetadata(createCommentBody model.CreateCommentBody, thread *dbModel.Thread) *dbModel.Thread {
+	title := ""
+	if createCommentBody.Title != nil {
+		title = NormalizeThreadTitle(*createCommentBody.Title)
+	}
+	url := ""
+	if createCommentBody.URL != nil {
+		url = NormalizeThreadURL(*createCommentBody.URL)
+	}
+	if thread != nil && (title == "" || thread.Title != "") && (url == "" || thread.URL != "") {
+		return thread
+	}
+	db := *r.db
+	// the threads created for the comment have to be fetched
+	stored, err := db.GetThread(createCommentBody.Path)
+	if err != nil {
+		log.Println(err)
+		return thread
+	}
+	changed := false
+	if stored.Title == "" && title != "" {
+		stored.Title = title
+		changed = true
+	}
+	if stored.URL == "" && url != "" {
+		stored.URL = url
+		changed = true
+	}
+	if changed {
+		err = db.UpdateThread(stored)
+		if err != nil {
+			log.Println(err)
+		}
+	}
+	return &stored
+}
+
+// LockThread stops the readers from commenting on the thread. The staff can still post in it
+func (r *Router) LockThread(c *gin.Context) {
+	r.setThreadState(c, dbModel.ThreadLocked)
+}
+
+// UnlockThread opens a locked or archived thread for comments again
+func (r *Router) UnlockThread(c *gin.Context) {
+	r.setThreadState(c, dbModel.ThreadOpen)
+}
+
+// ArchiveThread makes the thread read-only
+func (r *Router) ArchiveThread(c *gin.Context) {
+	r.setThreadState(c, dbModel.ThreadArchived)
+}
+
+// setThreadState changes the state of the thread given in the request body
+func (r *Router) setThreadState(c *gin.Context, state dbModel.ThreadState) {
+	threadId, ok := r.bindThreadFlagBody(c)
+	if !ok {
+		return
+	}
+	db := *r.db
+	thread, err := db.GetThreadById(*threadId)
+	if err != nil {
+		r.abortWithThreadError(c, err)
+		return
+	}
+	if !r.canManageThread(c, thread) {
+		return
+	}
+	err = db.SetThreadState(*threadId, state)
+	if err != nil {
+		r.abortWithThreadError(c, err)
+		return
+	}
+	r.invalidateThreadCache(thread.Path)
+	c.AbortWithStatus(204)
+}
+
+// RenameThread moves the thread and all its comments to a new path, for example after the slug of a post changes
+func (r *Router) RenameThread(c *gin.Context) {
+	var body model.UpdateThreadBody
+	if !r.bindAdminBody(c, &body) {
+		return
+	}
+	threadId, ok := parseThreadId(c, body.ThreadId)
+	if !ok {
+		return
+	}
+	if body.Path == "" {
+		c.AbortWithStatusJSON(400, global.ErrBadRequest.Error())
+		return
+	}
+	db := *r.db
+	thread, err := db.GetThreadById(*threadId)
+	if err != nil {
+		r.abortWithThreadError(c, err)
+		return
+	}
+	if !r.canManageThread(c, thread) {
+		return
+	}
+	// the thread stays on its site
+	key, _ := SplitSitePath(thread.Path)
+	path := SitePath(key, r.normalizer.Normalize(body.Path))
+	// the alias would hide the renamed thread
+	_, err = db.GetThreadAlias(path)
+	if err == nil {
+		err = global.ErrThreadAliasAlreadyExists
+	}
+	if err != global.ErrThreadAliasNotFound {
+		r.abortWithThreadError(c, err)
+		return
+	}
+	err = db.RenameThread(*threadId, path)
+	if err != nil {
+		r.abortWithThreadError(c, err)
+		return
+	}
+	r.invalidateThreadCache(thread.Path)
+	r.invalidateThreadCache(path)
+	r.audit(c, dbModel.AuditThreadRename, thread.Id.String(), thread.Path+" -> "+path, thread.SiteId)
+	c.AbortWithStatus(204)
+}
+
+// MergeThreads moves all the comments and aliases of the thread to the target thread and deletes the emptied thread
+func (r *Router) MergeThreads(c *gin.Context) {
+	var body model.MergeThreadsBody
+	if !r.bindAdminBody(c, &body) {
+		return
+	}
+	sourceId, ok := parseThreadId(c, body.ThreadId)
+	if !ok {
+		return
+	}
+	targetId, ok := parseThreadId(c, body.TargetThreadId)
+	if !ok {
+		return
+	}
+	db := *r.db
+	source, err := db.GetThreadById(*sourceId)
+	if err != nil {
+		r.abortWithThreadError(c, err)
+		return
+	}
+	target, err := db.GetThreadById(*targetId)
+	if err != nil {
+		r.abortWithThreadError(c, err)
+		return
+	}
+	if !r.canManageThread(c, source) || !r.canManageThread(c, target) {
+		return
+	}
+	sourceSite, _ := SplitSitePath(source.Path)
+	targetSite, _ := SplitSitePath(target.Path)
+	if sourceSite != targetSite {
+		c.AbortWithStatusJSON(400, global.ErrCantMergeAcrossSites.Error())
+		return
+	}
+	err = db.MergeThreads(*sourceId, *targetId)
+	if err != nil {
+		r.abortWithThreadError(c, err)
+		return
+	}
+	r.invalidateThreadCache(source.Path)
+	r.invalidateThreadCache(target.Path)
+	r.audit(c, dbModel.AuditThreadMerge, source.Id.String(), source.Path+" -> "+target.Path+" ("+target.Id.String()+")", target.SiteId)
+	c.AbortWithStatus(204)
+}
+
+// DeleteThread permanently deletes the thread along with all its comments and aliases
+func (r *Router) DeleteThread(c *gin.Context) {
+	threadId, ok := r.bindThreadFlagBody(c)
+	if !ok {
+		return
+	}
+	db := *r.db
+	thread, err := db.GetThreadById(*threadId)
+	if err != nil {
+		r.abortWithThreadError(c, err)
+		return
+	}
+	if !r.canManageThread(c, thread) {
+		return
+	}
+	err = db.DeleteThread(*threadId)
+	if err != nil {
+		r.abortWithThreadError(c, err)
+		return
+	}
+	r.invalidateThreadCache(thread.Path)
+	r.audit(c, dbModel.AuditThreadDelete, thread.Id.String(), thread.Path, thread.SiteId)
+	c.AbortWithStatus(204)
+}
+
+// UpdateThreadMetadata sets the title, url and the per-thread moderation settings of the thread
+func (r *Router) UpdateThreadMetadata(c *gin.Context) {
+	var body model.ThreadMetadataBody
+	if !r.bindAdminBody(c, &body) {
+		return
+	}
+	threadId, ok := parseThreadId(c, body.ThreadId)
+	if !ok {
+		return
+	}
+	url := NormalizeThreadURL(body.URL)
+	if (body.URL != "" && url == "") || (body.MaxCommentLength != nil && *body.MaxCommentLength < 0) {
+		c.AbortWithStatusJSON(400, global.ErrBadRequest.Error())
+		return
+	}
+	db := *r.db
+	thread, err := db.GetThreadById(*threadId)
+	if err != nil {
+		r.abortWithThreadError(c, err)
+		return
+	}
+	if !r.canManageThread(c, thread) {
+		return
+	}
+	thread.Title = NormalizeThreadTitle(body.Title)
+	thread.URL = url
+	thread.Moderation = body.Moderation
+	thread.MaxCommentLength = body.MaxCommentLength
+	err = db.UpdateThread(thread)
+	if err != nil {
+		r.abortWithThreadError(c, err)
+		return
+	}
+	r.audit(c, dbModel.AuditThreadUpdate, thread.Id.String(), thread.Path, thread.SiteId)
+	c.AbortWithStatus(204)
+}
+
+// GetThreadAliases returns all the thread aliases. The admins of a single site only get the aliases of their site
+func (r *Router) GetThreadAliases(c *gin.Context) {
+	if !r.isAdmin(c) {
+		c.AbortWithStatusJSON(401, global.ErrUnauthorized.Error())
+		return
+	}
+	db := *r.db
+	var aliases []dbModel.ThreadAlias
+	var err error
+	if siteId := r.adminSiteId(c); siteId != nil {
+		aliases, err = db.GetSiteThreadAliases(*siteId)
+	} else {
+		aliases, err = db.GetAllThreadAliases()
+	}
+	if err != nil {
+		log.Println(err)
+		c.AbortWithStatusJSON(500, global.ErrInternalServerError.Error())
+		return
+	}
+	if aliases == nil {
+		aliases = make([]dbModel.ThreadAlias, 0)
+	}
+	c.JSON(200, aliases)
+}
+
+// CreateThreadAlias makes the path show the comments of the thread, for example when the same post is reachable from several urls
+func (r *Router) CreateThreadAlias(c *gin.Context) {
+	var body model.ThreadAliasBody
+	if !r.bindAdminBody(c, &body) {
+		return
+	}
+	threadId, ok := parseThreadId(c, body.ThreadId)
+	if !ok {
+		return
+	}
+	if body.Path == "" {
+		c.AbortWithStatusJSON(400, global.ErrBadRequest.Error())
+		return
+	}
+	db := *r.db
+	thread, err := db.GetThreadById(*threadId)
+	if err != nil {
+		r.abortWithThreadError(c, err)
+		return
+	}
+	if !r.canManageThread(c, thread) {
+		return
+	}
+	// aliases belong to the site of their thread
+	key, _ := SplitSitePath(thread.Path)
+	path := SitePath(key, r.normalizer.Normalize(body.Path))
+	err = db.CreateThreadAlias(path, *threadId)
+	if err != nil {
+		r.abortWithThreadError(c, err)
+		return
+	}
+	r.invalidateThreadCache(path)
+	r.audit(c, dbModel.AuditThreadAliasCreate, thread.Id.String(), path+" -> "+thread.Path, thread.SiteId)
+	c.AbortWithStatus(204)
+}
+
+// DeleteThreadAlias stops the path from showing the comments of the thread it is an alias of
+func (r *Router) DeleteThreadAlias(c *gin.Context) {
+	var body model.ThreadAliasBody
+	if !r.bindAdminBody(c, &body) {
+		return
+	}
+	if body.Path == "" {
+		c.AbortWithStatusJSON(400, global.ErrBadRequest.Error())
+		return
+	}
+	key := body.Site
+	if r.adminSiteId(c) != nil {
+		key = siteKey(r.adminSite(c))
+	}
+	path := SitePath(key, r.normalizer.Normalize(body.Path))
+	db := *r.db
+	alias, err := db.GetThreadAlias(path)
+	if err != nil {
+		r.abortWithThreadError(c, err)
+		return
+	}
+	thread, err := db.GetThreadById(alias.ThreadId)
+	if err != nil {
+		r.abortWithThreadError(c, err)
+		return
+	}
+	if !r.canManageThread(c, thread) {
+		return
+	}
+	err = db.DeleteThreadAlias(path)
+	if err != nil {
+		r.abortWithThreadError(c, err)
+		return
+	}
+	r.invalidateThreadCache(path)
+	r.audit(c, dbModel.AuditThreadAliasDelete, alias.ThreadId.String(), path, thread.SiteId)
+	c.AbortWithStatus(204)
+}
+
+// bindThreadFlagBody checks for admin rights and parses the thread id from the request body, aborting the request if either fails
+func (r *Router) bindThreadFlagBody(c *gin.Context) (*uuid.UUID, bool) {
+	var body model.ThreadFlagBody
+	if !r.bindAdminBody(c, &body) {
+		return nil, false
+	}
+	return parseThreadId(c, body.ThreadId)
+}
+
+// bindAdminBody checks for admin rights and binds the json request body, aborting the request if either fails
+func (r *Router) bindAdminBody(c *gin.Context, body interface{}) bool {
+	if !r.isAdmin(c) {
+		c.AbortWithStatusJSON(401, global.ErrUnauthorized.Error())
+		return false
+	}
+	err := c.BindJSON(body)
+	if err != nil {
+		log.Println(err)
+		c.AbortWithStatusJSON(400, global.ErrBadRequest.Error())
+		return false
+	}
+	return true
+}
+
+// parseThreadId parses the thread id, aborting the request if it is not valid
+func parseThreadId(c *gin.Context, id string) (*uuid.UUID, bool) {
+	threadId, err := global.ParseUUIDFromString(id)
+	if err != nil {
+		log.Println(err)
+		c.AbortWithStatusJSON(400, global.ErrBadRequest.Error())
+		return nil, false
+	}
+	return threadId, true
+}
+
+// abortWithThreadError responds with 404 for missing threads and aliases, 409 for path conflicts, 400 for merging a thread into itself and 500 for everything else
+func (r *Router) abortWithThreadError(c *gin.Context, err error) {
+	switch err {
+	case global.ErrThreadNotFound, global.ErrThreadAliasNotFound:
+		c.AbortWithStatusJSON(404, err.Error())
+		return
+	case global.ErrThreadAlreadyExists, global.ErrThreadAliasAlreadyExists:
+		c.AbortWithStatusJSON(409, err.Error())
+		return
+	case global.ErrCantMergeThreadIntoItself:
+		c.AbortWithStatusJSON(400, err.Error())
+		return
+	}
+	log.Println(err)
+	c.AbortWithStatusJSON(500, global.ErrInternalServerError.Error())
+}
@@ -0,0 +1,172 @@
+package api
+
+import (
+	"crypto/tls"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/vkuznecovas/mouthful/config/model"
+	"github.com/vkuznecovas/mouthful/global"
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// TLS holds what serving mouthful over https takes
+type TLS struct {
+	// Config is the tls config of the https listener
+	Config *tls.Config
+	// RedirectAddress is the address of the plain http listener, or empty if there is none
+	RedirectAddress string
+	// RedirectHandler redirects the plain http requests to https, answering the ACME http-01 challenges first when ACME is enabled
+	RedirectHandler http.Handler
+}
+
+// NewTLS creates the tls settings from the given config. The redirects point to the given https port.
+// It returns nil if tls is not enabled, or an error if the config is incomplete or the certificate files can't be loaded
+func NewTLS(config model.TLS, port int) (*TLS, error) {
+	if !config.Enabled {
+		return nil, nil
+	}
+	result := TLS{}
+	if config.RedirectAddress != nil {
+		result.RedirectAddress = *config.RedirectAddress
+	}
+	redirect := RedirectToHTTPS(port)
+	hasFiles := config.CertFile != nil || config.KeyFile != nil
+	if config.ACME.Enabled {
+		if hasFiles {
+			return nil, fmt.Errorf("config.API.TLS can't have both the certificate files and ACME enabled")
+		}
+		manager, err := newACMEManager(config.ACME)
+		if err != nil {
+			return nil, err
+		}
+		if config.RedirectAddress == nil {
+			result.RedirectAddress = global.DefaultACMERedirectAddress
+		}
+		result.Config = manager.TLSConfig()
+		result.RedirectHandler = manager.HTTPHandler(redirect)
+	} else {
+		if config.CertFile == nil || *config.CertFile == "" || config.KeyFile == nil || *config.KeyFile == "" {
+			return nil, fmt.Errorf("config.API.TLS needs either the certFile and keyFile or ACME enabled")
+		}
+		reloader := &certificateReloader{certFile: *config.CertFile, keyFile: *config.KeyFile}
+		_, err := reloader.GetCertificate(nil)
+		if err != nil {
+			return nil, fmt.Errorf("Couldn't load the certificate of config.API.TLS: %v", err)
+		}
+		result.Config = &tls.Config{GetCertificate: reloader.GetCertificate}
+		result.RedirectHandler = redirect
+	}
+	result.Config.MinVersion = tls.VersionTLS12
+	return &result, nil
+}
+
+// newACMEManager creates the manager obtaining the certificates of the configured domains from the ACME directory
+func newACMEManager(config model.ACME) (*autocert.Manager, error) {
+	if !config.AcceptTermsOfService {
+		return nil, fmt.Errorf("config.API.TLS.ACME.AcceptTermsOfService has to be true, agreeing to the terms of service of the ACME directory")
+	}
+	if config.Domains == nil || len(*config.Domains) == 0 {
+		return nil, fmt.Errorf("config.API.TLS.ACME.Domains has to list the domains to get the certificates for")
+	}
+	for i, v := range *config.Domains {
+		if v == "" || strings.ContainsAny(v, "/:* ") {
+			return nil, fmt.Errorf("config.API.TLS.ACME.Domains[%v] is not a valid domain: %v", i, v)
+		}
+	}
+	cacheDirectory := global.DefaultACMECacheDirectory
+	if config.CacheDirectory != nil && *config.CacheDirectory != "" {
+		cacheDirectory = *config.CacheDirectory
+	}
+	manager := autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		Cache:      autocert.DirCache(cacheDirectory),
+		HostPolicy: autocert.HostWhitelist(*config.Domains...),
+	}
+	if config.Email != nil {
+		manager.Email = *config.Email
+	}
+	if config.DirectoryURL != nil && *config.DirectoryURL != "" {
+		manager.Client = &acme.Client{DirectoryURL: *config.DirectoryURL}
+	}
+	return &manager, nil
+}
+
+// RedirectToHTTPS returns a handler redirecting the requests to the same url over https on the given port
+func RedirectToHTTPS(port int) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" && r.Method != "HEAD" {
+			http.Error(w, "Use HTTPS", http.StatusBadRequest)
+			return
+		}
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		host = strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
+		if port != 443 {
+			host = net.JoinHostPort(host, strconv.Itoa(port))
+		} else if strings.Contains(host, ":") {
+			host = "[" + host + "]"
+		}
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusMovedPermanently)
+	})
+}
+
+// certificateReloader serves the certificate from the cert and key files, loading them again whenever either changes, so that renewed certificates are picked up without a restart
+type certificateReloader struct {
+	certFile    string
+	keyFile     string
+	mutex       sync.Mutex
+	certificate *tls.Certificate
+	loadedFrom  [2]time.Time
+}
+
+// GetCertificate returns the certificate, reloading it if the files were modified since the last load. If the reload fails, the last certificate keeps being served
+func (cr *certificateReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	certInfo, err := os.Stat(cr.certFile)
+	if err != nil {
+		return cr.lastCertificate(err)
+	}
+	keyInfo, err := os.Stat(cr.keyFile)
+	if err != nil {
+		return cr.lastCertificate(err)
+	}
+	modified := [2]time.Time{certInfo.ModTime(), keyInfo.ModTime()}
+	cr.mutex.Lock()
+	defer cr.mutex.Unlock()
+	if cr.certificate != nil && modified[0].Equal(cr.loadedFrom[0]) && modified[1].Equal(cr.loadedFrom[1]) {
+		return cr.certificate, nil
+	}
+	certificate, err := tls.LoadX509KeyPair(cr.certFile, cr.keyFile)
+	if err != nil {
+		if cr.certificate != nil {
+			// the files might be halfway through being replaced, the next handshake tries again
+			log.Println(err)
+			return cr.certificate, nil
+		}
+		return nil, err
+	}
+	cr.certificate = &certificate
+	cr.loadedFrom = modified
+	return cr.certificate, nil
+}
+
+// lastCertificate returns the certificate loaded last, logging the error, or the error if none was loaded yet
+func (cr *certificateReloader) lastCertificate(err error) (*tls.Certificate, error) {
+	cr.mutex.Lock()
+	defer cr.mutex.Unlock()
+	if cr.certificate == nil {
+		return nil, err
+	}
+	log.Println(err)
+	return cr.certificate, nil
+}
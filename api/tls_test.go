@@ -0,0 +1,159 @@
+package api_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/vkuznecovas/mouthful/api"
+	configModel "github.com/vkuznecovas/mouthful/config/model"
+)
+
+// writeCertificate writes a self signed certificate for the common name to the cert and key files
+func writeCertificate(t *testing.T, certFile string, keyFile string, commonName string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(t, err)
+	template := x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	assert.Nil(t, err)
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	assert.Nil(t, err)
+	err = ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644)
+	assert.Nil(t, err)
+	err = ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600)
+	assert.Nil(t, err)
+}
+
+func certificateCommonName(t *testing.T, settings *api.TLS) string {
+	certificate, err := settings.Config.GetCertificate(&tls.ClientHelloInfo{ServerName: "example.com"})
+	if !assert.Nil(t, err) {
+		return ""
+	}
+	parsed, err := x509.ParseCertificate(certificate.Certificate[0])
+	assert.Nil(t, err)
+	return parsed.Subject.CommonName
+}
+
+func TestNewTLSDisabled(t *testing.T) {
+	settings, err := api.NewTLS(configModel.TLS{}, 443)
+	assert.Nil(t, err)
+	assert.Nil(t, settings)
+}
+
+func TestNewTLSInvalid(t *testing.T) {
+	missing := "/does/not/exist.pem"
+	_, err := api.NewTLS(configModel.TLS{Enabled: true}, 443)
+	assert.NotNil(t, err)
+	_, err = api.NewTLS(configModel.TLS{Enabled: true, CertFile: &missing}, 443)
+	assert.NotNil(t, err)
+	_, err = api.NewTLS(configModel.TLS{Enabled: true, CertFile: &missing, KeyFile: &missing}, 443)
+	assert.NotNil(t, err)
+	_, err = api.NewTLS(configModel.TLS{Enabled: true, CertFile: &missing, KeyFile: &missing, ACME: configModel.ACME{Enabled: true, AcceptTermsOfService: true, Domains: &[]string{"example.com"}}}, 443)
+	assert.NotNil(t, err)
+	_, err = api.NewTLS(configModel.TLS{Enabled: true, ACME: configModel.ACME{Enabled: true, Domains: &[]string{"example.com"}}}, 443)
+	assert.NotNil(t, err)
+	_, err = api.NewTLS(configModel.TLS{Enabled: true, ACME: configModel.ACME{Enabled: true, AcceptTermsOfService: true}}, 443)
+	assert.NotNil(t, err)
+	_, err = api.NewTLS(configModel.TLS{Enabled: true, ACME: configModel.ACME{Enabled: true, AcceptTermsOfService: true, Domains: &[]string{"https://example.com"}}}, 443)
+	assert.NotNil(t, err)
+}
+
+func TestNewTLSReloadsCertificate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mouthful-tls")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	writeCertificate(t, certFile, keyFile, "first")
+
+	settings, err := api.NewTLS(configModel.TLS{Enabled: true, CertFile: &certFile, KeyFile: &keyFile}, 443)
+	assert.Nil(t, err)
+	assert.Equal(t, "", settings.RedirectAddress)
+	assert.Equal(t, uint16(tls.VersionTLS12), settings.Config.MinVersion)
+	assert.Equal(t, "first", certificateCommonName(t, settings))
+
+	writeCertificate(t, certFile, keyFile, "second")
+	later := time.Now().Add(time.Minute)
+	assert.Nil(t, os.Chtimes(certFile, later, later))
+	assert.Nil(t, os.Chtimes(keyFile, later, later))
+	assert.Equal(t, "second", certificateCommonName(t, settings))
+
+	// a broken renewal keeps the last certificate
+	assert.Nil(t, ioutil.WriteFile(keyFile, []byte("broken"), 0600))
+	later = later.Add(time.Minute)
+	assert.Nil(t, os.Chtimes(keyFile, later, later))
+	assert.Equal(t, "second", certificateCommonName(t, settings))
+	assert.Nil(t, os.Remove(certFile))
+	assert.Equal(t, "second", certificateCommonName(t, settings))
+}
+
+func TestNewTLSACME(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mouthful-acme")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	directoryURL := "https://localhost:14000/dir"
+	settings, err := api.NewTLS(configModel.TLS{Enabled: true, ACME: configModel.ACME{
+		Enabled:              true,
+		AcceptTermsOfService: true,
+		Domains:              &[]string{"comments.example.com"},
+		CacheDirectory:       &dir,
+		DirectoryURL:         &directoryURL,
+	}}, 443)
+	assert.Nil(t, err)
+	assert.Equal(t, ":80", settings.RedirectAddress)
+	assert.Contains(t, settings.Config.NextProtos, "acme-tls/1")
+
+	// hosts that are not listed don't get a certificate, without a request to the directory
+	_, err = settings.Config.GetCertificate(&tls.ClientHelloInfo{ServerName: "other.example.com"})
+	assert.NotNil(t, err)
+
+	recorder := httptest.NewRecorder()
+	settings.RedirectHandler.ServeHTTP(recorder, httptest.NewRequest("GET", "http://comments.example.com/v1/comments?uri=/", nil))
+	assert.Equal(t, 301, recorder.Code)
+	assert.Equal(t, "https://comments.example.com/v1/comments?uri=/", recorder.Header().Get("Location"))
+
+	recorder = httptest.NewRecorder()
+	settings.RedirectHandler.ServeHTTP(recorder, httptest.NewRequest("GET", "http://comments.example.com/.well-known/acme-challenge/token", nil))
+	assert.Equal(t, 404, recorder.Code)
+}
+
+func TestRedirectToHTTPS(t *testing.T) {
+	cases := []struct {
+		port     int
+		target   string
+		location string
+	}{
+		{443, "http://example.com/v1/comments?uri=/a", "https://example.com/v1/comments?uri=/a"},
+		{443, "http://example.com:80/", "https://example.com/"},
+		{8443, "http://example.com:8080/admin", "https://example.com:8443/admin"},
+		{8443, "http://[::1]:8080/", "https://[::1]:8443/"},
+		{443, "http://[::1]/", "https://[::1]/"},
+	}
+	for _, v := range cases {
+		recorder := httptest.NewRecorder()
+		api.RedirectToHTTPS(v.port).ServeHTTP(recorder, httptest.NewRequest("GET", v.target, nil))
+		assert.Equal(t, 301, recorder.Code)
+		assert.Equal(t, v.location, recorder.Header().Get("Location"))
+	}
+	recorder := httptest.NewRecorder()
+	api.RedirectToHTTPS(443).ServeHTTP(recorder, httptest.NewRequest("POST", "http://example.com/v1/comments", nil))
+	assert.Equal(t, 400, recorder.Code)
+}
@@ -0,0 +1,182 @@
+package api
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// totpStep is the time step of the totp codes
+const totpStep = 30
+
+// totpDigits is the amount of digits in a totp code
+const totpDigits = 6
+
+// totpSkew is the amount of time steps a code is still accepted for before and after its own, so clocks that are a little off still work
+const totpSkew = 1
+
+// totpSecretBytes is the amount of random bytes in a totp secret, the size of a sha1 hmac key as recommended by RFC 4226
+const totpSecretBytes = 20
+
+// recoveryCodeCount is the amount of recovery codes handed out at a time
+const recoveryCodeCount = 10
+
+// recoveryCodeBytes is the amount of random bytes in a recovery code
+const recoveryCodeBytes = 5
+
+// totpEncoding is the base32 encoding the totp secrets are shown in, as expected by the authenticator apps
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// newTOTPSecret generates a random totp secret, base32 encoded
+func newTOTPSecret() (string, error) {
+	secret := make([]byte, totpSecretBytes)
+	_, err := rand.Read(secret)
+	if err != nil {
+		return "", err
+	}
+	return totpEncoding.EncodeToString(secret), nil
+}
+
+// totpCodeAt returns the code of the base32 encoded secret for the given time step, as described by RFC 6238 and RFC 4226
+func totpCodeAt(secret string, step int64) (string, error) {
+	key, err := totpEncoding.DecodeString(strings.ToUpper(strings.TrimRight(secret, "=")))
+	if err != nil {
+		return "", err
+	}
+	counter := make([]byte, 8)
+	binary.BigEndian.PutUint64(counter, uint64(step))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(counter)
+	sum := mac.Sum(nil)
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	modulo := uint32(1)
+	for i := 0; i < totpDigits; i++ {
+		modulo *= 10
+	}
+	return fmt.Sprintf("%0*d", totpDigits, value%modulo), nil
+}
+
+// TOTPCode returns the totp code of the base32 encoded secret at the given time
+func TOTPCode(secret string, at time.Time) (string, error) {
+	return totpCodeAt(secret, at.Unix()/totpStep)
+}
+
+// verifyTOTP checks the code against the base32 encoded secret at the given time, allowing for a little clock skew.
+// Codes of the time steps up to lastUsedStep are turned down, so a code can't be used twice. It returns the time step of the code if it's valid
+func verifyTOTP(secret string, code string, at time.Time, lastUsedStep int64) (int64, bool) {
+	code = strings.Replace(code, " ", "", -1)
+	if len(code) != totpDigits {
+		return 0, false
+	}
+	current := at.Unix() / totpStep
+	for step := current - totpSkew; step <= current+totpSkew; step++ {
+		if step <= lastUsedStep {
+			continue
+		}
+		expected, err := totpCodeAt(secret, step)
+		if err != nil {
+			return 0, false
+		}
+		if subtle.ConstantTimeCompare([]byte(expected), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+// totpURI returns the otpauth uri of the secret, which the authenticator apps can be set up with, usually through a qr code
+func totpURI(issuer string, account string, secret string) string {
+	label := url.PathEscape(issuer) + ":" + url.PathEscape(account)
+	query := url.Values{}
+	query.Set("secret", secret)
+	query.Set("issuer", issuer)
+	query.Set("algorithm", "SHA1")
+	query.Set("digits", fmt.Sprint(totpDigits))
+	query.Set("period", fmt.Sprint(totpStep))
+	return "otpauth://totp/" + label + "?" + query.Encode()
+}
+
+// newRecoveryCodes generates a set of recovery codes, returning them along with their hashes, which is all that gets stored
+func newRecoveryCodes() ([]string, []string, error) {
+	codes := make([]string, 0, recoveryCodeCount)
+	hashes := make([]string, 0, recoveryCodeCount)
+	for i := 0; i < recoveryCodeCount; i++ {
+		raw := make([]byte, recoveryCodeBytes)
+		_, err := rand.Read(raw)
+		if err != nil {
+			return nil, nil, err
+		}
+		code := hex.EncodeToString(raw)
+		code = code[:len(code)/2] + "-" + code[len(code)/2:]
+		codes = append(codes, code)
+		hashes = append(hashes, hashRecoveryCode(code))
+	}
+	return codes, hashes, nil
+}
+
+// hashRecoveryCode hashes the recovery code for storing. The codes are random enough for a plain hash to do
+func hashRecoveryCode(code string) string {
+	normalized := strings.ToLower(strings.Replace(strings.Replace(strings.TrimSpace(code), "-", "", -1), " ", "", -1))
+	sum := sha256.Sum256([]byte(normalized))
+	return hex.EncodeToString(sum[:])
+}
+
+// secretCipher encrypts the totp secrets stored in the database with AES-GCM, using a key derived from the configured encryption key
+type secretCipher struct {
+	aead cipher.AEAD
+}
+
+// newSecretCipher creates a cipher for the totp secrets from the configured encryption key
+func newSecretCipher(encryptionKey string) (*secretCipher, error) {
+	key := sha256.Sum256([]byte(encryptionKey))
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &secretCipher{aead: aead}, nil
+}
+
+// encrypt encrypts the secret, bound to the account it belongs to so it can't be moved to another one
+func (sc *secretCipher) encrypt(account string, secret string) (string, error) {
+	nonce := make([]byte, sc.aead.NonceSize())
+	_, err := rand.Read(nonce)
+	if err != nil {
+		return "", err
+	}
+	sealed := sc.aead.Seal(nonce, nonce, []byte(secret), []byte(account))
+	return base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// decrypt decrypts the secret of the account
+func (sc *secretCipher) decrypt(account string, encrypted string) (string, error) {
+	sealed, err := base64.StdEncoding.DecodeString(encrypted)
+	if err != nil {
+		return "", err
+	}
+	if len(sealed) < sc.aead.NonceSize() {
+		return "", fmt.Errorf("the encrypted secret is too short")
+	}
+	nonce, ciphertext := sealed[:sc.aead.NonceSize()], sealed[sc.aead.NonceSize():]
+	secret, err := sc.aead.Open(nil, nonce, ciphertext, []byte(account))
+	if err != nil {
+		return "", err
+	}
+	return string(secret), nil
+}
@@ -0,0 +1,427 @@
+package api
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+	"github.com/gofrs/uuid"
+	"github.com/vkuznecovas/mouthful/api/model"
+	dbModel "github.com/vkuznecovas/mouthful/db/model"
+	"github.com/vkuznecovas/mouthful/global"
+)
+
+// the session keys the password login waiting for its second factor is kept under. The session is not an admin one until the second factor passes
+const (
+	twoFactorAccountKey = "twoFactorAccount"
+	twoFactorSiteIdKey  = "twoFactorSiteId"
+	twoFactorSinceKey   = "twoFactorSince"
+)
+
+// setTwoFactorKey sets the key the totp secrets are encrypted with, enabling the two-factor authentication
+func (r *Router) setTwoFactorKey(key string) error {
+	secretCipher, err := newSecretCipher(key)
+	if err != nil {
+		return err
+	}
+	r.twoFactorCipher = secretCipher
+	return nil
+}
+
+// confirmedTwoFactor returns the second factor of the account, or nil if it has none or it's not confirmed yet
+func (r *Router) confirmedTwoFactor(account string) (*dbModel.TwoFactor, error) {
+	if r.twoFactorCipher == nil {
+		return nil, nil
+	}
+	twoFactor, err := (*r.db).GetTwoFactor(account)
+	if err == global.ErrTwoFactorNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !twoFactor.Confirmed {
+		return nil, nil
+	}
+	return &twoFactor, nil
+}
+
+// checkSecondFactor checks the totp or recovery code in the body against the second factor of the account, using the code up.
+// It returns whether the code was right, and whether it was a recovery code
+func (r *Router) checkSecondFactor(twoFactor *dbModel.TwoFactor, body model.TwoFactorBody) (bool, bool, error) {
+	if body.RecoveryCode != "" {
+		hash := hashRecoveryCode(body.RecoveryCode)
+		for i, v := range twoFactor.RecoveryCodes {
+			if subtle.ConstantTimeCompare([]byte(v), []byte(hash)) != 1 {
+				continue
+			}
+			remaining := make(dbModel.StringList, 0, len(twoFactor.RecoveryCodes)-1)
+			remaining = append(remaining, twoFactor.RecoveryCodes[:i]...)
+			twoFactor.RecoveryCodes = append(remaining, twoFactor.RecoveryCodes[i+1:]...)
+			return true, true, (*r.db).SaveTwoFactor(*twoFactor)
+		}
+		return false, true, nil
+	}
+	secret, err := r.twoFactorCipher.decrypt(twoFactor.Id, twoFactor.Secret)
+	if err != nil {
+		return false, false, err
+	}
+	step, ok := verifyTOTP(secret, body.Code, time.Now(), twoFactor.LastUsedStep)
+	if !ok {
+		return false, false, nil
+	}
+	twoFactor.LastUsedStep = step
+	return true, false, (*r.db).SaveTwoFactor(*twoFactor)
+}
+
+// beginTwoFactorLogin remembers the password login in the session until the second factor is entered, replacing whatever session the request had
+func (r *Router) beginTwoFactorLogin(c *gin.Context, account string, siteId *uuid.UUID) error {
+	session := sessions.Default(c)
+	if r.config.Moderation.ServerSideSessions {
+		if previous := sessionId(session); previous != nil {
+			err := (*r.db).DeleteAdminSession(*previous)
+			if err != nil && err != global.ErrSessionNotFound {
+				log.Println(err)
+			}
+		}
+	}
+	session.Clear()
+	session.Set(twoFactorAccountKey, account)
+	if siteId != nil {
+		session.Set(twoFactorSiteIdKey, siteId.String())
+	}
+	session.Set(twoFactorSinceKey, time.Now().Unix())
+	return session.Save()
+}
+
+// LoginTwoFactor completes a password login with the totp code or one of the recovery codes of the account.
+// Wrong codes count as failed logins, so they get throttled the same way as wrong passwords
+func (r *Router) LoginTwoFactor(c *gin.Context) {
+	if !r.isAdminOrigin(c) {
+		c.AbortWithStatusJSON(403, global.ErrInvalidOrigin.Error())
+		return
+	}
+	session := sessions.Default(c)
+	account, ok := session.Get(twoFactorAccountKey).(string)
+	since, _ := session.Get(twoFactorSinceKey).(int64)
+	if !ok || account == "" || time.Since(time.Unix(since, 0)) > global.TwoFactorLoginTimeoutSeconds*time.Second {
+		c.AbortWithStatusJSON(401, global.ErrUnauthorized.Error())
+		return
+	}
+	var siteId *uuid.UUID
+	if id, ok := session.Get(twoFactorSiteIdKey).(string); ok && id != "" {
+		parsed, err := global.ParseUUIDFromString(id)
+		if err != nil {
+			c.AbortWithStatusJSON(401, global.ErrUnauthorized.Error())
+			return
+		}
+		siteId = parsed
+	}
+	var body model.TwoFactorBody
+	err := c.BindJSON(&body)
+	if err != nil {
+		log.Println(err)
+		c.AbortWithStatusJSON(400, global.ErrBadRequest.Error())
+		return
+	}
+
+	ids := []string{LoginFailureIPId(c.ClientIP()), LoginFailureAccountId(account)}
+	unlock := r.loginLocks.lock(ids)
+	defer unlock()
+	if wait := r.loginBlockedFor(ids); wait > 0 {
+		abortWithTooManyLoginAttempts(c, wait)
+		return
+	}
+	twoFactor, err := r.confirmedTwoFactor(account)
+	if err != nil {
+		log.Println(err)
+		c.AbortWithStatusJSON(500, global.ErrInternalServerError.Error())
+		return
+	}
+	if twoFactor == nil {
+		// the second factor was turned off in the meantime, so the password has to be entered again
+		c.AbortWithStatusJSON(401, global.ErrUnauthorized.Error())
+		return
+	}
+	ok, recovery, err := r.checkSecondFactor(twoFactor, body)
+	if err != nil {
+		log.Println(err)
+		c.AbortWithStatusJSON(500, global.ErrInternalServerError.Error())
+		return
+	}
+	if !ok {
+		r.recordTwoFactorFailure(c, ids, account, siteId)
+		c.AbortWithStatusJSON(401, global.ErrInvalidTwoFactorCode.Error())
+		return
+	}
+	r.clearLoginFailures(ids)
+	if recovery {
+		r.audit(c, dbModel.AuditTwoFactorRecoveryCodeUse, account, fmt.Sprintf("%v recovery codes left", len(twoFactor.RecoveryCodes)), siteId)
+	}
+
+	err = r.startSession(c, adminIdentity{userId: PasswordUserId, provider: PasswordProvider, siteId: siteId})
+	if err != nil {
+		log.Println(err)
+		c.AbortWithStatusJSON(500, global.ErrInternalServerError.Error())
+		return
+	}
+	c.AbortWithStatus(204)
+}
+
+// adminPasswordAccount returns the password account the admin is logged in to, along with its site. Only the admins logged in with a password have one
+func (r *Router) adminPasswordAccount(c *gin.Context) (string, *uuid.UUID, bool) {
+	if provider, _ := sessions.Default(c).Get("provider").(string); provider != PasswordProvider {
+		return "", nil, false
+	}
+	siteId := r.adminSiteId(c)
+	if siteId == nil {
+		return PasswordUserId, nil, true
+	}
+	site := r.adminSite(c)
+	if site == nil {
+		return "", nil, false
+	}
+	return passwordAccount(site.Key), siteId, true
+}
+
+// twoFactorAdmin checks that the request comes from an admin logged in with a password, aborting it otherwise. It returns the account and its site
+func (r *Router) twoFactorAdmin(c *gin.Context) (string, *uuid.UUID, bool) {
+	if !r.isAdmin(c) {
+		c.AbortWithStatusJSON(401, global.ErrUnauthorized.Error())
+		return "", nil, false
+	}
+	account, siteId, ok := r.adminPasswordAccount(c)
+	if !ok {
+		c.AbortWithStatusJSON(403, global.ErrPasswordLoginOnly.Error())
+		return "", nil, false
+	}
+	return account, siteId, true
+}
+
+// abortWithTwoFactorError responds with 404 for accounts without a second factor, 409 for enrolling again, 400 for wrong codes and 500 for everything else
+func abortWithTwoFactorError(c *gin.Context, err error) {
+	switch err {
+	case global.ErrTwoFactorNotFound:
+		c.AbortWithStatusJSON(404, err.Error())
+		return
+	case global.ErrTwoFactorAlreadyEnabled:
+		c.AbortWithStatusJSON(409, err.Error())
+		return
+	case global.ErrInvalidTwoFactorCode:
+		c.AbortWithStatusJSON(400, err.Error())
+		return
+	}
+	log.Println(err)
+	c.AbortWithStatusJSON(500, global.ErrInternalServerError.Error())
+}
+
+// GetTwoFactor returns the two-factor authentication state of the account the admin is logged in to
+func (r *Router) GetTwoFactor(c *gin.Context) {
+	account, _, ok := r.twoFactorAdmin(c)
+	if !ok {
+		return
+	}
+	status := model.TwoFactorStatus{}
+	twoFactor, err := (*r.db).GetTwoFactor(account)
+	if err != nil && err != global.ErrTwoFactorNotFound {
+		abortWithTwoFactorError(c, err)
+		return
+	}
+	if err == nil {
+		status.Enabled = twoFactor.Confirmed
+		status.Pending = !twoFactor.Confirmed
+		status.RecoveryCodesLeft = len(twoFactor.RecoveryCodes)
+	}
+	c.JSON(200, status)
+}
+
+// EnrollTwoFactor generates a new totp secret for the account the admin is logged in to. It only takes effect once it's confirmed with a code, replacing any earlier unconfirmed one
+func (r *Router) EnrollTwoFactor(c *gin.Context) {
+	account, _, ok := r.twoFactorAdmin(c)
+	if !ok {
+		return
+	}
+	existing, err := r.confirmedTwoFactor(account)
+	if err != nil {
+		abortWithTwoFactorError(c, err)
+		return
+	}
+	if existing != nil {
+		abortWithTwoFactorError(c, global.ErrTwoFactorAlreadyEnabled)
+		return
+	}
+	secret, err := newTOTPSecret()
+	if err != nil {
+		abortWithTwoFactorError(c, err)
+		return
+	}
+	encrypted, err := r.twoFactorCipher.encrypt(account, secret)
+	if err != nil {
+		abortWithTwoFactorError(c, err)
+		return
+	}
+	err = (*r.db).SaveTwoFactor(dbModel.TwoFactor{Id: account, Secret: encrypted, CreatedAt: time.Now().UTC()})
+	if err != nil {
+		abortWithTwoFactorError(c, err)
+		return
+	}
+	issuer := global.DefaultTwoFactorIssuer
+	if r.config.Moderation.TwoFactor.Issuer != "" {
+		issuer = r.config.Moderation.TwoFactor.Issuer
+	}
+	c.JSON(200, model.TwoFactorEnrollment{Secret: secret, URI: totpURI(issuer, account, secret)})
+}
+
+// ConfirmTwoFactor turns the two-factor authentication on for the account the admin is logged in to, once the code from the new secret checks out. The recovery codes are returned, for the only time
+func (r *Router) ConfirmTwoFactor(c *gin.Context) {
+	account, siteId, ok := r.twoFactorAdmin(c)
+	if !ok {
+		return
+	}
+	var body model.TwoFactorBody
+	err := c.BindJSON(&body)
+	if err != nil {
+		log.Println(err)
+		c.AbortWithStatusJSON(400, global.ErrBadRequest.Error())
+		return
+	}
+	twoFactor, err := (*r.db).GetTwoFactor(account)
+	if err != nil {
+		abortWithTwoFactorError(c, err)
+		return
+	}
+	if twoFactor.Confirmed {
+		abortWithTwoFactorError(c, global.ErrTwoFactorAlreadyEnabled)
+		return
+	}
+	secret, err := r.twoFactorCipher.decrypt(account, twoFactor.Secret)
+	if err != nil {
+		abortWithTwoFactorError(c, err)
+		return
+	}
+	step, ok := verifyTOTP(secret, body.Code, time.Now(), 0)
+	if !ok {
+		abortWithTwoFactorError(c, global.ErrInvalidTwoFactorCode)
+		return
+	}
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		abortWithTwoFactorError(c, err)
+		return
+	}
+	twoFactor.Confirmed = true
+	twoFactor.LastUsedStep = step
+	twoFactor.RecoveryCodes = hashes
+	err = (*r.db).SaveTwoFactor(twoFactor)
+	if err != nil {
+		abortWithTwoFactorError(c, err)
+		return
+	}
+	r.audit(c, dbModel.AuditTwoFactorEnable, account, "", siteId)
+	c.JSON(200, model.RecoveryCodesResponse{RecoveryCodes: codes})
+}
+
+// RegenerateRecoveryCodes replaces the recovery codes of the account the admin is logged in to. It takes a current totp code, or one of the old recovery codes
+func (r *Router) RegenerateRecoveryCodes(c *gin.Context) {
+	account, siteId, ok := r.twoFactorAdmin(c)
+	if !ok {
+		return
+	}
+	twoFactor, ok := r.verifiedTwoFactor(c, account, siteId)
+	if !ok {
+		return
+	}
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		abortWithTwoFactorError(c, err)
+		return
+	}
+	twoFactor.RecoveryCodes = hashes
+	err = (*r.db).SaveTwoFactor(*twoFactor)
+	if err != nil {
+		abortWithTwoFactorError(c, err)
+		return
+	}
+	r.audit(c, dbModel.AuditTwoFactorRecoveryCodes, account, "", siteId)
+	c.JSON(200, model.RecoveryCodesResponse{RecoveryCodes: codes})
+}
+
+// DisableTwoFactor turns the two-factor authentication off for the account the admin is logged in to. It takes a current totp code, or one of the recovery codes, unless the secret was never confirmed
+func (r *Router) DisableTwoFactor(c *gin.Context) {
+	account, siteId, ok := r.twoFactorAdmin(c)
+	if !ok {
+		return
+	}
+	existing, err := r.confirmedTwoFactor(account)
+	if err != nil {
+		abortWithTwoFactorError(c, err)
+		return
+	}
+	if existing != nil {
+		if _, ok := r.verifiedTwoFactor(c, account, siteId); !ok {
+			return
+		}
+	}
+	err = (*r.db).DeleteTwoFactor(account)
+	if err != nil {
+		abortWithTwoFactorError(c, err)
+		return
+	}
+	if existing != nil {
+		r.audit(c, dbModel.AuditTwoFactorDisable, account, "", siteId)
+	}
+	c.AbortWithStatus(204)
+}
+
+// verifiedTwoFactor checks the code in the request body against the confirmed second factor of the account, aborting the request if there's none or the code is wrong.
+// The wrong codes are throttled like the ones entered when logging in, so a stolen session can't be used to guess them
+func (r *Router) verifiedTwoFactor(c *gin.Context, account string, siteId *uuid.UUID) (*dbModel.TwoFactor, bool) {
+	var body model.TwoFactorBody
+	err := c.BindJSON(&body)
+	if err != nil {
+		log.Println(err)
+		c.AbortWithStatusJSON(400, global.ErrBadRequest.Error())
+		return nil, false
+	}
+	ids := []string{LoginFailureIPId(c.ClientIP()), LoginFailureAccountId(account)}
+	unlock := r.loginLocks.lock(ids)
+	defer unlock()
+	if wait := r.loginBlockedFor(ids); wait > 0 {
+		abortWithTooManyLoginAttempts(c, wait)
+		return nil, false
+	}
+	twoFactor, err := r.confirmedTwoFactor(account)
+	if err != nil {
+		abortWithTwoFactorError(c, err)
+		return nil, false
+	}
+	if twoFactor == nil {
+		abortWithTwoFactorError(c, global.ErrTwoFactorNotFound)
+		return nil, false
+	}
+	ok, _, err := r.checkSecondFactor(twoFactor, body)
+	if err != nil {
+		abortWithTwoFactorError(c, err)
+		return nil, false
+	}
+	if !ok {
+		r.recordTwoFactorFailure(c, ids, account, siteId)
+		abortWithTwoFactorError(c, global.ErrInvalidTwoFactorCode)
+		return nil, false
+	}
+	r.clearLoginFailures(ids)
+	return twoFactor, true
+}
+
+// recordTwoFactorFailure records a wrong two-factor code for the client and account by id, along with an audit entry
+func (r *Router) recordTwoFactorFailure(c *gin.Context, ids []string, account string, siteId *uuid.UUID) {
+	delay, lockedOut := r.recordLoginFailure(ids)
+	if lockedOut {
+		r.audit(c, dbModel.AuditLoginLockout, account, "wrong two-factor code, locked out for "+delay.String(), siteId)
+	} else {
+		r.audit(c, dbModel.AuditLoginFailure, account, "wrong two-factor code, blocked for "+delay.String(), siteId)
+	}
+}
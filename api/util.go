@@ -1,6 +1,70 @@
 package api
 
-import "strings"
+import (
+	"net/url"
+	"regexp"
+	"strings"
+	"unicode"
+
+	"github.com/vkuznecovas/mouthful/global"
+)
+
+// ThreadKeyPrefix prefixes the paths of the threads identified by an explicit key. Normalized paths always start with a slash, so the two can't clash
+const ThreadKeyPrefix = "key:"
+
+var threadKeyRegexp = regexp.MustCompile(`^[A-Za-z0-9._:/-]+$`)
+
+// ThreadKeyPath validates the explicit thread key and returns the path the thread is stored under
+func ThreadKeyPath(key string) (string, error) {
+	if len(key) > global.DefaultThreadKeyLengthLimit || !threadKeyRegexp.MatchString(key) {
+		return "", global.ErrInvalidThreadKey
+	}
+	return ThreadKeyPrefix + key, nil
+}
+
+// SitePathPrefix prefixes the paths of the threads that belong to a site, followed by the site key and a colon.
+// The threads of the default site keep their paths as they are, so the sites never share a thread
+const SitePathPrefix = "site:"
+
+var siteKeyRegexp = regexp.MustCompile(`^[a-z0-9-]+$`)
+
+// ValidateSiteKey checks that the site key is a short slug of lowercase letters, digits and dashes
+func ValidateSiteKey(key string) error {
+	if len(key) > global.DefaultSiteKeyLengthLimit || !siteKeyRegexp.MatchString(key) {
+		return global.ErrInvalidSiteKey
+	}
+	return nil
+}
+
+// SitePath returns the path the thread at the given path of the site is stored under. An empty site key stands for the default site
+func SitePath(siteKey string, path string) string {
+	if siteKey == "" {
+		return path
+	}
+	return SitePathPrefix + siteKey + ":" + path
+}
+
+// SplitSitePath splits the stored thread path into the site key and the path within the site. The key is empty for the threads of the default site
+func SplitSitePath(path string) (siteKey string, sitePath string) {
+	if !strings.HasPrefix(path, SitePathPrefix) {
+		return "", path
+	}
+	rest := path[len(SitePathPrefix):]
+	i := strings.Index(rest, ":")
+	if i < 0 {
+		return "", path
+	}
+	return rest[:i], rest[i+1:]
+}
+
+// NormalizeOrigin returns the lowercased scheme://host[:port] origin of the given http or https url, or an empty string if it is not one
+func NormalizeOrigin(input string) string {
+	parsed, err := url.Parse(strings.TrimSpace(input))
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return ""
+	}
+	return strings.ToLower(parsed.Scheme + "://" + parsed.Host)
+}
 
 // NormalizePath adds a missing slash at the front or the end of given input path
 func NormalizePath(input string) string {
@@ -26,3 +90,44 @@ func ShortenAuthor(input string, allowedLength int) string {
 	}
 	return input
 }
+
+// NormalizeAuthorName lowercases the author name and strips everything but letters and digits, so that names differing only in case, spacing or punctuation compare equal
+func NormalizeAuthorName(input string) string {
+	var builder strings.Builder
+	for _, r := range strings.ToLower(input) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			builder.WriteRune(r)
+		}
+	}
+	return builder.String()
+}
+
+// NormalizeThreadTitle trims the page title sent by the embed and cuts it to the length the database can store
+func NormalizeThreadTitle(input string) string {
+	input = strings.TrimSpace(input)
+	if len(input) <= global.DefaultThreadTitleLengthLimit {
+		return input
+	}
+	// cut on a rune boundary, so we don't store broken utf-8
+	cut := 0
+	for i := range input {
+		if i > global.DefaultThreadTitleLengthLimit {
+			break
+		}
+		cut = i
+	}
+	return input[:cut]
+}
+
+// NormalizeThreadURL returns the canonical url sent by the embed if it is an absolute http or https url short enough to store, or an empty string otherwise
+func NormalizeThreadURL(input string) string {
+	input = strings.TrimSpace(input)
+	if len(input) > global.DefaultThreadURLLengthLimit {
+		return ""
+	}
+	parsed, err := url.Parse(input)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return ""
+	}
+	return parsed.String()
+}
@@ -1,11 +1,16 @@
 package api_test
 
 import (
+	"encoding/base32"
+	"strings"
 	"testing"
+	"time"
+	"unicode/utf8"
 
 	"github.com/stretchr/testify/assert"
 
 	"github.com/vkuznecovas/mouthful/api"
+	"github.com/vkuznecovas/mouthful/global"
 )
 
 func TestNormalizePath(t *testing.T) {
@@ -56,3 +61,78 @@ func TestShortenAuthor(t *testing.T) {
 	assert.Equal(t, 4, len(res))
 	assert.Equal(t, "a...", res)
 }
+
+func TestNormalizeAuthorName(t *testing.T) {
+	assert.Equal(t, "siteowner", api.NormalizeAuthorName("Site Owner"))
+	assert.Equal(t, "siteowner", api.NormalizeAuthorName("  s.i.t.e_OWNER!"))
+	assert.Equal(t, "žmogus2", api.NormalizeAuthorName("Žmogus 2"))
+	assert.Equal(t, "", api.NormalizeAuthorName("..."))
+}
+
+func TestNormalizeThreadTitle(t *testing.T) {
+	assert.Equal(t, "A post", api.NormalizeThreadTitle("  A post \n"))
+	long := strings.Repeat("a", 254) + "ž"
+	res := api.NormalizeThreadTitle(long)
+	assert.Equal(t, strings.Repeat("a", 254), res)
+	assert.True(t, utf8.ValidString(res))
+	assert.Equal(t, strings.Repeat("a", 255), api.NormalizeThreadTitle(strings.Repeat("a", 300)))
+}
+
+func TestNormalizeThreadURL(t *testing.T) {
+	assert.Equal(t, "https://example.com/post/", api.NormalizeThreadURL(" https://example.com/post/ "))
+	assert.Equal(t, "http://example.com/post?id=1", api.NormalizeThreadURL("http://example.com/post?id=1"))
+	assert.Equal(t, "", api.NormalizeThreadURL("javascript:alert(1)"))
+	assert.Equal(t, "", api.NormalizeThreadURL("/post/"))
+	assert.Equal(t, "", api.NormalizeThreadURL("https://example.com/"+strings.Repeat("a", 2048)))
+}
+
+func TestThreadKeyPath(t *testing.T) {
+	path, err := api.ThreadKeyPath("blog/post-42")
+	assert.Nil(t, err)
+	assert.Equal(t, "key:blog/post-42", path)
+	for _, key := range []string{"", "with space", "<script>", strings.Repeat("a", 201)} {
+		_, err = api.ThreadKeyPath(key)
+		assert.Equal(t, global.ErrInvalidThreadKey, err, key)
+	}
+}
+
+func TestValidateSiteKey(t *testing.T) {
+	assert.Nil(t, api.ValidateSiteKey("blog"))
+	assert.Nil(t, api.ValidateSiteKey("my-blog-2"))
+	assert.Equal(t, global.ErrInvalidSiteKey, api.ValidateSiteKey(""))
+	assert.Equal(t, global.ErrInvalidSiteKey, api.ValidateSiteKey("Blog"))
+	assert.Equal(t, global.ErrInvalidSiteKey, api.ValidateSiteKey("my:blog"))
+	assert.Equal(t, global.ErrInvalidSiteKey, api.ValidateSiteKey(strings.Repeat("a", global.DefaultSiteKeyLengthLimit+1)))
+}
+
+func TestSitePath(t *testing.T) {
+	assert.Equal(t, "/post/", api.SitePath("", "/post/"))
+	assert.Equal(t, "site:blog:/post/", api.SitePath("blog", "/post/"))
+	assert.Equal(t, "site:blog:key:post-1", api.SitePath("blog", "key:post-1"))
+
+	key, path := api.SplitSitePath("site:blog:key:post-1")
+	assert.Equal(t, "blog", key)
+	assert.Equal(t, "key:post-1", path)
+	key, path = api.SplitSitePath("/post/")
+	assert.Equal(t, "", key)
+	assert.Equal(t, "/post/", path)
+}
+
+func TestNormalizeOrigin(t *testing.T) {
+	assert.Equal(t, "https://blog.example.com", api.NormalizeOrigin("https://Blog.Example.com/"))
+	assert.Equal(t, "http://localhost:8080", api.NormalizeOrigin("http://localhost:8080/some/page?x=1"))
+	assert.Equal(t, "", api.NormalizeOrigin("blog.example.com"))
+	assert.Equal(t, "", api.NormalizeOrigin("ftp://blog.example.com"))
+}
+
+func TestTOTPCode(t *testing.T) {
+	// the sha1 test vectors of RFC 6238, cut down to 6 digits
+	secret := base32.StdEncoding.EncodeToString([]byte("12345678901234567890"))
+	for seconds, expected := range map[int64]string{59: "287082", 1111111109: "081804", 1111111111: "050471", 1234567890: "005924", 2000000000: "279037"} {
+		code, err := api.TOTPCode(secret, time.Unix(seconds, 0))
+		assert.Nil(t, err)
+		assert.Equal(t, expected, code)
+	}
+	_, err := api.TOTPCode("not base32!", time.Now())
+	assert.NotNil(t, err)
+}
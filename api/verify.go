@@ -0,0 +1,223 @@
+package api
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/vkuznecovas/mouthful/api/model"
+	configModel "github.com/vkuznecovas/mouthful/config/model"
+	"github.com/vkuznecovas/mouthful/global"
+)
+
+// sitemapURLSet is the part of a sitemap.xml the page urls are read from
+type sitemapURLSet struct {
+	URLs []struct {
+		Loc string `xml:"loc"`
+	} `xml:"url"`
+}
+
+// PostVerifier checks where the comments are posted from and which paths new threads can be created for
+type PostVerifier struct {
+	checkOrigin bool
+	origins     map[string]bool
+	patterns    []*regexp.Regexp
+	sitemapFile string
+	normalizer  *PathNormalizer
+
+	mutex          sync.RWMutex
+	sitemapPaths   map[string]bool
+	sitemapModTime time.Time
+}
+
+// NewPostVerifier creates a post verifier from the given verification config.
+// The allowed origins of the cors config, if any, are allowed on top of the configured ones.
+// The sitemap paths are normalized with the given normalizer, just like the paths of the posted comments.
+// It returns an error if any of the origins or patterns is invalid or the sitemap can't be read.
+func NewPostVerifier(config configModel.Verification, corsOrigins []string, normalizer *PathNormalizer) (*PostVerifier, error) {
+	verifier := PostVerifier{
+		checkOrigin: config.CheckOrigin,
+		origins:     make(map[string]bool),
+		normalizer:  normalizer,
+	}
+	if config.AllowedOrigins != nil {
+		for i, v := range *config.AllowedOrigins {
+			origin := NormalizeOrigin(v)
+			if origin == "" {
+				return nil, fmt.Errorf("config.API.Verification.AllowedOrigins[%v] is not a valid origin: %v", i, v)
+			}
+			verifier.origins[origin] = true
+		}
+	}
+	for _, v := range corsOrigins {
+		if origin := NormalizeOrigin(v); origin != "" {
+			verifier.origins[origin] = true
+		}
+	}
+	if config.PathPatterns != nil {
+		for i, v := range *config.PathPatterns {
+			pattern, err := regexp.Compile(v)
+			if err != nil {
+				return nil, fmt.Errorf("config.API.Verification.PathPatterns[%v] is not a valid regular expression: %v", i, err)
+			}
+			verifier.patterns = append(verifier.patterns, pattern)
+		}
+	}
+	if config.SitemapFile != nil && *config.SitemapFile != "" {
+		verifier.sitemapFile = *config.SitemapFile
+		err := verifier.reloadSitemap()
+		if err != nil {
+			return nil, fmt.Errorf("config.API.Verification.SitemapFile can't be read: %v", err)
+		}
+	}
+	return &verifier, nil
+}
+
+// OriginAllowed checks if comments can be posted from the given origin. Without the origin check enabled, every origin is allowed
+func (v *PostVerifier) OriginAllowed(origin string) bool {
+	if !v.checkOrigin {
+		return true
+	}
+	return origin != "" && v.origins[NormalizeOrigin(origin)]
+}
+
+// OriginVerified checks if the given origin is one of the allowed ones, with the origin check enabled
+func (v *PostVerifier) OriginVerified(origin string) bool {
+	return v.checkOrigin && origin != "" && v.origins[NormalizeOrigin(origin)]
+}
+
+// PathAllowed checks if a new thread can be created for the given normalized page path.
+// If neither patterns nor a sitemap are configured, every path is allowed. Otherwise the path has to match one of the patterns or be listed in the sitemap.
+func (v *PostVerifier) PathAllowed(path string) bool {
+	if len(v.patterns) == 0 && v.sitemapFile == "" {
+		return true
+	}
+	for _, pattern := range v.patterns {
+		if pattern.MatchString(path) {
+			return true
+		}
+	}
+	if v.sitemapFile == "" {
+		return false
+	}
+	// the sitemap is usually regenerated along with the site, so it's reread whenever it changes
+	if info, err := os.Stat(v.sitemapFile); err == nil {
+		v.mutex.RLock()
+		changed := !info.ModTime().Equal(v.sitemapModTime)
+		v.mutex.RUnlock()
+		if changed {
+			if err := v.reloadSitemap(); err != nil {
+				log.Println(err)
+			}
+		}
+	} else {
+		log.Println(err)
+	}
+	v.mutex.RLock()
+	defer v.mutex.RUnlock()
+	return v.sitemapPaths[path]
+}
+
+// reloadSitemap reads the sitemap file, keeping the current paths if the file can't be read
+func (v *PostVerifier) reloadSitemap() error {
+	info, err := os.Stat(v.sitemapFile)
+	if err != nil {
+		return err
+	}
+	contents, err := ioutil.ReadFile(v.sitemapFile)
+	if err != nil {
+		return err
+	}
+	paths, err := parseSitemap(contents)
+	if err != nil {
+		return err
+	}
+	normalized := make(map[string]bool, len(paths))
+	for _, path := range paths {
+		normalized[v.normalizer.Normalize(path)] = true
+	}
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	v.sitemapPaths = normalized
+	v.sitemapModTime = info.ModTime()
+	return nil
+}
+
+// parseSitemap returns the page urls or paths listed in the given sitemap. Both sitemap.xml files and plain text files with an url or path per line are supported
+func parseSitemap(contents []byte) ([]string, error) {
+	result := make([]string, 0)
+	if strings.HasPrefix(string(bytes.TrimSpace(contents)), "<") {
+		var urlSet sitemapURLSet
+		err := xml.Unmarshal(contents, &urlSet)
+		if err != nil {
+			return nil, err
+		}
+		for _, v := range urlSet.URLs {
+			if loc := strings.TrimSpace(v.Loc); loc != "" {
+				result = append(result, loc)
+			}
+		}
+		return result, nil
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(contents))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line != "" && !strings.HasPrefix(line, "#") {
+			result = append(result, line)
+		}
+	}
+	return result, scanner.Err()
+}
+
+// SetPostVerifier sets the verifier the posted comments are checked with
+func (r *Router) SetPostVerifier(verifier *PostVerifier) {
+	r.verifier = verifier
+}
+
+// verifyOrigin checks if comments can be posted from the origin of the request. The origins of the sites are always allowed
+func (r *Router) verifyOrigin(c *gin.Context) bool {
+	if r.verifier == nil {
+		return true
+	}
+	origin := requestOrigin(c)
+	return r.verifier.OriginAllowed(origin) || (origin != "" && r.isSiteOrigin(origin))
+}
+
+// acceptsThreadMetadata checks if the page title and url sent along with a comment can be stored on its thread.
+// They show up in the admin panel and the webhook notifications, so the readers only get to set them from the verified origins and the origins of the sites
+func (r *Router) acceptsThreadMetadata(c *gin.Context) bool {
+	origin := requestOrigin(c)
+	if origin == "" {
+		return false
+	}
+	return (r.verifier != nil && r.verifier.OriginVerified(origin)) || r.isSiteOrigin(origin)
+}
+
+// verifyThreadPath checks if a new thread can be created for the given normalized page path
+func (r *Router) verifyThreadPath(path string) bool {
+	if r.verifier == nil {
+		return true
+	}
+	return r.verifier.PathAllowed(path)
+}
+
+// abortWithPostError aborts a comment post. Posts rejected by the verification get a structured error telling the reason, the rest the plain error
+func abortWithPostError(c *gin.Context, status int, err error) {
+	switch err {
+	case global.ErrPostOriginNotAllowed:
+		c.AbortWithStatusJSON(status, model.ErrorResponse{Error: err.Error(), Code: model.ErrorCodeOriginNotAllowed})
+	case global.ErrPathNotAllowed:
+		c.AbortWithStatusJSON(status, model.ErrorResponse{Error: err.Error(), Code: model.ErrorCodePathNotAllowed})
+	default:
+		c.AbortWithStatusJSON(status, err.Error())
+	}
+}
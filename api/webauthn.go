@@ -0,0 +1,548 @@
+package api
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+	"github.com/gofrs/uuid"
+	"github.com/vkuznecovas/mouthful/api/model"
+	dbModel "github.com/vkuznecovas/mouthful/db/model"
+	"github.com/vkuznecovas/mouthful/global"
+)
+
+// webAuthnChallengeIdKey is the session key the id of the challenge of the webauthn ceremony in progress is kept under
+const webAuthnChallengeIdKey = "webauthnChallengeId"
+
+// webAuthnCredentialType is the only type of credential there is
+const webAuthnCredentialType = "public-key"
+
+// webAuthnUserVerification is asked of the authenticators, as the credentials are the only factor of the logins they are used for
+const webAuthnUserVerification = "required"
+
+// webAuthnRPName returns the name the relying party shows up under in the passkey prompts
+func (r *Router) webAuthnRPName() string {
+	if r.config.Moderation.WebAuthn.RPName != "" {
+		return r.config.Moderation.WebAuthn.RPName
+	}
+	return global.DefaultWebAuthnRPName
+}
+
+// webAuthnChallenges keeps the challenges of the webauthn ceremonies in progress by random id. Only the id goes into the session,
+// so the cookie can't be replayed to answer a challenge again. The challenges are limited per client ip rather than overall, so no client can keep the others from logging in
+type webAuthnChallenges struct {
+	mutex      sync.Mutex
+	challenges map[string]webAuthnChallenge
+}
+
+// webAuthnChallenge is a challenge waiting for the response of the authenticator
+type webAuthnChallenge struct {
+	challenge string
+	clientIP  string
+	expiresAt time.Time
+}
+
+// add remembers the challenge until it is taken or expires, returning the id it is kept under.
+// The expired challenges are dropped first, and it returns global.ErrTooManyWebAuthnChallenges if the client has too many in progress still
+func (wc *webAuthnChallenges) add(challenge string, clientIP string) (string, error) {
+	id, err := newWebAuthnChallenge()
+	if err != nil {
+		return "", err
+	}
+	now := time.Now()
+	wc.mutex.Lock()
+	defer wc.mutex.Unlock()
+	if wc.challenges == nil {
+		wc.challenges = make(map[string]webAuthnChallenge)
+	}
+	pending := 0
+	for k, v := range wc.challenges {
+		if now.After(v.expiresAt) {
+			delete(wc.challenges, k)
+		} else if v.clientIP == clientIP {
+			pending++
+		}
+	}
+	if pending >= global.MaxWebAuthnChallengesPerClient {
+		return "", global.ErrTooManyWebAuthnChallenges
+	}
+	wc.challenges[id] = webAuthnChallenge{challenge: challenge, clientIP: clientIP, expiresAt: now.Add(global.WebAuthnTimeoutSeconds * time.Second)}
+	return id, nil
+}
+
+// take returns the challenge kept under the id, forgetting it so it is only answered once. It returns false if there is none, or it has expired
+func (wc *webAuthnChallenges) take(id string) (string, bool) {
+	wc.mutex.Lock()
+	defer wc.mutex.Unlock()
+	challenge, ok := wc.challenges[id]
+	if !ok {
+		return "", false
+	}
+	delete(wc.challenges, id)
+	return challenge.challenge, !time.Now().After(challenge.expiresAt)
+}
+
+// beginWebAuthnCeremony generates a challenge and remembers it until the response of the authenticator comes back, replacing the one the session was waiting for
+func (r *Router) beginWebAuthnCeremony(c *gin.Context) (string, error) {
+	challenge, err := newWebAuthnChallenge()
+	if err != nil {
+		return "", err
+	}
+	session := sessions.Default(c)
+	if previous, ok := session.Get(webAuthnChallengeIdKey).(string); ok {
+		r.webAuthnChallenges.take(previous)
+	}
+	id, err := r.webAuthnChallenges.add(challenge, c.ClientIP())
+	if err != nil {
+		return "", err
+	}
+	session.Set(webAuthnChallengeIdKey, id)
+	return challenge, session.Save()
+}
+
+// takeWebAuthnChallenge returns the challenge of the ceremony in progress in the session, forgetting it so every challenge is only answered once. It returns false if there is none, or it has expired
+func (r *Router) takeWebAuthnChallenge(c *gin.Context) (string, bool) {
+	session := sessions.Default(c)
+	id, ok := session.Get(webAuthnChallengeIdKey).(string)
+	if !ok || id == "" {
+		return "", false
+	}
+	session.Delete(webAuthnChallengeIdKey)
+	err := session.Save()
+	if err != nil {
+		log.Println(err)
+	}
+	return r.webAuthnChallenges.take(id)
+}
+
+// verifyWebAuthnClientData checks that the client data of the response is for the ceremony, answers the challenge and comes from the admin panel
+func (r *Router) verifyWebAuthnClientData(c *gin.Context, raw []byte, ceremony string, challenge string) error {
+	origin, err := parseClientData(raw, ceremony, challenge)
+	if err != nil {
+		return err
+	}
+	if !r.isAllowedAdminOrigin(origin, c.Request.Host) || !isRelyingPartyOrigin(origin, r.config.Moderation.WebAuthn.RPID) {
+		return fmt.Errorf("the ceremony took place on %v", origin)
+	}
+	return nil
+}
+
+// sessionAdminIdentity returns the identity of the logged in admin, or of the admin the api token of the request acts as
+func (r *Router) sessionAdminIdentity(c *gin.Context) adminIdentity {
+	if token := r.requestAPIToken(c); token != nil {
+		return apiTokenIdentity(*token)
+	}
+	session := sessions.Default(c)
+	identity := adminIdentity{siteId: r.adminSiteId(c)}
+	identity.userId, _ = session.Get("userId").(string)
+	identity.name, _ = session.Get("userName").(string)
+	identity.provider, _ = session.Get("provider").(string)
+	return identity
+}
+
+// webAuthnUserHandle returns the user handle the credentials of the admin are created with, which is the same for all of them so the authenticators keep a single passkey per admin
+func webAuthnUserHandle(identity adminIdentity) string {
+	owner := identity.provider + ":" + identity.userId
+	if identity.siteId != nil {
+		owner += ":" + identity.siteId.String()
+	}
+	handle := sha256.Sum256([]byte(owner))
+	return base64.RawURLEncoding.EncodeToString(handle[:])
+}
+
+// credentialIdentity returns the identity of the admin who registered the credential
+func credentialIdentity(credential dbModel.WebAuthnCredential) adminIdentity {
+	return adminIdentity{userId: credential.UserId, name: credential.UserName, provider: credential.Provider, siteId: credential.SiteId}
+}
+
+// sameAdmin checks if the two identities are of the same admin
+func sameAdmin(a adminIdentity, b adminIdentity) bool {
+	if a.userId != b.userId || a.provider != b.provider {
+		return false
+	}
+	if a.siteId == nil || b.siteId == nil {
+		return a.siteId == nil && b.siteId == nil
+	}
+	return *a.siteId == *b.siteId
+}
+
+// isStillAdmin checks that the admin could still log in the way the credential or api token was created with, so removing an admin or turning a login off also turns the credentials and tokens of the admin away.
+// The admins of the tokens created with spoon stay admins for as long as their site exists, and the ones logged in through the proxy for as long as they are on its AdminUsers, as the groups only come along with their requests
+func (r *Router) isStillAdmin(identity adminIdentity) bool {
+	var site *dbModel.Site
+	if identity.siteId != nil {
+		site = r.sites.byId(*identity.siteId)
+		if site == nil {
+			return false
+		}
+	}
+	switch identity.provider {
+	case SpoonProvider:
+		return true
+	case ProxyProvider:
+		return r.proxyAuth != nil && r.proxyAuth.adminUsers[identity.userId]
+	case PasswordProvider:
+		if site != nil {
+			return site.AdminPasswordHash != ""
+		}
+		return !r.config.Moderation.DisablePasswordLogin
+	}
+	if site != nil {
+		return site.HasAdmin(identity.provider, identity.userId)
+	}
+	provider, ok := r.providers[identity.provider]
+	if !ok || provider == nil {
+		return false
+	}
+	for _, v := range provider.AdminUserIds {
+		if v == identity.userId {
+			return true
+		}
+	}
+	return false
+}
+
+// adminCredentials returns the webauthn credentials registered by the admin
+func (r *Router) adminCredentials(identity adminIdentity) ([]dbModel.WebAuthnCredential, error) {
+	credentials, err := (*r.db).GetWebAuthnCredentials()
+	if err != nil {
+		return nil, err
+	}
+	result := make([]dbModel.WebAuthnCredential, 0)
+	for _, v := range credentials {
+		if sameAdmin(credentialIdentity(v), identity) {
+			result = append(result, v)
+		}
+	}
+	return result, nil
+}
+
+// abortWithWebAuthnError responds with 404 for unknown credentials, 409 for registering one twice, 400 for responses that don't check out and 500 for everything else
+func abortWithWebAuthnError(c *gin.Context, err error) {
+	switch err {
+	case global.ErrWebAuthnCredentialNotFound:
+		c.AbortWithStatusJSON(404, err.Error())
+		return
+	case global.ErrWebAuthnCredentialExists:
+		c.AbortWithStatusJSON(409, err.Error())
+		return
+	case global.ErrInvalidWebAuthnResponse:
+		c.AbortWithStatusJSON(400, err.Error())
+		return
+	case global.ErrTooManyWebAuthnChallenges:
+		c.AbortWithStatusJSON(429, err.Error())
+		return
+	}
+	log.Println(err)
+	c.AbortWithStatusJSON(500, global.ErrInternalServerError.Error())
+}
+
+// BeginWebAuthnRegistration returns the options for registering a new passkey or security key for the logged in admin
+func (r *Router) BeginWebAuthnRegistration(c *gin.Context) {
+	if !r.isAdmin(c) {
+		c.AbortWithStatusJSON(401, global.ErrUnauthorized.Error())
+		return
+	}
+	identity := r.sessionAdminIdentity(c)
+	existing, err := r.adminCredentials(identity)
+	if err != nil {
+		abortWithWebAuthnError(c, err)
+		return
+	}
+	challenge, err := r.beginWebAuthnCeremony(c)
+	if err != nil {
+		abortWithWebAuthnError(c, err)
+		return
+	}
+	// the name tells the passkeys of the admins apart in the prompts
+	name := identity.provider + ":" + identity.userId
+	if identity.provider == PasswordProvider {
+		name = PasswordUserId
+		if site := r.adminSite(c); site != nil {
+			name = passwordAccount(site.Key)
+		}
+	}
+	displayName := identity.name
+	if displayName == "" {
+		displayName = name
+	}
+	options := model.WebAuthnCreationOptions{
+		Challenge:          challenge,
+		RelyingParty:       model.WebAuthnRelyingParty{Id: r.config.Moderation.WebAuthn.RPID, Name: r.webAuthnRPName()},
+		User:               model.WebAuthnUser{Id: webAuthnUserHandle(identity), Name: name, DisplayName: displayName},
+		Parameters:         make([]model.WebAuthnCredentialParameter, 0, len(webAuthnAlgorithms)),
+		Timeout:            global.WebAuthnTimeoutSeconds * 1000,
+		Attestation:        "none",
+		ExcludeCredentials: make([]model.WebAuthnCredentialDescriptor, 0, len(existing)),
+		AuthenticatorSelection: model.WebAuthnAuthenticatorSelection{
+			ResidentKey:      "required",
+			UserVerification: webAuthnUserVerification,
+		},
+	}
+	for _, v := range webAuthnAlgorithms {
+		options.Parameters = append(options.Parameters, model.WebAuthnCredentialParameter{Type: webAuthnCredentialType, Algorithm: v})
+	}
+	for _, v := range existing {
+		options.ExcludeCredentials = append(options.ExcludeCredentials, model.WebAuthnCredentialDescriptor{Type: webAuthnCredentialType, Id: v.Id})
+	}
+	c.JSON(200, options)
+}
+
+// FinishWebAuthnRegistration registers the passkey or security key created with the options from BeginWebAuthnRegistration for the logged in admin.
+// The attestation statement is not checked, any authenticator will do
+func (r *Router) FinishWebAuthnRegistration(c *gin.Context) {
+	if !r.isAdmin(c) {
+		c.AbortWithStatusJSON(401, global.ErrUnauthorized.Error())
+		return
+	}
+	var body model.WebAuthnRegistrationBody
+	err := c.BindJSON(&body)
+	if err != nil || len(body.Name) > global.MaxWebAuthnCredentialNameLength {
+		c.AbortWithStatusJSON(400, global.ErrBadRequest.Error())
+		return
+	}
+	challenge, ok := r.takeWebAuthnChallenge(c)
+	if !ok || body.Type != webAuthnCredentialType {
+		abortWithWebAuthnError(c, global.ErrInvalidWebAuthnResponse)
+		return
+	}
+	clientDataJSON, err := decodeBase64URL(body.Response.ClientDataJSON)
+	if err == nil {
+		err = r.verifyWebAuthnClientData(c, clientDataJSON, "webauthn.create", challenge)
+	}
+	var data authenticatorData
+	if err == nil {
+		var raw []byte
+		raw, err = decodeBase64URL(body.Response.AttestationObject)
+		if err == nil {
+			data, err = parseAttestationObject(raw)
+		}
+	}
+	if err == nil {
+		err = data.verify(r.config.Moderation.WebAuthn.RPID)
+	}
+	if err == nil && base64.RawURLEncoding.EncodeToString(data.credentialId) != body.Id {
+		err = fmt.Errorf("the credential id does not match")
+	}
+	if err != nil {
+		log.Println(err)
+		abortWithWebAuthnError(c, global.ErrInvalidWebAuthnResponse)
+		return
+	}
+	db := *r.db
+	_, err = db.GetWebAuthnCredential(body.Id)
+	if err == nil {
+		abortWithWebAuthnError(c, global.ErrWebAuthnCredentialExists)
+		return
+	}
+	if err != global.ErrWebAuthnCredentialNotFound {
+		abortWithWebAuthnError(c, err)
+		return
+	}
+	publicKey, err := x509.MarshalPKIXPublicKey(data.publicKey)
+	if err != nil {
+		abortWithWebAuthnError(c, err)
+		return
+	}
+	identity := r.sessionAdminIdentity(c)
+	credential := dbModel.WebAuthnCredential{
+		Id:        body.Id,
+		UserId:    identity.userId,
+		UserName:  identity.name,
+		Provider:  identity.provider,
+		SiteId:    identity.siteId,
+		Name:      body.Name,
+		PublicKey: base64.StdEncoding.EncodeToString(publicKey),
+		Algorithm: data.algorithm,
+		SignCount: int64(data.signCount),
+		CreatedAt: time.Now().UTC(),
+	}
+	err = db.SaveWebAuthnCredential(credential)
+	if err != nil {
+		abortWithWebAuthnError(c, err)
+		return
+	}
+	r.audit(c, dbModel.AuditWebAuthnRegister, credential.Id, credential.Provider+":"+credential.UserId+" "+credential.Name, credential.SiteId)
+	c.JSON(200, credential)
+}
+
+// GetWebAuthnCredentials returns the webauthn credentials of the logged in admin
+func (r *Router) GetWebAuthnCredentials(c *gin.Context) {
+	if !r.isAdmin(c) {
+		c.AbortWithStatusJSON(401, global.ErrUnauthorized.Error())
+		return
+	}
+	credentials, err := r.adminCredentials(r.sessionAdminIdentity(c))
+	if err != nil {
+		abortWithWebAuthnError(c, err)
+		return
+	}
+	c.JSON(200, credentials)
+}
+
+// DeleteWebAuthnCredential deletes one of the webauthn credentials of the logged in admin
+func (r *Router) DeleteWebAuthnCredential(c *gin.Context) {
+	if !r.isAdmin(c) {
+		c.AbortWithStatusJSON(401, global.ErrUnauthorized.Error())
+		return
+	}
+	var body model.WebAuthnCredentialFlagBody
+	err := c.BindJSON(&body)
+	if err != nil {
+		log.Println(err)
+		c.AbortWithStatusJSON(400, global.ErrBadRequest.Error())
+		return
+	}
+	db := *r.db
+	credential, err := db.GetWebAuthnCredential(body.Id)
+	if err == nil && !sameAdmin(credentialIdentity(credential), r.sessionAdminIdentity(c)) {
+		err = global.ErrWebAuthnCredentialNotFound
+	}
+	if err == nil {
+		err = db.DeleteWebAuthnCredential(body.Id)
+	}
+	if err != nil {
+		abortWithWebAuthnError(c, err)
+		return
+	}
+	r.audit(c, dbModel.AuditWebAuthnDelete, credential.Id, credential.Provider+":"+credential.UserId+" "+credential.Name, credential.SiteId)
+	c.AbortWithStatus(204)
+}
+
+// BeginWebAuthnLogin returns the options for logging in with a passkey or security key
+func (r *Router) BeginWebAuthnLogin(c *gin.Context) {
+	if !r.isAdminOrigin(c) {
+		c.AbortWithStatusJSON(403, global.ErrInvalidOrigin.Error())
+		return
+	}
+	challenge, err := r.beginWebAuthnCeremony(c)
+	if err != nil {
+		abortWithWebAuthnError(c, err)
+		return
+	}
+	c.JSON(200, model.WebAuthnRequestOptions{
+		Challenge:        challenge,
+		RelyingPartyId:   r.config.Moderation.WebAuthn.RPID,
+		Timeout:          global.WebAuthnTimeoutSeconds * 1000,
+		UserVerification: webAuthnUserVerification,
+	})
+}
+
+// FinishWebAuthnLogin logs the admin who registered the credential in, once the response to the challenge from BeginWebAuthnLogin checks out.
+// The failures are throttled by client along with the failed password logins. As the credentials can't be guessed, the accounts are not locked out by them
+func (r *Router) FinishWebAuthnLogin(c *gin.Context) {
+	if !r.isAdminOrigin(c) {
+		c.AbortWithStatusJSON(403, global.ErrInvalidOrigin.Error())
+		return
+	}
+	var body model.WebAuthnAssertionBody
+	err := c.BindJSON(&body)
+	if err != nil {
+		log.Println(err)
+		c.AbortWithStatusJSON(400, global.ErrBadRequest.Error())
+		return
+	}
+	ids := []string{LoginFailureIPId(c.ClientIP())}
+	unlock := r.loginLocks.lock(ids)
+	defer unlock()
+	if wait := r.loginBlockedFor(ids); wait > 0 {
+		abortWithTooManyLoginAttempts(c, wait)
+		return
+	}
+	challenge, ok := r.takeWebAuthnChallenge(c)
+	if !ok {
+		c.AbortWithStatusJSON(401, global.ErrUnauthorized.Error())
+		return
+	}
+
+	credential, err := (*r.db).GetWebAuthnCredential(body.Id)
+	if err != nil && err != global.ErrWebAuthnCredentialNotFound {
+		abortWithWebAuthnError(c, err)
+		return
+	}
+	var siteId *uuid.UUID
+	account := "webauthn"
+	if err == nil {
+		siteId = credential.SiteId
+		account = credential.Provider + ":" + credential.UserId
+		err = r.verifyWebAuthnAssertion(c, credential, body, challenge)
+	}
+	if err != nil {
+		log.Println(err)
+		delay, _ := r.recordLoginFailure(ids)
+		r.audit(c, dbModel.AuditLoginFailure, account, "webauthn, blocked for "+delay.String(), siteId)
+		c.AbortWithStatusJSON(401, global.ErrInvalidWebAuthnResponse.Error())
+		return
+	}
+	r.clearLoginFailures(ids)
+
+	err = r.startSession(c, credentialIdentity(credential))
+	if err != nil {
+		log.Println(err)
+		c.AbortWithStatusJSON(500, global.ErrInternalServerError.Error())
+		return
+	}
+	c.AbortWithStatus(204)
+}
+
+// verifyWebAuthnAssertion checks the response of the authenticator to the login challenge against the credential, bumping its signature counter if it checks out
+func (r *Router) verifyWebAuthnAssertion(c *gin.Context, credential dbModel.WebAuthnCredential, body model.WebAuthnAssertionBody, challenge string) error {
+	if body.Type != webAuthnCredentialType {
+		return fmt.Errorf("unexpected credential type %v", body.Type)
+	}
+	if !r.isStillAdmin(credentialIdentity(credential)) {
+		return fmt.Errorf("the credential %v belongs to somebody who is no longer an admin", credential.Id)
+	}
+	if body.Response.UserHandle != "" && body.Response.UserHandle != webAuthnUserHandle(credentialIdentity(credential)) {
+		return fmt.Errorf("the user handle does not match")
+	}
+	clientDataJSON, err := decodeBase64URL(body.Response.ClientDataJSON)
+	if err != nil {
+		return err
+	}
+	err = r.verifyWebAuthnClientData(c, clientDataJSON, "webauthn.get", challenge)
+	if err != nil {
+		return err
+	}
+	rawAuthData, err := decodeBase64URL(body.Response.AuthenticatorData)
+	if err != nil {
+		return err
+	}
+	data, err := parseAuthenticatorData(rawAuthData)
+	if err != nil {
+		return err
+	}
+	err = data.verify(r.config.Moderation.WebAuthn.RPID)
+	if err != nil {
+		return err
+	}
+	signature, err := decodeBase64URL(body.Response.Signature)
+	if err != nil {
+		return err
+	}
+	rawKey, err := base64.StdEncoding.DecodeString(credential.PublicKey)
+	if err != nil {
+		return err
+	}
+	publicKey, err := x509.ParsePKIXPublicKey(rawKey)
+	if err != nil {
+		return err
+	}
+	if !verifyWebAuthnSignature(publicKey, credential.Algorithm, rawAuthData, clientDataJSON, signature) {
+		return fmt.Errorf("the signature does not match")
+	}
+	// authenticators that count the signatures never go backwards, so a counter that does points to a cloned authenticator
+	if (data.signCount != 0 || credential.SignCount != 0) && int64(data.signCount) <= credential.SignCount {
+		return fmt.Errorf("the signature counter of %v went from %v to %v", credential.Id, credential.SignCount, data.signCount)
+	}
+	now := time.Now().UTC()
+	credential.SignCount = int64(data.signCount)
+	credential.LastUsedAt = &now
+	return (*r.db).SaveWebAuthnCredential(credential)
+}
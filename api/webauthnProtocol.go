@@ -0,0 +1,253 @@
+package api
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/url"
+	"strings"
+
+	"github.com/ugorji/go/codec"
+)
+
+// the COSE algorithms of the webauthn credentials accepted, in the order of preference
+const (
+	coseAlgorithmES256 = -7
+	coseAlgorithmEdDSA = -8
+	coseAlgorithmRS256 = -257
+)
+
+// webAuthnAlgorithms lists the COSE algorithms of the webauthn credentials accepted, in the order of preference
+var webAuthnAlgorithms = []int{coseAlgorithmES256, coseAlgorithmEdDSA, coseAlgorithmRS256}
+
+// the flags of the authenticator data
+const (
+	authenticatorUserPresent  = 0x01
+	authenticatorUserVerified = 0x04
+	authenticatorAttestedData = 0x40
+)
+
+// webAuthnChallengeBytes is the amount of random bytes in a webauthn challenge
+const webAuthnChallengeBytes = 32
+
+// the fixed size parts of the authenticator data: the hash of the relying party id, the flags and the signature counter, followed by the aaguid and the credential id length of the attested credential data
+const (
+	authenticatorDataMinLength = 37
+	attestedDataMinLength      = 18
+)
+
+// newWebAuthnChallenge generates a random webauthn challenge, base64url encoded
+func newWebAuthnChallenge() (string, error) {
+	challenge := make([]byte, webAuthnChallengeBytes)
+	_, err := rand.Read(challenge)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(challenge), nil
+}
+
+// decodeBase64URL decodes the base64url encoded fields of the webauthn responses, with or without the padding
+func decodeBase64URL(value string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(strings.TrimRight(value, "="))
+}
+
+// cborHandle returns the handle the webauthn cbor structures are decoded with. The COSE keys have negative labels, so the integers are all decoded as signed
+func cborHandle() *codec.CborHandle {
+	handle := &codec.CborHandle{}
+	handle.SignedInteger = true
+	return handle
+}
+
+// clientData is the part of the client data of a webauthn response that is checked
+type clientData struct {
+	Type      string `json:"type"`
+	Challenge string `json:"challenge"`
+	Origin    string `json:"origin"`
+}
+
+// parseClientData checks that the client data is for the given ceremony, webauthn.create or webauthn.get, and answers the challenge. It returns the origin the ceremony took place on
+func parseClientData(raw []byte, ceremony string, challenge string) (string, error) {
+	var data clientData
+	err := json.Unmarshal(raw, &data)
+	if err != nil {
+		return "", err
+	}
+	if data.Type != ceremony {
+		return "", fmt.Errorf("expected a %v ceremony, got %v", ceremony, data.Type)
+	}
+	if subtle.ConstantTimeCompare([]byte(strings.TrimRight(data.Challenge, "=")), []byte(challenge)) != 1 {
+		return "", fmt.Errorf("the challenge does not match")
+	}
+	return data.Origin, nil
+}
+
+// isRelyingPartyOrigin checks that the host of the origin is the relying party id or one of its subdomains, which is what the browsers allow the credentials to be used on
+func isRelyingPartyOrigin(origin string, rpId string) bool {
+	parsed, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	host := strings.ToLower(parsed.Hostname())
+	rpId = strings.ToLower(rpId)
+	return host == rpId || strings.HasSuffix(host, "."+rpId)
+}
+
+// authenticatorData is the parsed authenticator data of a webauthn response. The credential id and the public key are only there for registrations
+type authenticatorData struct {
+	rpIdHash     []byte
+	flags        byte
+	signCount    uint32
+	credentialId []byte
+	publicKey    crypto.PublicKey
+	algorithm    int
+}
+
+// parseAuthenticatorData parses the authenticator data of a webauthn response, as laid out in the webauthn spec
+func parseAuthenticatorData(raw []byte) (authenticatorData, error) {
+	data := authenticatorData{}
+	if len(raw) < authenticatorDataMinLength {
+		return data, fmt.Errorf("the authenticator data is too short")
+	}
+	data.rpIdHash = raw[:32]
+	data.flags = raw[32]
+	data.signCount = binary.BigEndian.Uint32(raw[33:37])
+	if data.flags&authenticatorAttestedData == 0 {
+		return data, nil
+	}
+	rest := raw[authenticatorDataMinLength:]
+	if len(rest) < attestedDataMinLength {
+		return data, fmt.Errorf("the attested credential data is too short")
+	}
+	idLength := int(binary.BigEndian.Uint16(rest[16:18]))
+	rest = rest[attestedDataMinLength:]
+	if len(rest) < idLength {
+		return data, fmt.Errorf("the credential id is too short")
+	}
+	data.credentialId = rest[:idLength]
+	publicKey, algorithm, err := parseCOSEKey(rest[idLength:])
+	if err != nil {
+		return data, err
+	}
+	data.publicKey = publicKey
+	data.algorithm = algorithm
+	return data, nil
+}
+
+// verify checks that the authenticator data is for the relying party, and that the user was there and verified
+func (ad authenticatorData) verify(rpId string) error {
+	rpIdHash := sha256.Sum256([]byte(rpId))
+	if subtle.ConstantTimeCompare(ad.rpIdHash, rpIdHash[:]) != 1 {
+		return fmt.Errorf("the relying party id does not match")
+	}
+	if ad.flags&authenticatorUserPresent == 0 {
+		return fmt.Errorf("the user was not present")
+	}
+	if ad.flags&authenticatorUserVerified == 0 {
+		return fmt.Errorf("the user was not verified")
+	}
+	return nil
+}
+
+// attestationObject is the part of the attestation object of a registration that is used. The attestation statement is not checked, as no attestation is asked for
+type attestationObject struct {
+	Format   string `codec:"fmt"`
+	AuthData []byte `codec:"authData"`
+}
+
+// parseAttestationObject parses the attestation object of a registration, returning its authenticator data
+func parseAttestationObject(raw []byte) (authenticatorData, error) {
+	var object attestationObject
+	err := codec.NewDecoderBytes(raw, cborHandle()).Decode(&object)
+	if err != nil {
+		return authenticatorData{}, err
+	}
+	data, err := parseAuthenticatorData(object.AuthData)
+	if err != nil {
+		return data, err
+	}
+	if data.publicKey == nil {
+		return data, fmt.Errorf("the attestation carries no credential")
+	}
+	return data, nil
+}
+
+// parseCOSEKey parses the COSE encoded public key of a credential, returning the key along with its algorithm.
+// Only the ES256 keys on P-256, the EdDSA keys on Ed25519 and the RS256 keys are accepted
+func parseCOSEKey(raw []byte) (crypto.PublicKey, int, error) {
+	var key map[interface{}]interface{}
+	err := codec.NewDecoderBytes(raw, cborHandle()).Decode(&key)
+	if err != nil {
+		return nil, 0, err
+	}
+	integer := func(label int64) int64 {
+		value, _ := key[label].(int64)
+		return value
+	}
+	bytes := func(label int64) []byte {
+		value, _ := key[label].([]byte)
+		return value
+	}
+	keyType, algorithm := integer(1), int(integer(3))
+	switch {
+	case keyType == 2 && algorithm == coseAlgorithmES256 && integer(-1) == 1:
+		x, y := bytes(-2), bytes(-3)
+		if len(x) != 32 || len(y) != 32 {
+			return nil, 0, fmt.Errorf("invalid ES256 key")
+		}
+		publicKey := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !publicKey.Curve.IsOnCurve(publicKey.X, publicKey.Y) {
+			return nil, 0, fmt.Errorf("the ES256 key is not on the curve")
+		}
+		return publicKey, algorithm, nil
+	case keyType == 1 && algorithm == coseAlgorithmEdDSA && integer(-1) == 6:
+		x := bytes(-2)
+		if len(x) != ed25519.PublicKeySize {
+			return nil, 0, fmt.Errorf("invalid EdDSA key")
+		}
+		return ed25519.PublicKey(x), algorithm, nil
+	case keyType == 3 && algorithm == coseAlgorithmRS256:
+		n, e := bytes(-1), bytes(-2)
+		if len(n) < 256 || len(e) == 0 || len(e) > 4 {
+			return nil, 0, fmt.Errorf("invalid RS256 key")
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, algorithm, nil
+	}
+	return nil, 0, fmt.Errorf("unsupported key type %v with algorithm %v", keyType, algorithm)
+}
+
+// verifyWebAuthnSignature checks the signature of a webauthn login, made over the authenticator data followed by the hash of the client data
+func verifyWebAuthnSignature(publicKey crypto.PublicKey, algorithm int, authData []byte, clientDataJSON []byte, signature []byte) bool {
+	clientDataHash := sha256.Sum256(clientDataJSON)
+	signed := append(append([]byte{}, authData...), clientDataHash[:]...)
+	digest := sha256.Sum256(signed)
+	switch key := publicKey.(type) {
+	case *ecdsa.PublicKey:
+		if algorithm != coseAlgorithmES256 {
+			return false
+		}
+		var parsed struct {
+			R, S *big.Int
+		}
+		rest, err := asn1.Unmarshal(signature, &parsed)
+		if err != nil || len(rest) != 0 {
+			return false
+		}
+		return ecdsa.Verify(key, digest[:], parsed.R, parsed.S)
+	case ed25519.PublicKey:
+		return algorithm == coseAlgorithmEdDSA && ed25519.Verify(key, signed, signature)
+	case *rsa.PublicKey:
+		return algorithm == coseAlgorithmRS256 && rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature) == nil
+	}
+	return false
+}
@@ -0,0 +1,26 @@
+// Package broker provides the publish/subscribe primitives mouthful uses to push live updates to its clients.
+package broker
+
+// Event represents a single message published on a topic
+type Event struct {
+	Id    uint64
+	Topic string
+	Data  []byte
+}
+
+// Subscription represents a single subscriber to a topic
+type Subscription interface {
+	// Events returns the channel the events for the subscribed topic are delivered on. The channel gets closed once the subscription is closed, either by the subscriber or by the broker.
+	Events() <-chan Event
+	// Close unsubscribes from the topic
+	Close()
+}
+
+// Broker is a publish/subscribe message broker.
+// The in-memory implementation only delivers events within a single mouthful process, other implementations can be backed by a shared bus.
+type Broker interface {
+	// Publish sends the data to all the subscribers of the given topic
+	Publish(topic string, data []byte) (Event, error)
+	// Subscribe subscribes to the given topic. If lastEventId is not nil, the events published after it that are still retained by the broker get delivered first.
+	Subscribe(topic string, lastEventId *uint64) (Subscription, error)
+}
@@ -0,0 +1,120 @@
+package broker
+
+import "sync"
+
+// MemoryBroker is an in-process broker. It retains the last few events of every topic so that subscribers can resume after reconnecting.
+type MemoryBroker struct {
+	mutex      sync.Mutex
+	lastId     uint64
+	bufferSize int
+	topics     map[string]*memoryTopic
+}
+
+type memoryTopic struct {
+	history     []Event
+	subscribers map[*memorySubscription]struct{}
+}
+
+type memorySubscription struct {
+	broker *MemoryBroker
+	topic  string
+	events chan Event
+	closed bool
+}
+
+// NewMemoryBroker returns a new in-memory broker retaining up to bufferSize events per topic.
+// The buffer size also limits how many undelivered events a subscriber can have before it gets disconnected.
+func NewMemoryBroker(bufferSize int) *MemoryBroker {
+	if bufferSize < 1 {
+		bufferSize = 1
+	}
+	return &MemoryBroker{
+		bufferSize: bufferSize,
+		topics:     make(map[string]*memoryTopic),
+	}
+}
+
+// Publish sends the data to all the subscribers of the given topic
+func (b *MemoryBroker) Publish(topic string, data []byte) (Event, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.lastId++
+	event := Event{
+		Id:    b.lastId,
+		Topic: topic,
+		Data:  data,
+	}
+	t := b.getTopic(topic)
+	t.history = append(t.history, event)
+	if len(t.history) > b.bufferSize {
+		t.history = t.history[len(t.history)-b.bufferSize:]
+	}
+	for s := range t.subscribers {
+		select {
+		case s.events <- event:
+		default:
+			// the subscriber is not keeping up, drop it. It can resume from the last event it received once it reconnects.
+			b.unsubscribe(s)
+		}
+	}
+	return event, nil
+}
+
+// Subscribe subscribes to the given topic. If lastEventId is not nil, the retained events published after it get delivered first.
+func (b *MemoryBroker) Subscribe(topic string, lastEventId *uint64) (Subscription, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	s := &memorySubscription{
+		broker: b,
+		topic:  topic,
+		events: make(chan Event, b.bufferSize),
+	}
+	t := b.getTopic(topic)
+	if lastEventId != nil {
+		for _, v := range t.history {
+			if v.Id > *lastEventId {
+				s.events <- v
+			}
+		}
+	}
+	t.subscribers[s] = struct{}{}
+	return s, nil
+}
+
+func (b *MemoryBroker) getTopic(topic string) *memoryTopic {
+	t, ok := b.topics[topic]
+	if !ok {
+		t = &memoryTopic{
+			subscribers: make(map[*memorySubscription]struct{}),
+		}
+		b.topics[topic] = t
+	}
+	return t
+}
+
+func (b *MemoryBroker) unsubscribe(s *memorySubscription) {
+	if s.closed {
+		return
+	}
+	s.closed = true
+	close(s.events)
+	if t, ok := b.topics[s.topic]; ok {
+		delete(t.subscribers, s)
+		// topics nothing was published on would pile up, as anyone can subscribe to any thread
+		if len(t.subscribers) == 0 && len(t.history) == 0 {
+			delete(b.topics, s.topic)
+		}
+	}
+}
+
+// Events returns the channel the events are delivered on
+func (s *memorySubscription) Events() <-chan Event {
+	return s.events
+}
+
+// Close unsubscribes from the topic
+func (s *memorySubscription) Close() {
+	s.broker.mutex.Lock()
+	defer s.broker.mutex.Unlock()
+	s.broker.unsubscribe(s)
+}
@@ -0,0 +1,85 @@
+package broker_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vkuznecovas/mouthful/broker"
+)
+
+func TestMemoryBrokerDeliversToSubscribers(t *testing.T) {
+	b := broker.NewMemoryBroker(10)
+	sub, err := b.Subscribe("topic", nil)
+	assert.Nil(t, err)
+	other, err := b.Subscribe("other", nil)
+	assert.Nil(t, err)
+
+	event, err := b.Publish("topic", []byte("data"))
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(1), event.Id)
+
+	received := <-sub.Events()
+	assert.Equal(t, event, received)
+	assert.Len(t, other.Events(), 0)
+}
+
+func TestMemoryBrokerResumesFromLastEventId(t *testing.T) {
+	b := broker.NewMemoryBroker(10)
+	first, err := b.Publish("topic", []byte("first"))
+	assert.Nil(t, err)
+	_, err = b.Publish("other", []byte("other"))
+	assert.Nil(t, err)
+	second, err := b.Publish("topic", []byte("second"))
+	assert.Nil(t, err)
+
+	sub, err := b.Subscribe("topic", &first.Id)
+	assert.Nil(t, err)
+	assert.Len(t, sub.Events(), 1)
+	assert.Equal(t, second, <-sub.Events())
+
+	fresh, err := b.Subscribe("topic", nil)
+	assert.Nil(t, err)
+	assert.Len(t, fresh.Events(), 0)
+}
+
+func TestMemoryBrokerRetainsOnlyBufferSizeEvents(t *testing.T) {
+	b := broker.NewMemoryBroker(2)
+	for i := 0; i < 5; i++ {
+		_, err := b.Publish("topic", []byte("data"))
+		assert.Nil(t, err)
+	}
+	lastEventId := uint64(0)
+	sub, err := b.Subscribe("topic", &lastEventId)
+	assert.Nil(t, err)
+	assert.Len(t, sub.Events(), 2)
+	assert.Equal(t, uint64(4), (<-sub.Events()).Id)
+	assert.Equal(t, uint64(5), (<-sub.Events()).Id)
+}
+
+func TestMemoryBrokerDropsSlowSubscribers(t *testing.T) {
+	b := broker.NewMemoryBroker(1)
+	sub, err := b.Subscribe("topic", nil)
+	assert.Nil(t, err)
+	_, err = b.Publish("topic", []byte("first"))
+	assert.Nil(t, err)
+	_, err = b.Publish("topic", []byte("second"))
+	assert.Nil(t, err)
+
+	event, ok := <-sub.Events()
+	assert.True(t, ok)
+	assert.Equal(t, "first", string(event.Data))
+	_, ok = <-sub.Events()
+	assert.False(t, ok)
+}
+
+func TestMemoryBrokerClose(t *testing.T) {
+	b := broker.NewMemoryBroker(10)
+	sub, err := b.Subscribe("topic", nil)
+	assert.Nil(t, err)
+	sub.Close()
+	sub.Close()
+	_, err = b.Publish("topic", []byte("data"))
+	assert.Nil(t, err)
+	_, ok := <-sub.Events()
+	assert.False(t, ok)
+}
@@ -22,6 +22,7 @@ export default class Comment extends Component {
     render(props) {
         return <div>
         <div class={this.getStyle("mouthful_author")}>{this.props.comment.Author}
+        {this.props.comment.Staff ? <span class={this.getStyle("mouthful_staff_badge")}>Staff</span> : null}
         <span class={this.getStyle("mouthful_date")}>{formatDate(this.props.comment.CreatedAt)}</span>
         {(!this.props.comment.Confirmed && this.props.config.moderation) ? <span class={this.getStyle("mouthful_moderation")}>In queue for moderation</span> : null}
         </div>
@@ -14,6 +14,7 @@ const handleStateChange = (http, context) => {
   }
   if (http.status == 200) {
     var parsedResponse = JSON.parse(http.responseText)
+    var locked = http.getResponseHeader("X-Thread-Locked") == "true"
 
     if (parsedResponse.length > 0) {
       var forms = context.state.forms;
@@ -27,9 +28,9 @@ const handleStateChange = (http, context) => {
       parsedResponse = parsedResponse.map(x => {
         return Object.assign({}, x, { RepliesToLoad: context.state.config.pageSize })
       })
-      context.setState({ loaded: true, comments: parsedResponse, threadId: parsedResponse[0].ThreadId, forms })
+      context.setState({ loaded: true, comments: parsedResponse, threadId: parsedResponse[0].ThreadId, forms, locked })
     } else {
-      context.setState({ loaded: true, comments: [] })
+      context.setState({ loaded: true, comments: [], locked })
     }
   } else if (http.status == 404) {
     context.setState({ loaded: true, comments: [] })
@@ -58,6 +59,7 @@ export default class App extends Component {
       },
       comments: [],
       threadId: 0,
+      locked: false,
       author: cookies.get("mouthful_author") ? cookies.get("mouthful_author") : "",
       showComments: 0,
       forms: [{
@@ -157,10 +159,7 @@ export default class App extends Component {
         }
       }
     }
-    var path = window.location.pathname;
-    if (this.state.pathPrefix) {
-      path = this.state.pathPrefix + window.location.pathname;
-    }
+    var path = this.getPath();
     var bod = {
       Body: comment,
       Author: author,
@@ -170,8 +169,31 @@ export default class App extends Component {
     if (email != null) {
       bod.Email = email
     }
+    if (this.state.threadKey) {
+      bod.Thread = this.state.threadKey
+    }
+    // without an explicit site the server picks it by the origin of the page
+    if (this.state.site) {
+      bod.site = this.state.site
+    }
+    // lets the thread be named after the page, the canonical url is preferred if the page has one
+    if (document.title) {
+      bod.Title = document.title
+    }
+    var canonical = document.querySelector("link[rel=canonical]")
+    bod.URL = canonical && canonical.href ? canonical.href : window.location.href
     http.send(JSON.stringify(bod))
   }
+  getPath() {
+    if (this.state.pathPrefix) {
+      return this.state.pathPrefix + window.location.pathname;
+    }
+    // the server drops the host, unless its paths are host aware
+    if (window.location.host) {
+      return "//" + window.location.host + window.location.pathname;
+    }
+    return window.location.pathname;
+  }
   isFormVisible(id) {
     filtered = this.state.forms.filter(x=>x.id == id)
     return filtered[0].visible;
@@ -185,6 +207,8 @@ export default class App extends Component {
     this.setState({
       hostUrl: document.querySelector("#mouthful-comments").dataset.url,
       pathPrefix: prefix,
+      threadKey: document.querySelector("#mouthful-comments").dataset.thread,
+      site: document.querySelector("#mouthful-comments").dataset.site,
     })
     if (!this.state.configLoaded && this.state.hostUrl != "") {
       this.fetchConfig()
@@ -198,6 +222,9 @@ export default class App extends Component {
     var context = this;
     var http = new XMLHttpRequest();
     var url = this.state.hostUrl + "/v1/client/config";
+    if (this.state.site) {
+      url += "?site=" + encodeURIComponent(this.state.site)
+    }
     http.open("GET", url, true);
     http.onreadystatechange = function () {
       if (http.readyState != 4) {
@@ -217,11 +244,15 @@ export default class App extends Component {
     if (typeof window == "undefined") { return }
     var context = this;
     var http = new XMLHttpRequest();
-    var path = window.location.pathname;
-    if (this.state.pathPrefix) {
-      path = this.state.pathPrefix + window.location.pathname;
-    }
+    var path = this.getPath();
     var url = this.state.hostUrl + "/v1/comments?uri=" + encodeURIComponent(path);
+    // an explicit thread key keeps the comments when the page moves to another url
+    if (this.state.threadKey) {
+      url += "&thread=" + encodeURIComponent(this.state.threadKey)
+    }
+    if (this.state.site) {
+      url += "&site=" + encodeURIComponent(this.state.site)
+    }
     http.open("GET", url, true);
     http.onreadystatechange = function () {
       handleStateChange(http, context)
@@ -271,7 +302,7 @@ export default class App extends Component {
             this.refMap.set(this.state.config.commentRefPrefix + x.Id, c)
           }}>
             <Comment comment={x} config={this.state.config}/>
-            <FormWrapper comment={x} config={this.state.config} flipFormVisibility={this.flipFormVisiblity} visible={this.state.forms[this.findFormIndex(x.Id)].visible}  author={this.state.author}  replyTo={comment.Id} submitForm={this.submitForm}/>
+            {this.state.locked ? null : <FormWrapper comment={x} config={this.state.config} flipFormVisibility={this.flipFormVisiblity} visible={this.state.forms[this.findFormIndex(x.Id)].visible}  author={this.state.author}  replyTo={comment.Id} submitForm={this.submitForm}/>}
           </div>
         });
         var formIndex = this.findFormIndex(comment.Id);
@@ -279,7 +310,7 @@ export default class App extends Component {
           this.refMap.set(this.state.config.commentRefPrefix + comment.Id, c)
         }}>
           <Comment comment={comment} config={this.state.config}/>
-          <FormWrapper comment={comment} config={this.state.config} flipFormVisibility={this.flipFormVisiblity} visible={this.state.forms[this.findFormIndex(comment.Id)].visible}  author={this.state.author}  replyTo={comment.Id} submitForm={this.submitForm}/>
+          {this.state.locked ? null : <FormWrapper comment={comment} config={this.state.config} flipFormVisibility={this.flipFormVisiblity} visible={this.state.forms[this.findFormIndex(comment.Id)].visible}  author={this.state.author}  replyTo={comment.Id} submitForm={this.submitForm}/>}
           <div>
             {replies}
             {loadMoreReplies}
@@ -290,7 +321,9 @@ export default class App extends Component {
 
     return (
       <div class={this.getStyle("mouthful_wrapper")}>
-        <Form id={-1} config={this.state.config} visible={this.state.forms[this.findFormIndex(-1)].visible} author={this.state.author} comment={""} replyTo={null} submitForm={this.submitForm} />
+        {this.state.locked
+          ? <div class={this.getStyle("mouthful_locked")}>Comments are closed.</div>
+          : <Form id={-1} config={this.state.config} visible={this.state.forms[this.findFormIndex(-1)].visible} author={this.state.author} comment={""} replyTo={null} submitForm={this.submitForm} />}
         {commentDiv}
         {loadMoreComments}
       </div>
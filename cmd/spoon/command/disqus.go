@@ -36,7 +36,7 @@ func getThread(threads *[]*model.Cthread, id string) *model.Cthread {
 	return nil
 }
 
-func insertComment(comment *model.Cpost, comments *[]*model.Cpost, threads *[]*model.Cthread, database sqlxDriver.Database) error {
+func insertComment(comment *model.Cpost, comments *[]*model.Cpost, threads *[]*model.Cthread, database sqlxDriver.Database, normalizer *api.PathNormalizer) error {
 	// Insert parent if parent exists, and all its parents if needed
 	if _, ok := commentParentMap[comment.AttrDsqSpaceid]; ok {
 		if commentParentMap[comment.AttrDsqSpaceid].Parent != nil {
@@ -47,7 +47,7 @@ func insertComment(comment *model.Cpost, comments *[]*model.Cpost, threads *[]*m
 					break
 				}
 			}
-			err := insertComment(c, comments, threads, database)
+			err := insertComment(c, comments, threads, database, normalizer)
 			if err != nil {
 				panic(err)
 			}
@@ -61,7 +61,8 @@ func insertComment(comment *model.Cpost, comments *[]*model.Cpost, threads *[]*m
 			if err != nil {
 				panic(err)
 			}
-			path := api.NormalizePath(u.Path)
+			// the query strings of the disqus links are dropped, the host is kept for the host aware paths
+			path := normalizer.Normalize("//" + u.Host + u.Path)
 			author := ""
 			if comment.Cauthor.Cname.SValue != "" {
 				author = comment.Cauthor.Cname.SValue
@@ -79,7 +80,7 @@ func insertComment(comment *model.Cpost, comments *[]*model.Cpost, threads *[]*m
 			t, err := database.GetThread(path)
 			if err != nil {
 				if err == global.ErrThreadNotFound {
-					tuid, err := database.CreateThread(path)
+					tuid, err := database.CreateThread(path, nil)
 					if err != nil {
 						panic(err)
 					}
@@ -115,8 +116,14 @@ func insertComment(comment *model.Cpost, comments *[]*model.Cpost, threads *[]*m
 	return nil
 }
 
-// DisqusMigrationRun migrates the provided disqus dump to a sqlite instance of mouthful
-func DisqusMigrationRun(disqusDumpPath string) error {
+// DisqusMigrationRun migrates the provided disqus dump to a sqlite instance of mouthful.
+// The thread paths are normalized with the rules of the config at configPath, if one is given.
+func DisqusMigrationRun(disqusDumpPath string, configPath string) error {
+	normalizer, err := pathNormalizer(configPath)
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+
 	// read disqus.xml
 	contents, err := ioutil.ReadFile(disqusDumpPath)
 	if err != nil {
@@ -156,7 +163,7 @@ func DisqusMigrationRun(disqusDumpPath string) error {
 	}
 
 	for _, v := range dis.Cpost {
-		err = insertComment(v, &dis.Cpost, &dis.Cthread, *driverCasted)
+		err = insertComment(v, &dis.Cpost, &dis.Cthread, *driverCasted, normalizer)
 		if err != nil {
 			return cli.NewExitError(fmt.Sprintf("Couldn't insert disqus comment id: %v \n, Error: %v", *v.Cid, err.Error()), 1)
 		}
@@ -9,7 +9,6 @@ import (
 	uuid "github.com/gofrs/uuid"
 	"github.com/jmoiron/sqlx"
 	"github.com/urfave/cli"
-	"github.com/vkuznecovas/mouthful/api"
 	"github.com/vkuznecovas/mouthful/cmd/spoon/command/model"
 	"github.com/vkuznecovas/mouthful/db/sqlxDriver/sqlite"
 	"github.com/vkuznecovas/mouthful/global"
@@ -21,8 +20,13 @@ type commentParentMapIsso struct {
 	Parent *int
 }
 
-// IssoCommandRun takes the issoDbPath connects to it and mmigrates all the comments to a new mouthful sqlite instance
-func IssoCommandRun(issoDbPath string) error {
+// IssoCommandRun takes the issoDbPath connects to it and mmigrates all the comments to a new mouthful sqlite instance.
+// The thread paths are normalized with the rules of the config at configPath, if one is given.
+func IssoCommandRun(issoDbPath string, configPath string) error {
+	normalizer, err := pathNormalizer(configPath)
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
 	issoDB, err := sqlx.Connect("sqlite3", issoDbPath)
 	if err != nil {
 		return cli.NewExitError(fmt.Sprintf("Couldn't connect to isso db %v \n Error: %v", issoDbPath, err.Error()), 1)
@@ -40,18 +44,24 @@ func IssoCommandRun(issoDbPath string) error {
 	}
 	log.Println("Migration started")
 	commentMap := make(map[int]commentParentMapIsso)
+	// isso threads that normalize to the same path end up in a single thread
+	threadMap := make(map[string]uuid.UUID)
 	for threads.Next() {
 		var t model.Thread
 		err = threads.StructScan(&t)
 		if err != nil {
 			return cli.NewExitError(fmt.Sprintf("Couldn't select thread from isso DB %v", err.Error()), 1)
 		}
-		uri := api.NormalizePath(*t.Uri)
+		uri := normalizer.Normalize(*t.Uri)
 		log.Println("Migrating thread " + uri)
-		tuid := global.GetUUID()
-		_, err = mouthDB.Exec(mouthDB.Rebind("INSERT INTO Thread(Id,Path) VALUES(?, ?)"), tuid, uri)
-		if err != nil {
-			return cli.NewExitError(fmt.Sprintf("Couldn't insert thread into mouthful DB %v, Error: %v", t, err.Error()), 1)
+		tuid, found := threadMap[uri]
+		if !found {
+			tuid = global.GetUUID()
+			_, err = mouthDB.Exec(mouthDB.Rebind("INSERT INTO Thread(Id,Path) VALUES(?, ?)"), tuid, uri)
+			if err != nil {
+				return cli.NewExitError(fmt.Sprintf("Couldn't insert thread into mouthful DB %v, Error: %v", t, err.Error()), 1)
+			}
+			threadMap[uri] = tuid
 		}
 		comments, err := issoDB.Queryx(issoDB.Rebind("select * from comments where tid = ? order by created asc"), t.Id)
 		for comments.Next() {
@@ -0,0 +1,172 @@
+package command
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/gofrs/uuid"
+	"github.com/urfave/cli"
+	"github.com/vkuznecovas/mouthful/api"
+	"github.com/vkuznecovas/mouthful/config"
+	configModel "github.com/vkuznecovas/mouthful/config/model"
+	"github.com/vkuznecovas/mouthful/db"
+	"github.com/vkuznecovas/mouthful/db/abstraction"
+	dbModel "github.com/vkuznecovas/mouthful/db/model"
+	"github.com/vkuznecovas/mouthful/global"
+)
+
+// normalizeActor is recorded as the actor of the audit entries created by the normalize command
+const normalizeActor = "spoon normalize"
+
+// ThreadChange describes what happens to a thread when the paths get normalized
+type ThreadChange struct {
+	Thread dbModel.Thread
+	// Path is the normalized path of the thread
+	Path string
+	// Target is the thread the thread gets merged into, if the normalized path already belongs to one. Otherwise the thread is renamed
+	Target *uuid.UUID
+}
+
+// pathNormalizer creates the path normalizer configured in the config file at configPath, or a default one if no config path is given
+func pathNormalizer(configPath string) (*api.PathNormalizer, error) {
+	if configPath == "" {
+		return api.NewPathNormalizer(configModel.Paths{})
+	}
+	contents, err := ioutil.ReadFile(configPath)
+	if err != nil {
+		return nil, fmt.Errorf("Couldn't read config file %v", configPath)
+	}
+	config, err := config.ParseConfig(contents)
+	if err != nil {
+		return nil, fmt.Errorf("Couldn't parse the config file %v", err.Error())
+	}
+	return api.NewPathNormalizer(config.API.Paths)
+}
+
+// normalizeThreadPath normalizes the path of the thread within its site
+func normalizeThreadPath(normalizer *api.PathNormalizer, path string) string {
+	key, sitePath := api.SplitSitePath(path)
+	return api.SitePath(key, normalizer.Normalize(sitePath))
+}
+
+// NormalizeThreads normalizes the paths of all the threads in the database, renaming the threads whose path changes.
+// Threads that end up on the same path, or on the path of an alias, are merged into a single thread. Threads with explicit keys are left alone, and threads never move to another site.
+// If dryRun is set, the changes are only returned, not applied.
+func NormalizeThreads(database abstraction.Database, normalizer *api.PathNormalizer, dryRun bool) ([]ThreadChange, error) {
+	threads, err := database.GetAllThreads()
+	if err != nil {
+		return nil, err
+	}
+	// the threads already on their normalized path keep it, the rest go in order of path, so the runs are repeatable
+	sort.Slice(threads, func(i, j int) bool { return threads[i].Path < threads[j].Path })
+	owners := make(map[string]uuid.UUID)
+	pending := make([]dbModel.Thread, 0)
+	for _, v := range threads {
+		if _, sitePath := api.SplitSitePath(v.Path); strings.HasPrefix(sitePath, api.ThreadKeyPrefix) {
+			continue
+		}
+		if normalizeThreadPath(normalizer, v.Path) == v.Path {
+			owners[v.Path] = v.Id
+			continue
+		}
+		pending = append(pending, v)
+	}
+	changes := make([]ThreadChange, 0)
+	for _, v := range pending {
+		change := ThreadChange{Thread: v, Path: normalizeThreadPath(normalizer, v.Path)}
+		if owner, ok := owners[change.Path]; ok {
+			target := owner
+			change.Target = &target
+		} else {
+			alias, err := database.GetThreadAlias(change.Path)
+			if err == nil {
+				change.Target = &alias.ThreadId
+			} else if err != global.ErrThreadAliasNotFound {
+				return changes, err
+			}
+		}
+		// an alias of the thread itself is in the way of the rename
+		if change.Target != nil && *change.Target == v.Id {
+			change.Target = nil
+			if !dryRun {
+				err = database.DeleteThreadAlias(change.Path)
+				if err != nil {
+					return changes, err
+				}
+			}
+		}
+		if change.Target == nil {
+			owners[change.Path] = v.Id
+		}
+		changes = append(changes, change)
+		if dryRun {
+			continue
+		}
+		if change.Target != nil {
+			err = database.MergeThreads(v.Id, *change.Target)
+			if err != nil {
+				return changes, err
+			}
+			err = database.CreateAuditEntry(dbModel.AuditThreadMerge, v.Id.String(), v.Path+" -> "+change.Path+" ("+change.Target.String()+")", normalizeActor, "", v.SiteId)
+		} else {
+			err = database.RenameThread(v.Id, change.Path)
+			if err != nil {
+				return changes, err
+			}
+			err = database.CreateAuditEntry(dbModel.AuditThreadRename, v.Id.String(), v.Path+" -> "+change.Path, normalizeActor, "", v.SiteId)
+		}
+		if err != nil {
+			return changes, err
+		}
+	}
+	return changes, nil
+}
+
+// NormalizeCommandRun normalizes the thread paths in the database pointed at by the config, using the path rules of the same config
+func NormalizeCommandRun(configPath string, dryRun bool) error {
+	if _, err := os.Stat(configPath); os.IsNotExist(err) {
+		return cli.NewExitError(fmt.Sprintf("Couldn't find config file %v", configPath), 1)
+	}
+	contents, err := ioutil.ReadFile(configPath)
+	if err != nil {
+		return cli.NewExitError(fmt.Sprintf("Couldn't read config file %v", configPath), 1)
+	}
+
+	// unmarshal config
+	config, err := config.ParseConfig(contents)
+	if err != nil {
+		return cli.NewExitError(fmt.Sprintf("Couldn't parse the config file %v", err.Error()), 1)
+	}
+	normalizer, err := api.NewPathNormalizer(config.API.Paths)
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+
+	// set up db according to config
+	database, err := db.GetDBInstance(config.Database)
+	if err != nil {
+		return cli.NewExitError(fmt.Sprintf("Couldn't connect to the database %v", err.Error()), 1)
+	}
+
+	changes, err := NormalizeThreads(database, normalizer, dryRun)
+	for _, v := range changes {
+		if v.Target != nil {
+			log.Printf("Merging %v into %v (%v)\n", v.Thread.Path, v.Path, v.Target.String())
+		} else {
+			log.Printf("Renaming %v to %v\n", v.Thread.Path, v.Path)
+		}
+	}
+	if err != nil {
+		return cli.NewExitError(fmt.Sprintf("Couldn't normalize the threads %v", err.Error()), 1)
+	}
+	if dryRun {
+		log.Printf("Dry run done, %v threads would change\n", len(changes))
+		return nil
+	}
+	log.Printf("Done, %v threads changed\n", len(changes))
+	return nil
+}
@@ -0,0 +1,73 @@
+package command_test
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/vkuznecovas/mouthful/api"
+	"github.com/vkuznecovas/mouthful/cmd/spoon/command"
+	"github.com/vkuznecovas/mouthful/config/model"
+	dbModel "github.com/vkuznecovas/mouthful/db/model"
+	"github.com/vkuznecovas/mouthful/db/sqlxDriver/sqlite"
+)
+
+func TestNormalizeThreads(t *testing.T) {
+	sqlitePath := "./mouthful_normalize_test_db"
+	database, err := sqlite.CreateDatabase(model.Database{
+		Dialect:  "sqlite3",
+		Database: &sqlitePath,
+	})
+	assert.Nil(t, err)
+	defer func() { os.Remove(sqlitePath) }()
+	for _, path := range []string{"/blog/post/", "/Blog/Post/", "/blog/post/index.html", "/Other/", "/aliased/", "key:Post", "site:blog:/Blog/Post/", "site:blog:key:Post"} {
+		_, err = database.CreateComment("body", "author", path, true, nil)
+		assert.Nil(t, err)
+	}
+	aliased, err := database.GetThread("/aliased/")
+	assert.Nil(t, err)
+	err = database.CreateThreadAlias("/renamed/", aliased.Id)
+	assert.Nil(t, err)
+	_, err = database.CreateComment("body", "author", "/Renamed/", true, nil)
+	assert.Nil(t, err)
+
+	normalizer, err := api.NewPathNormalizer(model.Paths{Lowercase: true, StripIndex: true})
+	assert.Nil(t, err)
+	changes, err := command.NormalizeThreads(database, normalizer, true)
+	assert.Nil(t, err)
+	assert.Len(t, changes, 5)
+	threads, err := database.GetAllThreads()
+	assert.Nil(t, err)
+	assert.Len(t, threads, 9)
+
+	_, err = command.NormalizeThreads(database, normalizer, false)
+	assert.Nil(t, err)
+	threads, err = database.GetAllThreads()
+	assert.Nil(t, err)
+	paths := make([]string, 0, len(threads))
+	for _, v := range threads {
+		paths = append(paths, v.Path)
+	}
+	// the threads of other sites stay on their site
+	assert.ElementsMatch(t, []string{"/blog/post/", "/other/", "/aliased/", "key:Post", "site:blog:/blog/post/", "site:blog:key:Post"}, paths)
+	post, err := database.GetCommentsByThread("/blog/post/")
+	assert.Nil(t, err)
+	assert.Len(t, post, 3)
+	comments, err := database.GetCommentsByThread("/aliased/")
+	assert.Nil(t, err)
+	assert.Len(t, comments, 2)
+	entries, err := database.GetAuditEntries(dbModel.AuditQuery{})
+	assert.Nil(t, err)
+	assert.Len(t, entries, 5)
+	actions := make([]string, 0, len(entries))
+	for _, v := range entries {
+		actions = append(actions, v.Action)
+	}
+	assert.ElementsMatch(t, []string{dbModel.AuditThreadMerge, dbModel.AuditThreadMerge, dbModel.AuditThreadMerge, dbModel.AuditThreadRename, dbModel.AuditThreadRename}, actions)
+
+	// a second run has nothing left to do
+	changes, err = command.NormalizeThreads(database, normalizer, false)
+	assert.Nil(t, err)
+	assert.Len(t, changes, 0)
+}
@@ -0,0 +1,141 @@
+package command
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+
+	"github.com/urfave/cli"
+	"github.com/vkuznecovas/mouthful/api"
+	"github.com/vkuznecovas/mouthful/config"
+	"github.com/vkuznecovas/mouthful/db"
+	"github.com/vkuznecovas/mouthful/db/abstraction"
+	dbModel "github.com/vkuznecovas/mouthful/db/model"
+	"github.com/vkuznecovas/mouthful/global"
+)
+
+// tokenActor is recorded as the actor of the audit entries created by the token commands
+const tokenActor = "spoon token"
+
+// CreateToken creates an api token with the given scopes, expiring after the given amount of days, or never for 0. The token acts as the admin of the site by key, or of the whole instance if no key is given.
+// It returns the token to hand out, along with what is stored of it
+func CreateToken(database abstraction.Database, name string, scopes []string, siteKey string, expiresInDays int) (string, dbModel.APIToken, error) {
+	owner := dbModel.APIToken{Name: name, UserId: api.SpoonProvider, Provider: api.SpoonProvider}
+	if siteKey != "" {
+		sites, err := database.GetSites()
+		if err != nil {
+			return "", owner, err
+		}
+		for i := range sites {
+			if sites[i].Key == siteKey {
+				owner.SiteId = &sites[i].Id
+			}
+		}
+		if owner.SiteId == nil {
+			return "", owner, global.ErrSiteNotFound
+		}
+	}
+	value, token, err := api.NewAPIToken(owner, scopes, expiresInDays)
+	if err != nil {
+		return "", token, err
+	}
+	err = database.SaveAPIToken(token)
+	if err != nil {
+		return "", token, err
+	}
+	err = database.CreateAuditEntry(dbModel.AuditAPITokenCreate, token.Id, token.Provider+":"+token.UserId+" "+token.Name+" ("+token.Scopes+")", tokenActor, "", token.SiteId)
+	return value, token, err
+}
+
+// RevokeToken deletes the api token by id
+func RevokeToken(database abstraction.Database, id string) error {
+	token, err := database.GetAPIToken(id)
+	if err != nil {
+		return err
+	}
+	err = database.DeleteAPIToken(id)
+	if err != nil {
+		return err
+	}
+	return database.CreateAuditEntry(dbModel.AuditAPITokenRevoke, token.Id, token.Provider+":"+token.UserId+" "+token.Name, tokenActor, "", token.SiteId)
+}
+
+// tokenDatabase connects to the database pointed by the config at configPath
+func tokenDatabase(configPath string) (abstraction.Database, error) {
+	if _, err := os.Stat(configPath); os.IsNotExist(err) {
+		return nil, cli.NewExitError(fmt.Sprintf("Couldn't find config file %v", configPath), 1)
+	}
+	contents, err := ioutil.ReadFile(configPath)
+	if err != nil {
+		return nil, cli.NewExitError(fmt.Sprintf("Couldn't read config file %v", configPath), 1)
+	}
+
+	// unmarshal config
+	config, err := config.ParseConfig(contents)
+	if err != nil {
+		return nil, cli.NewExitError(fmt.Sprintf("Couldn't parse the config file %v", err.Error()), 1)
+	}
+
+	// set up db according to config
+	database, err := db.GetDBInstance(config.Database)
+	if err != nil {
+		return nil, cli.NewExitError(fmt.Sprintf("Couldn't connect to the database %v", err.Error()), 1)
+	}
+	return database, nil
+}
+
+// TokenCreateCommandRun creates an api token in the database pointed by the config at configPath, printing the token
+func TokenCreateCommandRun(configPath string, name string, scopes []string, siteKey string, expiresInDays int) error {
+	database, err := tokenDatabase(configPath)
+	if err != nil {
+		return err
+	}
+	value, token, err := CreateToken(database, name, scopes, siteKey, expiresInDays)
+	if err != nil {
+		return cli.NewExitError(fmt.Sprintf("Couldn't create the token %v", err.Error()), 1)
+	}
+	log.Printf("Created token %v with the scopes %v, it won't be shown again:\n", token.Id, token.Scopes)
+	fmt.Println(value)
+	return nil
+}
+
+// TokenListCommandRun lists the api tokens in the database pointed by the config at configPath
+func TokenListCommandRun(configPath string) error {
+	database, err := tokenDatabase(configPath)
+	if err != nil {
+		return err
+	}
+	tokens, err := database.GetAPITokens()
+	if err != nil {
+		return cli.NewExitError(fmt.Sprintf("Couldn't get the tokens %v", err.Error()), 1)
+	}
+	for _, v := range tokens {
+		expires := "never"
+		if v.ExpiresAt != nil {
+			expires = v.ExpiresAt.String()
+		}
+		lastUsed := "never"
+		if v.LastUsedAt != nil {
+			lastUsed = v.LastUsedAt.String()
+		}
+		log.Printf("%v: %q of %v:%v with the scopes %v, expires %v, last used %v\n", v.Id, v.Name, v.Provider, v.UserId, v.Scopes, expires, lastUsed)
+	}
+	return nil
+}
+
+// TokenRevokeCommandRun revokes the api tokens by id in the database pointed by the config at configPath
+func TokenRevokeCommandRun(configPath string, ids []string) error {
+	database, err := tokenDatabase(configPath)
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		err = RevokeToken(database, id)
+		if err != nil {
+			return cli.NewExitError(fmt.Sprintf("Couldn't revoke the token %v %v", id, err.Error()), 1)
+		}
+		log.Printf("Revoked %v\n", id)
+	}
+	return nil
+}
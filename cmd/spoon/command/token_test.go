@@ -0,0 +1,67 @@
+package command_test
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/vkuznecovas/mouthful/api"
+	"github.com/vkuznecovas/mouthful/cmd/spoon/command"
+	"github.com/vkuznecovas/mouthful/config/model"
+	dbModel "github.com/vkuznecovas/mouthful/db/model"
+	"github.com/vkuznecovas/mouthful/db/sqlxDriver/sqlite"
+	"github.com/vkuznecovas/mouthful/global"
+)
+
+func TestCreateAndRevokeToken(t *testing.T) {
+	sqlitePath := "./mouthful_token_test_db"
+	database, err := sqlite.CreateDatabase(model.Database{
+		Dialect:  "sqlite3",
+		Database: &sqlitePath,
+	})
+	assert.Nil(t, err)
+	defer func() { os.Remove(sqlitePath) }()
+	siteId, err := database.CreateSite(dbModel.Site{Key: "blog"})
+	assert.Nil(t, err)
+
+	_, _, err = command.CreateToken(database, "backup", []string{"moderate"}, "missing", 0)
+	assert.Equal(t, global.ErrSiteNotFound, err)
+	_, _, err = command.CreateToken(database, "backup", []string{"everything"}, "", 0)
+	assert.NotNil(t, err)
+
+	value, token, err := command.CreateToken(database, "backup", []string{"moderate", "read"}, "", 0)
+	assert.Nil(t, err)
+	assert.True(t, strings.HasPrefix(value, "mouthful_"))
+	assert.Equal(t, api.APITokenId(value), token.Id)
+	assert.Equal(t, "read,moderate", token.Scopes)
+	assert.Equal(t, api.SpoonProvider, token.Provider)
+	assert.Nil(t, token.SiteId)
+	assert.Nil(t, token.ExpiresAt)
+
+	_, siteToken, err := command.CreateToken(database, "blog moderation", []string{"moderate"}, "blog", 30)
+	assert.Nil(t, err)
+	assert.NotNil(t, siteToken.SiteId)
+	assert.Equal(t, *siteId, *siteToken.SiteId)
+	assert.NotNil(t, siteToken.ExpiresAt)
+
+	stored, err := database.GetAPIToken(token.Id)
+	assert.Nil(t, err)
+	assert.Equal(t, "backup", stored.Name)
+
+	err = command.RevokeToken(database, token.Id)
+	assert.Nil(t, err)
+	err = command.RevokeToken(database, token.Id)
+	assert.Equal(t, global.ErrAPITokenNotFound, err)
+	tokens, err := database.GetAPITokens()
+	assert.Nil(t, err)
+	assert.Len(t, tokens, 1)
+
+	entries, err := database.GetAuditEntries(dbModel.AuditQuery{})
+	assert.Nil(t, err)
+	assert.Len(t, entries, 3)
+	for _, v := range entries {
+		assert.Equal(t, "spoon token", v.Actor)
+	}
+}
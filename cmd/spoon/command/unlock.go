@@ -0,0 +1,100 @@
+package command
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+
+	"github.com/urfave/cli"
+	"github.com/vkuznecovas/mouthful/api"
+	"github.com/vkuznecovas/mouthful/config"
+	"github.com/vkuznecovas/mouthful/db"
+	"github.com/vkuznecovas/mouthful/db/abstraction"
+	dbModel "github.com/vkuznecovas/mouthful/db/model"
+	"github.com/vkuznecovas/mouthful/global"
+)
+
+// unlockActor is recorded as the actor of the audit entries created by the unlock command
+const unlockActor = "spoon unlock"
+
+// ClearLockouts forgets the failed admin logins of the clients and accounts by id, lifting their blocks and lockouts. Without any ids, the failed logins of everybody are forgotten.
+// It returns the ids that had failed logins recorded
+func ClearLockouts(database abstraction.Database, ids []string) ([]string, error) {
+	if len(ids) == 0 {
+		failures, err := database.GetLoginFailures()
+		if err != nil {
+			return nil, err
+		}
+		for _, v := range failures {
+			ids = append(ids, v.Id)
+		}
+	}
+	cleared := make([]string, 0, len(ids))
+	for _, id := range ids {
+		err := database.DeleteLoginFailure(id)
+		if err == global.ErrLoginFailureNotFound {
+			continue
+		}
+		if err != nil {
+			return cleared, err
+		}
+		cleared = append(cleared, id)
+		err = database.CreateAuditEntry(dbModel.AuditLoginUnlock, id, "", unlockActor, "", nil)
+		if err != nil {
+			return cleared, err
+		}
+	}
+	return cleared, nil
+}
+
+// UnlockCommandRun lists the failed admin logins in the database pointed by the config at configPath, or forgets the ones of the given client ips and accounts, everybody's if none are given
+func UnlockCommandRun(configPath string, ips []string, accounts []string, list bool) error {
+	if _, err := os.Stat(configPath); os.IsNotExist(err) {
+		return cli.NewExitError(fmt.Sprintf("Couldn't find config file %v", configPath), 1)
+	}
+	contents, err := ioutil.ReadFile(configPath)
+	if err != nil {
+		return cli.NewExitError(fmt.Sprintf("Couldn't read config file %v", configPath), 1)
+	}
+
+	// unmarshal config
+	config, err := config.ParseConfig(contents)
+	if err != nil {
+		return cli.NewExitError(fmt.Sprintf("Couldn't parse the config file %v", err.Error()), 1)
+	}
+
+	// set up db according to config
+	database, err := db.GetDBInstance(config.Database)
+	if err != nil {
+		return cli.NewExitError(fmt.Sprintf("Couldn't connect to the database %v", err.Error()), 1)
+	}
+
+	if list {
+		failures, err := database.GetLoginFailures()
+		if err != nil {
+			return cli.NewExitError(fmt.Sprintf("Couldn't get the failed logins %v", err.Error()), 1)
+		}
+		for _, v := range failures {
+			log.Printf("%v: %v failed logins in a row, last at %v, blocked until %v\n", v.Id, v.Failures, v.LastFailureAt, v.BlockedUntil)
+		}
+		return nil
+	}
+
+	ids := make([]string, 0, len(ips)+len(accounts))
+	for _, v := range ips {
+		ids = append(ids, api.LoginFailureIPId(v))
+	}
+	for _, v := range accounts {
+		ids = append(ids, api.LoginFailureAccountId(v))
+	}
+	cleared, err := ClearLockouts(database, ids)
+	for _, v := range cleared {
+		log.Printf("Unlocked %v\n", v)
+	}
+	if err != nil {
+		return cli.NewExitError(fmt.Sprintf("Couldn't clear the failed logins %v", err.Error()), 1)
+	}
+	log.Printf("Done, %v unlocked\n", len(cleared))
+	return nil
+}
@@ -0,0 +1,56 @@
+package command_test
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/vkuznecovas/mouthful/api"
+	"github.com/vkuznecovas/mouthful/cmd/spoon/command"
+	"github.com/vkuznecovas/mouthful/config/model"
+	dbModel "github.com/vkuznecovas/mouthful/db/model"
+	"github.com/vkuznecovas/mouthful/db/sqlxDriver/sqlite"
+	"github.com/vkuznecovas/mouthful/global"
+)
+
+func TestClearLockouts(t *testing.T) {
+	sqlitePath := "./mouthful_unlock_test_db"
+	database, err := sqlite.CreateDatabase(model.Database{
+		Dialect:  "sqlite3",
+		Database: &sqlitePath,
+	})
+	assert.Nil(t, err)
+	defer func() { os.Remove(sqlitePath) }()
+	now := time.Now().UTC()
+	ids := []string{api.LoginFailureIPId("10.0.0.1"), api.LoginFailureIPId("10.0.0.2"), api.LoginFailureAccountId("admin")}
+	for _, id := range ids {
+		err = database.SaveLoginFailure(dbModel.LoginFailure{Id: id, Failures: 10, LastFailureAt: now, BlockedUntil: now.Add(time.Hour)})
+		assert.Nil(t, err)
+	}
+
+	cleared, err := command.ClearLockouts(database, []string{api.LoginFailureIPId("10.0.0.1"), api.LoginFailureIPId("10.0.0.3")})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{api.LoginFailureIPId("10.0.0.1")}, cleared)
+	_, err = database.GetLoginFailure(api.LoginFailureIPId("10.0.0.1"))
+	assert.Equal(t, global.ErrLoginFailureNotFound, err)
+	failures, err := database.GetLoginFailures()
+	assert.Nil(t, err)
+	assert.Len(t, failures, 2)
+
+	cleared, err = command.ClearLockouts(database, nil)
+	assert.Nil(t, err)
+	assert.Len(t, cleared, 2)
+	failures, err = database.GetLoginFailures()
+	assert.Nil(t, err)
+	assert.Len(t, failures, 0)
+
+	entries, err := database.GetAuditEntries(dbModel.AuditQuery{})
+	assert.Nil(t, err)
+	assert.Len(t, entries, 3)
+	for _, v := range entries {
+		assert.Equal(t, dbModel.AuditLoginUnlock, v.Action)
+		assert.Equal(t, "spoon unlock", v.Actor)
+	}
+}
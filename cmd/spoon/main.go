@@ -57,6 +57,128 @@ func main() {
 				return command.ImportCommandRun(configPath, dumpPath)
 			},
 		},
+		{
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:   "config, c",
+					Value:  "",
+					Usage:  "path to mouthful config file",
+					EnvVar: "MOUTHFUL_CONFIG",
+				},
+				cli.BoolFlag{
+					Name:  "dry-run",
+					Usage: "only print the changes, without applying them",
+				},
+			},
+			Name:    "normalize",
+			Aliases: []string{"n"},
+			Usage:   "re-normalizes the thread paths in the database pointed by the config provided with its path rules, merging the threads that end up on the same path",
+			Action: func(c *cli.Context) error {
+				configPath := c.String("config")
+				return command.NormalizeCommandRun(configPath, c.Bool("dry-run"))
+			},
+		},
+		{
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:   "config, c",
+					Value:  "",
+					Usage:  "path to mouthful config file",
+					EnvVar: "MOUTHFUL_CONFIG",
+				},
+				cli.StringSliceFlag{
+					Name:  "ip",
+					Usage: "client ip to unlock, can be given more than once",
+				},
+				cli.StringSliceFlag{
+					Name:  "account",
+					Usage: "account to unlock, admin for the admin password or site:<key> for the admin password of a site, can be given more than once",
+				},
+				cli.BoolFlag{
+					Name:  "list",
+					Usage: "only list the clients and accounts with failed logins, without unlocking them",
+				},
+			},
+			Name:    "unlock",
+			Aliases: []string{"u"},
+			Usage:   "lifts the admin login lockouts in the database pointed by the config provided, all of them unless ips or accounts are given",
+			Action: func(c *cli.Context) error {
+				configPath := c.String("config")
+				return command.UnlockCommandRun(configPath, c.StringSlice("ip"), c.StringSlice("account"), c.Bool("list"))
+			},
+		},
+		{
+			Name:    "token",
+			Aliases: []string{"t"},
+			Usage:   "manages the api tokens the admin api can be called with",
+			Subcommands: cli.Commands{
+				{
+					Flags: []cli.Flag{
+						cli.StringFlag{
+							Name:   "config, c",
+							Value:  "",
+							Usage:  "path to mouthful config file",
+							EnvVar: "MOUTHFUL_CONFIG",
+						},
+						cli.StringFlag{
+							Name:  "name",
+							Usage: "name to tell the token apart by",
+						},
+						cli.StringSliceFlag{
+							Name:  "scope",
+							Usage: "scope to give the token, one of read, moderate and settings, can be given more than once",
+						},
+						cli.StringFlag{
+							Name:  "site",
+							Usage: "key of the site to limit the token to, the whole instance if not given",
+						},
+						cli.IntFlag{
+							Name:  "days",
+							Usage: "amount of days the token expires after, never if not given",
+						},
+					},
+					Name:  "create",
+					Usage: "creates an api token in the database pointed by the config provided, printing it",
+					Action: func(c *cli.Context) error {
+						configPath := c.String("config")
+						return command.TokenCreateCommandRun(configPath, c.String("name"), c.StringSlice("scope"), c.String("site"), c.Int("days"))
+					},
+				},
+				{
+					Flags: []cli.Flag{
+						cli.StringFlag{
+							Name:   "config, c",
+							Value:  "",
+							Usage:  "path to mouthful config file",
+							EnvVar: "MOUTHFUL_CONFIG",
+						},
+					},
+					Name:  "list",
+					Usage: "lists the api tokens in the database pointed by the config provided",
+					Action: func(c *cli.Context) error {
+						configPath := c.String("config")
+						return command.TokenListCommandRun(configPath)
+					},
+				},
+				{
+					Flags: []cli.Flag{
+						cli.StringFlag{
+							Name:   "config, c",
+							Value:  "",
+							Usage:  "path to mouthful config file",
+							EnvVar: "MOUTHFUL_CONFIG",
+						},
+					},
+					Name:      "revoke",
+					Usage:     "revokes the api tokens by id in the database pointed by the config provided",
+					ArgsUsage: "<id>...",
+					Action: func(c *cli.Context) error {
+						configPath := c.String("config")
+						return command.TokenRevokeCommandRun(configPath, c.Args())
+					},
+				},
+			},
+		},
 		{
 			Name:    "migrate",
 			Aliases: []string{"m"},
@@ -70,12 +192,18 @@ func main() {
 							Usage:  "path to disqus dump file",
 							EnvVar: "DISQUS_DUMP",
 						},
+						cli.StringFlag{
+							Name:   "config, c",
+							Value:  "",
+							Usage:  "path to mouthful config file, its path rules are used to normalize the thread paths",
+							EnvVar: "MOUTHFUL_CONFIG",
+						},
 					},
 					Name:  "disqus",
 					Usage: "imports the given dump to a mouthful sqlite instance",
 					Action: func(c *cli.Context) error {
 						dumpPath := c.String("dump")
-						return command.DisqusMigrationRun(dumpPath)
+						return command.DisqusMigrationRun(dumpPath, c.String("config"))
 					},
 				},
 				{
@@ -86,12 +214,18 @@ func main() {
 							Usage:  "path to isso sqlite file",
 							EnvVar: "ISSO_FILE",
 						},
+						cli.StringFlag{
+							Name:   "config, c",
+							Value:  "",
+							Usage:  "path to mouthful config file, its path rules are used to normalize the thread paths",
+							EnvVar: "MOUTHFUL_CONFIG",
+						},
 					},
 					Name:  "isso",
 					Usage: "imports the given isso sqlite to a mouthful sqlite instance",
 					Action: func(c *cli.Context) error {
 						issoPath := c.String("isso")
-						return command.IssoCommandRun(issoPath)
+						return command.IssoCommandRun(issoPath, c.String("config"))
 					},
 				},
 				{
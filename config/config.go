@@ -39,6 +39,7 @@ func TransformConfigToClientConfig(input *model.Config) (conf *model.ClientConfi
 		conf.MaxAuthorLength = &length
 	}
 	conf.UseDefaultStyle = input.Client.UseDefaultStyle
+	conf.Stream = input.API.Stream.Enabled
 	return conf
 }
 
@@ -60,6 +61,9 @@ func TransformToAdminConfig(input *model.Config) (conf *model.AdminConfig) {
 		DisablePasswordLogin: input.Moderation.DisablePasswordLogin,
 		OauthProviders:       &providers,
 		Path:                 path,
+		TwoFactor:            input.Moderation.TwoFactor.Enabled,
+		WebAuthn:             input.Moderation.WebAuthn.Enabled,
+		ProxyAuth:            input.Moderation.ProxyAuth.Enabled,
 	}
 	return conf
 }
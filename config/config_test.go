@@ -70,6 +70,8 @@ func TestTransformToAdminConfig_Sets_Defaults(t *testing.T) {
 	assert.Equal(t, "/", res.Path)
 	assert.False(t, res.DisablePasswordLogin)
 	assert.Len(t, *res.OauthProviders, 0)
+	assert.False(t, res.WebAuthn)
+	assert.False(t, res.ProxyAuth)
 }
 
 func TestTransformToAdminConfig_Overrides_Defaults(t *testing.T) {
@@ -89,6 +91,8 @@ func TestTransformToAdminConfig_Overrides_Defaults(t *testing.T) {
 		OAauthProviders:      providers,
 		Path:                 &path,
 		DisablePasswordLogin: true,
+		WebAuthn:             model.WebAuthn{Enabled: true, RPID: "example.com"},
+		ProxyAuth:            model.ProxyAuth{Enabled: true},
 	}
 	res := config.TransformToAdminConfig(&cfg)
 	assert.True(t, res.DisablePasswordLogin)
@@ -96,4 +100,6 @@ func TestTransformToAdminConfig_Overrides_Defaults(t *testing.T) {
 	cp := *res.OauthProviders
 	assert.Equal(t, "test", cp[0])
 	assert.Equal(t, path, res.Path)
+	assert.True(t, res.WebAuthn)
+	assert.True(t, res.ProxyAuth)
 }
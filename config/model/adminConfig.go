@@ -5,4 +5,8 @@ type AdminConfig struct {
 	DisablePasswordLogin bool      `json:"disablePasswordLogin"`
 	OauthProviders       *[]string `json:"oauthProviders,omitempty"`
 	Path                 string    `json:"path"`
+	TwoFactor            bool      `json:"twoFactor"`
+	WebAuthn             bool      `json:"webauthn"`
+	ProxyAuth            bool      `json:"proxyAuth"`
+	CSRFToken            string    `json:"csrfToken,omitempty"`
 }
@@ -9,4 +9,5 @@ type ClientConfig struct {
 	UseDefaultStyle  bool `json:"useDefaultStyle"`
 	Moderation       bool `json:"moderation"`
 	PageSize         int  `json:"pageSize"`
+	Stream           bool `json:"stream"`
 }
@@ -15,6 +15,8 @@ type Database struct {
 	DynamoDBCommentWriteUnits *int64  `json:"dynamoDBCommentWriteUnits,omitempty"`
 	DynamoDBIndexWriteUnits   *int64  `json:"dynamoDBIndexWriteUnits,omitempty"`
 	DynamoDBIndexReadUnits    *int64  `json:"dynamoDBIndexReadUnits,omitempty"`
+	DynamoDBAuditReadUnits    *int64  `json:"dynamoDBAuditReadUnits,omitempty"`
+	DynamoDBAuditWriteUnits   *int64  `json:"dynamoDBAuditWriteUnits,omitempty"`
 	DynamoDBEndpoint          *string `json:"dynamoDBEndpoint,omitempty"`
 	AwsAccessKeyID            *string `json:"awsAccessKeyID,omitempty"`
 	AwsSecretAccessKey        *string `json:"awsSecretAccessKey,omitempty"`
@@ -28,12 +30,67 @@ type Moderation struct {
 	AdminPassword          string           `json:"adminPassword"`
 	DisablePasswordLogin   bool             `json:"disablePasswordLogin"`
 	SessionDurationSeconds int              `json:"sessionDurationSeconds"`
+	SessionSecret          string           `json:"sessionSecret"`
+	OldSessionSecrets      *[]string        `json:"oldSessionSecrets,omitempty"`
+	ServerSideSessions     bool             `json:"serverSideSessions"`
+	SessionCookie          SessionCookie    `json:"sessionCookie"`
+	AdminOrigins           *[]string        `json:"adminOrigins,omitempty"`
+	LoginThrottling        LoginThrottling  `json:"loginThrottling"`
+	TwoFactor              TwoFactor        `json:"twoFactor"`
+	WebAuthn               WebAuthn         `json:"webauthn"`
+	ProxyAuth              ProxyAuth        `json:"proxyAuth"`
 	MaxCommentLength       *int             `json:"maxCommentLength,omitempty"`
 	MaxAuthorLength        *int             `json:"maxAuthorLength,omitempty"`
 	Path                   *string          `json:"path,omitempty"`
 	OAauthProviders        *[]OauthProvider `json:"oauthProviders,omitempty"`
 	OAuthCallbackOrigin    *string          `json:"oauthCallbackOrigin,omitempty"`
 	PeriodicCleanUp        *PeriodicCleanUp `json:"periodicCleanup,omitempty"`
+	MaxPinsPerThread       *int             `json:"maxPinsPerThread,omitempty"`
+	StaffNames             *[]string        `json:"staffNames,omitempty"`
+	AutoCloseAfterDays     *int             `json:"autoCloseAfterDays,omitempty"`
+}
+
+// SessionCookie represents the attributes of the admin session cookie
+type SessionCookie struct {
+	SameSite string `json:"sameSite"`
+	Secure   bool   `json:"secure"`
+}
+
+// LoginThrottling represents how the failed admin logins are throttled. Each failure blocks the logins of the client and the account for a while, doubling the delay with every failure in a row,
+// and too many failures in a row lock them out. Unset values fall back to the defaults
+type LoginThrottling struct {
+	BaseDelaySeconds *int `json:"baseDelaySeconds,omitempty"`
+	MaxDelaySeconds  *int `json:"maxDelaySeconds,omitempty"`
+	MaxFailures      *int `json:"maxFailures,omitempty"`
+	LockoutSeconds   *int `json:"lockoutSeconds,omitempty"`
+}
+
+// TwoFactor represents the totp two-factor authentication of the admin password logins. The secrets are stored in the database encrypted with EncryptionKey.
+// Issuer is the name the accounts show up under in the authenticator apps
+type TwoFactor struct {
+	Enabled       bool   `json:"enabled"`
+	EncryptionKey string `json:"encryptionKey"`
+	Issuer        string `json:"issuer,omitempty"`
+}
+
+// WebAuthn represents the passkey and security key logins of the admins. RPID is the domain the credentials are bound to, the host of the admin panel or a parent domain of it.
+// RPName is the name the relying party shows up under in the passkey prompts
+type WebAuthn struct {
+	Enabled bool   `json:"enabled"`
+	RPID    string `json:"rpId"`
+	RPName  string `json:"rpName,omitempty"`
+}
+
+// ProxyAuth represents the admin logins through a single sign-on proxy in front of mouthful. The user the proxy sends in UserHeader is logged in as an admin if it's one of AdminUsers,
+// or one of the comma separated groups in GroupsHeader is one of AdminGroups. The headers are only trusted on the requests coming straight from one of the TrustedProxies, given as ips or cidr ranges
+type ProxyAuth struct {
+	Enabled        bool      `json:"enabled"`
+	UserHeader     string    `json:"userHeader,omitempty"`
+	NameHeader     string    `json:"nameHeader,omitempty"`
+	GroupsHeader   string    `json:"groupsHeader,omitempty"`
+	TrustedProxies *[]string `json:"trustedProxies,omitempty"`
+	AdminUsers     *[]string `json:"adminUsers,omitempty"`
+	AdminGroups    *[]string `json:"adminGroups,omitempty"`
 }
 
 // Config - root of our config
@@ -59,13 +116,26 @@ type Webhook struct {
 
 // API - api configuration part
 type API struct {
-	Port         *int         `json:"port,omitempty"`
-	BindAddress  *string      `json:"bindAddress,omitempty"`
-	Debug        bool         `json:"debug"`
-	Cache        Cache        `json:"cache"`
-	RateLimiting RateLimiting `json:"rateLimiting"`
-	Cors         Cors         `json:"cors"`
-	Logging      bool         `json:"logging"`
+	Port            *int            `json:"port,omitempty"`
+	BindAddress     *string         `json:"bindAddress,omitempty"`
+	Debug           bool            `json:"debug"`
+	Cache           Cache           `json:"cache"`
+	RateLimiting    RateLimiting    `json:"rateLimiting"`
+	Cors            Cors            `json:"cors"`
+	Logging         bool            `json:"logging"`
+	Stream          Stream          `json:"stream"`
+	Render          Render          `json:"render"`
+	CacheHeaders    CacheHeaders    `json:"cacheHeaders"`
+	Compression     Compression     `json:"compression"`
+	Paths           Paths           `json:"paths"`
+	Verification    Verification    `json:"verification"`
+	SecurityHeaders SecurityHeaders `json:"securityHeaders"`
+	TLS             TLS             `json:"tls"`
+	UnixSocket      UnixSocket      `json:"unixSocket"`
+	// TrustedProxies are the ips or cidr ranges of the proxies whose X-Forwarded-For and X-Real-IP headers give the address of the client. None are trusted by default
+	TrustedProxies *[]string `json:"trustedProxies,omitempty"`
+	// ShutdownTimeoutInSeconds is how long the requests in flight get to finish on shutdown
+	ShutdownTimeoutInSeconds *int `json:"shutdownTimeoutInSeconds,omitempty"`
 }
 
 // Client - client configuration part
@@ -87,6 +157,96 @@ type Cache struct {
 	IntervalInSeconds int  `json:"entervalInSeconds"`
 }
 
+// Stream represents the settings for live comment updates via server-sent events
+type Stream struct {
+	Enabled             bool `json:"enabled"`
+	HeartbeatSeconds    int  `json:"heartbeatSeconds"`
+	MaxConnectionsPerIP int  `json:"maxConnectionsPerIP"`
+	BufferSize          int  `json:"bufferSize"`
+}
+
+// Render represents the settings for server-side rendering of comment threads
+type Render struct {
+	Enabled           bool    `json:"enabled"`
+	TemplateDirectory *string `json:"templateDirectory,omitempty"`
+	PublicURL         *string `json:"publicURL,omitempty"`
+}
+
+// CacheHeaders represents the http caching headers sent with the comments, useful when running mouthful behind a CDN
+type CacheHeaders struct {
+	CacheControl  *string `json:"cacheControl,omitempty"`
+	SurrogateKeys bool    `json:"surrogateKeys"`
+}
+
+// Compression represents the settings for gzip and brotli compression of the responses
+type Compression struct {
+	Enabled       bool `json:"enabled"`
+	GzipLevel     *int `json:"gzipLevel,omitempty"`
+	BrotliQuality *int `json:"brotliQuality,omitempty"`
+}
+
+// SecurityHeaders represents the overrides of the security headers sent with every response. Leaving a setting out keeps the strict default, setting a header to an empty string leaves it out
+type SecurityHeaders struct {
+	ContentSecurityPolicy      *string   `json:"contentSecurityPolicy,omitempty"`
+	EmbedContentSecurityPolicy *string   `json:"embedContentSecurityPolicy,omitempty"`
+	FrameAncestors             *[]string `json:"frameAncestors,omitempty"`
+	ReferrerPolicy             *string   `json:"referrerPolicy,omitempty"`
+	PermissionsPolicy          *string   `json:"permissionsPolicy,omitempty"`
+	HSTSMaxAgeInSeconds        *int      `json:"hstsMaxAgeInSeconds,omitempty"`
+	HSTSIncludeSubdomains      bool      `json:"hstsIncludeSubdomains"`
+}
+
+// TLS represents the settings for serving mouthful over https, either with the given certificate files or with certificates obtained through ACME
+type TLS struct {
+	Enabled  bool    `json:"enabled"`
+	CertFile *string `json:"certFile,omitempty"`
+	KeyFile  *string `json:"keyFile,omitempty"`
+	// RedirectAddress is the address of the plain http listener redirecting to https. It answers the ACME http-01 challenges as well
+	RedirectAddress *string `json:"redirectAddress,omitempty"`
+	ACME            ACME    `json:"acme"`
+}
+
+// ACME represents the settings for obtaining and renewing the certificates automatically from an ACME directory, such as Let's Encrypt
+type ACME struct {
+	Enabled              bool      `json:"enabled"`
+	Domains              *[]string `json:"domains,omitempty"`
+	Email                *string   `json:"email,omitempty"`
+	CacheDirectory       *string   `json:"cacheDirectory,omitempty"`
+	DirectoryURL         *string   `json:"directoryURL,omitempty"`
+	AcceptTermsOfService bool      `json:"acceptTermsOfService"`
+}
+
+// UnixSocket represents the permissions of the unix socket the api listens on when the bind address is unix:/path/to.sock
+type UnixSocket struct {
+	Mode  *string `json:"mode,omitempty"`
+	Owner *string `json:"owner,omitempty"`
+	Group *string `json:"group,omitempty"`
+}
+
+// Paths represents the rules used to normalize the page paths that identify the threads, so that different spellings of the same page share a thread
+type Paths struct {
+	StripQuery    bool           `json:"stripQuery"`
+	StripFragment bool           `json:"stripFragment"`
+	Lowercase     bool           `json:"lowercase"`
+	StripIndex    bool           `json:"stripIndex"`
+	HostAware     bool           `json:"hostAware"`
+	Rewrites      *[]PathRewrite `json:"rewrites,omitempty"`
+}
+
+// PathRewrite represents a regular expression replacement applied to the normalized paths
+type PathRewrite struct {
+	Pattern     string `json:"pattern"`
+	Replacement string `json:"replacement"`
+}
+
+// Verification represents the checks the posted comments have to pass, limiting where they can be posted from and which paths can get new threads
+type Verification struct {
+	CheckOrigin    bool      `json:"checkOrigin"`
+	AllowedOrigins *[]string `json:"allowedOrigins,omitempty"`
+	PathPatterns   *[]string `json:"pathPatterns,omitempty"`
+	SitemapFile    *string   `json:"sitemapFile,omitempty"`
+}
+
 // Cors represents the cross origin resource sharing settings
 type Cors struct {
 	Enabled        bool      `json:"enabled"`
@@ -10,19 +10,67 @@ import (
 // Database is a database instance for your selected DB
 type Database interface {
 	InitializeDatabase() error
-	CreateThread(path string) (*uuid.UUID, error)
+	CreateThread(path string, siteId *uuid.UUID) (*uuid.UUID, error)
 	GetThread(path string) (thread model.Thread, err error)
+	GetThreadById(id uuid.UUID) (thread model.Thread, err error)
+	SetThreadState(id uuid.UUID, state model.ThreadState) error
+	UpdateThread(thread model.Thread) error
+	RenameThread(id uuid.UUID, path string) error
+	MergeThreads(sourceId uuid.UUID, targetId uuid.UUID) error
+	DeleteThread(id uuid.UUID) error
+	CreateThreadAlias(path string, threadId uuid.UUID) error
+	GetThreadAlias(path string) (model.ThreadAlias, error)
+	GetAllThreadAliases() ([]model.ThreadAlias, error)
+	GetSiteThreadAliases(siteId uuid.UUID) ([]model.ThreadAlias, error)
+	DeleteThreadAlias(path string) error
 	CreateComment(body string, author string, path string, confirmed bool, replyTo *uuid.UUID) (*uuid.UUID, error)
+	CreateStaffComment(body string, author string, path string, replyTo *uuid.UUID) (*uuid.UUID, error)
 	GetCommentsByThread(path string) ([]model.Comment, error)
+	QueryCommentsByThread(path string, query model.CommentQuery) ([]model.Comment, error)
 	UpdateComment(id uuid.UUID, body, author string, confirmed bool) error
 	DeleteComment(id uuid.UUID) error
 	RestoreDeletedComment(id uuid.UUID) error
+	SetCommentPinned(id uuid.UUID, pinned bool) error
+	SetCommentFeatured(id uuid.UUID, featured bool) error
 	GetComment(id uuid.UUID) (model.Comment, error)
 	GetAllThreads() ([]model.Thread, error)
+	GetSiteThreads(siteId uuid.UUID) ([]model.Thread, error)
+	GetThreadStats() ([]model.ThreadStats, error)
+	GetSiteThreadStats(siteId uuid.UUID) ([]model.ThreadStats, error)
 	GetAllComments() ([]model.Comment, error)
+	GetSiteComments(siteId uuid.UUID) ([]model.Comment, error)
 	GetDatabaseDialect() string
 	GetUnderlyingStruct() interface{}
+	Close() error
 	CleanUpStaleData(target global.CleanupType, timeout int64) error
 	HardDeleteComment(commentId uuid.UUID) error
 	ImportData(pathToDump string) error
+	CreateAuditEntry(action string, subject string, details string, actor string, ip string, siteId *uuid.UUID) error
+	GetAuditEntries(query model.AuditQuery) ([]model.AuditEntry, error)
+	CreateSite(site model.Site) (*uuid.UUID, error)
+	GetSites() ([]model.Site, error)
+	GetSite(id uuid.UUID) (model.Site, error)
+	UpdateSite(site model.Site) error
+	DeleteSite(id uuid.UUID) error
+	CreateAdminSession(session model.AdminSession) error
+	GetAdminSession(id uuid.UUID) (model.AdminSession, error)
+	GetAdminSessions() ([]model.AdminSession, error)
+	DeleteAdminSession(id uuid.UUID) error
+	DeleteExpiredAdminSessions() error
+	SaveLoginFailure(failure model.LoginFailure) error
+	GetLoginFailure(id string) (model.LoginFailure, error)
+	GetLoginFailures() ([]model.LoginFailure, error)
+	DeleteLoginFailure(id string) error
+	DeleteLoginFailures() error
+	SaveTwoFactor(twoFactor model.TwoFactor) error
+	GetTwoFactor(id string) (model.TwoFactor, error)
+	DeleteTwoFactor(id string) error
+	SaveWebAuthnCredential(credential model.WebAuthnCredential) error
+	GetWebAuthnCredential(id string) (model.WebAuthnCredential, error)
+	GetWebAuthnCredentials() ([]model.WebAuthnCredential, error)
+	DeleteWebAuthnCredential(id string) error
+	SaveAPIToken(token model.APIToken) error
+	GetAPIToken(id string) (model.APIToken, error)
+	GetAPITokens() ([]model.APIToken, error)
+	DeleteAPIToken(id string) error
 }
@@ -10,6 +10,7 @@ import (
 	"github.com/vkuznecovas/mouthful/config/model"
 	"github.com/vkuznecovas/mouthful/db/abstraction"
 	dynamoModel "github.com/vkuznecovas/mouthful/db/dynamodb/model"
+	dbModel "github.com/vkuznecovas/mouthful/db/model"
 	"github.com/vkuznecovas/mouthful/global"
 )
 
@@ -128,7 +129,7 @@ func CreateTestDatabase() abstraction.Database {
 	return database
 }
 
-// WipeOutData deletes all the threads and comments in the database if the database is a test one
+// WipeOutData deletes all the threads, aliases, comments, audit entries, sites, admin sessions, login failures, second factors and webauthn credentials in the database if the database is a test one
 func (d *Database) WipeOutData() error {
 	if !d.IsTest {
 		return nil
@@ -155,10 +156,89 @@ func (d *Database) WipeOutData() error {
 			return err
 		}
 	}
+	var aliases []dbModel.ThreadAlias
+	err = d.DB.Table(d.TablePrefix + global.DefaultDynamoDbThreadAliasTableName).Scan().All(&aliases)
+	if err != nil {
+		return err
+	}
+	for _, v := range aliases {
+		err := d.DB.Table(d.TablePrefix+global.DefaultDynamoDbThreadAliasTableName).Delete("Path", v.Path).Run()
+		if err != nil {
+			return err
+		}
+	}
+	var entries []dbModel.AuditEntry
+	err = d.DB.Table(d.TablePrefix + global.DefaultDynamoDbAuditTableName).Scan().All(&entries)
+	if err != nil {
+		return err
+	}
+	for _, v := range entries {
+		err := d.DB.Table(d.TablePrefix+global.DefaultDynamoDbAuditTableName).Delete("ID", v.Id).Run()
+		if err != nil {
+			return err
+		}
+	}
+	var sites []dbModel.Site
+	err = d.DB.Table(d.TablePrefix + global.DefaultDynamoDbSiteTableName).Scan().All(&sites)
+	if err != nil {
+		return err
+	}
+	for _, v := range sites {
+		err := d.DB.Table(d.TablePrefix+global.DefaultDynamoDbSiteTableName).Delete("ID", v.Id).Run()
+		if err != nil {
+			return err
+		}
+	}
+	var sessions []dbModel.AdminSession
+	err = d.DB.Table(d.TablePrefix + global.DefaultDynamoDbAdminSessionTableName).Scan().All(&sessions)
+	if err != nil {
+		return err
+	}
+	for _, v := range sessions {
+		err := d.DB.Table(d.TablePrefix+global.DefaultDynamoDbAdminSessionTableName).Delete("ID", v.Id).Run()
+		if err != nil {
+			return err
+		}
+	}
+	err = d.DeleteLoginFailures()
+	if err != nil {
+		return err
+	}
+	var twoFactors []dbModel.TwoFactor
+	err = d.DB.Table(d.TablePrefix + global.DefaultDynamoDbTwoFactorTableName).Scan().All(&twoFactors)
+	if err != nil {
+		return err
+	}
+	for _, v := range twoFactors {
+		err := d.DB.Table(d.TablePrefix+global.DefaultDynamoDbTwoFactorTableName).Delete("ID", v.Id).Run()
+		if err != nil {
+			return err
+		}
+	}
+	credentials, err := d.GetWebAuthnCredentials()
+	if err != nil {
+		return err
+	}
+	for _, v := range credentials {
+		err := d.DB.Table(d.TablePrefix+global.DefaultDynamoDbWebAuthnCredentialTableName).Delete("ID", v.Id).Run()
+		if err != nil {
+			return err
+		}
+	}
+	tokens, err := d.GetAPITokens()
+	if err != nil {
+		return err
+	}
+	for _, v := range tokens {
+		err := d.DB.Table(d.TablePrefix+global.DefaultDynamoDbAPITokenTableName).Delete("ID", v.Id).Run()
+		if err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
-// DeleteTables deletes the thread and comment tables in the database if the database is a test one
+// DeleteTables deletes the thread, alias, comment, audit, site, admin session, login failure, two-factor, webauthn credential and api token tables in the database if the database is a test one
 func (d *Database) DeleteTables() error {
 	if !d.IsTest {
 		return nil
@@ -171,5 +251,37 @@ func (d *Database) DeleteTables() error {
 	if err != nil {
 		return err
 	}
+	err = d.DB.Table(d.TablePrefix + global.DefaultDynamoDbAuditTableName).DeleteTable().Run()
+	if err != nil {
+		return err
+	}
+	err = d.DB.Table(d.TablePrefix + global.DefaultDynamoDbThreadAliasTableName).DeleteTable().Run()
+	if err != nil {
+		return err
+	}
+	err = d.DB.Table(d.TablePrefix + global.DefaultDynamoDbSiteTableName).DeleteTable().Run()
+	if err != nil {
+		return err
+	}
+	err = d.DB.Table(d.TablePrefix + global.DefaultDynamoDbAdminSessionTableName).DeleteTable().Run()
+	if err != nil {
+		return err
+	}
+	err = d.DB.Table(d.TablePrefix + global.DefaultDynamoDbLoginFailureTableName).DeleteTable().Run()
+	if err != nil {
+		return err
+	}
+	err = d.DB.Table(d.TablePrefix + global.DefaultDynamoDbTwoFactorTableName).DeleteTable().Run()
+	if err != nil {
+		return err
+	}
+	err = d.DB.Table(d.TablePrefix + global.DefaultDynamoDbWebAuthnCredentialTableName).DeleteTable().Run()
+	if err != nil {
+		return err
+	}
+	err = d.DB.Table(d.TablePrefix + global.DefaultDynamoDbAPITokenTableName).DeleteTable().Run()
+	if err != nil {
+		return err
+	}
 	return nil
 }
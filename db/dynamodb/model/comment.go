@@ -20,6 +20,9 @@ type Comment struct {
 	CreatedAt time.Time `dynamo:"CreatedAt"`
 	DeletedAt *int64    `dynamo:"DeletedAt,omitempty"`
 	ReplyTo   *string   `dynamo:"ReplyTo,omitempty"`
+	Pinned    bool      `dynamo:"Pinned"`
+	Featured  bool      `dynamo:"Featured"`
+	Staff     bool      `dynamo:"Staff"`
 }
 
 // ToComment converts dynamoDb comment object to mouthful comment
@@ -47,6 +50,9 @@ func (c *Comment) ToComment() (model.Comment, error) {
 		CreatedAt: c.CreatedAt,
 		DeletedAt: deletedAt,
 		ReplyTo:   replyTo,
+		Pinned:    c.Pinned,
+		Featured:  c.Featured,
+		Staff:     c.Staff,
 	}, nil
 }
 
@@ -58,6 +64,9 @@ func (c *Comment) FromComment(input model.Comment) {
 	c.Body = input.Body
 	c.Confirmed = input.Confirmed
 	c.CreatedAt = input.CreatedAt
+	c.Pinned = input.Pinned
+	c.Featured = input.Featured
+	c.Staff = input.Staff
 	if input.DeletedAt != nil {
 		da := input.DeletedAt.UnixNano()
 		c.DeletedAt = &da
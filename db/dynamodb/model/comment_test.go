@@ -24,6 +24,9 @@ func TestCommentConversion(t *testing.T) {
 		CreatedAt: ca,
 		DeletedAt: &da,
 		ReplyTo:   &rt,
+		Pinned:    true,
+		Featured:  true,
+		Staff:     true,
 	}
 	dynamoComment := dynamoModel.Comment{}
 	dynamoComment.FromComment(inputMouthfulComment)
@@ -37,4 +40,7 @@ func TestCommentConversion(t *testing.T) {
 	assert.Equal(t, inputMouthfulComment.CreatedAt, comment.CreatedAt)
 	assert.Equal(t, inputMouthfulComment.DeletedAt, comment.DeletedAt)
 	assert.Equal(t, inputMouthfulComment.ReplyTo, comment.ReplyTo)
+	assert.Equal(t, inputMouthfulComment.Pinned, comment.Pinned)
+	assert.Equal(t, inputMouthfulComment.Featured, comment.Featured)
+	assert.Equal(t, inputMouthfulComment.Staff, comment.Staff)
 }
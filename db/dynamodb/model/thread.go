@@ -15,17 +15,49 @@ type Thread struct {
 	Id        uuid.UUID `dynamo:"ID"`
 	Path      string    `dynamo:"Path,hash"`
 	CreatedAt time.Time `dynamo:"CreatedAt"`
+	State     string    `dynamo:"State,omitempty"`
+	Title     string    `dynamo:"Title,omitempty"`
+	URL       string    `dynamo:"URL,omitempty"`
+	// Moderation and MaxCommentLength are only stored if they override the global settings
+	Moderation       *bool `dynamo:"Moderation,omitempty"`
+	MaxCommentLength *int  `dynamo:"MaxCommentLength,omitempty"`
+	// SiteId is only stored for the threads that do not belong to the default site
+	SiteId *uuid.UUID `dynamo:"SiteId,omitempty"`
 }
 
 // ToThread converts dynamodb thread to mouthful thread
 func (t *Thread) ToThread() model.Thread {
+	// threads created by older versions have no state
+	state := model.ThreadState(t.State)
+	if !state.IsValid() {
+		state = model.ThreadOpen
+	}
 	return model.Thread{
-		Id:        t.Id,
-		Path:      t.Path,
-		CreatedAt: t.CreatedAt,
+		Id:               t.Id,
+		Path:             t.Path,
+		CreatedAt:        t.CreatedAt,
+		State:            state,
+		Title:            t.Title,
+		URL:              t.URL,
+		Moderation:       t.Moderation,
+		MaxCommentLength: t.MaxCommentLength,
+		SiteId:           t.SiteId,
 	}
 }
 
+// FromThread converts mouthful thread to dynamodb thread
+func (t *Thread) FromThread(thread model.Thread) {
+	t.Id = thread.Id
+	t.Path = thread.Path
+	t.CreatedAt = thread.CreatedAt
+	t.State = string(thread.State)
+	t.Title = thread.Title
+	t.URL = thread.URL
+	t.Moderation = thread.Moderation
+	t.MaxCommentLength = thread.MaxCommentLength
+	t.SiteId = thread.SiteId
+}
+
 // ThreadSlice represents a collection of threads
 type ThreadSlice []Thread
 
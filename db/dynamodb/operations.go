@@ -9,6 +9,8 @@ import (
 	"strings"
 	"time"
 
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
 	"github.com/guregu/dynamo"
 
 	"github.com/gofrs/uuid"
@@ -20,14 +22,38 @@ import (
 
 // InitializeDatabase runs the queries for an initial database seed
 func (db *Database) InitializeDatabase() error {
-	tables := [...]string{global.DefaultDynamoDbThreadTableName, global.DefaultDynamoDbCommentTableName}
+	tables := [...]string{global.DefaultDynamoDbThreadTableName, global.DefaultDynamoDbCommentTableName, global.DefaultDynamoDbAuditTableName, global.DefaultDynamoDbThreadAliasTableName, global.DefaultDynamoDbSiteTableName, global.DefaultDynamoDbAdminSessionTableName, global.DefaultDynamoDbLoginFailureTableName, global.DefaultDynamoDbTwoFactorTableName, global.DefaultDynamoDbWebAuthnCredentialTableName, global.DefaultDynamoDbAPITokenTableName}
 	tableModelMap := map[string]interface{}{
-		global.DefaultDynamoDbThreadTableName:  dynamoModel.Thread{},
-		global.DefaultDynamoDbCommentTableName: dynamoModel.Comment{},
+		global.DefaultDynamoDbThreadTableName:             dynamoModel.Thread{},
+		global.DefaultDynamoDbCommentTableName:            dynamoModel.Comment{},
+		global.DefaultDynamoDbAuditTableName:              model.AuditEntry{},
+		global.DefaultDynamoDbThreadAliasTableName:        model.ThreadAlias{},
+		global.DefaultDynamoDbSiteTableName:               model.Site{},
+		global.DefaultDynamoDbAdminSessionTableName:       model.AdminSession{},
+		global.DefaultDynamoDbLoginFailureTableName:       model.LoginFailure{},
+		global.DefaultDynamoDbTwoFactorTableName:          model.TwoFactor{},
+		global.DefaultDynamoDbWebAuthnCredentialTableName: model.WebAuthnCredential{},
+		global.DefaultDynamoDbAPITokenTableName:           model.APIToken{},
+	}
+	auditReadUnits := global.DefaultDynamoDbAuditUnits
+	if db.Config.DynamoDBAuditReadUnits != nil {
+		auditReadUnits = *db.Config.DynamoDBAuditReadUnits
+	}
+	auditWriteUnits := global.DefaultDynamoDbAuditUnits
+	if db.Config.DynamoDBAuditWriteUnits != nil {
+		auditWriteUnits = *db.Config.DynamoDBAuditWriteUnits
 	}
 	tableUnitsMap := map[string][2]int64{
-		global.DefaultDynamoDbThreadTableName:  [...]int64{*db.Config.DynamoDBThreadReadUnits, *db.Config.DynamoDBThreadWriteUnits},
-		global.DefaultDynamoDbCommentTableName: [...]int64{*db.Config.DynamoDBCommentReadUnits, *db.Config.DynamoDBCommentWriteUnits},
+		global.DefaultDynamoDbThreadTableName:             [...]int64{*db.Config.DynamoDBThreadReadUnits, *db.Config.DynamoDBThreadWriteUnits},
+		global.DefaultDynamoDbCommentTableName:            [...]int64{*db.Config.DynamoDBCommentReadUnits, *db.Config.DynamoDBCommentWriteUnits},
+		global.DefaultDynamoDbAuditTableName:              [...]int64{auditReadUnits, auditWriteUnits},
+		global.DefaultDynamoDbThreadAliasTableName:        [...]int64{*db.Config.DynamoDBThreadReadUnits, *db.Config.DynamoDBThreadWriteUnits},
+		global.DefaultDynamoDbSiteTableName:               [...]int64{*db.Config.DynamoDBThreadReadUnits, *db.Config.DynamoDBThreadWriteUnits},
+		global.DefaultDynamoDbAdminSessionTableName:       [...]int64{auditReadUnits, auditWriteUnits},
+		global.DefaultDynamoDbLoginFailureTableName:       [...]int64{auditReadUnits, auditWriteUnits},
+		global.DefaultDynamoDbTwoFactorTableName:          [...]int64{auditReadUnits, auditWriteUnits},
+		global.DefaultDynamoDbWebAuthnCredentialTableName: [...]int64{auditReadUnits, auditWriteUnits},
+		global.DefaultDynamoDbAPITokenTableName:           [...]int64{auditReadUnits, auditWriteUnits},
 	}
 	prefix := ""
 	if db.Config.TablePrefix != nil {
@@ -100,7 +126,7 @@ func (db *Database) InitializeDatabase() error {
 }
 
 // CreateThread takes the thread path and creates it in the database
-func (db *Database) CreateThread(path string) (*uuid.UUID, error) {
+func (db *Database) CreateThread(path string, siteId *uuid.UUID) (*uuid.UUID, error) {
 	thread, err := db.GetThread(path)
 	if err != nil {
 		if err == global.ErrThreadNotFound {
@@ -109,6 +135,8 @@ func (db *Database) CreateThread(path string) (*uuid.UUID, error) {
 				Id:        uid,
 				Path:      path,
 				CreatedAt: time.Now(),
+				State:     string(model.ThreadOpen),
+				SiteId:    siteId,
 			}).Run()
 			return &uid, err
 		}
@@ -132,16 +160,39 @@ func (db *Database) GetThread(path string) (thread model.Thread, err error) {
 	return result.ToThread(), err
 }
 
+// GetThreadById takes the thread id and fetches it from the database
+func (db *Database) GetThreadById(id uuid.UUID) (thread model.Thread, err error) {
+	var result []dynamoModel.Thread
+	// threads are keyed by path, so we have to scan for the id
+	err = db.DB.Table(db.TablePrefix+global.DefaultDynamoDbThreadTableName).Scan().Filter("'ID' = ?", id).All(&result)
+	if err != nil {
+		return thread, err
+	}
+	if len(result) == 0 {
+		return thread, global.ErrThreadNotFound
+	}
+	return result[0].ToThread(), nil
+}
+
 // CreateComment takes in a body, author, and path and creates a comment for the given thread. If thread does not exist, it creates one
 func (db *Database) CreateComment(body string, author string, path string, confirmed bool, replyTo *uuid.UUID) (*uuid.UUID, error) {
+	return db.createComment(body, author, path, confirmed, false, replyTo)
+}
+
+// CreateStaffComment creates a confirmed comment marked as posted by the site staff. If thread does not exist, it creates one
+func (db *Database) CreateStaffComment(body string, author string, path string, replyTo *uuid.UUID) (*uuid.UUID, error) {
+	return db.createComment(body, author, path, true, true, replyTo)
+}
+
+func (db *Database) createComment(body string, author string, path string, confirmed bool, staff bool, replyTo *uuid.UUID) (*uuid.UUID, error) {
 	thread, err := db.GetThread(path)
 	if err != nil {
 		if err == global.ErrThreadNotFound {
-			_, err := db.CreateThread(path)
+			_, err := db.CreateThread(path, nil)
 			if err != nil {
 				return nil, err
 			}
-			return db.CreateComment(body, author, path, confirmed, replyTo)
+			return db.createComment(body, author, path, confirmed, staff, replyTo)
 		}
 		return nil, err
 	}
@@ -176,12 +227,19 @@ func (db *Database) CreateComment(body string, author string, path string, confi
 		Confirmed: confirmed,
 		CreatedAt: time.Now().UTC(),
 		ReplyTo:   toReplyTo,
+		Staff:     staff,
 	}).Run()
 	return &uid, err
 }
 
-// GetCommentsByThread gets all the comments by thread path
+// GetCommentsByThread gets all the comments by thread path, oldest first
 func (db *Database) GetCommentsByThread(path string) (comments []model.Comment, err error) {
+	return db.QueryCommentsByThread(path, model.CommentQuery{})
+}
+
+// QueryCommentsByThread gets the comments by thread path, sorted and filtered by the given query.
+// Scans can't be ordered in dynamodb, so the sorting and filtering is done after fetching the thread.
+func (db *Database) QueryCommentsByThread(path string, query model.CommentQuery) (comments []model.Comment, err error) {
 	thread, err := db.GetThread(path)
 	if err != nil {
 		return comments, err
@@ -198,6 +256,7 @@ func (db *Database) GetCommentsByThread(path string) (comments []model.Comment,
 	sort.Sort(result)
 
 	comments = make([]model.Comment, 0)
+	replyCounts := make(map[uuid.UUID]int)
 	for i := range result {
 		comment, err := result[i].ToComment()
 		if err != nil {
@@ -209,8 +268,28 @@ func (db *Database) GetCommentsByThread(path string) (comments []model.Comment,
 		if comment.DeletedAt != nil {
 			continue
 		}
+		if comment.ReplyTo != nil {
+			replyCounts[*comment.ReplyTo]++
+		}
+		if query.Since != nil && !comment.CreatedAt.After(*query.Since) {
+			continue
+		}
 		comments = append(comments, comment)
 	}
+	switch query.Sort {
+	case model.SortNewest:
+		sort.SliceStable(comments, func(i, j int) bool {
+			return comments[i].CreatedAt.After(comments[j].CreatedAt)
+		})
+	case model.SortReplies:
+		sort.SliceStable(comments, func(i, j int) bool {
+			return replyCounts[comments[i].Id] > replyCounts[comments[j].Id]
+		})
+	}
+	// pinned comments always go first
+	sort.SliceStable(comments, func(i, j int) bool {
+		return comments[i].Pinned && !comments[j].Pinned
+	})
 	// Filter("'Count' = ? AND $ = ?", w.Count, "Message", w.Msg)
 	return comments, nil
 }
@@ -289,6 +368,282 @@ func (db *Database) RestoreDeletedComment(id uuid.UUID) error {
 	return err
 }
 
+// SetCommentPinned pins or unpins the comment by id
+func (db *Database) SetCommentPinned(id uuid.UUID, pinned bool) error {
+	_, err := db.GetComment(id)
+	if err != nil {
+		return err
+	}
+	return db.DB.Table(db.TablePrefix+global.DefaultDynamoDbCommentTableName).Update("ID", id).Set("Pinned", pinned).Run()
+}
+
+// SetCommentFeatured features or unfeatures the comment by id
+func (db *Database) SetCommentFeatured(id uuid.UUID, featured bool) error {
+	_, err := db.GetComment(id)
+	if err != nil {
+		return err
+	}
+	return db.DB.Table(db.TablePrefix+global.DefaultDynamoDbCommentTableName).Update("ID", id).Set("Featured", featured).Run()
+}
+
+// SetThreadState changes the state of the thread by id
+func (db *Database) SetThreadState(id uuid.UUID, state model.ThreadState) error {
+	thread, err := db.GetThreadById(id)
+	if err != nil {
+		return err
+	}
+	return db.DB.Table(db.TablePrefix+global.DefaultDynamoDbThreadTableName).Update("Path", thread.Path).Set("State", string(state)).Run()
+}
+
+// UpdateThread updates the title, url, site and the per-thread settings of the thread
+func (db *Database) UpdateThread(thread model.Thread) error {
+	existing, err := db.GetThreadById(thread.Id)
+	if err != nil {
+		return err
+	}
+	// empty attributes are removed rather than stored
+	update := db.DB.Table(db.TablePrefix+global.DefaultDynamoDbThreadTableName).Update("Path", existing.Path)
+	if thread.Title != "" {
+		update.Set("Title", thread.Title)
+	} else {
+		update.Remove("Title")
+	}
+	if thread.URL != "" {
+		update.Set("URL", thread.URL)
+	} else {
+		update.Remove("URL")
+	}
+	if thread.Moderation != nil {
+		update.Set("Moderation", *thread.Moderation)
+	} else {
+		update.Remove("Moderation")
+	}
+	if thread.MaxCommentLength != nil {
+		update.Set("MaxCommentLength", *thread.MaxCommentLength)
+	} else {
+		update.Remove("MaxCommentLength")
+	}
+	if thread.SiteId != nil {
+		update.Set("SiteId", *thread.SiteId)
+	} else {
+		update.Remove("SiteId")
+	}
+	return update.Run()
+}
+
+// maxTransactionItems is the maximum amount of operations a single dynamodb transaction can contain
+const maxTransactionItems = 25
+
+// RenameThread changes the path of the thread by id, keeping all its comments.
+// The path is the hash key of the thread table, so the thread is put under the new path and deleted from the old one in a single transaction.
+func (db *Database) RenameThread(id uuid.UUID, path string) error {
+	thread, err := db.GetThreadById(id)
+	if err != nil {
+		return err
+	}
+	if thread.Path == path {
+		return nil
+	}
+	_, err = db.GetThread(path)
+	if err == nil {
+		return global.ErrThreadAlreadyExists
+	}
+	if err != global.ErrThreadNotFound {
+		return err
+	}
+	renamed := dynamoModel.Thread{}
+	renamed.FromThread(thread)
+	renamed.Path = path
+	table := db.DB.Table(db.TablePrefix + global.DefaultDynamoDbThreadTableName)
+	return db.DB.WriteTx().
+		Put(table.Put(renamed).If("attribute_not_exists('Path')")).
+		Delete(table.Delete("Path", thread.Path)).
+		Run()
+}
+
+// MergeThreads moves all the comments and aliases of the source thread to the target thread and deletes the source thread.
+// Dynamodb transactions are limited in size, so the comments of large threads are moved in several transactions, the last one deleting the source thread.
+func (db *Database) MergeThreads(sourceId uuid.UUID, targetId uuid.UUID) error {
+	if bytes.Equal(sourceId.Bytes(), targetId.Bytes()) {
+		return global.ErrCantMergeThreadIntoItself
+	}
+	source, err := db.GetThreadById(sourceId)
+	if err != nil {
+		return err
+	}
+	target, err := db.GetThreadById(targetId)
+	if err != nil {
+		return err
+	}
+	comments, err := db.getThreadComments(source.Id)
+	if err != nil {
+		return err
+	}
+	aliases, err := db.getThreadAliases(source.Id)
+	if err != nil {
+		return err
+	}
+	threadTable := db.DB.Table(db.TablePrefix + global.DefaultDynamoDbThreadTableName)
+	commentTable := db.DB.Table(db.TablePrefix + global.DefaultDynamoDbCommentTableName)
+	aliasTable := db.DB.Table(db.TablePrefix + global.DefaultDynamoDbThreadAliasTableName)
+	return db.runInTransactions(len(comments)+len(aliases), func(tx *dynamo.WriteTx, i int) {
+		if i < len(comments) {
+			tx.Update(commentTable.Update("ID", comments[i].Id).Set("ThreadId", target.Id))
+			return
+		}
+		tx.Update(aliasTable.Update("Path", aliases[i-len(comments)].Path).Set("ThreadId", target.Id))
+	}, func(tx *dynamo.WriteTx) {
+		tx.Check(threadTable.Check("Path", target.Path).IfExists())
+		tx.Delete(threadTable.Delete("Path", source.Path))
+	})
+}
+
+// DeleteThread deletes the thread by id along with all its comments and aliases.
+// As with merging, the comments of large threads are deleted in several transactions, the last one deleting the thread itself.
+func (db *Database) DeleteThread(id uuid.UUID) error {
+	thread, err := db.GetThreadById(id)
+	if err != nil {
+		return err
+	}
+	comments, err := db.getThreadComments(thread.Id)
+	if err != nil {
+		return err
+	}
+	aliases, err := db.getThreadAliases(thread.Id)
+	if err != nil {
+		return err
+	}
+	threadTable := db.DB.Table(db.TablePrefix + global.DefaultDynamoDbThreadTableName)
+	commentTable := db.DB.Table(db.TablePrefix + global.DefaultDynamoDbCommentTableName)
+	aliasTable := db.DB.Table(db.TablePrefix + global.DefaultDynamoDbThreadAliasTableName)
+	return db.runInTransactions(len(comments)+len(aliases), func(tx *dynamo.WriteTx, i int) {
+		if i < len(comments) {
+			tx.Delete(commentTable.Delete("ID", comments[i].Id))
+			return
+		}
+		tx.Delete(aliasTable.Delete("Path", aliases[i-len(comments)].Path))
+	}, func(tx *dynamo.WriteTx) {
+		tx.Delete(threadTable.Delete("Path", thread.Path))
+	})
+}
+
+// getThreadComments gets all the comments of the thread, including the unconfirmed and deleted ones
+func (db *Database) getThreadComments(threadId uuid.UUID) (comments []dynamoModel.Comment, err error) {
+	err = db.DB.Table(db.TablePrefix+global.DefaultDynamoDbCommentTableName).Scan().Filter("'ThreadId' = ?", threadId).All(&comments)
+	if err != nil && err != dynamo.ErrNotFound {
+		return nil, err
+	}
+	return comments, nil
+}
+
+// getThreadAliases gets all the aliases pointing to the thread
+func (db *Database) getThreadAliases(threadId uuid.UUID) (aliases []model.ThreadAlias, err error) {
+	err = db.DB.Table(db.TablePrefix+global.DefaultDynamoDbThreadAliasTableName).Scan().Filter("'ThreadId' = ?", threadId).All(&aliases)
+	if err != nil && err != dynamo.ErrNotFound {
+		return nil, err
+	}
+	return aliases, nil
+}
+
+// CreateThreadAlias makes the path an alias of the thread by id. Paths that have a thread of their own can't be aliased
+func (db *Database) CreateThreadAlias(path string, threadId uuid.UUID) error {
+	thread, err := db.GetThreadById(threadId)
+	if err != nil {
+		return err
+	}
+	_, err = db.GetThread(path)
+	if err == nil {
+		return global.ErrThreadAlreadyExists
+	}
+	if err != global.ErrThreadNotFound {
+		return err
+	}
+	err = db.DB.Table(db.TablePrefix + global.DefaultDynamoDbThreadAliasTableName).Put(model.ThreadAlias{
+		Path:      path,
+		ThreadId:  thread.Id,
+		CreatedAt: time.Now().UTC(),
+	}).If("attribute_not_exists('Path')").Run()
+	if isConditionalCheckFailed(err) {
+		return global.ErrThreadAliasAlreadyExists
+	}
+	return err
+}
+
+// GetThreadAlias gets the alias by path
+func (db *Database) GetThreadAlias(path string) (alias model.ThreadAlias, err error) {
+	err = db.DB.Table(db.TablePrefix+global.DefaultDynamoDbThreadAliasTableName).Get("Path", path).One(&alias)
+	if err == dynamo.ErrNotFound {
+		return alias, global.ErrThreadAliasNotFound
+	}
+	return alias, err
+}
+
+// GetAllThreadAliases gets all the thread aliases found in the database
+func (db *Database) GetAllThreadAliases() (aliases []model.ThreadAlias, err error) {
+	err = db.DB.Table(db.TablePrefix + global.DefaultDynamoDbThreadAliasTableName).Scan().All(&aliases)
+	if err != nil && err != dynamo.ErrNotFound {
+		return nil, err
+	}
+	sort.Slice(aliases, func(i, j int) bool { return aliases[i].Path < aliases[j].Path })
+	return aliases, nil
+}
+
+// GetSiteThreadAliases gets the aliases of the threads of the site
+func (db *Database) GetSiteThreadAliases(siteId uuid.UUID) (aliases []model.ThreadAlias, err error) {
+	threadIds, err := db.siteThreadIds(siteId)
+	if err != nil {
+		return nil, err
+	}
+	all, err := db.GetAllThreadAliases()
+	if err != nil {
+		return nil, err
+	}
+	for _, v := range all {
+		if threadIds[v.ThreadId] {
+			aliases = append(aliases, v)
+		}
+	}
+	return aliases, nil
+}
+
+// DeleteThreadAlias deletes the alias by path, leaving the thread it points to alone
+func (db *Database) DeleteThreadAlias(path string) error {
+	err := db.DB.Table(db.TablePrefix+global.DefaultDynamoDbThreadAliasTableName).Delete("Path", path).If("attribute_exists('Path')").Run()
+	if isConditionalCheckFailed(err) {
+		return global.ErrThreadAliasNotFound
+	}
+	return err
+}
+
+// isConditionalCheckFailed checks if the error was caused by a failed write condition
+func isConditionalCheckFailed(err error) bool {
+	if aerr, ok := err.(awserr.RequestFailure); ok {
+		return aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException
+	}
+	return false
+}
+
+// runInTransactions adds count operations to transactions of at most maxTransactionItems operations and runs them.
+// The final operations, which must fit in two items, are added to the last transaction.
+func (db *Database) runInTransactions(count int, add func(tx *dynamo.WriteTx, i int), final func(tx *dynamo.WriteTx)) error {
+	tx := db.DB.WriteTx()
+	items := 0
+	for i := 0; i < count; i++ {
+		add(tx, i)
+		items++
+		if items == maxTransactionItems-2 {
+			err := tx.Run()
+			if err != nil {
+				return err
+			}
+			tx = db.DB.WriteTx()
+			items = 0
+		}
+	}
+	final(tx)
+	return tx.Run()
+}
+
 // GetAllThreads gets all the threads found in the database
 func (db *Database) GetAllThreads() (threads []model.Thread, err error) {
 	var result dynamoModel.ThreadSlice
@@ -296,12 +651,82 @@ func (db *Database) GetAllThreads() (threads []model.Thread, err error) {
 	if err != nil {
 		return nil, err
 	}
+	return toThreads(result), err
+}
+
+// GetSiteThreads gets the threads of the site
+func (db *Database) GetSiteThreads(siteId uuid.UUID) (threads []model.Thread, err error) {
+	var result dynamoModel.ThreadSlice
+	err = db.DB.Table(db.TablePrefix+global.DefaultDynamoDbThreadTableName).Scan().Filter("'SiteId' = ?", siteId).All(&result)
+	if err != nil {
+		return nil, err
+	}
+	return toThreads(result), err
+}
+
+// toThreads sorts the threads and converts them to the shared model
+func toThreads(result dynamoModel.ThreadSlice) []model.Thread {
 	sort.Sort(result)
-	threads = make([]model.Thread, len(result))
+	threads := make([]model.Thread, len(result))
 	for i := range result {
 		threads[i] = result[i].ToThread()
 	}
-	return threads, err
+	return threads
+}
+
+// siteThreadIds gets the ids of the threads of the site. Dynamo can't join, so the other site queries filter by them
+func (db *Database) siteThreadIds(siteId uuid.UUID) (map[uuid.UUID]bool, error) {
+	var result []dynamoModel.Thread
+	err := db.DB.Table(db.TablePrefix+global.DefaultDynamoDbThreadTableName).Scan().Filter("'SiteId' = ?", siteId).Project("ID").All(&result)
+	if err != nil {
+		return nil, err
+	}
+	threadIds := make(map[uuid.UUID]bool, len(result))
+	for _, v := range result {
+		threadIds[v.Id] = true
+	}
+	return threadIds, nil
+}
+
+// GetThreadStats gets the amount of comments that are not deleted and the time of the newest one for every thread that has any.
+// Dynamo can't group, so only the attributes needed are read from the comments
+func (db *Database) GetThreadStats() (stats []model.ThreadStats, err error) {
+	return db.threadStats(nil)
+}
+
+// GetSiteThreadStats gets the same stats as GetThreadStats for the threads of the site
+func (db *Database) GetSiteThreadStats(siteId uuid.UUID) (stats []model.ThreadStats, err error) {
+	threadIds, err := db.siteThreadIds(siteId)
+	if err != nil {
+		return nil, err
+	}
+	return db.threadStats(threadIds)
+}
+
+// threadStats computes the thread stats, only for the given threads if there are any given
+func (db *Database) threadStats(threadIds map[uuid.UUID]bool) (stats []model.ThreadStats, err error) {
+	var result []dynamoModel.Comment
+	err = db.DB.Table(db.TablePrefix+global.DefaultDynamoDbCommentTableName).Scan().Project("ThreadId", "CreatedAt", "DeletedAt").All(&result)
+	if err != nil {
+		return nil, err
+	}
+	index := make(map[uuid.UUID]int)
+	for _, v := range result {
+		if v.DeletedAt != nil || (threadIds != nil && !threadIds[v.ThreadId]) {
+			continue
+		}
+		i, ok := index[v.ThreadId]
+		if !ok {
+			i = len(stats)
+			index[v.ThreadId] = i
+			stats = append(stats, model.ThreadStats{ThreadId: v.ThreadId})
+		}
+		stats[i].CommentCount++
+		if v.CreatedAt.After(stats[i].LastCommentAt) {
+			stats[i].LastCommentAt = v.CreatedAt
+		}
+	}
+	return stats, nil
 }
 
 // GetAllComments gets all the comments found in the database
@@ -311,6 +736,31 @@ func (db *Database) GetAllComments() (comments []model.Comment, err error) {
 	if err != nil {
 		return nil, err
 	}
+	return toComments(result)
+}
+
+// GetSiteComments gets the comments on the threads of the site
+func (db *Database) GetSiteComments(siteId uuid.UUID) (comments []model.Comment, err error) {
+	threadIds, err := db.siteThreadIds(siteId)
+	if err != nil {
+		return nil, err
+	}
+	var all dynamoModel.CommentSlice
+	err = db.DB.Table(db.TablePrefix + global.DefaultDynamoDbCommentTableName).Scan().All(&all)
+	if err != nil {
+		return nil, err
+	}
+	result := make(dynamoModel.CommentSlice, 0, len(all))
+	for _, v := range all {
+		if threadIds[v.ThreadId] {
+			result = append(result, v)
+		}
+	}
+	return toComments(result)
+}
+
+// toComments sorts the comments and converts them to the shared model
+func toComments(result dynamoModel.CommentSlice) (comments []model.Comment, err error) {
 	sort.Sort(result)
 	comments = make([]model.Comment, len(result))
 	for i := range result {
@@ -320,7 +770,7 @@ func (db *Database) GetAllComments() (comments []model.Comment, err error) {
 		}
 		comments[i] = comment
 	}
-	return comments, err
+	return comments, nil
 }
 
 // GetDatabaseDialect returns the current database dialect
@@ -333,6 +783,11 @@ func (db *Database) GetUnderlyingStruct() interface{} {
 	return db
 }
 
+// Close does nothing, as dynamodb is reached over http without keeping a connection
+func (db *Database) Close() error {
+	return nil
+}
+
 // CleanUpStaleData removes the stale data from the database
 func (db *Database) CleanUpStaleData(target global.CleanupType, timeout int64) error {
 	timeoutDuration := time.Duration(int64(time.Second) * timeout)
@@ -420,11 +875,9 @@ func (db *Database) CleanupDeleted(olderThan time.Time) error {
 // ImportData performs the data import for the given driver
 func (db *Database) ImportData(pathToDump string) error {
 	importThread := func(t model.Thread) error {
-		err := db.DB.Table(db.TablePrefix + global.DefaultDynamoDbThreadTableName).Put(dynamoModel.Thread{
-			Id:        t.Id,
-			Path:      t.Path,
-			CreatedAt: t.CreatedAt,
-		}).Run()
+		thread := dynamoModel.Thread{}
+		thread.FromThread(t)
+		err := db.DB.Table(db.TablePrefix + global.DefaultDynamoDbThreadTableName).Put(thread).Run()
 		if err != nil {
 			return err
 		}
@@ -442,3 +895,302 @@ func (db *Database) ImportData(pathToDump string) error {
 	err := tool.ImportData(pathToDump, importThread, importComment)
 	return err
 }
+
+// CreateAuditEntry records an administrative action in the audit log
+func (db *Database) CreateAuditEntry(action string, subject string, details string, actor string, ip string, siteId *uuid.UUID) error {
+	return db.DB.Table(db.TablePrefix + global.DefaultDynamoDbAuditTableName).Put(model.AuditEntry{
+		Id:        global.GetUUID(),
+		Action:    action,
+		Subject:   subject,
+		Details:   details,
+		Actor:     actor,
+		IP:        ip,
+		CreatedAt: time.Now().UTC(),
+		SiteId:    siteId,
+	}).Run()
+}
+
+// GetAuditEntries gets the page of the audit log entries selected by the query, newest first
+func (db *Database) GetAuditEntries(query model.AuditQuery) (entries []model.AuditEntry, err error) {
+	var all model.AuditEntrySlice
+	err = db.DB.Table(db.TablePrefix + global.DefaultDynamoDbAuditTableName).Scan().All(&all)
+	if err != nil {
+		return nil, err
+	}
+	result := make(model.AuditEntrySlice, 0, len(all))
+	for _, v := range all {
+		if query.Matches(v) {
+			result = append(result, v)
+		}
+	}
+	sort.Sort(result)
+	if query.Limit > 0 && len(result) > query.Limit {
+		result = result[:query.Limit]
+	}
+	return result, nil
+}
+
+// CreateSite stores the site, generating its id. The site keys are unique
+func (db *Database) CreateSite(site model.Site) (*uuid.UUID, error) {
+	sites, err := db.GetSites()
+	if err != nil {
+		return nil, err
+	}
+	for _, v := range sites {
+		if v.Key == site.Key {
+			return nil, global.ErrSiteAlreadyExists
+		}
+	}
+	site.Id = global.GetUUID()
+	site.CreatedAt = time.Now().UTC()
+	err = db.DB.Table(db.TablePrefix + global.DefaultDynamoDbSiteTableName).Put(site).If("attribute_not_exists('ID')").Run()
+	if err != nil {
+		return nil, err
+	}
+	return &site.Id, nil
+}
+
+// GetSites gets all the sites, ordered by key
+func (db *Database) GetSites() (sites []model.Site, err error) {
+	var result model.SiteSlice
+	err = db.DB.Table(db.TablePrefix + global.DefaultDynamoDbSiteTableName).Scan().All(&result)
+	if err != nil {
+		return nil, err
+	}
+	sort.Sort(result)
+	return result, nil
+}
+
+// GetSite gets the site by id
+func (db *Database) GetSite(id uuid.UUID) (site model.Site, err error) {
+	err = db.DB.Table(db.TablePrefix+global.DefaultDynamoDbSiteTableName).Get("ID", id).One(&site)
+	if err == dynamo.ErrNotFound {
+		return site, global.ErrSiteNotFound
+	}
+	return site, err
+}
+
+// UpdateSite updates the name, origins, admins and the moderation settings of the site. The key of a site never changes
+func (db *Database) UpdateSite(site model.Site) error {
+	existing, err := db.GetSite(site.Id)
+	if err != nil {
+		return err
+	}
+	site.Key = existing.Key
+	site.CreatedAt = existing.CreatedAt
+	err = db.DB.Table(db.TablePrefix + global.DefaultDynamoDbSiteTableName).Put(site).If("attribute_exists('ID')").Run()
+	if isConditionalCheckFailed(err) {
+		return global.ErrSiteNotFound
+	}
+	return err
+}
+
+// DeleteSite deletes the site by id. Sites that still have threads can't be deleted
+func (db *Database) DeleteSite(id uuid.UUID) error {
+	var threads []dynamoModel.Thread
+	err := db.DB.Table(db.TablePrefix+global.DefaultDynamoDbThreadTableName).Scan().Filter("'SiteId' = ?", id).All(&threads)
+	if err != nil {
+		return err
+	}
+	if len(threads) > 0 {
+		return global.ErrSiteHasThreads
+	}
+	err = db.DB.Table(db.TablePrefix+global.DefaultDynamoDbSiteTableName).Delete("ID", id).If("attribute_exists('ID')").Run()
+	if isConditionalCheckFailed(err) {
+		return global.ErrSiteNotFound
+	}
+	return err
+}
+
+// CreateAdminSession stores the session of a logged in admin
+func (db *Database) CreateAdminSession(session model.AdminSession) error {
+	return db.DB.Table(db.TablePrefix + global.DefaultDynamoDbAdminSessionTableName).Put(session).Run()
+}
+
+// GetAdminSession gets the admin session by id. Expired sessions are returned as well, until they get deleted
+func (db *Database) GetAdminSession(id uuid.UUID) (session model.AdminSession, err error) {
+	err = db.DB.Table(db.TablePrefix+global.DefaultDynamoDbAdminSessionTableName).Get("ID", id).One(&session)
+	if err == dynamo.ErrNotFound {
+		return session, global.ErrSessionNotFound
+	}
+	return session, err
+}
+
+// GetAdminSessions gets all the admin sessions, newest first
+func (db *Database) GetAdminSessions() (sessions []model.AdminSession, err error) {
+	var result model.AdminSessionSlice
+	err = db.DB.Table(db.TablePrefix + global.DefaultDynamoDbAdminSessionTableName).Scan().All(&result)
+	if err != nil {
+		return nil, err
+	}
+	sort.Sort(result)
+	return result, nil
+}
+
+// DeleteAdminSession deletes the admin session by id, logging the admin out
+func (db *Database) DeleteAdminSession(id uuid.UUID) error {
+	err := db.DB.Table(db.TablePrefix+global.DefaultDynamoDbAdminSessionTableName).Delete("ID", id).If("attribute_exists('ID')").Run()
+	if isConditionalCheckFailed(err) {
+		return global.ErrSessionNotFound
+	}
+	return err
+}
+
+// DeleteExpiredAdminSessions deletes the admin sessions that have expired
+func (db *Database) DeleteExpiredAdminSessions() error {
+	sessions, err := db.GetAdminSessions()
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	for _, v := range sessions {
+		if !v.ExpiresAt.Before(now) {
+			continue
+		}
+		err := db.DB.Table(db.TablePrefix+global.DefaultDynamoDbAdminSessionTableName).Delete("ID", v.Id).Run()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SaveLoginFailure stores the failed logins of a client or account, replacing what was recorded for it before
+func (db *Database) SaveLoginFailure(failure model.LoginFailure) error {
+	return db.DB.Table(db.TablePrefix + global.DefaultDynamoDbLoginFailureTableName).Put(failure).Run()
+}
+
+// GetLoginFailure gets the failed logins of a client or account
+func (db *Database) GetLoginFailure(id string) (failure model.LoginFailure, err error) {
+	err = db.DB.Table(db.TablePrefix+global.DefaultDynamoDbLoginFailureTableName).Get("ID", id).One(&failure)
+	if err == dynamo.ErrNotFound {
+		return failure, global.ErrLoginFailureNotFound
+	}
+	return failure, err
+}
+
+// GetLoginFailures gets the failed logins of all the clients and accounts, the most recent failures first
+func (db *Database) GetLoginFailures() (failures []model.LoginFailure, err error) {
+	var result model.LoginFailureSlice
+	err = db.DB.Table(db.TablePrefix + global.DefaultDynamoDbLoginFailureTableName).Scan().All(&result)
+	if err != nil {
+		return nil, err
+	}
+	sort.Sort(result)
+	return result, nil
+}
+
+// DeleteLoginFailure forgets the failed logins of a client or account, lifting its lockout
+func (db *Database) DeleteLoginFailure(id string) error {
+	err := db.DB.Table(db.TablePrefix+global.DefaultDynamoDbLoginFailureTableName).Delete("ID", id).If("attribute_exists('ID')").Run()
+	if isConditionalCheckFailed(err) {
+		return global.ErrLoginFailureNotFound
+	}
+	return err
+}
+
+// DeleteLoginFailures forgets all the failed logins, lifting every lockout
+func (db *Database) DeleteLoginFailures() error {
+	failures, err := db.GetLoginFailures()
+	if err != nil {
+		return err
+	}
+	for _, v := range failures {
+		err := db.DB.Table(db.TablePrefix+global.DefaultDynamoDbLoginFailureTableName).Delete("ID", v.Id).Run()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SaveTwoFactor stores the second factor of an admin account, replacing the previous one
+func (db *Database) SaveTwoFactor(twoFactor model.TwoFactor) error {
+	return db.DB.Table(db.TablePrefix + global.DefaultDynamoDbTwoFactorTableName).Put(twoFactor).Run()
+}
+
+// GetTwoFactor gets the second factor of an admin account
+func (db *Database) GetTwoFactor(id string) (twoFactor model.TwoFactor, err error) {
+	err = db.DB.Table(db.TablePrefix+global.DefaultDynamoDbTwoFactorTableName).Get("ID", id).One(&twoFactor)
+	if err == dynamo.ErrNotFound {
+		return twoFactor, global.ErrTwoFactorNotFound
+	}
+	return twoFactor, err
+}
+
+// DeleteTwoFactor deletes the second factor of an admin account, turning the two-factor authentication off for it
+func (db *Database) DeleteTwoFactor(id string) error {
+	err := db.DB.Table(db.TablePrefix+global.DefaultDynamoDbTwoFactorTableName).Delete("ID", id).If("attribute_exists('ID')").Run()
+	if isConditionalCheckFailed(err) {
+		return global.ErrTwoFactorNotFound
+	}
+	return err
+}
+
+// SaveWebAuthnCredential stores a webauthn credential, replacing the previous version of it
+func (db *Database) SaveWebAuthnCredential(credential model.WebAuthnCredential) error {
+	return db.DB.Table(db.TablePrefix + global.DefaultDynamoDbWebAuthnCredentialTableName).Put(credential).Run()
+}
+
+// GetWebAuthnCredential gets the webauthn credential by id
+func (db *Database) GetWebAuthnCredential(id string) (credential model.WebAuthnCredential, err error) {
+	err = db.DB.Table(db.TablePrefix+global.DefaultDynamoDbWebAuthnCredentialTableName).Get("ID", id).One(&credential)
+	if err == dynamo.ErrNotFound {
+		return credential, global.ErrWebAuthnCredentialNotFound
+	}
+	return credential, err
+}
+
+// GetWebAuthnCredentials gets all the webauthn credentials, oldest first
+func (db *Database) GetWebAuthnCredentials() (credentials []model.WebAuthnCredential, err error) {
+	var result model.WebAuthnCredentialSlice
+	err = db.DB.Table(db.TablePrefix + global.DefaultDynamoDbWebAuthnCredentialTableName).Scan().All(&result)
+	if err != nil {
+		return nil, err
+	}
+	sort.Sort(result)
+	return result, nil
+}
+
+// DeleteWebAuthnCredential deletes the webauthn credential by id, so it can no longer be logged in with
+func (db *Database) DeleteWebAuthnCredential(id string) error {
+	err := db.DB.Table(db.TablePrefix+global.DefaultDynamoDbWebAuthnCredentialTableName).Delete("ID", id).If("attribute_exists('ID')").Run()
+	if isConditionalCheckFailed(err) {
+		return global.ErrWebAuthnCredentialNotFound
+	}
+	return err
+}
+
+// SaveAPIToken stores an api token, replacing the previous version of it
+func (db *Database) SaveAPIToken(token model.APIToken) error {
+	return db.DB.Table(db.TablePrefix + global.DefaultDynamoDbAPITokenTableName).Put(token).Run()
+}
+
+// GetAPIToken gets the api token by id
+func (db *Database) GetAPIToken(id string) (token model.APIToken, err error) {
+	err = db.DB.Table(db.TablePrefix+global.DefaultDynamoDbAPITokenTableName).Get("ID", id).One(&token)
+	if err == dynamo.ErrNotFound {
+		return token, global.ErrAPITokenNotFound
+	}
+	return token, err
+}
+
+// GetAPITokens gets all the api tokens, oldest first
+func (db *Database) GetAPITokens() (tokens []model.APIToken, err error) {
+	var result model.APITokenSlice
+	err = db.DB.Table(db.TablePrefix + global.DefaultDynamoDbAPITokenTableName).Scan().All(&result)
+	if err != nil {
+		return nil, err
+	}
+	sort.Sort(result)
+	return result, nil
+}
+
+// DeleteAPIToken deletes the api token by id, revoking it
+func (db *Database) DeleteAPIToken(id string) error {
+	err := db.DB.Table(db.TablePrefix+global.DefaultDynamoDbAPITokenTableName).Delete("ID", id).If("attribute_exists('ID')").Run()
+	if isConditionalCheckFailed(err) {
+		return global.ErrAPITokenNotFound
+	}
+	return err
+}
@@ -45,7 +45,7 @@ func TestDynamoDatawipe(t *testing.T) {
 	assert.Nil(t, err)
 	_, err = testDb.CreateComment(body, author, path, true, nil)
 	assert.Nil(t, err)
-	_, err = testDb.CreateThread("/t")
+	_, err = testDb.CreateThread("/t", nil)
 	assert.Nil(t, err)
 
 	c, err := testDb.GetAllComments()
@@ -0,0 +1,34 @@
+package model
+
+import (
+	"time"
+
+	"github.com/gofrs/uuid"
+)
+
+// AdminSession represents a logged in admin. Sessions are only stored with the server-side sessions enabled, which allows them to be listed and revoked.
+// UserId and Provider identify the admin, such as the user id on github or admin on password logins. SiteId limits the session to a single site
+type AdminSession struct {
+	Id        uuid.UUID  `db:"Id" dynamo:"ID,hash" json:"Id"`
+	UserId    string     `db:"UserId" dynamo:"UserId" json:"UserId"`
+	Name      string     `db:"Name" dynamo:"Name,omitempty" json:"Name"`
+	Provider  string     `db:"Provider" dynamo:"Provider" json:"Provider"`
+	SiteId    *uuid.UUID `db:"SiteId" dynamo:"SiteId,omitempty" json:"SiteId,omitempty"`
+	CreatedAt time.Time  `db:"CreatedAt" dynamo:"CreatedAt" json:"CreatedAt"`
+	ExpiresAt time.Time  `db:"ExpiresAt" dynamo:"ExpiresAt" json:"ExpiresAt"`
+}
+
+// AdminSessionSlice represents a collection of admin sessions, sorted newest first
+type AdminSessionSlice []AdminSession
+
+func (ass AdminSessionSlice) Len() int {
+	return len(ass)
+}
+
+func (ass AdminSessionSlice) Less(i, j int) bool {
+	return ass[i].CreatedAt.After(ass[j].CreatedAt)
+}
+
+func (ass AdminSessionSlice) Swap(i, j int) {
+	ass[i], ass[j] = ass[j], ass[i]
+}
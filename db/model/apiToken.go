@@ -0,0 +1,49 @@
+package model
+
+import (
+	"strings"
+	"time"
+
+	"github.com/gofrs/uuid"
+)
+
+// APIToken represents a personal access token the admin api is called with. Id is the sha256 hash of the token, hex encoded, as the token itself is only shown when it's created.
+// UserId, UserName, Provider and SiteId identify the admin who created it, the same way as for the admin sessions, and the token acts as that admin within its Scopes, comma separated.
+// Tokens without an ExpiresAt never expire
+type APIToken struct {
+	Id         string     `db:"Id" dynamo:"ID,hash" json:"Id"`
+	Name       string     `db:"Name" dynamo:"Name,omitempty" json:"Name"`
+	Scopes     string     `db:"Scopes" dynamo:"Scopes" json:"Scopes"`
+	UserId     string     `db:"UserId" dynamo:"UserId" json:"UserId"`
+	UserName   string     `db:"UserName" dynamo:"UserName,omitempty" json:"UserName"`
+	Provider   string     `db:"Provider" dynamo:"Provider" json:"Provider"`
+	SiteId     *uuid.UUID `db:"SiteId" dynamo:"SiteId,omitempty" json:"SiteId,omitempty"`
+	CreatedAt  time.Time  `db:"CreatedAt" dynamo:"CreatedAt" json:"CreatedAt"`
+	ExpiresAt  *time.Time `db:"ExpiresAt" dynamo:"ExpiresAt,omitempty" json:"ExpiresAt,omitempty"`
+	LastUsedAt *time.Time `db:"LastUsedAt" dynamo:"LastUsedAt,omitempty" json:"LastUsedAt,omitempty"`
+}
+
+// HasScope checks if the token was given the scope
+func (t APIToken) HasScope(scope string) bool {
+	for _, v := range strings.Split(t.Scopes, ",") {
+		if v == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// APITokenSlice represents a collection of api tokens, sorted oldest first
+type APITokenSlice []APIToken
+
+func (ats APITokenSlice) Len() int {
+	return len(ats)
+}
+
+func (ats APITokenSlice) Less(i, j int) bool {
+	return ats[i].CreatedAt.Before(ats[j].CreatedAt)
+}
+
+func (ats APITokenSlice) Swap(i, j int) {
+	ats[i], ats[j] = ats[j], ats[i]
+}
@@ -0,0 +1,104 @@
+package model
+
+import (
+	"bytes"
+	"time"
+
+	"github.com/gofrs/uuid"
+)
+
+// AuditThreadRename is the audit action for renaming a thread
+const AuditThreadRename = "thread.rename"
+
+// AuditThreadMerge is the audit action for merging a thread into another one
+const AuditThreadMerge = "thread.merge"
+
+// AuditThreadDelete is the audit action for deleting a thread with all its comments
+const AuditThreadDelete = "thread.delete"
+
+// AuditThreadUpdate is the audit action for changing the title, url or settings of a thread
+const AuditThreadUpdate = "thread.update"
+
+// AuditThreadAliasCreate is the audit action for making a path an alias of a thread
+const AuditThreadAliasCreate = "thread.alias.create"
+
+// AuditThreadAliasDelete is the audit action for removing a thread alias
+const AuditThreadAliasDelete = "thread.alias.delete"
+
+// AuditSiteCreate is the audit action for creating a site
+const AuditSiteCreate = "site.create"
+
+// AuditSiteUpdate is the audit action for changing the settings of a site
+const AuditSiteUpdate = "site.update"
+
+// AuditSiteDelete is the audit action for deleting a site
+const AuditSiteDelete = "site.delete"
+
+// AuditSessionRevoke is the audit action for revoking the session of an admin
+const AuditSessionRevoke = "session.revoke"
+
+// AuditLoginFailure is the audit action for a failed admin password login
+const AuditLoginFailure = "login.failure"
+
+// AuditLoginLockout is the audit action for locking out a client or account after too many failed logins in a row
+const AuditLoginLockout = "login.lockout"
+
+// AuditLoginUnlock is the audit action for lifting the lockouts
+const AuditLoginUnlock = "login.unlock"
+
+// AuditTwoFactorEnable is the audit action for turning the two-factor authentication on for an admin account
+const AuditTwoFactorEnable = "twofactor.enable"
+
+// AuditTwoFactorDisable is the audit action for turning the two-factor authentication off for an admin account
+const AuditTwoFactorDisable = "twofactor.disable"
+
+// AuditTwoFactorRecoveryCodes is the audit action for replacing the recovery codes of an admin account
+const AuditTwoFactorRecoveryCodes = "twofactor.recovery.regenerate"
+
+// AuditTwoFactorRecoveryCodeUse is the audit action for logging in with a recovery code instead of a totp code
+const AuditTwoFactorRecoveryCodeUse = "twofactor.recovery.use"
+
+// AuditWebAuthnRegister is the audit action for registering a webauthn credential for an admin
+const AuditWebAuthnRegister = "webauthn.register"
+
+// AuditWebAuthnDelete is the audit action for deleting a webauthn credential
+const AuditWebAuthnDelete = "webauthn.delete"
+
+// AuditAPITokenCreate is the audit action for creating an api token
+const AuditAPITokenCreate = "token.create"
+
+// AuditAPITokenRevoke is the audit action for revoking an api token
+const AuditAPITokenRevoke = "token.revoke"
+
+// AuditEntry records an administrative action
+type AuditEntry struct {
+	Id      uuid.UUID `db:"Id" dynamo:"ID,hash" json:"Id"`
+	Action  string    `db:"Action" dynamo:"Action" json:"Action"`
+	Subject string    `db:"Subject" dynamo:"Subject" json:"Subject"`
+	Details string    `db:"Details" dynamo:"Details" json:"Details"`
+	Actor   string    `db:"Actor" dynamo:"Actor" json:"Actor"`
+	// IP is the address of the client the action came from
+	IP        string    `db:"IP" dynamo:"IP,omitempty" json:"IP"`
+	CreatedAt time.Time `db:"CreatedAt" dynamo:"CreatedAt" json:"CreatedAt"`
+	// SiteId is the site the subject of the action belongs to, if any
+	SiteId *uuid.UUID `db:"SiteId" dynamo:"SiteId,omitempty" json:"SiteId,omitempty"`
+}
+
+// AuditEntrySlice represents a collection of audit entries, sorted newest first
+type AuditEntrySlice []AuditEntry
+
+func (aes AuditEntrySlice) Len() int {
+	return len(aes)
+}
+
+// Less orders the entries created at the same time by id, the same way the databases do, so that the pages of the audit log don't overlap
+func (aes AuditEntrySlice) Less(i, j int) bool {
+	if !aes[i].CreatedAt.Equal(aes[j].CreatedAt) {
+		return aes[i].CreatedAt.After(aes[j].CreatedAt)
+	}
+	return bytes.Compare(aes[i].Id.Bytes(), aes[j].Id.Bytes()) > 0
+}
+
+func (aes AuditEntrySlice) Swap(i, j int) {
+	aes[i], aes[j] = aes[j], aes[i]
+}
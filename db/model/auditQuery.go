@@ -0,0 +1,34 @@
+package model
+
+import (
+	"bytes"
+	"time"
+
+	"github.com/gofrs/uuid"
+)
+
+// AuditQuery selects a page of the audit log entries, newest first
+type AuditQuery struct {
+	// Limit is the most entries returned, 0 returning all of them
+	Limit int
+	// Before limits the entries to the ones created before the given time, which is the creation time of the last entry of the previous page
+	Before *time.Time
+	// BeforeId is the id of the last entry of the previous page. With it, the entries created at the Before time are included if their id sorts before it
+	BeforeId *uuid.UUID
+	// SiteId limits the entries to the ones of the given site
+	SiteId *uuid.UUID
+}
+
+// Matches checks if the entry is selected by the query, apart from the limit
+func (aq AuditQuery) Matches(entry AuditEntry) bool {
+	if aq.SiteId != nil && (entry.SiteId == nil || *entry.SiteId != *aq.SiteId) {
+		return false
+	}
+	if aq.Before == nil {
+		return true
+	}
+	if entry.CreatedAt.Before(*aq.Before) {
+		return true
+	}
+	return aq.BeforeId != nil && entry.CreatedAt.Equal(*aq.Before) && bytes.Compare(entry.Id.Bytes(), aq.BeforeId.Bytes()) < 0
+}
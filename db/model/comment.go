@@ -17,6 +17,9 @@ type Comment struct {
 	CreatedAt time.Time  `db:"CreatedAt" json:"CreatedAt"`
 	DeletedAt *time.Time `db:"DeletedAt" json:"DeletedAt,omitempty"`
 	ReplyTo   *uuid.UUID `db:"ReplyTo" json:"ReplyTo,omitempty"`
+	Pinned    bool       `db:"Pinned" json:"Pinned"`
+	Featured  bool       `db:"Featured" json:"Featured"`
+	Staff     bool       `db:"Staff" json:"Staff"`
 }
 
 // CommentSlice represents a collection of comments
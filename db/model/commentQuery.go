@@ -0,0 +1,22 @@
+package model
+
+import "time"
+
+// CommentSort determines the order the comments of a thread are returned in
+type CommentSort string
+
+// SortOldest returns the oldest comments first. This is the default
+const SortOldest CommentSort = "oldest"
+
+// SortNewest returns the newest comments first
+const SortNewest CommentSort = "newest"
+
+// SortReplies returns the comments with the most replies first
+const SortReplies CommentSort = "replies"
+
+// CommentQuery represents the sorting and filtering options for fetching the comments of a thread
+type CommentQuery struct {
+	Sort CommentSort
+	// Since limits the comments to the ones created after the given time
+	Since *time.Time
+}
@@ -0,0 +1,29 @@
+package model
+
+import (
+	"time"
+)
+
+// LoginFailure records the failed admin logins of a client or an account. Id is the ip of the client or the account, prefixed with ip: or account: respectively.
+// Every failure blocks the logins of the client or account until BlockedUntil, which gets further away with each failure in a row
+type LoginFailure struct {
+	Id            string    `db:"Id" dynamo:"ID,hash" json:"Id"`
+	Failures      int       `db:"Failures" dynamo:"Failures" json:"Failures"`
+	LastFailureAt time.Time `db:"LastFailureAt" dynamo:"LastFailureAt" json:"LastFailureAt"`
+	BlockedUntil  time.Time `db:"BlockedUntil" dynamo:"BlockedUntil" json:"BlockedUntil"`
+}
+
+// LoginFailureSlice represents a collection of login failures, sorted by the last failure, newest first
+type LoginFailureSlice []LoginFailure
+
+func (lfs LoginFailureSlice) Len() int {
+	return len(lfs)
+}
+
+func (lfs LoginFailureSlice) Less(i, j int) bool {
+	return lfs[i].LastFailureAt.After(lfs[j].LastFailureAt)
+}
+
+func (lfs LoginFailureSlice) Swap(i, j int) {
+	lfs[i], lfs[j] = lfs[j], lfs[i]
+}
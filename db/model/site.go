@@ -0,0 +1,90 @@
+package model
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gofrs/uuid"
+)
+
+// StringList is a list of strings stored as a single newline separated column in the relational databases
+type StringList []string
+
+// Value joins the list for storing
+func (sl StringList) Value() (driver.Value, error) {
+	return strings.Join(sl, "\n"), nil
+}
+
+// Scan splits the stored list, dropping the empty entries
+func (sl *StringList) Scan(src interface{}) error {
+	var joined string
+	switch v := src.(type) {
+	case nil:
+	case string:
+		joined = v
+	case []byte:
+		joined = string(v)
+	default:
+		return fmt.Errorf("Can't scan %T into a StringList", src)
+	}
+	list := make(StringList, 0)
+	for _, v := range strings.Split(joined, "\n") {
+		if v != "" {
+			list = append(list, v)
+		}
+	}
+	*sl = list
+	return nil
+}
+
+// Site represents one of the sites sharing the mouthful instance.
+// Threads of a site are stored under paths prefixed with the site key, so different sites can have comments on the same paths.
+// Moderation and MaxCommentLength override the global moderation settings for the threads of the site, if set
+type Site struct {
+	Id                uuid.UUID  `db:"Id" dynamo:"ID,hash" json:"Id"`
+	Key               string     `db:"SiteKey" dynamo:"SiteKey" json:"Key"`
+	Name              string     `db:"Name" dynamo:"Name,omitempty" json:"Name"`
+	Origins           StringList `db:"Origins" dynamo:"Origins,omitempty" json:"Origins"`
+	Moderation        *bool      `db:"Moderation" dynamo:"Moderation,omitempty" json:"Moderation,omitempty"`
+	MaxCommentLength  *int       `db:"MaxCommentLength" dynamo:"MaxCommentLength,omitempty" json:"MaxCommentLength,omitempty"`
+	AdminUserIds      StringList `db:"AdminUserIds" dynamo:"AdminUserIds,omitempty" json:"AdminUserIds"`
+	AdminPasswordHash string     `db:"AdminPasswordHash" dynamo:"AdminPasswordHash,omitempty" json:"-"`
+	CreatedAt         time.Time  `db:"CreatedAt" dynamo:"CreatedAt" json:"CreatedAt"`
+}
+
+// HasOrigin checks if the origin is one of the allowed origins of the site
+func (s Site) HasOrigin(origin string) bool {
+	for _, v := range s.Origins {
+		if strings.EqualFold(strings.TrimSuffix(v, "/"), origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// HasAdmin checks if the oauth user is one of the admins of the site. The admin ids are stored as provider:id, as the ids of different providers can collide
+func (s Site) HasAdmin(provider, userId string) bool {
+	for _, v := range s.AdminUserIds {
+		if v == provider+":"+userId {
+			return true
+		}
+	}
+	return false
+}
+
+// SiteSlice represents a collection of sites, sorted by key
+type SiteSlice []Site
+
+func (ss SiteSlice) Len() int {
+	return len(ss)
+}
+
+func (ss SiteSlice) Less(i, j int) bool {
+	return ss[i].Key < ss[j].Key
+}
+
+func (ss SiteSlice) Swap(i, j int) {
+	ss[i], ss[j] = ss[j], ss[i]
+}
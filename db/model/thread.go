@@ -6,11 +6,36 @@ import (
 	"github.com/gofrs/uuid"
 )
 
-// Thread represents a commenting thread
+// ThreadState determines if a thread accepts new comments
+type ThreadState string
+
+// ThreadOpen is the state of threads accepting new comments
+const ThreadOpen ThreadState = "open"
+
+// ThreadLocked is the state of threads that don't accept new comments from readers. The staff can still post in them
+const ThreadLocked ThreadState = "locked"
+
+// ThreadArchived is the state of read-only threads, nobody can post in them
+const ThreadArchived ThreadState = "archived"
+
+// IsValid checks if the state is one of the known thread states
+func (ts ThreadState) IsValid() bool {
+	return ts == ThreadOpen || ts == ThreadLocked || ts == ThreadArchived
+}
+
+// Thread represents a commenting thread.
+// Moderation and MaxCommentLength override the global and site moderation settings for the thread, if set.
+// Threads with no SiteId belong to the default site
 type Thread struct {
-	Id        uuid.UUID `db:"Id" dynamo:"ID" json:"Id"`
-	Path      string    `db:"Path" dynamo:"Path,hash" json:"Path"`
-	CreatedAt time.Time `db:"CreatedAt" dynamo:"CreatedAt,range" json:"CreatedAt,omitempty"`
+	Id               uuid.UUID   `db:"Id" dynamo:"ID" json:"Id"`
+	Path             string      `db:"Path" dynamo:"Path,hash" json:"Path"`
+	CreatedAt        time.Time   `db:"CreatedAt" dynamo:"CreatedAt,range" json:"CreatedAt,omitempty"`
+	State            ThreadState `db:"State" dynamo:"State" json:"State"`
+	Title            string      `db:"Title" dynamo:"Title" json:"Title,omitempty"`
+	URL              string      `db:"URL" dynamo:"URL" json:"URL,omitempty"`
+	Moderation       *bool       `db:"Moderation" dynamo:"Moderation" json:"Moderation,omitempty"`
+	MaxCommentLength *int        `db:"MaxCommentLength" dynamo:"MaxCommentLength" json:"MaxCommentLength,omitempty"`
+	SiteId           *uuid.UUID  `db:"SiteId" dynamo:"SiteId" json:"SiteId,omitempty"`
 }
 
 // ThreadSlice represents a collection of threads
@@ -0,0 +1,14 @@
+package model
+
+import (
+	"time"
+
+	"github.com/gofrs/uuid"
+)
+
+// ThreadAlias maps an additional path to an existing thread, so that several urls share the same comments
+type ThreadAlias struct {
+	Path      string    `db:"Path" dynamo:"Path,hash" json:"Path"`
+	ThreadId  uuid.UUID `db:"ThreadId" dynamo:"ThreadId" json:"ThreadId"`
+	CreatedAt time.Time `db:"CreatedAt" dynamo:"CreatedAt" json:"CreatedAt"`
+}
@@ -0,0 +1,14 @@
+package model
+
+import (
+	"time"
+
+	"github.com/gofrs/uuid"
+)
+
+// ThreadStats holds the amount of comments of a thread that are not deleted, and when the newest of them was posted
+type ThreadStats struct {
+	ThreadId      uuid.UUID `db:"ThreadId" json:"ThreadId"`
+	CommentCount  int       `db:"CommentCount" json:"CommentCount"`
+	LastCommentAt time.Time `db:"LastCommentAt" json:"LastCommentAt"`
+}
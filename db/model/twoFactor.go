@@ -0,0 +1,17 @@
+package model
+
+import (
+	"time"
+)
+
+// TwoFactor holds the totp second factor of an admin password account. Id is the account, admin for the admin password and site: followed by the site key for the admin password of a site.
+// The secret is stored encrypted, and only the hashes of the unused recovery codes are kept. The second factor is only asked for once it's confirmed with a code.
+// LastUsedStep is the time step of the last code used, so a code can't be used twice
+type TwoFactor struct {
+	Id            string     `db:"Id" dynamo:"ID,hash" json:"Id"`
+	Secret        string     `db:"Secret" dynamo:"Secret" json:"-"`
+	RecoveryCodes StringList `db:"RecoveryCodes" dynamo:"RecoveryCodes,omitempty" json:"-"`
+	Confirmed     bool       `db:"Confirmed" dynamo:"Confirmed" json:"Confirmed"`
+	LastUsedStep  int64      `db:"LastUsedStep" dynamo:"LastUsedStep" json:"-"`
+	CreatedAt     time.Time  `db:"CreatedAt" dynamo:"CreatedAt" json:"CreatedAt"`
+}
@@ -0,0 +1,39 @@
+package model
+
+import (
+	"time"
+
+	"github.com/gofrs/uuid"
+)
+
+// WebAuthnCredential represents a passkey or security key an admin logs in with. Id is the credential id, base64url encoded.
+// UserId, UserName, Provider and SiteId identify the admin who registered it, the same way as for the admin sessions, and they are logged in as that admin.
+// PublicKey is the PKIX encoded public key of the credential, base64 encoded, and Algorithm its COSE algorithm. SignCount is the signature counter of the authenticator, which never goes backwards for a genuine one
+type WebAuthnCredential struct {
+	Id         string     `db:"Id" dynamo:"ID,hash" json:"Id"`
+	UserId     string     `db:"UserId" dynamo:"UserId" json:"UserId"`
+	UserName   string     `db:"UserName" dynamo:"UserName,omitempty" json:"UserName"`
+	Provider   string     `db:"Provider" dynamo:"Provider" json:"Provider"`
+	SiteId     *uuid.UUID `db:"SiteId" dynamo:"SiteId,omitempty" json:"SiteId,omitempty"`
+	Name       string     `db:"Name" dynamo:"Name,omitempty" json:"Name"`
+	PublicKey  string     `db:"PublicKey" dynamo:"PublicKey" json:"-"`
+	Algorithm  int        `db:"Algorithm" dynamo:"Algorithm" json:"Algorithm"`
+	SignCount  int64      `db:"SignCount" dynamo:"SignCount" json:"SignCount"`
+	CreatedAt  time.Time  `db:"CreatedAt" dynamo:"CreatedAt" json:"CreatedAt"`
+	LastUsedAt *time.Time `db:"LastUsedAt" dynamo:"LastUsedAt,omitempty" json:"LastUsedAt,omitempty"`
+}
+
+// WebAuthnCredentialSlice represents a collection of webauthn credentials, sorted oldest first
+type WebAuthnCredentialSlice []WebAuthnCredential
+
+func (wcs WebAuthnCredentialSlice) Len() int {
+	return len(wcs)
+}
+
+func (wcs WebAuthnCredentialSlice) Less(i, j int) bool {
+	return wcs[i].CreatedAt.Before(wcs[j].CreatedAt)
+}
+
+func (wcs WebAuthnCredentialSlice) Swap(i, j int) {
+	wcs[i], wcs[j] = wcs[j], wcs[i]
+}
@@ -19,19 +19,28 @@ import (
 
 // Database is a database instance for sqlx
 type Database struct {
-	DB      *sqlx.DB
-	Queries []string
-	Dialect string
-	IsTest  bool
+	DB         *sqlx.DB
+	Queries    []string
+	Migrations []Migration
+	Dialect    string
+	IsTest     bool
 }
 
-// CreateThread takes the thread path and creates it in the database
-func (db *Database) CreateThread(path string) (*uuid.UUID, error) {
+// Migration adds a column to a table created by an older version of mouthful.
+// Query is only run if the column can't be found in the table.
+type Migration struct {
+	Table  string
+	Column string
+	Query  string
+}
+
+// CreateThread takes the thread path and creates it in the database, along with the site it belongs to. The threads of the default site have no site id
+func (db *Database) CreateThread(path string, siteId *uuid.UUID) (*uuid.UUID, error) {
 	thread, err := db.GetThread(path)
 	if err != nil {
 		if err == global.ErrThreadNotFound {
 			uid := global.GetUUID()
-			res, err := db.DB.Exec(db.DB.Rebind("INSERT INTO Thread(Id,Path) VALUES(?, ?)"), uid, path)
+			res, err := db.DB.Exec(db.DB.Rebind("INSERT INTO Thread(Id,Path,SiteId) VALUES(?, ?, ?)"), uid, path, siteId)
 			if err != nil {
 				return nil, err
 			}
@@ -51,7 +60,19 @@ func (db *Database) CreateThread(path string) (*uuid.UUID, error) {
 
 // GetThread takes the thread path and fetches it from the database
 func (db *Database) GetThread(path string) (thread model.Thread, err error) {
-	err = db.DB.QueryRowx(db.DB.Rebind("SELECT Id, Path, CreatedAt FROM Thread where Path=? LIMIT 1"), path).StructScan(&thread)
+	err = db.DB.QueryRowx(db.DB.Rebind("SELECT Id, Path, CreatedAt, State, Title, URL, Moderation, MaxCommentLength, SiteId FROM Thread where Path=? LIMIT 1"), path).StructScan(&thread)
+	if err != nil {
+		if err.Error() == "sql: no rows in result set" {
+			return thread, global.ErrThreadNotFound
+		}
+		return thread, err
+	}
+	return thread, err
+}
+
+// GetThreadById takes the thread id and fetches it from the database
+func (db *Database) GetThreadById(id uuid.UUID) (thread model.Thread, err error) {
+	err = db.DB.QueryRowx(db.DB.Rebind("SELECT Id, Path, CreatedAt, State, Title, URL, Moderation, MaxCommentLength, SiteId FROM Thread where Id=? LIMIT 1"), id).StructScan(&thread)
 	if err != nil {
 		if err.Error() == "sql: no rows in result set" {
 			return thread, global.ErrThreadNotFound
@@ -63,10 +84,19 @@ func (db *Database) GetThread(path string) (thread model.Thread, err error) {
 
 // CreateComment takes in a body, author, and path and creates a comment for the given thread. If thread does not exist, it creates one
 func (db *Database) CreateComment(body string, author string, path string, confirmed bool, replyTo *uuid.UUID) (*uuid.UUID, error) {
+	return db.createComment(body, author, path, confirmed, false, replyTo)
+}
+
+// CreateStaffComment creates a confirmed comment marked as posted by the site staff. If thread does not exist, it creates one
+func (db *Database) CreateStaffComment(body string, author string, path string, replyTo *uuid.UUID) (*uuid.UUID, error) {
+	return db.createComment(body, author, path, true, true, replyTo)
+}
+
+func (db *Database) createComment(body string, author string, path string, confirmed bool, staff bool, replyTo *uuid.UUID) (*uuid.UUID, error) {
 	thread, err := db.GetThread(path)
 	if err != nil {
 		if err == global.ErrThreadNotFound {
-			threadId, err := db.CreateThread(path)
+			threadId, err := db.CreateThread(path, nil)
 			if err != nil {
 				return nil, err
 			}
@@ -74,7 +104,7 @@ func (db *Database) CreateComment(body string, author string, path string, confi
 			if replyTo != nil {
 				return nil, global.ErrWrongReplyTo
 			}
-			res, err := db.DB.Exec(db.DB.Rebind("INSERT INTO Comment(Id, ThreadId, Body, Author, Confirmed, CreatedAt, ReplyTo) VALUES(?,?,?,?,?,?,?)"), uid, threadId, body, author, confirmed, time.Now().UTC(), nil)
+			res, err := db.DB.Exec(db.DB.Rebind("INSERT INTO Comment(Id, ThreadId, Body, Author, Confirmed, CreatedAt, ReplyTo, Staff) VALUES(?,?,?,?,?,?,?,?)"), uid, threadId, body, author, confirmed, time.Now().UTC(), nil, staff)
 			if err != nil {
 				return nil, err
 			}
@@ -107,7 +137,7 @@ func (db *Database) CreateComment(body string, author string, path string, confi
 		}
 	}
 	uid := global.GetUUID()
-	res, err := db.DB.Exec(db.DB.Rebind("INSERT INTO Comment(Id, ThreadId, Body, Author, Confirmed, CreatedAt, ReplyTo) VALUES(?,?,?,?,?,?,?)"), uid, thread.Id, body, author, confirmed, time.Now().UTC(), replyTo)
+	res, err := db.DB.Exec(db.DB.Rebind("INSERT INTO Comment(Id, ThreadId, Body, Author, Confirmed, CreatedAt, ReplyTo, Staff) VALUES(?,?,?,?,?,?,?,?)"), uid, thread.Id, body, author, confirmed, time.Now().UTC(), replyTo, staff)
 	if err != nil {
 		return nil, err
 	}
@@ -118,18 +148,39 @@ func (db *Database) CreateComment(body string, author string, path string, confi
 	return &uid, err
 }
 
-// GetCommentsByThread gets all the comments by thread path
+// GetCommentsByThread gets all the comments by thread path, oldest first
 func (db *Database) GetCommentsByThread(path string) (comments []model.Comment, err error) {
+	return db.QueryCommentsByThread(path, model.CommentQuery{})
+}
+
+// QueryCommentsByThread gets the comments by thread path, sorted and filtered by the given query
+func (db *Database) QueryCommentsByThread(path string, query model.CommentQuery) (comments []model.Comment, err error) {
 	var commentSlice model.CommentSlice
 	thread, err := db.GetThread(path)
 	if err != nil {
 		return nil, err
 	}
-	err = db.DB.Select(&commentSlice, db.DB.Rebind("select * from Comment where ThreadId=? and Confirmed=? and DeletedAt is null"), thread.Id, true)
+	statement := "select c.* from Comment c where c.ThreadId=? and c.Confirmed=? and c.DeletedAt is null"
+	args := []interface{}{thread.Id, true}
+	if query.Since != nil {
+		statement += " and c.CreatedAt>?"
+		args = append(args, query.Since.UTC())
+	}
+	// pinned comments always go first
+	statement += " order by c.Pinned desc"
+	switch query.Sort {
+	case model.SortNewest:
+		statement += ", c.CreatedAt desc"
+	case model.SortReplies:
+		statement += ", (select count(*) from Comment r where r.ReplyTo=c.Id and r.Confirmed=? and r.DeletedAt is null) desc, c.CreatedAt asc"
+		args = append(args, true)
+	default:
+		statement += ", c.CreatedAt asc"
+	}
+	err = db.DB.Select(&commentSlice, db.DB.Rebind(statement), args...)
 	if err != nil {
 		return nil, err
 	}
-	sort.Sort(commentSlice)
 	return commentSlice, nil
 }
 
@@ -193,6 +244,211 @@ func (db *Database) RestoreDeletedComment(id uuid.UUID) error {
 	return nil
 }
 
+// SetCommentPinned pins or unpins the comment by id
+func (db *Database) SetCommentPinned(id uuid.UUID, pinned bool) error {
+	return db.setCommentFlag(id, "Pinned", pinned)
+}
+
+// SetCommentFeatured features or unfeatures the comment by id
+func (db *Database) SetCommentFeatured(id uuid.UUID, featured bool) error {
+	return db.setCommentFlag(id, "Featured", featured)
+}
+
+func (db *Database) setCommentFlag(id uuid.UUID, column string, value bool) error {
+	// mysql reports no affected rows if the value does not change, so the existence is checked beforehand
+	_, err := db.GetComment(id)
+	if err != nil {
+		return err
+	}
+	_, err = db.DB.Exec(db.DB.Rebind(fmt.Sprintf("update Comment set %v=? where Id=?", column)), value, id)
+	return err
+}
+
+// SetThreadState changes the state of the thread by id
+func (db *Database) SetThreadState(id uuid.UUID, state model.ThreadState) error {
+	// mysql reports no affected rows if the value does not change, so the existence is checked beforehand
+	_, err := db.GetThreadById(id)
+	if err != nil {
+		return err
+	}
+	_, err = db.DB.Exec(db.DB.Rebind("update Thread set State=? where Id=?"), state, id)
+	return err
+}
+
+// UpdateThread updates the title, url, site and the per-thread settings of the thread
+func (db *Database) UpdateThread(thread model.Thread) error {
+	// mysql reports no affected rows if the values do not change, so the existence is checked beforehand
+	_, err := db.GetThreadById(thread.Id)
+	if err != nil {
+		return err
+	}
+	_, err = db.DB.Exec(db.DB.Rebind("update Thread set Title=?, URL=?, Moderation=?, MaxCommentLength=?, SiteId=? where Id=?"), thread.Title, thread.URL, thread.Moderation, thread.MaxCommentLength, thread.SiteId, thread.Id)
+	return err
+}
+
+// inTransaction runs f in a transaction, committing it if f succeeds and rolling it back otherwise
+func (db *Database) inTransaction(f func(tx *sqlx.Tx) error) error {
+	tx, err := db.DB.Beginx()
+	if err != nil {
+		return err
+	}
+	err = f(tx)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// threadExists checks if the thread with the given id exists within the transaction
+func threadExists(tx *sqlx.Tx, id uuid.UUID) error {
+	var count int
+	err := tx.Get(&count, tx.Rebind("select count(*) from Thread where Id=?"), id)
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		return global.ErrThreadNotFound
+	}
+	return nil
+}
+
+// RenameThread changes the path of the thread by id, keeping all its comments
+func (db *Database) RenameThread(id uuid.UUID, path string) error {
+	return db.inTransaction(func(tx *sqlx.Tx) error {
+		err := threadExists(tx, id)
+		if err != nil {
+			return err
+		}
+		var existing []uuid.UUID
+		err = tx.Select(&existing, tx.Rebind("select Id from Thread where Path=?"), path)
+		if err != nil {
+			return err
+		}
+		for _, v := range existing {
+			if !bytes.Equal(v.Bytes(), id.Bytes()) {
+				return global.ErrThreadAlreadyExists
+			}
+		}
+		_, err = tx.Exec(tx.Rebind("update Thread set Path=? where Id=?"), path, id)
+		return err
+	})
+}
+
+// MergeThreads moves all the comments and aliases of the source thread to the target thread and deletes the source thread
+func (db *Database) MergeThreads(sourceId uuid.UUID, targetId uuid.UUID) error {
+	if bytes.Equal(sourceId.Bytes(), targetId.Bytes()) {
+		return global.ErrCantMergeThreadIntoItself
+	}
+	return db.inTransaction(func(tx *sqlx.Tx) error {
+		err := threadExists(tx, sourceId)
+		if err != nil {
+			return err
+		}
+		err = threadExists(tx, targetId)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(tx.Rebind("update Comment set ThreadId=? where ThreadId=?"), targetId, sourceId)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(tx.Rebind("update ThreadAlias set ThreadId=? where ThreadId=?"), targetId, sourceId)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(tx.Rebind("delete from Thread where Id=?"), sourceId)
+		return err
+	})
+}
+
+// DeleteThread deletes the thread by id along with all its comments and aliases
+func (db *Database) DeleteThread(id uuid.UUID) error {
+	return db.inTransaction(func(tx *sqlx.Tx) error {
+		err := threadExists(tx, id)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(tx.Rebind("delete from Comment where ThreadId=?"), id)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(tx.Rebind("delete from ThreadAlias where ThreadId=?"), id)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(tx.Rebind("delete from Thread where Id=?"), id)
+		return err
+	})
+}
+
+// CreateThreadAlias makes the path an alias of the thread by id. Paths that have a thread of their own can't be aliased
+func (db *Database) CreateThreadAlias(path string, threadId uuid.UUID) error {
+	return db.inTransaction(func(tx *sqlx.Tx) error {
+		err := threadExists(tx, threadId)
+		if err != nil {
+			return err
+		}
+		var count int
+		err = tx.Get(&count, tx.Rebind("select count(*) from Thread where Path=?"), path)
+		if err != nil {
+			return err
+		}
+		if count > 0 {
+			return global.ErrThreadAlreadyExists
+		}
+		err = tx.Get(&count, tx.Rebind("select count(*) from ThreadAlias where Path=?"), path)
+		if err != nil {
+			return err
+		}
+		if count > 0 {
+			return global.ErrThreadAliasAlreadyExists
+		}
+		_, err = tx.Exec(tx.Rebind("INSERT INTO ThreadAlias(Path, ThreadId, CreatedAt) VALUES(?,?,?)"), path, threadId, time.Now().UTC())
+		return err
+	})
+}
+
+// GetThreadAlias gets the alias by path
+func (db *Database) GetThreadAlias(path string) (alias model.ThreadAlias, err error) {
+	err = db.DB.Get(&alias, db.DB.Rebind("select Path, ThreadId, CreatedAt from ThreadAlias where Path=?"), path)
+	if err != nil {
+		if err.Error() == "sql: no rows in result set" {
+			return alias, global.ErrThreadAliasNotFound
+		}
+		return alias, err
+	}
+	return alias, nil
+}
+
+// GetAllThreadAliases gets all the thread aliases found in the database
+func (db *Database) GetAllThreadAliases() (aliases []model.ThreadAlias, err error) {
+	err = db.DB.Select(&aliases, "select Path, ThreadId, CreatedAt from ThreadAlias order by Path")
+	return aliases, err
+}
+
+// GetSiteThreadAliases gets the aliases of the threads of the site
+func (db *Database) GetSiteThreadAliases(siteId uuid.UUID) (aliases []model.ThreadAlias, err error) {
+	err = db.DB.Select(&aliases, db.DB.Rebind("select ThreadAlias.Path, ThreadAlias.ThreadId, ThreadAlias.CreatedAt from ThreadAlias inner join Thread on ThreadAlias.ThreadId=Thread.Id where Thread.SiteId=? order by ThreadAlias.Path"), siteId)
+	return aliases, err
+}
+
+// DeleteThreadAlias deletes the alias by path, leaving the thread it points to alone
+func (db *Database) DeleteThreadAlias(path string) error {
+	res, err := db.DB.Exec(db.DB.Rebind("delete from ThreadAlias where Path=?"), path)
+	if err != nil {
+		return err
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return global.ErrThreadAliasNotFound
+	}
+	return nil
+}
+
 // GetAllThreads gets all the threads found in the database
 func (db *Database) GetAllThreads() (threads []model.Thread, err error) {
 	var threadSlice model.ThreadSlice
@@ -204,6 +460,86 @@ func (db *Database) GetAllThreads() (threads []model.Thread, err error) {
 	return threadSlice, err
 }
 
+// GetSiteThreads gets the threads of the site
+func (db *Database) GetSiteThreads(siteId uuid.UUID) (threads []model.Thread, err error) {
+	var threadSlice model.ThreadSlice
+	err = db.DB.Select(&threadSlice, db.DB.Rebind("select * from Thread where SiteId=?"), siteId)
+	if err != nil {
+		return threads, err
+	}
+	sort.Sort(threadSlice)
+	return threadSlice, err
+}
+
+// aggregateTime scans the times computed by the queries. Without a column type to go by, sqlite returns them as text, in the format its driver stores them in
+type aggregateTime struct {
+	time.Time
+}
+
+// aggregateTimeFormats are the formats the times come in as text, from sqlite, or mysql without parseTime
+var aggregateTimeFormats = []string{
+	"2006-01-02 15:04:05.999999999-07:00",
+	"2006-01-02T15:04:05.999999999-07:00",
+	"2006-01-02 15:04:05.999999999",
+	"2006-01-02T15:04:05.999999999",
+	time.RFC3339Nano,
+}
+
+// Scan implements sql.Scanner
+func (at *aggregateTime) Scan(value interface{}) error {
+	var text string
+	switch v := value.(type) {
+	case time.Time:
+		at.Time = v
+		return nil
+	case nil:
+		at.Time = time.Time{}
+		return nil
+	case []byte:
+		text = string(v)
+	case string:
+		text = v
+	default:
+		return fmt.Errorf("can't scan %T into a time", value)
+	}
+	for _, format := range aggregateTimeFormats {
+		parsed, err := time.Parse(format, text)
+		if err == nil {
+			at.Time = parsed.UTC()
+			return nil
+		}
+	}
+	return fmt.Errorf("can't parse the time %v", text)
+}
+
+// GetThreadStats gets the amount of comments that are not deleted and the time of the newest one for every thread that has any
+func (db *Database) GetThreadStats() (stats []model.ThreadStats, err error) {
+	return db.threadStats("select ThreadId, count(*) as CommentCount, max(CreatedAt) as LastCommentAt from Comment where DeletedAt is null group by ThreadId")
+}
+
+// GetSiteThreadStats gets the same stats as GetThreadStats for the threads of the site
+func (db *Database) GetSiteThreadStats(siteId uuid.UUID) (stats []model.ThreadStats, err error) {
+	return db.threadStats(db.DB.Rebind("select Comment.ThreadId, count(*) as CommentCount, max(Comment.CreatedAt) as LastCommentAt from Comment inner join Thread on Comment.ThreadId=Thread.Id where Comment.DeletedAt is null and Thread.SiteId=? group by Comment.ThreadId"), siteId)
+}
+
+// threadStats runs the query computing the thread stats
+func (db *Database) threadStats(query string, args ...interface{}) (stats []model.ThreadStats, err error) {
+	var rows []struct {
+		ThreadId      uuid.UUID     `db:"ThreadId"`
+		CommentCount  int           `db:"CommentCount"`
+		LastCommentAt aggregateTime `db:"LastCommentAt"`
+	}
+	err = db.DB.Select(&rows, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	stats = make([]model.ThreadStats, len(rows))
+	for i, v := range rows {
+		stats[i] = model.ThreadStats{ThreadId: v.ThreadId, CommentCount: v.CommentCount, LastCommentAt: v.LastCommentAt.Time}
+	}
+	return stats, nil
+}
+
 // GetAllComments gets all the comments found in the database
 func (db *Database) GetAllComments() (comments []model.Comment, err error) {
 	var commentSlice model.CommentSlice
@@ -215,19 +551,470 @@ func (db *Database) GetAllComments() (comments []model.Comment, err error) {
 	return commentSlice, err
 }
 
+// GetSiteComments gets the comments on the threads of the site
+func (db *Database) GetSiteComments(siteId uuid.UUID) (comments []model.Comment, err error) {
+	var commentSlice model.CommentSlice
+	err = db.DB.Select(&commentSlice, db.DB.Rebind("select Comment.* from Comment inner join Thread on Comment.ThreadId=Thread.Id where Thread.SiteId=?"), siteId)
+	if err != nil {
+		return comments, err
+	}
+	sort.Sort(commentSlice)
+	return commentSlice, err
+}
+
+// CreateAuditEntry records an administrative action in the audit log
+func (db *Database) CreateAuditEntry(action string, subject string, details string, actor string, ip string, siteId *uuid.UUID) error {
+	// the creation time is set here rather than by the database, as sqlite only keeps whole seconds and the entries would not sort
+	_, err := db.DB.Exec(db.DB.Rebind("INSERT INTO AuditEntry(Id, Action, Subject, Details, Actor, IP, CreatedAt, SiteId) VALUES(?,?,?,?,?,?,?,?)"), global.GetUUID(), action, subject, details, actor, ip, time.Now().UTC(), siteId)
+	return err
+}
+
+// GetAuditEntries gets the page of the audit log entries selected by the query, newest first
+func (db *Database) GetAuditEntries(query model.AuditQuery) (entries []model.AuditEntry, err error) {
+	var entrySlice model.AuditEntrySlice
+	statement := "select * from AuditEntry where 1=1"
+	args := []interface{}{}
+	if query.SiteId != nil {
+		statement += " and SiteId=?"
+		args = append(args, *query.SiteId)
+	}
+	if query.Before != nil {
+		if query.BeforeId != nil {
+			statement += " and (CreatedAt<? or (CreatedAt=? and Id<?))"
+			args = append(args, query.Before.UTC(), query.Before.UTC(), *query.BeforeId)
+		} else {
+			statement += " and CreatedAt<?"
+			args = append(args, query.Before.UTC())
+		}
+	}
+	statement += " order by CreatedAt desc, Id desc"
+	if query.Limit > 0 {
+		statement += " limit ?"
+		args = append(args, query.Limit)
+	}
+	err = db.DB.Select(&entrySlice, db.DB.Rebind(statement), args...)
+	if err != nil {
+		return entries, err
+	}
+	sort.Sort(entrySlice)
+	return entrySlice, err
+}
+
+// siteColumns lists the columns of the Site table in the order of the insert statement
+const siteColumns = "Id, SiteKey, Name, Origins, Moderation, MaxCommentLength, AdminUserIds, AdminPasswordHash, CreatedAt"
+
+// CreateSite stores the site, generating its id. The site keys are unique
+func (db *Database) CreateSite(site model.Site) (*uuid.UUID, error) {
+	uid := global.GetUUID()
+	err := db.inTransaction(func(tx *sqlx.Tx) error {
+		var count int
+		err := tx.Get(&count, tx.Rebind("select count(*) from Site where SiteKey=?"), site.Key)
+		if err != nil {
+			return err
+		}
+		if count > 0 {
+			return global.ErrSiteAlreadyExists
+		}
+		_, err = tx.Exec(tx.Rebind("INSERT INTO Site("+siteColumns+") VALUES(?,?,?,?,?,?,?,?,?)"), uid, site.Key, site.Name, site.Origins, site.Moderation, site.MaxCommentLength, site.AdminUserIds, site.AdminPasswordHash, time.Now().UTC())
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &uid, nil
+}
+
+// GetSites gets all the sites, ordered by key
+func (db *Database) GetSites() (sites []model.Site, err error) {
+	err = db.DB.Select(&sites, "select "+siteColumns+" from Site order by SiteKey")
+	return sites, err
+}
+
+// GetSite gets the site by id
+func (db *Database) GetSite(id uuid.UUID) (site model.Site, err error) {
+	err = db.DB.Get(&site, db.DB.Rebind("select "+siteColumns+" from Site where Id=?"), id)
+	if err != nil {
+		if err.Error() == "sql: no rows in result set" {
+			return site, global.ErrSiteNotFound
+		}
+		return site, err
+	}
+	return site, nil
+}
+
+// UpdateSite updates the name, origins, admins and the moderation settings of the site. The key of a site never changes
+func (db *Database) UpdateSite(site model.Site) error {
+	// mysql reports no affected rows if the values do not change, so the existence is checked beforehand
+	_, err := db.GetSite(site.Id)
+	if err != nil {
+		return err
+	}
+	_, err = db.DB.Exec(db.DB.Rebind("update Site set Name=?, Origins=?, Moderation=?, MaxCommentLength=?, AdminUserIds=?, AdminPasswordHash=? where Id=?"), site.Name, site.Origins, site.Moderation, site.MaxCommentLength, site.AdminUserIds, site.AdminPasswordHash, site.Id)
+	return err
+}
+
+// DeleteSite deletes the site by id. Sites that still have threads can't be deleted
+func (db *Database) DeleteSite(id uuid.UUID) error {
+	return db.inTransaction(func(tx *sqlx.Tx) error {
+		var count int
+		err := tx.Get(&count, tx.Rebind("select count(*) from Thread where SiteId=?"), id)
+		if err != nil {
+			return err
+		}
+		if count > 0 {
+			return global.ErrSiteHasThreads
+		}
+		res, err := tx.Exec(tx.Rebind("delete from Site where Id=?"), id)
+		if err != nil {
+			return err
+		}
+		affected, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if affected == 0 {
+			return global.ErrSiteNotFound
+		}
+		return nil
+	})
+}
+
+// adminSessionColumns lists the columns of the AdminSession table in the order of the insert statement
+const adminSessionColumns = "Id, UserId, Name, Provider, SiteId, CreatedAt, ExpiresAt"
+
+// CreateAdminSession stores the session of a logged in admin
+func (db *Database) CreateAdminSession(session model.AdminSession) error {
+	_, err := db.DB.Exec(db.DB.Rebind("INSERT INTO AdminSession("+adminSessionColumns+") VALUES(?,?,?,?,?,?,?)"), session.Id, session.UserId, session.Name, session.Provider, session.SiteId, session.CreatedAt.UTC(), session.ExpiresAt.UTC())
+	return err
+}
+
+// GetAdminSession gets the admin session by id. Expired sessions are returned as well, until they get deleted
+func (db *Database) GetAdminSession(id uuid.UUID) (session model.AdminSession, err error) {
+	err = db.DB.Get(&session, db.DB.Rebind("select "+adminSessionColumns+" from AdminSession where Id=?"), id)
+	if err != nil {
+		if err.Error() == "sql: no rows in result set" {
+			return session, global.ErrSessionNotFound
+		}
+		return session, err
+	}
+	return session, nil
+}
+
+// GetAdminSessions gets all the admin sessions, newest first
+func (db *Database) GetAdminSessions() (sessions []model.AdminSession, err error) {
+	err = db.DB.Select(&sessions, "select "+adminSessionColumns+" from AdminSession order by CreatedAt desc")
+	return sessions, err
+}
+
+// DeleteAdminSession deletes the admin session by id, logging the admin out
+func (db *Database) DeleteAdminSession(id uuid.UUID) error {
+	res, err := db.DB.Exec(db.DB.Rebind("delete from AdminSession where Id=?"), id)
+	if err != nil {
+		return err
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return global.ErrSessionNotFound
+	}
+	return nil
+}
+
+// DeleteExpiredAdminSessions deletes the admin sessions that have expired
+func (db *Database) DeleteExpiredAdminSessions() error {
+	sessions, err := db.GetAdminSessions()
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	for _, v := range sessions {
+		if v.ExpiresAt.Before(now) {
+			_, err = db.DB.Exec(db.DB.Rebind("delete from AdminSession where Id=?"), v.Id)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// loginFailureColumns lists the columns of the LoginFailure table in the order of the insert statement
+const loginFailureColumns = "Id, Failures, LastFailureAt, BlockedUntil"
+
+// SaveLoginFailure stores the failed logins of a client or account, replacing what was recorded for it before
+func (db *Database) SaveLoginFailure(failure model.LoginFailure) error {
+	tx, err := db.DB.Beginx()
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(tx.Rebind("delete from LoginFailure where Id=?"), failure.Id)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	_, err = tx.Exec(tx.Rebind("INSERT INTO LoginFailure("+loginFailureColumns+") VALUES(?,?,?,?)"), failure.Id, failure.Failures, failure.LastFailureAt.UTC(), failure.BlockedUntil.UTC())
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// GetLoginFailure gets the failed logins of a client or account
+func (db *Database) GetLoginFailure(id string) (failure model.LoginFailure, err error) {
+	err = db.DB.Get(&failure, db.DB.Rebind("select "+loginFailureColumns+" from LoginFailure where Id=?"), id)
+	if err != nil {
+		if err.Error() == "sql: no rows in result set" {
+			return failure, global.ErrLoginFailureNotFound
+		}
+		return failure, err
+	}
+	return failure, nil
+}
+
+// GetLoginFailures gets the failed logins of all the clients and accounts, the most recent failures first
+func (db *Database) GetLoginFailures() (failures []model.LoginFailure, err error) {
+	err = db.DB.Select(&failures, "select "+loginFailureColumns+" from LoginFailure order by LastFailureAt desc")
+	return failures, err
+}
+
+// DeleteLoginFailure forgets the failed logins of a client or account, lifting its lockout
+func (db *Database) DeleteLoginFailure(id string) error {
+	res, err := db.DB.Exec(db.DB.Rebind("delete from LoginFailure where Id=?"), id)
+	if err != nil {
+		return err
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return global.ErrLoginFailureNotFound
+	}
+	return nil
+}
+
+// DeleteLoginFailures forgets all the failed logins, lifting every lockout
+func (db *Database) DeleteLoginFailures() error {
+	_, err := db.DB.Exec("delete from LoginFailure")
+	return err
+}
+
+// twoFactorColumns lists the columns of the TwoFactor table in the order of the insert statement
+const twoFactorColumns = "Id, Secret, RecoveryCodes, Confirmed, LastUsedStep, CreatedAt"
+
+// SaveTwoFactor stores the second factor of an admin account, replacing the previous one
+func (db *Database) SaveTwoFactor(twoFactor model.TwoFactor) error {
+	tx, err := db.DB.Beginx()
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(tx.Rebind("delete from TwoFactor where Id=?"), twoFactor.Id)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	_, err = tx.Exec(tx.Rebind("INSERT INTO TwoFactor("+twoFactorColumns+") VALUES(?,?,?,?,?,?)"), twoFactor.Id, twoFactor.Secret, twoFactor.RecoveryCodes, twoFactor.Confirmed, twoFactor.LastUsedStep, twoFactor.CreatedAt.UTC())
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// GetTwoFactor gets the second factor of an admin account
+func (db *Database) GetTwoFactor(id string) (twoFactor model.TwoFactor, err error) {
+	err = db.DB.Get(&twoFactor, db.DB.Rebind("select "+twoFactorColumns+" from TwoFactor where Id=?"), id)
+	if err != nil {
+		if err.Error() == "sql: no rows in result set" {
+			return twoFactor, global.ErrTwoFactorNotFound
+		}
+		return twoFactor, err
+	}
+	return twoFactor, nil
+}
+
+// DeleteTwoFactor deletes the second factor of an admin account, turning the two-factor authentication off for it
+func (db *Database) DeleteTwoFactor(id string) error {
+	res, err := db.DB.Exec(db.DB.Rebind("delete from TwoFactor where Id=?"), id)
+	if err != nil {
+		return err
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return global.ErrTwoFactorNotFound
+	}
+	return nil
+}
+
+// webAuthnCredentialColumns lists the columns of the WebAuthnCredential table in the order of the insert statement
+const webAuthnCredentialColumns = "Id, UserId, UserName, Provider, SiteId, Name, PublicKey, Algorithm, SignCount, CreatedAt, LastUsedAt"
+
+// SaveWebAuthnCredential stores a webauthn credential, replacing the previous version of it
+func (db *Database) SaveWebAuthnCredential(credential model.WebAuthnCredential) error {
+	var lastUsedAt *time.Time
+	if credential.LastUsedAt != nil {
+		utc := credential.LastUsedAt.UTC()
+		lastUsedAt = &utc
+	}
+	tx, err := db.DB.Beginx()
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(tx.Rebind("delete from WebAuthnCredential where Id=?"), credential.Id)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	_, err = tx.Exec(tx.Rebind("INSERT INTO WebAuthnCredential("+webAuthnCredentialColumns+") VALUES(?,?,?,?,?,?,?,?,?,?,?)"), credential.Id, credential.UserId, credential.UserName, credential.Provider, credential.SiteId, credential.Name, credential.PublicKey, credential.Algorithm, credential.SignCount, credential.CreatedAt.UTC(), lastUsedAt)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// GetWebAuthnCredential gets the webauthn credential by id
+func (db *Database) GetWebAuthnCredential(id string) (credential model.WebAuthnCredential, err error) {
+	err = db.DB.Get(&credential, db.DB.Rebind("select "+webAuthnCredentialColumns+" from WebAuthnCredential where Id=?"), id)
+	if err != nil {
+		if err.Error() == "sql: no rows in result set" {
+			return credential, global.ErrWebAuthnCredentialNotFound
+		}
+		return credential, err
+	}
+	return credential, nil
+}
+
+// GetWebAuthnCredentials gets all the webauthn credentials, oldest first
+func (db *Database) GetWebAuthnCredentials() (credentials []model.WebAuthnCredential, err error) {
+	err = db.DB.Select(&credentials, "select "+webAuthnCredentialColumns+" from WebAuthnCredential order by CreatedAt asc")
+	return credentials, err
+}
+
+// DeleteWebAuthnCredential deletes the webauthn credential by id, so it can no longer be logged in with
+func (db *Database) DeleteWebAuthnCredential(id string) error {
+	res, err := db.DB.Exec(db.DB.Rebind("delete from WebAuthnCredential where Id=?"), id)
+	if err != nil {
+		return err
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return global.ErrWebAuthnCredentialNotFound
+	}
+	return nil
+}
+
+// apiTokenColumns lists the columns of the APIToken table in the order of the insert statement
+const apiTokenColumns = "Id, Name, Scopes, UserId, UserName, Provider, SiteId, CreatedAt, ExpiresAt, LastUsedAt"
+
+// SaveAPIToken stores an api token, replacing the previous version of it
+func (db *Database) SaveAPIToken(token model.APIToken) error {
+	var expiresAt, lastUsedAt *time.Time
+	if token.ExpiresAt != nil {
+		utc := token.ExpiresAt.UTC()
+		expiresAt = &utc
+	}
+	if token.LastUsedAt != nil {
+		utc := token.LastUsedAt.UTC()
+		lastUsedAt = &utc
+	}
+	tx, err := db.DB.Beginx()
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(tx.Rebind("delete from APIToken where Id=?"), token.Id)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	_, err = tx.Exec(tx.Rebind("INSERT INTO APIToken("+apiTokenColumns+") VALUES(?,?,?,?,?,?,?,?,?,?)"), token.Id, token.Name, token.Scopes, token.UserId, token.UserName, token.Provider, token.SiteId, token.CreatedAt.UTC(), expiresAt, lastUsedAt)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// GetAPIToken gets the api token by id
+func (db *Database) GetAPIToken(id string) (token model.APIToken, err error) {
+	err = db.DB.Get(&token, db.DB.Rebind("select "+apiTokenColumns+" from APIToken where Id=?"), id)
+	if err != nil {
+		if err.Error() == "sql: no rows in result set" {
+			return token, global.ErrAPITokenNotFound
+		}
+		return token, err
+	}
+	return token, nil
+}
+
+// GetAPITokens gets all the api tokens, oldest first
+func (db *Database) GetAPITokens() (tokens []model.APIToken, err error) {
+	err = db.DB.Select(&tokens, "select "+apiTokenColumns+" from APIToken order by CreatedAt asc")
+	return tokens, err
+}
+
+// DeleteAPIToken deletes the api token by id, revoking it
+func (db *Database) DeleteAPIToken(id string) error {
+	res, err := db.DB.Exec(db.DB.Rebind("delete from APIToken where Id=?"), id)
+	if err != nil {
+		return err
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return global.ErrAPITokenNotFound
+	}
+	return nil
+}
+
 // GetUnderlyingStruct returns the underlying database struct for the driver
 func (db *Database) GetUnderlyingStruct() interface{} {
 	return db
 }
 
+// Close closes the connections to the database
+func (db *Database) Close() error {
+	return db.DB.Close()
+}
+
 // InitializeDatabase runs the queries for an initial database seed
 func (db *Database) InitializeDatabase() error {
 	for _, v := range db.Queries {
 		db.DB.MustExec(v)
 	}
+	for _, v := range db.Migrations {
+		if db.columnExists(v.Table, v.Column) {
+			continue
+		}
+		_, err := db.DB.Exec(v.Query)
+		if err != nil {
+			return fmt.Errorf("Failed to add the column %v to table %v: %v", v.Column, v.Table, err)
+		}
+	}
 	return nil
 }
 
+// columnExists checks if the given table has the given column
+func (db *Database) columnExists(table string, column string) bool {
+	rows, err := db.DB.Query(fmt.Sprintf("SELECT %v FROM %v LIMIT 1", column, table))
+	if err != nil {
+		return false
+	}
+	rows.Close()
+	return true
+}
+
 // GetDatabaseDialect returns the current database dialect
 func (db *Database) GetDatabaseDialect() string {
 	return db.Dialect
@@ -299,13 +1086,13 @@ func (db *Database) CleanupDeleted(olderThan time.Time) error {
 	return nil
 }
 
-// WipeOutData deletes all the threads and comments in the database if the database is a test one
+// WipeOutData deletes all the threads, aliases, comments, audit entries, sites, admin sessions, login failures, second factors, webauthn credentials and api tokens in the database if the database is a test one
 func (db *Database) WipeOutData() error {
 	if !db.IsTest {
 		return nil
 	}
 	if db.Dialect == "postgres" {
-		_, err := db.DB.Exec("truncate table Thread CASCADE")
+		_, err := db.DB.Exec("truncate table Thread, ThreadAlias, AuditEntry, Site, AdminSession, LoginFailure, TwoFactor, WebAuthnCredential, APIToken CASCADE")
 		if err != nil {
 			return err
 		}
@@ -325,6 +1112,38 @@ func (db *Database) WipeOutData() error {
 	if err != nil {
 		return err
 	}
+	_, err = tx.Exec("truncate table ThreadAlias")
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("truncate table AuditEntry")
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("truncate table Site")
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("truncate table AdminSession")
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("truncate table LoginFailure")
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("truncate table TwoFactor")
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("truncate table WebAuthnCredential")
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("truncate table APIToken")
+	if err != nil {
+		return err
+	}
 	_, err = tx.Exec("truncate table Thread")
 	if err != nil {
 		return err
@@ -346,14 +1165,18 @@ func (db *Database) WipeOutData() error {
 // ImportData performs the data import for the given driver
 func (db *Database) ImportData(pathToDump string) error {
 	importThread := func(t model.Thread) error {
-		_, err := db.DB.Exec(db.DB.Rebind("INSERT INTO Thread(Id,Path,CreatedAt) VALUES(?, ?, ?)"), t.Id, t.Path, t.CreatedAt)
+		// dumps made by older versions have no thread state
+		if !t.State.IsValid() {
+			t.State = model.ThreadOpen
+		}
+		_, err := db.DB.Exec(db.DB.Rebind("INSERT INTO Thread(Id,Path,CreatedAt,State,Title,URL,Moderation,MaxCommentLength,SiteId) VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?)"), t.Id, t.Path, t.CreatedAt, t.State, t.Title, t.URL, t.Moderation, t.MaxCommentLength, t.SiteId)
 		if err != nil {
 			return err
 		}
 		return nil
 	}
 	importComment := func(c model.Comment) error {
-		_, err := db.DB.Exec(db.DB.Rebind("INSERT INTO Comment(Id, ThreadId, Body, Author, Confirmed, CreatedAt, ReplyTo, DeletedAt) VALUES(?,?,?,?,?,?,?,?)"), c.Id, c.ThreadId, c.Body, c.Author, c.Confirmed, c.CreatedAt, c.ReplyTo, c.DeletedAt)
+		_, err := db.DB.Exec(db.DB.Rebind("INSERT INTO Comment(Id, ThreadId, Body, Author, Confirmed, CreatedAt, ReplyTo, DeletedAt, Pinned, Featured, Staff) VALUES(?,?,?,?,?,?,?,?,?,?,?)"), c.Id, c.ThreadId, c.Body, c.Author, c.Confirmed, c.CreatedAt, c.ReplyTo, c.DeletedAt, c.Pinned, c.Featured, c.Staff)
 		if err != nil {
 			return err
 		}
@@ -4,6 +4,7 @@ import (
 	"reflect"
 	"testing"
 
+	"github.com/jmoiron/sqlx"
 	"github.com/stretchr/testify/assert"
 	"github.com/vkuznecovas/mouthful/db"
 	"github.com/vkuznecovas/mouthful/db/abstraction"
@@ -86,7 +87,7 @@ func TestSqlxDatawipe(t *testing.T) {
 	assert.Nil(t, err)
 	_, err = testDb.CreateComment(body, author, path, true, nil)
 	assert.Nil(t, err)
-	_, err = testDb.CreateThread("/t")
+	_, err = testDb.CreateThread("/t", nil)
 	assert.Nil(t, err)
 
 	c, err := testDb.GetAllComments()
@@ -106,3 +107,63 @@ func TestSqlxDatawipe(t *testing.T) {
 	assert.Nil(t, err)
 	assert.Len(t, th, 0)
 }
+
+func TestSqliteMigrationsAddMissingColumns(t *testing.T) {
+	database, err := sqlx.Open("sqlite3", ":memory:")
+	assert.Nil(t, err)
+	// the comment table as created by older versions of mouthful
+	database.MustExec(`CREATE TABLE Comment(
+			Id BLOB PRIMARY KEY,
+			ThreadId BLOB not null,
+			Body text not null,
+			Author varchar(255) not null,
+			Confirmed bool not null default false,
+			CreatedAt TIMESTAMP DEFAULT CURRENT_TIMESTAMP not null,
+			ReplyTo BLOB default null,
+			DeletedAt TIMESTAMP DEFAULT null
+		)`)
+	database.MustExec("INSERT INTO Comment(Id, ThreadId, Body, Author, Confirmed) VALUES('a', 'b', 'body', 'author', 1)")
+	database.MustExec(`CREATE TABLE Thread(
+			Id BLOB PRIMARY KEY,
+			CreatedAt TIMESTAMP DEFAULT CURRENT_TIMESTAMP not null,
+			Path varchar(1024) not null UNIQUE
+		)`)
+	database.MustExec("INSERT INTO Thread(Id, Path) VALUES('b', '/')")
+	DB := sqlxDriver.Database{
+		DB:         database,
+		Queries:    sqlite.SqliteQueries,
+		Migrations: sqlite.SqliteMigrations,
+		Dialect:    "sqlite3",
+		IsTest:     true,
+	}
+	err = DB.InitializeDatabase()
+	assert.Nil(t, err)
+	var flags struct {
+		Pinned   bool `db:"Pinned"`
+		Featured bool `db:"Featured"`
+		Staff    bool `db:"Staff"`
+	}
+	err = database.Get(&flags, "SELECT Pinned, Featured, Staff FROM Comment")
+	assert.Nil(t, err)
+	assert.False(t, flags.Pinned)
+	assert.False(t, flags.Featured)
+	assert.False(t, flags.Staff)
+	var thread struct {
+		State            string `db:"State"`
+		Title            string `db:"Title"`
+		URL              string `db:"URL"`
+		Moderation       *bool  `db:"Moderation"`
+		MaxCommentLength *int   `db:"MaxCommentLength"`
+	}
+	err = database.Get(&thread, "SELECT State, Title, URL, Moderation, MaxCommentLength FROM Thread")
+	assert.Nil(t, err)
+	assert.Equal(t, "open", thread.State)
+	assert.Equal(t, "", thread.Title)
+	assert.Equal(t, "", thread.URL)
+	assert.Nil(t, thread.Moderation)
+	assert.Nil(t, thread.MaxCommentLength)
+
+	// running the migrations again is a no-op
+	err = DB.InitializeDatabase()
+	assert.Nil(t, err)
+}
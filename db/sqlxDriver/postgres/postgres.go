@@ -21,7 +21,13 @@ var PostgresQueries = []string{
 	`CREATE TABLE IF NOT EXISTS Thread(
 			Id uuid PRIMARY KEY,
 			CreatedAt TIMESTAMP(6) DEFAULT CURRENT_TIMESTAMP(6) not null,
-			Path varchar(255) not null UNIQUE
+			Path varchar(255) not null UNIQUE,
+			State varchar(16) not null default 'open',
+			Title varchar(255) not null default '',
+			URL varchar(2048) not null default '',
+			Moderation bool default null,
+			MaxCommentLength int default null,
+			SiteId uuid default null
 		)`,
 	`CREATE TABLE IF NOT EXISTS Comment(
 			Id uuid PRIMARY KEY,
@@ -32,8 +38,101 @@ var PostgresQueries = []string{
 			CreatedAt TIMESTAMP(6) DEFAULT CURRENT_TIMESTAMP(6) not null,
 			ReplyTo uuid default null,
 			DeletedAt TIMESTAMP(6) NULL,
+			Pinned bool not null default false,
+			Featured bool not null default false,
+			Staff bool not null default false,
 			FOREIGN KEY(ThreadId) references Thread(Id)
 		)`,
+	`CREATE TABLE IF NOT EXISTS AuditEntry(
+			Id uuid PRIMARY KEY,
+			Action varchar(64) not null,
+			Subject varchar(255) not null,
+			Details text not null,
+			Actor varchar(255) not null,
+			IP varchar(64) not null default '',
+			CreatedAt TIMESTAMP(6) DEFAULT CURRENT_TIMESTAMP(6) not null,
+			SiteId uuid default null
+		)`,
+	`CREATE TABLE IF NOT EXISTS ThreadAlias(
+			Path varchar(255) PRIMARY KEY,
+			ThreadId uuid not null,
+			CreatedAt TIMESTAMP(6) DEFAULT CURRENT_TIMESTAMP(6) not null,
+			FOREIGN KEY(ThreadId) references Thread(Id)
+		)`,
+	`CREATE TABLE IF NOT EXISTS Site(
+			Id uuid PRIMARY KEY,
+			SiteKey varchar(64) not null UNIQUE,
+			Name varchar(255) not null default '',
+			Origins text not null,
+			Moderation bool default null,
+			MaxCommentLength int default null,
+			AdminUserIds text not null,
+			AdminPasswordHash varchar(255) not null default '',
+			CreatedAt TIMESTAMP(6) DEFAULT CURRENT_TIMESTAMP(6) not null
+		)`,
+	`CREATE TABLE IF NOT EXISTS AdminSession(
+			Id uuid PRIMARY KEY,
+			UserId varchar(255) not null,
+			Name varchar(255) not null default '',
+			Provider varchar(64) not null,
+			SiteId uuid default null,
+			CreatedAt TIMESTAMP(6) DEFAULT CURRENT_TIMESTAMP(6) not null,
+			ExpiresAt TIMESTAMP(6) not null
+		)`,
+	`CREATE TABLE IF NOT EXISTS LoginFailure(
+			Id varchar(255) PRIMARY KEY,
+			Failures int not null,
+			LastFailureAt TIMESTAMP(6) not null,
+			BlockedUntil TIMESTAMP(6) not null
+		)`,
+	`CREATE TABLE IF NOT EXISTS TwoFactor(
+			Id varchar(255) PRIMARY KEY,
+			Secret text not null,
+			RecoveryCodes text not null,
+			Confirmed bool not null default false,
+			LastUsedStep bigint not null default 0,
+			CreatedAt TIMESTAMP(6) DEFAULT CURRENT_TIMESTAMP(6) not null
+		)`,
+	`CREATE TABLE IF NOT EXISTS WebAuthnCredential(
+			Id varchar(255) PRIMARY KEY,
+			UserId varchar(255) not null,
+			UserName varchar(255) not null default '',
+			Provider varchar(64) not null,
+			SiteId uuid default null,
+			Name varchar(255) not null default '',
+			PublicKey text not null,
+			Algorithm int not null,
+			SignCount bigint not null default 0,
+			CreatedAt TIMESTAMP(6) DEFAULT CURRENT_TIMESTAMP(6) not null,
+			LastUsedAt TIMESTAMP(6) default null
+		)`,
+	`CREATE TABLE IF NOT EXISTS APIToken(
+			Id varchar(64) PRIMARY KEY,
+			Name varchar(255) not null default '',
+			Scopes varchar(255) not null,
+			UserId varchar(255) not null,
+			UserName varchar(255) not null default '',
+			Provider varchar(64) not null,
+			SiteId uuid default null,
+			CreatedAt TIMESTAMP(6) DEFAULT CURRENT_TIMESTAMP(6) not null,
+			ExpiresAt TIMESTAMP(6) default null,
+			LastUsedAt TIMESTAMP(6) default null
+		)`,
+}
+
+// PostgresMigrations represents a list of columns added to the tables after their initial creation in Postgres
+var PostgresMigrations = []sqlxDriver.Migration{
+	{Table: "Comment", Column: "Pinned", Query: "ALTER TABLE Comment ADD COLUMN Pinned bool not null default false"},
+	{Table: "Comment", Column: "Featured", Query: "ALTER TABLE Comment ADD COLUMN Featured bool not null default false"},
+	{Table: "Comment", Column: "Staff", Query: "ALTER TABLE Comment ADD COLUMN Staff bool not null default false"},
+	{Table: "Thread", Column: "State", Query: "ALTER TABLE Thread ADD COLUMN State varchar(16) not null default 'open'"},
+	{Table: "Thread", Column: "Title", Query: "ALTER TABLE Thread ADD COLUMN Title varchar(255) not null default ''"},
+	{Table: "Thread", Column: "URL", Query: "ALTER TABLE Thread ADD COLUMN URL varchar(2048) not null default ''"},
+	{Table: "Thread", Column: "Moderation", Query: "ALTER TABLE Thread ADD COLUMN Moderation bool default null"},
+	{Table: "Thread", Column: "MaxCommentLength", Query: "ALTER TABLE Thread ADD COLUMN MaxCommentLength int default null"},
+	{Table: "Thread", Column: "SiteId", Query: "ALTER TABLE Thread ADD COLUMN SiteId uuid default null"},
+	{Table: "AuditEntry", Column: "SiteId", Query: "ALTER TABLE AuditEntry ADD COLUMN SiteId uuid default null"},
+	{Table: "AuditEntry", Column: "IP", Query: "ALTER TABLE AuditEntry ADD COLUMN IP varchar(64) not null default ''"},
 }
 
 // ValidateConfig validates the config for mysql
@@ -89,10 +188,11 @@ func CreateDatabase(databaseConfig model.Database) (abstraction.Database, error)
 		},
 	)
 	DB := sqlxDriver.Database{
-		DB:      db,
-		Queries: PostgresQueries,
-		Dialect: "postgres",
-		IsTest:  false,
+		DB:         db,
+		Queries:    PostgresQueries,
+		Migrations: PostgresMigrations,
+		Dialect:    "postgres",
+		IsTest:     false,
 	}
 	err = DB.InitializeDatabase()
 	if err != nil {
@@ -114,10 +214,11 @@ func CreateTestDatabase() abstraction.Database {
 		},
 	)
 	DB := sqlxDriver.Database{
-		DB:      db,
-		Queries: PostgresQueries,
-		Dialect: "postgres",
-		IsTest:  true,
+		DB:         db,
+		Queries:    PostgresQueries,
+		Migrations: PostgresMigrations,
+		Dialect:    "postgres",
+		IsTest:     true,
 	}
 	db.DB.SetMaxOpenConns(1)
 	err = DB.InitializeDatabase()
@@ -23,7 +23,13 @@ var SqliteQueries = []string{
 	`CREATE TABLE IF NOT EXISTS Thread(
 			Id BLOB PRIMARY KEY,
 			CreatedAt TIMESTAMP DEFAULT CURRENT_TIMESTAMP not null,
-			Path varchar(1024) not null UNIQUE
+			Path varchar(1024) not null UNIQUE,
+			State varchar(16) not null default 'open',
+			Title varchar(255) not null default '',
+			URL varchar(2048) not null default '',
+			Moderation bool default null,
+			MaxCommentLength int default null,
+			SiteId BLOB default null
 		)`,
 	`CREATE TABLE IF NOT EXISTS Comment(
 			Id BLOB PRIMARY KEY,
@@ -34,8 +40,101 @@ var SqliteQueries = []string{
 			CreatedAt TIMESTAMP DEFAULT CURRENT_TIMESTAMP not null,
 			ReplyTo BLOB default null,
 			DeletedAt TIMESTAMP DEFAULT null,
+			Pinned bool not null default false,
+			Featured bool not null default false,
+			Staff bool not null default false,
 			FOREIGN KEY(ThreadId) references Thread(Id)
 		)`,
+	`CREATE TABLE IF NOT EXISTS AuditEntry(
+			Id BLOB PRIMARY KEY,
+			Action varchar(64) not null,
+			Subject varchar(255) not null,
+			Details text not null,
+			Actor varchar(255) not null,
+			IP varchar(64) not null default '',
+			CreatedAt TIMESTAMP DEFAULT CURRENT_TIMESTAMP not null,
+			SiteId BLOB default null
+		)`,
+	`CREATE TABLE IF NOT EXISTS ThreadAlias(
+			Path varchar(1024) PRIMARY KEY,
+			ThreadId BLOB not null,
+			CreatedAt TIMESTAMP DEFAULT CURRENT_TIMESTAMP not null,
+			FOREIGN KEY(ThreadId) references Thread(Id)
+		)`,
+	`CREATE TABLE IF NOT EXISTS Site(
+			Id BLOB PRIMARY KEY,
+			SiteKey varchar(64) not null UNIQUE,
+			Name varchar(255) not null default '',
+			Origins text not null,
+			Moderation bool default null,
+			MaxCommentLength int default null,
+			AdminUserIds text not null,
+			AdminPasswordHash varchar(255) not null default '',
+			CreatedAt TIMESTAMP DEFAULT CURRENT_TIMESTAMP not null
+		)`,
+	`CREATE TABLE IF NOT EXISTS AdminSession(
+			Id BLOB PRIMARY KEY,
+			UserId varchar(255) not null,
+			Name varchar(255) not null default '',
+			Provider varchar(64) not null,
+			SiteId BLOB default null,
+			CreatedAt TIMESTAMP DEFAULT CURRENT_TIMESTAMP not null,
+			ExpiresAt TIMESTAMP not null
+		)`,
+	`CREATE TABLE IF NOT EXISTS LoginFailure(
+			Id varchar(255) PRIMARY KEY,
+			Failures int not null,
+			LastFailureAt TIMESTAMP not null,
+			BlockedUntil TIMESTAMP not null
+		)`,
+	`CREATE TABLE IF NOT EXISTS TwoFactor(
+			Id varchar(255) PRIMARY KEY,
+			Secret text not null,
+			RecoveryCodes text not null,
+			Confirmed bool not null default false,
+			LastUsedStep bigint not null default 0,
+			CreatedAt TIMESTAMP DEFAULT CURRENT_TIMESTAMP not null
+		)`,
+	`CREATE TABLE IF NOT EXISTS WebAuthnCredential(
+			Id varchar(255) PRIMARY KEY,
+			UserId varchar(255) not null,
+			UserName varchar(255) not null default '',
+			Provider varchar(64) not null,
+			SiteId BLOB default null,
+			Name varchar(255) not null default '',
+			PublicKey text not null,
+			Algorithm int not null,
+			SignCount bigint not null default 0,
+			CreatedAt TIMESTAMP DEFAULT CURRENT_TIMESTAMP not null,
+			LastUsedAt TIMESTAMP default null
+		)`,
+	`CREATE TABLE IF NOT EXISTS APIToken(
+			Id varchar(64) PRIMARY KEY,
+			Name varchar(255) not null default '',
+			Scopes varchar(255) not null,
+			UserId varchar(255) not null,
+			UserName varchar(255) not null default '',
+			Provider varchar(64) not null,
+			SiteId BLOB default null,
+			CreatedAt TIMESTAMP DEFAULT CURRENT_TIMESTAMP not null,
+			ExpiresAt TIMESTAMP default null,
+			LastUsedAt TIMESTAMP default null
+		)`,
+}
+
+// SqliteMigrations represents a list of columns added to the tables after their initial creation in sqlite
+var SqliteMigrations = []sqlxDriver.Migration{
+	{Table: "Comment", Column: "Pinned", Query: "ALTER TABLE Comment ADD COLUMN Pinned bool not null default false"},
+	{Table: "Comment", Column: "Featured", Query: "ALTER TABLE Comment ADD COLUMN Featured bool not null default false"},
+	{Table: "Comment", Column: "Staff", Query: "ALTER TABLE Comment ADD COLUMN Staff bool not null default false"},
+	{Table: "Thread", Column: "State", Query: "ALTER TABLE Thread ADD COLUMN State varchar(16) not null default 'open'"},
+	{Table: "Thread", Column: "Title", Query: "ALTER TABLE Thread ADD COLUMN Title varchar(255) not null default ''"},
+	{Table: "Thread", Column: "URL", Query: "ALTER TABLE Thread ADD COLUMN URL varchar(2048) not null default ''"},
+	{Table: "Thread", Column: "Moderation", Query: "ALTER TABLE Thread ADD COLUMN Moderation bool default null"},
+	{Table: "Thread", Column: "MaxCommentLength", Query: "ALTER TABLE Thread ADD COLUMN MaxCommentLength int default null"},
+	{Table: "Thread", Column: "SiteId", Query: "ALTER TABLE Thread ADD COLUMN SiteId BLOB default null"},
+	{Table: "AuditEntry", Column: "SiteId", Query: "ALTER TABLE AuditEntry ADD COLUMN SiteId BLOB default null"},
+	{Table: "AuditEntry", Column: "IP", Query: "ALTER TABLE AuditEntry ADD COLUMN IP varchar(64) not null default ''"},
 }
 
 // ValidateConfig validates the config for sqlite
@@ -75,9 +174,10 @@ func CreateDatabase(databaseConfig model.Database) (abstraction.Database, error)
 		db = d
 	}
 	DB := sqlxDriver.Database{
-		DB:      db,
-		Queries: SqliteQueries,
-		Dialect: "sqlite3",
+		DB:         db,
+		Queries:    SqliteQueries,
+		Migrations: SqliteMigrations,
+		Dialect:    "sqlite3",
 	}
 	err = DB.InitializeDatabase()
 	if err != nil {
@@ -104,10 +204,11 @@ func CreateTestDatabase() abstraction.Database {
 		panic(err)
 	}
 	DB := sqlxDriver.Database{
-		DB:      db,
-		Queries: SqliteQueries,
-		Dialect: "sqlite3",
-		IsTest:  true,
+		DB:         db,
+		Queries:    SqliteQueries,
+		Migrations: SqliteMigrations,
+		Dialect:    "sqlite3",
+		IsTest:     true,
 	}
 	err = DB.InitializeDatabase()
 	if err != nil {
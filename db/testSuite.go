@@ -5,12 +5,14 @@ import (
 	"encoding/json"
 	"os"
 	"reflect"
+	"strconv"
 	"strings"
 	"testing"
 	"time"
 
 	"github.com/vkuznecovas/mouthful/global"
 
+	"github.com/gofrs/uuid"
 	"github.com/stretchr/testify/assert"
 	"github.com/vkuznecovas/mouthful/db/abstraction"
 	"github.com/vkuznecovas/mouthful/db/model"
@@ -36,7 +38,7 @@ type TestSuite struct {
 
 // CreateThread checks if a thread is correctly created
 func (ts TestSuite) CreateThread(t *testing.T, database abstraction.Database) {
-	uid, err := database.CreateThread("/test")
+	uid, err := database.CreateThread("/test", nil)
 	assert.Nil(t, err)
 	assert.NotNil(t, uid)
 	thread, err := database.GetThread("/test")
@@ -47,17 +49,17 @@ func (ts TestSuite) CreateThread(t *testing.T, database abstraction.Database) {
 
 // CreateThreadUniqueViolation checks if duplicate thread creation throws no errors
 func (ts TestSuite) CreateThreadUniqueViolation(t *testing.T, database abstraction.Database) {
-	uid, err := database.CreateThread("/test")
+	uid, err := database.CreateThread("/test", nil)
 	assert.Nil(t, err)
 	assert.NotNil(t, uid)
-	uidNew, err := database.CreateThread("/test")
+	uidNew, err := database.CreateThread("/test", nil)
 	assert.Nil(t, err)
 	assert.True(t, bytes.Equal(uid.Bytes(), uidNew.Bytes()))
 }
 
 // GetThread checks if a created thread is gotten alright
 func (ts TestSuite) GetThread(t *testing.T, database abstraction.Database) {
-	uid, err := database.CreateThread("/test")
+	uid, err := database.CreateThread("/test", nil)
 	assert.Nil(t, err)
 	assert.NotNil(t, uid)
 	thread, err := database.GetThread("/test")
@@ -73,6 +75,688 @@ func (ts TestSuite) GetThreadNotFound(t *testing.T, database abstraction.Databas
 	assert.Equal(t, global.ErrThreadNotFound, err)
 }
 
+// GetThreadById checks if a created thread is gotten alright by its id
+func (ts TestSuite) GetThreadById(t *testing.T, database abstraction.Database) {
+	uid, err := database.CreateThread("/test", nil)
+	assert.Nil(t, err)
+	assert.NotNil(t, uid)
+	thread, err := database.GetThreadById(*uid)
+	assert.Nil(t, err)
+	assert.True(t, bytes.Equal(uid.Bytes(), thread.Id.Bytes()))
+	assert.Equal(t, "/test", thread.Path)
+}
+
+// GetThreadByIdNotFound asserts that we correctly get a response saying we're not finding the thread
+func (ts TestSuite) GetThreadByIdNotFound(t *testing.T, database abstraction.Database) {
+	_, err := database.GetThreadById(global.GetUUID())
+	assert.NotNil(t, err)
+	assert.Equal(t, global.ErrThreadNotFound, err)
+}
+
+// SetThreadState checks that new threads are open and their state can be changed
+func (ts TestSuite) SetThreadState(t *testing.T, database abstraction.Database) {
+	uid, err := database.CreateThread("/test", nil)
+	assert.Nil(t, err)
+	thread, err := database.GetThread("/test")
+	assert.Nil(t, err)
+	assert.Equal(t, model.ThreadOpen, thread.State)
+	for _, state := range []model.ThreadState{model.ThreadLocked, model.ThreadArchived, model.ThreadOpen} {
+		err = database.SetThreadState(*uid, state)
+		assert.Nil(t, err)
+		thread, err = database.GetThreadById(*uid)
+		assert.Nil(t, err)
+		assert.Equal(t, state, thread.State)
+	}
+	// setting the same state again is fine
+	err = database.SetThreadState(*uid, model.ThreadOpen)
+	assert.Nil(t, err)
+}
+
+// SetThreadStateNotFound asserts that changing the state of a missing thread fails
+func (ts TestSuite) SetThreadStateNotFound(t *testing.T, database abstraction.Database) {
+	err := database.SetThreadState(global.GetUUID(), model.ThreadLocked)
+	assert.Equal(t, global.ErrThreadNotFound, err)
+}
+
+// UpdateThread checks that the title, url and per-thread settings are stored and can be cleared
+func (ts TestSuite) UpdateThread(t *testing.T, database abstraction.Database) {
+	uid, err := database.CreateThread("/test", nil)
+	assert.Nil(t, err)
+	thread, err := database.GetThreadById(*uid)
+	assert.Nil(t, err)
+	assert.Equal(t, "", thread.Title)
+	assert.Equal(t, "", thread.URL)
+	assert.Nil(t, thread.Moderation)
+	assert.Nil(t, thread.MaxCommentLength)
+
+	moderation := false
+	maxCommentLength := 100
+	thread.Title = "Title"
+	thread.URL = "https://example.com/test"
+	thread.Moderation = &moderation
+	thread.MaxCommentLength = &maxCommentLength
+	// the path and the state are not changed by updates
+	thread.Path = "/other"
+	thread.State = model.ThreadLocked
+	err = database.UpdateThread(thread)
+	assert.Nil(t, err)
+	updated, err := database.GetThread("/test")
+	assert.Nil(t, err)
+	assert.Equal(t, "Title", updated.Title)
+	assert.Equal(t, "https://example.com/test", updated.URL)
+	assert.NotNil(t, updated.Moderation)
+	assert.False(t, *updated.Moderation)
+	assert.NotNil(t, updated.MaxCommentLength)
+	assert.Equal(t, 100, *updated.MaxCommentLength)
+	assert.Equal(t, model.ThreadOpen, updated.State)
+	threads, err := database.GetAllThreads()
+	assert.Nil(t, err)
+	assert.Len(t, threads, 1)
+	assert.Equal(t, "Title", threads[0].Title)
+
+	updated.Title = ""
+	updated.URL = ""
+	updated.Moderation = nil
+	updated.MaxCommentLength = nil
+	err = database.UpdateThread(updated)
+	assert.Nil(t, err)
+	cleared, err := database.GetThreadById(*uid)
+	assert.Nil(t, err)
+	assert.Equal(t, "", cleared.Title)
+	assert.Equal(t, "", cleared.URL)
+	assert.Nil(t, cleared.Moderation)
+	assert.Nil(t, cleared.MaxCommentLength)
+
+	err = database.UpdateThread(model.Thread{Id: global.GetUUID()})
+	assert.Equal(t, global.ErrThreadNotFound, err)
+}
+
+// RenameThread checks that renaming a thread keeps its comments
+func (ts TestSuite) RenameThread(t *testing.T, database abstraction.Database) {
+	_, err := database.CreateComment("body", "author", "/old", true, nil)
+	assert.Nil(t, err)
+	thread, err := database.GetThread("/old")
+	assert.Nil(t, err)
+	err = database.RenameThread(thread.Id, "/new")
+	assert.Nil(t, err)
+	_, err = database.GetThread("/old")
+	assert.Equal(t, global.ErrThreadNotFound, err)
+	renamed, err := database.GetThread("/new")
+	assert.Nil(t, err)
+	assert.True(t, bytes.Equal(thread.Id.Bytes(), renamed.Id.Bytes()))
+	comments, err := database.GetCommentsByThread("/new")
+	assert.Nil(t, err)
+	assert.Len(t, comments, 1)
+	// renaming to the same path is a no-op
+	err = database.RenameThread(thread.Id, "/new")
+	assert.Nil(t, err)
+}
+
+// RenameThreadConflicts asserts that a thread can't be renamed to a path taken by another thread, or renamed if missing
+func (ts TestSuite) RenameThreadConflicts(t *testing.T, database abstraction.Database) {
+	uid, err := database.CreateThread("/first", nil)
+	assert.Nil(t, err)
+	_, err = database.CreateThread("/second", nil)
+	assert.Nil(t, err)
+	err = database.RenameThread(*uid, "/second")
+	assert.Equal(t, global.ErrThreadAlreadyExists, err)
+	thread, err := database.GetThread("/first")
+	assert.Nil(t, err)
+	assert.True(t, bytes.Equal(uid.Bytes(), thread.Id.Bytes()))
+	err = database.RenameThread(global.GetUUID(), "/third")
+	assert.Equal(t, global.ErrThreadNotFound, err)
+}
+
+// MergeThreads checks that merging moves the comments and their replies to the target thread and deletes the source thread
+func (ts TestSuite) MergeThreads(t *testing.T, database abstraction.Database) {
+	parent, err := database.CreateComment("parent", "author", "/source", true, nil)
+	assert.Nil(t, err)
+	_, err = database.CreateComment("reply", "author", "/source", true, parent)
+	assert.Nil(t, err)
+	pending, err := database.CreateComment("pending", "author", "/source", false, nil)
+	assert.Nil(t, err)
+	_, err = database.CreateComment("target", "author", "/target", true, nil)
+	assert.Nil(t, err)
+	source, err := database.GetThread("/source")
+	assert.Nil(t, err)
+	target, err := database.GetThread("/target")
+	assert.Nil(t, err)
+
+	err = database.MergeThreads(source.Id, source.Id)
+	assert.Equal(t, global.ErrCantMergeThreadIntoItself, err)
+	err = database.MergeThreads(source.Id, global.GetUUID())
+	assert.Equal(t, global.ErrThreadNotFound, err)
+	err = database.MergeThreads(source.Id, target.Id)
+	assert.Nil(t, err)
+
+	_, err = database.GetThreadById(source.Id)
+	assert.Equal(t, global.ErrThreadNotFound, err)
+	comments, err := database.GetCommentsByThread("/target")
+	assert.Nil(t, err)
+	assert.Len(t, comments, 3)
+	for _, v := range comments {
+		if v.Body == "reply" {
+			assert.True(t, bytes.Equal(parent.Bytes(), v.ReplyTo.Bytes()))
+		}
+	}
+	comment, err := database.GetComment(*pending)
+	assert.Nil(t, err)
+	assert.True(t, bytes.Equal(target.Id.Bytes(), comment.ThreadId.Bytes()))
+}
+
+// DeleteThread checks that deleting a thread deletes all its comments, leaving the other threads alone
+func (ts TestSuite) DeleteThread(t *testing.T, database abstraction.Database) {
+	parent, err := database.CreateComment("parent", "author", "/deleted", true, nil)
+	assert.Nil(t, err)
+	_, err = database.CreateComment("reply", "author", "/deleted", true, parent)
+	assert.Nil(t, err)
+	_, err = database.CreateComment("other", "author", "/other", true, nil)
+	assert.Nil(t, err)
+	thread, err := database.GetThread("/deleted")
+	assert.Nil(t, err)
+	err = database.DeleteThread(thread.Id)
+	assert.Nil(t, err)
+	_, err = database.GetThread("/deleted")
+	assert.Equal(t, global.ErrThreadNotFound, err)
+	_, err = database.GetComment(*parent)
+	assert.Equal(t, global.ErrCommentNotFound, err)
+	comments, err := database.GetAllComments()
+	assert.Nil(t, err)
+	assert.Len(t, comments, 1)
+	err = database.DeleteThread(thread.Id)
+	assert.Equal(t, global.ErrThreadNotFound, err)
+}
+
+// ThreadAliases checks that aliases are created, fetched and deleted
+func (ts TestSuite) ThreadAliases(t *testing.T, database abstraction.Database) {
+	uid, err := database.CreateThread("/post", nil)
+	assert.Nil(t, err)
+	err = database.CreateThreadAlias("/post-alias", *uid)
+	assert.Nil(t, err)
+	err = database.CreateThreadAlias("/another-alias", *uid)
+	assert.Nil(t, err)
+	alias, err := database.GetThreadAlias("/post-alias")
+	assert.Nil(t, err)
+	assert.Equal(t, "/post-alias", alias.Path)
+	assert.Equal(t, *uid, alias.ThreadId)
+	aliases, err := database.GetAllThreadAliases()
+	assert.Nil(t, err)
+	assert.Len(t, aliases, 2)
+	assert.Equal(t, "/another-alias", aliases[0].Path)
+	err = database.DeleteThreadAlias("/post-alias")
+	assert.Nil(t, err)
+	_, err = database.GetThreadAlias("/post-alias")
+	assert.Equal(t, global.ErrThreadAliasNotFound, err)
+	err = database.DeleteThreadAlias("/post-alias")
+	assert.Equal(t, global.ErrThreadAliasNotFound, err)
+}
+
+// ThreadAliasConflicts checks that aliases can't shadow threads or other aliases and must point to existing threads
+func (ts TestSuite) ThreadAliasConflicts(t *testing.T, database abstraction.Database) {
+	first, err := database.CreateThread("/first", nil)
+	assert.Nil(t, err)
+	second, err := database.CreateThread("/second", nil)
+	assert.Nil(t, err)
+	err = database.CreateThreadAlias("/second", *first)
+	assert.Equal(t, global.ErrThreadAlreadyExists, err)
+	err = database.CreateThreadAlias("/alias", global.GetUUID())
+	assert.Equal(t, global.ErrThreadNotFound, err)
+	err = database.CreateThreadAlias("/alias", *first)
+	assert.Nil(t, err)
+	err = database.CreateThreadAlias("/alias", *second)
+	assert.Equal(t, global.ErrThreadAliasAlreadyExists, err)
+}
+
+// ThreadAliasesFollowMergeAndDelete checks that merging moves the aliases to the target thread and deleting removes them
+func (ts TestSuite) ThreadAliasesFollowMergeAndDelete(t *testing.T, database abstraction.Database) {
+	source, err := database.CreateThread("/source", nil)
+	assert.Nil(t, err)
+	target, err := database.CreateThread("/target", nil)
+	assert.Nil(t, err)
+	err = database.CreateThreadAlias("/source-alias", *source)
+	assert.Nil(t, err)
+	err = database.MergeThreads(*source, *target)
+	assert.Nil(t, err)
+	alias, err := database.GetThreadAlias("/source-alias")
+	assert.Nil(t, err)
+	assert.Equal(t, *target, alias.ThreadId)
+	err = database.DeleteThread(*target)
+	assert.Nil(t, err)
+	aliases, err := database.GetAllThreadAliases()
+	assert.Nil(t, err)
+	assert.Len(t, aliases, 0)
+}
+
+// AuditEntries checks that audit entries are stored and returned newest first, a page at a time
+func (ts TestSuite) AuditEntries(t *testing.T, database abstraction.Database) {
+	entries, err := database.GetAuditEntries(model.AuditQuery{})
+	assert.Nil(t, err)
+	assert.Len(t, entries, 0)
+	siteId := global.GetUUID()
+	err = database.CreateAuditEntry(model.AuditThreadRename, "subject", "first", "password:admin", "127.0.0.1", nil)
+	assert.Nil(t, err)
+	err = database.CreateAuditEntry(model.AuditThreadDelete, "subject", "second", "password:admin", "127.0.0.1", &siteId)
+	assert.Nil(t, err)
+	entries, err = database.GetAuditEntries(model.AuditQuery{})
+	assert.Nil(t, err)
+	assert.Len(t, entries, 2)
+	assert.Equal(t, "second", entries[0].Details)
+	assert.Equal(t, model.AuditThreadDelete, entries[0].Action)
+	assert.Equal(t, "first", entries[1].Details)
+	assert.Equal(t, "subject", entries[1].Subject)
+	assert.Equal(t, "password:admin", entries[1].Actor)
+	assert.Equal(t, "127.0.0.1", entries[1].IP)
+	assert.Equal(t, siteId, *entries[0].SiteId)
+	assert.Nil(t, entries[1].SiteId)
+
+	entries, err = database.GetAuditEntries(model.AuditQuery{SiteId: &siteId})
+	assert.Nil(t, err)
+	assert.Len(t, entries, 1)
+	assert.Equal(t, "second", entries[0].Details)
+
+	// the pages follow each other without gaps or overlaps
+	for i := 0; i < 3; i++ {
+		err = database.CreateAuditEntry(model.AuditThreadRename, "subject", strconv.Itoa(i), "password:admin", "127.0.0.1", nil)
+		assert.Nil(t, err)
+	}
+	all, err := database.GetAuditEntries(model.AuditQuery{})
+	assert.Nil(t, err)
+	assert.Len(t, all, 5)
+	paged := make([]model.AuditEntry, 0)
+	query := model.AuditQuery{Limit: 2}
+	for {
+		entries, err = database.GetAuditEntries(query)
+		assert.Nil(t, err)
+		assert.True(t, len(entries) <= 2)
+		if len(entries) == 0 {
+			break
+		}
+		paged = append(paged, entries...)
+		last := entries[len(entries)-1]
+		query.Before = &last.CreatedAt
+		query.BeforeId = &last.Id
+	}
+	assert.Len(t, paged, len(all))
+	for i := range all {
+		assert.Equal(t, all[i].Id, paged[i].Id)
+	}
+}
+
+// Sites checks that sites are created, listed, updated and deleted
+func (ts TestSuite) Sites(t *testing.T, database abstraction.Database) {
+	sites, err := database.GetSites()
+	assert.Nil(t, err)
+	assert.Len(t, sites, 0)
+	moderation := false
+	maxLength := 100
+	id, err := database.CreateSite(model.Site{
+		Key:               "blog",
+		Name:              "Blog",
+		Origins:           model.StringList{"https://blog.example.com", "https://www.blog.example.com"},
+		Moderation:        &moderation,
+		MaxCommentLength:  &maxLength,
+		AdminUserIds:      model.StringList{"github:12345"},
+		AdminPasswordHash: "hash",
+	})
+	assert.Nil(t, err)
+	assert.NotNil(t, id)
+	_, err = database.CreateSite(model.Site{Key: "docs"})
+	assert.Nil(t, err)
+	_, err = database.CreateSite(model.Site{Key: "blog"})
+	assert.Equal(t, global.ErrSiteAlreadyExists, err)
+
+	site, err := database.GetSite(*id)
+	assert.Nil(t, err)
+	assert.Equal(t, "blog", site.Key)
+	assert.Equal(t, "Blog", site.Name)
+	assert.Equal(t, model.StringList{"https://blog.example.com", "https://www.blog.example.com"}, site.Origins)
+	assert.False(t, *site.Moderation)
+	assert.Equal(t, 100, *site.MaxCommentLength)
+	assert.Equal(t, model.StringList{"github:12345"}, site.AdminUserIds)
+	assert.Equal(t, "hash", site.AdminPasswordHash)
+
+	sites, err = database.GetSites()
+	assert.Nil(t, err)
+	assert.Len(t, sites, 2)
+	assert.Equal(t, "blog", sites[0].Key)
+	assert.Equal(t, "docs", sites[1].Key)
+	assert.Len(t, sites[1].Origins, 0)
+	assert.Nil(t, sites[1].Moderation)
+
+	site.Name = "The blog"
+	site.Origins = model.StringList{"https://blog.example.com"}
+	site.Moderation = nil
+	site.AdminUserIds = nil
+	err = database.UpdateSite(site)
+	assert.Nil(t, err)
+	site, err = database.GetSite(*id)
+	assert.Nil(t, err)
+	assert.Equal(t, "The blog", site.Name)
+	assert.Equal(t, model.StringList{"https://blog.example.com"}, site.Origins)
+	assert.Nil(t, site.Moderation)
+	assert.Len(t, site.AdminUserIds, 0)
+
+	err = database.UpdateSite(model.Site{Id: global.GetUUID()})
+	assert.Equal(t, global.ErrSiteNotFound, err)
+	_, err = database.GetSite(global.GetUUID())
+	assert.Equal(t, global.ErrSiteNotFound, err)
+	err = database.DeleteSite(global.GetUUID())
+	assert.Equal(t, global.ErrSiteNotFound, err)
+}
+
+// SiteThreads checks that threads keep their site and that sites with threads can't be deleted
+func (ts TestSuite) AdminSessions(t *testing.T, database abstraction.Database) {
+	sessions, err := database.GetAdminSessions()
+	assert.Nil(t, err)
+	assert.Len(t, sessions, 0)
+	siteId := global.GetUUID()
+	now := time.Now().UTC()
+	session := model.AdminSession{
+		Id:        global.GetUUID(),
+		UserId:    "12345",
+		Name:      "someone",
+		Provider:  "github",
+		SiteId:    &siteId,
+		CreatedAt: now,
+		ExpiresAt: now.Add(time.Hour),
+	}
+	err = database.CreateAdminSession(session)
+	assert.Nil(t, err)
+	expired := model.AdminSession{
+		Id:        global.GetUUID(),
+		UserId:    "admin",
+		Provider:  "password",
+		CreatedAt: now.Add(-2 * time.Hour),
+		ExpiresAt: now.Add(-time.Hour),
+	}
+	err = database.CreateAdminSession(expired)
+	assert.Nil(t, err)
+
+	stored, err := database.GetAdminSession(session.Id)
+	assert.Nil(t, err)
+	assert.Equal(t, "12345", stored.UserId)
+	assert.Equal(t, "someone", stored.Name)
+	assert.Equal(t, "github", stored.Provider)
+	assert.Equal(t, siteId, *stored.SiteId)
+	assert.WithinDuration(t, session.ExpiresAt, stored.ExpiresAt, time.Second)
+
+	sessions, err = database.GetAdminSessions()
+	assert.Nil(t, err)
+	assert.Len(t, sessions, 2)
+	assert.Equal(t, session.Id, sessions[0].Id)
+	assert.Nil(t, sessions[1].SiteId)
+
+	err = database.DeleteExpiredAdminSessions()
+	assert.Nil(t, err)
+	_, err = database.GetAdminSession(expired.Id)
+	assert.Equal(t, global.ErrSessionNotFound, err)
+
+	err = database.DeleteAdminSession(session.Id)
+	assert.Nil(t, err)
+	err = database.DeleteAdminSession(session.Id)
+	assert.Equal(t, global.ErrSessionNotFound, err)
+	_, err = database.GetAdminSession(session.Id)
+	assert.Equal(t, global.ErrSessionNotFound, err)
+}
+
+func (ts TestSuite) LoginFailures(t *testing.T, database abstraction.Database) {
+	failures, err := database.GetLoginFailures()
+	assert.Nil(t, err)
+	assert.Len(t, failures, 0)
+	_, err = database.GetLoginFailure("ip:127.0.0.1")
+	assert.Equal(t, global.ErrLoginFailureNotFound, err)
+
+	now := time.Now().UTC()
+	failure := model.LoginFailure{Id: "ip:127.0.0.1", Failures: 1, LastFailureAt: now.Add(-time.Minute), BlockedUntil: now}
+	err = database.SaveLoginFailure(failure)
+	assert.Nil(t, err)
+	failure.Failures = 2
+	failure.LastFailureAt = now
+	failure.BlockedUntil = now.Add(time.Minute)
+	err = database.SaveLoginFailure(failure)
+	assert.Nil(t, err)
+	err = database.SaveLoginFailure(model.LoginFailure{Id: "account:admin", Failures: 1, LastFailureAt: now.Add(-time.Hour), BlockedUntil: now.Add(-time.Hour)})
+	assert.Nil(t, err)
+
+	stored, err := database.GetLoginFailure("ip:127.0.0.1")
+	assert.Nil(t, err)
+	assert.Equal(t, 2, stored.Failures)
+	assert.WithinDuration(t, failure.LastFailureAt, stored.LastFailureAt, time.Second)
+	assert.WithinDuration(t, failure.BlockedUntil, stored.BlockedUntil, time.Second)
+
+	failures, err = database.GetLoginFailures()
+	assert.Nil(t, err)
+	assert.Len(t, failures, 2)
+	assert.Equal(t, "ip:127.0.0.1", failures[0].Id)
+	assert.Equal(t, "account:admin", failures[1].Id)
+
+	err = database.DeleteLoginFailure("ip:127.0.0.1")
+	assert.Nil(t, err)
+	err = database.DeleteLoginFailure("ip:127.0.0.1")
+	assert.Equal(t, global.ErrLoginFailureNotFound, err)
+
+	err = database.DeleteLoginFailures()
+	assert.Nil(t, err)
+	failures, err = database.GetLoginFailures()
+	assert.Nil(t, err)
+	assert.Len(t, failures, 0)
+}
+
+func (ts TestSuite) TwoFactors(t *testing.T, database abstraction.Database) {
+	_, err := database.GetTwoFactor("admin")
+	assert.Equal(t, global.ErrTwoFactorNotFound, err)
+
+	twoFactor := model.TwoFactor{Id: "admin", Secret: "encrypted", CreatedAt: time.Now().UTC()}
+	err = database.SaveTwoFactor(twoFactor)
+	assert.Nil(t, err)
+	stored, err := database.GetTwoFactor("admin")
+	assert.Nil(t, err)
+	assert.Equal(t, "encrypted", stored.Secret)
+	assert.False(t, stored.Confirmed)
+	assert.Len(t, stored.RecoveryCodes, 0)
+
+	twoFactor.Confirmed = true
+	twoFactor.RecoveryCodes = model.StringList{"first", "second"}
+	twoFactor.LastUsedStep = 54321
+	err = database.SaveTwoFactor(twoFactor)
+	assert.Nil(t, err)
+	err = database.SaveTwoFactor(model.TwoFactor{Id: "site:blog", Secret: "other", CreatedAt: time.Now().UTC()})
+	assert.Nil(t, err)
+	stored, err = database.GetTwoFactor("admin")
+	assert.Nil(t, err)
+	assert.True(t, stored.Confirmed)
+	assert.Equal(t, model.StringList{"first", "second"}, stored.RecoveryCodes)
+	assert.Equal(t, int64(54321), stored.LastUsedStep)
+	assert.WithinDuration(t, twoFactor.CreatedAt, stored.CreatedAt, time.Second)
+
+	err = database.DeleteTwoFactor("admin")
+	assert.Nil(t, err)
+	err = database.DeleteTwoFactor("admin")
+	assert.Equal(t, global.ErrTwoFactorNotFound, err)
+	_, err = database.GetTwoFactor("admin")
+	assert.Equal(t, global.ErrTwoFactorNotFound, err)
+	stored, err = database.GetTwoFactor("site:blog")
+	assert.Nil(t, err)
+	assert.Equal(t, "other", stored.Secret)
+}
+
+func (ts TestSuite) WebAuthnCredentials(t *testing.T, database abstraction.Database) {
+	_, err := database.GetWebAuthnCredential("credential")
+	assert.Equal(t, global.ErrWebAuthnCredentialNotFound, err)
+	credentials, err := database.GetWebAuthnCredentials()
+	assert.Nil(t, err)
+	assert.Len(t, credentials, 0)
+
+	siteId := global.GetUUID()
+	createdAt := time.Now().UTC().Add(-time.Hour)
+	credential := model.WebAuthnCredential{Id: "credential", UserId: "admin", Provider: "password", Name: "laptop", PublicKey: "key", Algorithm: -7, CreatedAt: createdAt}
+	err = database.SaveWebAuthnCredential(credential)
+	assert.Nil(t, err)
+	err = database.SaveWebAuthnCredential(model.WebAuthnCredential{Id: "other", UserId: "12345", UserName: "someone", Provider: "github", SiteId: &siteId, PublicKey: "other key", Algorithm: -8, CreatedAt: time.Now().UTC()})
+	assert.Nil(t, err)
+	stored, err := database.GetWebAuthnCredential("credential")
+	assert.Nil(t, err)
+	assert.Equal(t, "admin", stored.UserId)
+	assert.Equal(t, "password", stored.Provider)
+	assert.Nil(t, stored.SiteId)
+	assert.Equal(t, "laptop", stored.Name)
+	assert.Equal(t, "key", stored.PublicKey)
+	assert.Equal(t, -7, stored.Algorithm)
+	assert.Equal(t, int64(0), stored.SignCount)
+	assert.Nil(t, stored.LastUsedAt)
+	assert.WithinDuration(t, createdAt, stored.CreatedAt, time.Second)
+
+	// logging in bumps the counter
+	lastUsedAt := time.Now().UTC()
+	credential.SignCount = 42
+	credential.LastUsedAt = &lastUsedAt
+	err = database.SaveWebAuthnCredential(credential)
+	assert.Nil(t, err)
+	stored, err = database.GetWebAuthnCredential("credential")
+	assert.Nil(t, err)
+	assert.Equal(t, int64(42), stored.SignCount)
+	assert.NotNil(t, stored.LastUsedAt)
+	assert.WithinDuration(t, lastUsedAt, *stored.LastUsedAt, time.Second)
+
+	credentials, err = database.GetWebAuthnCredentials()
+	assert.Nil(t, err)
+	assert.Len(t, credentials, 2)
+	assert.Equal(t, "credential", credentials[0].Id)
+	assert.Equal(t, "other", credentials[1].Id)
+	assert.Equal(t, "someone", credentials[1].UserName)
+	assert.NotNil(t, credentials[1].SiteId)
+	assert.Equal(t, siteId, *credentials[1].SiteId)
+
+	err = database.DeleteWebAuthnCredential("credential")
+	assert.Nil(t, err)
+	err = database.DeleteWebAuthnCredential("credential")
+	assert.Equal(t, global.ErrWebAuthnCredentialNotFound, err)
+	_, err = database.GetWebAuthnCredential("credential")
+	assert.Equal(t, global.ErrWebAuthnCredentialNotFound, err)
+	credentials, err = database.GetWebAuthnCredentials()
+	assert.Nil(t, err)
+	assert.Len(t, credentials, 1)
+}
+
+func (ts TestSuite) APITokens(t *testing.T, database abstraction.Database) {
+	_, err := database.GetAPIToken("hash")
+	assert.Equal(t, global.ErrAPITokenNotFound, err)
+	tokens, err := database.GetAPITokens()
+	assert.Nil(t, err)
+	assert.Len(t, tokens, 0)
+
+	siteId := global.GetUUID()
+	createdAt := time.Now().UTC().Add(-time.Hour)
+	expiresAt := time.Now().UTC().Add(time.Hour)
+	token := model.APIToken{Id: "hash", Name: "moderation script", Scopes: "read,moderate", UserId: "admin", Provider: "password", CreatedAt: createdAt, ExpiresAt: &expiresAt}
+	err = database.SaveAPIToken(token)
+	assert.Nil(t, err)
+	err = database.SaveAPIToken(model.APIToken{Id: "other", Scopes: "read", UserId: "12345", UserName: "someone", Provider: "github", SiteId: &siteId, CreatedAt: time.Now().UTC()})
+	assert.Nil(t, err)
+	stored, err := database.GetAPIToken("hash")
+	assert.Nil(t, err)
+	assert.Equal(t, "moderation script", stored.Name)
+	assert.Equal(t, "read,moderate", stored.Scopes)
+	assert.True(t, stored.HasScope("moderate"))
+	assert.False(t, stored.HasScope("settings"))
+	assert.Equal(t, "admin", stored.UserId)
+	assert.Equal(t, "password", stored.Provider)
+	assert.Nil(t, stored.SiteId)
+	assert.Nil(t, stored.LastUsedAt)
+	assert.NotNil(t, stored.ExpiresAt)
+	assert.WithinDuration(t, expiresAt, *stored.ExpiresAt, time.Second)
+	assert.WithinDuration(t, createdAt, stored.CreatedAt, time.Second)
+
+	// using the token records when it was last used
+	lastUsedAt := time.Now().UTC()
+	token.LastUsedAt = &lastUsedAt
+	err = database.SaveAPIToken(token)
+	assert.Nil(t, err)
+	stored, err = database.GetAPIToken("hash")
+	assert.Nil(t, err)
+	assert.NotNil(t, stored.LastUsedAt)
+	assert.WithinDuration(t, lastUsedAt, *stored.LastUsedAt, time.Second)
+
+	tokens, err = database.GetAPITokens()
+	assert.Nil(t, err)
+	assert.Len(t, tokens, 2)
+	assert.Equal(t, "hash", tokens[0].Id)
+	assert.Equal(t, "other", tokens[1].Id)
+	assert.Equal(t, "someone", tokens[1].UserName)
+	assert.Nil(t, tokens[1].ExpiresAt)
+	assert.NotNil(t, tokens[1].SiteId)
+	assert.Equal(t, siteId, *tokens[1].SiteId)
+
+	err = database.DeleteAPIToken("hash")
+	assert.Nil(t, err)
+	err = database.DeleteAPIToken("hash")
+	assert.Equal(t, global.ErrAPITokenNotFound, err)
+	_, err = database.GetAPIToken("hash")
+	assert.Equal(t, global.ErrAPITokenNotFound, err)
+	tokens, err = database.GetAPITokens()
+	assert.Nil(t, err)
+	assert.Len(t, tokens, 1)
+}
+
+// SiteThreads checks that the site queries only get the threads, comments, stats and aliases of the site
+func (ts TestSuite) SiteThreads(t *testing.T, database abstraction.Database) {
+	siteId, err := database.CreateSite(model.Site{Key: "blog"})
+	assert.Nil(t, err)
+	threadId, err := database.CreateThread("site:blog:/post/", siteId)
+	assert.Nil(t, err)
+	thread, err := database.GetThread("site:blog:/post/")
+	assert.Nil(t, err)
+	assert.Equal(t, *siteId, *thread.SiteId)
+	otherId, err := database.CreateThread("/post/", nil)
+	assert.Nil(t, err)
+	thread, err = database.GetThreadById(*otherId)
+	assert.Nil(t, err)
+	assert.Nil(t, thread.SiteId)
+	threads, err := database.GetAllThreads()
+	assert.Nil(t, err)
+	assert.Len(t, threads, 2)
+
+	siteComment, err := database.CreateComment("body", "author", "site:blog:/post/", true, nil)
+	assert.Nil(t, err)
+	_, err = database.CreateComment("body", "author", "/post/", true, nil)
+	assert.Nil(t, err)
+	err = database.CreateThreadAlias("site:blog:/old-post/", *threadId)
+	assert.Nil(t, err)
+	err = database.CreateThreadAlias("/old-post/", *otherId)
+	assert.Nil(t, err)
+
+	threads, err = database.GetSiteThreads(*siteId)
+	assert.Nil(t, err)
+	assert.Len(t, threads, 1)
+	assert.Equal(t, *threadId, threads[0].Id)
+	comments, err := database.GetSiteComments(*siteId)
+	assert.Nil(t, err)
+	assert.Len(t, comments, 1)
+	assert.Equal(t, *siteComment, comments[0].Id)
+	stats, err := database.GetSiteThreadStats(*siteId)
+	assert.Nil(t, err)
+	assert.Len(t, stats, 1)
+	assert.Equal(t, *threadId, stats[0].ThreadId)
+	assert.Equal(t, 1, stats[0].CommentCount)
+	aliases, err := database.GetSiteThreadAliases(*siteId)
+	assert.Nil(t, err)
+	assert.Len(t, aliases, 1)
+	assert.Equal(t, "site:blog:/old-post/", aliases[0].Path)
+	threads, err = database.GetSiteThreads(global.GetUUID())
+	assert.Nil(t, err)
+	assert.Len(t, threads, 0)
+
+	err = database.DeleteSite(*siteId)
+	assert.Equal(t, global.ErrSiteHasThreads, err)
+	err = database.DeleteThread(*threadId)
+	assert.Nil(t, err)
+	err = database.DeleteSite(*siteId)
+	assert.Nil(t, err)
+	sites, err := database.GetSites()
+	assert.Nil(t, err)
+	assert.Len(t, sites, 0)
+}
+
 // CreateComment checks if we create the comment alright
 func (ts TestSuite) CreateComment(t *testing.T, database abstraction.Database) {
 	now := time.Now().UTC()
@@ -91,6 +775,33 @@ func (ts TestSuite) CreateComment(t *testing.T, database abstraction.Database) {
 
 }
 
+// CreateStaffComment checks that staff comments are confirmed and marked as such
+func (ts TestSuite) CreateStaffComment(t *testing.T, database abstraction.Database) {
+	parent, err := database.CreateComment("body", "author", "/test", true, nil)
+	assert.Nil(t, err)
+	uid, err := database.CreateStaffComment("staff body", "staff", "/test", parent)
+	assert.Nil(t, err)
+	comment, err := database.GetComment(*uid)
+	assert.Nil(t, err)
+	assert.True(t, comment.Staff)
+	assert.True(t, comment.Confirmed)
+	assert.Equal(t, "staff body", comment.Body)
+	assert.Equal(t, "staff", comment.Author)
+	assert.Equal(t, *parent, *comment.ReplyTo)
+	comment, err = database.GetComment(*parent)
+	assert.Nil(t, err)
+	assert.False(t, comment.Staff)
+
+	// a new thread is created if needed
+	uid, err = database.CreateStaffComment("staff body", "staff", "/test/new", nil)
+	assert.Nil(t, err)
+	comments, err := database.GetCommentsByThread("/test/new")
+	assert.Nil(t, err)
+	assert.Len(t, comments, 1)
+	assert.Equal(t, *uid, comments[0].Id)
+	assert.True(t, comments[0].Staff)
+}
+
 // CreateCommentNoReply checks if we return an error upon replying to a non existant reply to
 func (ts TestSuite) CreateCommentNoReply(t *testing.T, database abstraction.Database) {
 	replyTo := global.GetUUID()
@@ -171,7 +882,7 @@ func (ts TestSuite) GetCommentsByThreadNoThread(t *testing.T, database abstracti
 
 // GetCommentsByThreadEmptyThread asserts that we return an empty array if the thread has no comments
 func (ts TestSuite) GetCommentsByThreadEmptyThread(t *testing.T, database abstraction.Database) {
-	_, err := database.CreateThread("/test")
+	_, err := database.CreateThread("/test", nil)
 	assert.Nil(t, err)
 	comments, err := database.GetCommentsByThread("/test")
 	assert.Nil(t, err)
@@ -180,7 +891,7 @@ func (ts TestSuite) GetCommentsByThreadEmptyThread(t *testing.T, database abstra
 
 // GetCommentsByThread asserts that we get correct comments for a specific thread, aka only confirmed ones.
 func (ts TestSuite) GetCommentsByThread(t *testing.T, database abstraction.Database) {
-	_, err := database.CreateThread("/test")
+	_, err := database.CreateThread("/test", nil)
 	assert.Nil(t, err)
 	_, err = database.CreateComment("body", "author", "/test", true, nil)
 	assert.Nil(t, err)
@@ -206,6 +917,122 @@ func (ts TestSuite) GetCommentsByThread(t *testing.T, database abstraction.Datab
 	assert.Equal(t, true, comments[1].Confirmed)
 }
 
+// QueryCommentsByThreadSorts asserts that the comments come back in the requested order
+func (ts TestSuite) QueryCommentsByThreadSorts(t *testing.T, database abstraction.Database) {
+	first, err := database.CreateComment("first", "author", "/test", true, nil)
+	assert.Nil(t, err)
+	second, err := database.CreateComment("second", "author", "/test", true, nil)
+	assert.Nil(t, err)
+	_, err = database.CreateComment("reply", "author", "/test", true, second)
+	assert.Nil(t, err)
+	_, err = database.CreateComment("hidden reply", "author", "/test", false, first)
+	assert.Nil(t, err)
+	_, err = database.CreateComment("hidden reply", "author", "/test", false, first)
+	assert.Nil(t, err)
+
+	comments, err := database.QueryCommentsByThread("/test", model.CommentQuery{Sort: model.SortOldest})
+	assert.Nil(t, err)
+	assert.Len(t, comments, 3)
+	assert.Equal(t, "first", comments[0].Body)
+	assert.Equal(t, "second", comments[1].Body)
+	assert.Equal(t, "reply", comments[2].Body)
+
+	comments, err = database.QueryCommentsByThread("/test", model.CommentQuery{Sort: model.SortNewest})
+	assert.Nil(t, err)
+	assert.Len(t, comments, 3)
+	assert.Equal(t, "reply", comments[0].Body)
+	assert.Equal(t, "second", comments[1].Body)
+	assert.Equal(t, "first", comments[2].Body)
+
+	// only the visible replies count
+	comments, err = database.QueryCommentsByThread("/test", model.CommentQuery{Sort: model.SortReplies})
+	assert.Nil(t, err)
+	assert.Len(t, comments, 3)
+	assert.Equal(t, "second", comments[0].Body)
+	assert.Equal(t, "first", comments[1].Body)
+	assert.Equal(t, "reply", comments[2].Body)
+}
+
+// QueryCommentsByThreadSince asserts that only the comments newer than the given time are returned
+func (ts TestSuite) QueryCommentsByThreadSince(t *testing.T, database abstraction.Database) {
+	_, err := database.CreateComment("first", "author", "/test", true, nil)
+	assert.Nil(t, err)
+	_, err = database.CreateComment("second", "author", "/test", true, nil)
+	assert.Nil(t, err)
+	comments, err := database.GetCommentsByThread("/test")
+	assert.Nil(t, err)
+	assert.Len(t, comments, 2)
+
+	since := comments[0].CreatedAt
+	comments, err = database.QueryCommentsByThread("/test", model.CommentQuery{Since: &since})
+	assert.Nil(t, err)
+	assert.Len(t, comments, 1)
+	assert.Equal(t, "second", comments[0].Body)
+
+	since = comments[0].CreatedAt
+	comments, err = database.QueryCommentsByThread("/test", model.CommentQuery{Since: &since})
+	assert.Nil(t, err)
+	assert.Len(t, comments, 0)
+}
+
+// SetCommentPinnedNotFound asserts that we get ErrCommentNotFound when pinning a comment that does not exist
+func (ts TestSuite) SetCommentPinnedNotFound(t *testing.T, database abstraction.Database) {
+	err := database.SetCommentPinned(global.GetUUID(), true)
+	assert.Equal(t, global.ErrCommentNotFound, err)
+	err = database.SetCommentFeatured(global.GetUUID(), true)
+	assert.Equal(t, global.ErrCommentNotFound, err)
+}
+
+// SetCommentPinnedAndFeatured checks that the flags get set and unset
+func (ts TestSuite) SetCommentPinnedAndFeatured(t *testing.T, database abstraction.Database) {
+	uid, err := database.CreateComment("body", "author", "/test", true, nil)
+	assert.Nil(t, err)
+	err = database.SetCommentPinned(*uid, true)
+	assert.Nil(t, err)
+	// setting the same value again is fine
+	err = database.SetCommentPinned(*uid, true)
+	assert.Nil(t, err)
+	err = database.SetCommentFeatured(*uid, true)
+	assert.Nil(t, err)
+	comment, err := database.GetComment(*uid)
+	assert.Nil(t, err)
+	assert.True(t, comment.Pinned)
+	assert.True(t, comment.Featured)
+	err = database.SetCommentPinned(*uid, false)
+	assert.Nil(t, err)
+	err = database.SetCommentFeatured(*uid, false)
+	assert.Nil(t, err)
+	comment, err = database.GetComment(*uid)
+	assert.Nil(t, err)
+	assert.False(t, comment.Pinned)
+	assert.False(t, comment.Featured)
+}
+
+// QueryCommentsByThreadPinnedFirst asserts that pinned comments come first regardless of the sort order
+func (ts TestSuite) QueryCommentsByThreadPinnedFirst(t *testing.T, database abstraction.Database) {
+	_, err := database.CreateComment("first", "author", "/test", true, nil)
+	assert.Nil(t, err)
+	second, err := database.CreateComment("second", "author", "/test", true, nil)
+	assert.Nil(t, err)
+	_, err = database.CreateComment("third", "author", "/test", true, nil)
+	assert.Nil(t, err)
+	err = database.SetCommentPinned(*second, true)
+	assert.Nil(t, err)
+	for sort, expected := range map[model.CommentSort][]string{
+		model.SortOldest:  {"second", "first", "third"},
+		model.SortNewest:  {"second", "third", "first"},
+		model.SortReplies: {"second", "first", "third"},
+	} {
+		comments, err := database.QueryCommentsByThread("/test", model.CommentQuery{Sort: sort})
+		assert.Nil(t, err)
+		bodies := make([]string, 0, len(comments))
+		for _, v := range comments {
+			bodies = append(bodies, v.Body)
+		}
+		assert.Equal(t, expected, bodies)
+	}
+}
+
 // UpdateCommentNotFound asserts that we return ErrCommentNotFound upon updating a non existant comment
 func (ts TestSuite) UpdateCommentNotFound(t *testing.T, database abstraction.Database) {
 	err := database.UpdateComment(global.GetUUID(), "t", "t", false)
@@ -255,9 +1082,9 @@ func (ts TestSuite) GetAllThreadsEmptyDatabase(t *testing.T, database abstractio
 
 // GetAllThreads asserts that we return all the threads correctly
 func (ts TestSuite) GetAllThreads(t *testing.T, database abstraction.Database) {
-	_, err := database.CreateThread("/test")
+	_, err := database.CreateThread("/test", nil)
 	assert.Nil(t, err)
-	_, err = database.CreateThread("/test1")
+	_, err = database.CreateThread("/test1", nil)
 	assert.Nil(t, err)
 	threads, err := database.GetAllThreads()
 	assert.Nil(t, err)
@@ -266,6 +1093,41 @@ func (ts TestSuite) GetAllThreads(t *testing.T, database abstraction.Database) {
 	assert.Equal(t, "/test1", threads[1].Path)
 }
 
+// ThreadStats checks that the comments that are not deleted are counted per thread, along with the time of the newest one
+func (ts TestSuite) ThreadStats(t *testing.T, database abstraction.Database) {
+	stats, err := database.GetThreadStats()
+	assert.Nil(t, err)
+	assert.Len(t, stats, 0)
+	_, err = database.CreateThread("/empty", nil)
+	assert.Nil(t, err)
+	_, err = database.CreateComment("body", "author", "/test", true, nil)
+	assert.Nil(t, err)
+	deleted, err := database.CreateComment("body", "author", "/test", true, nil)
+	assert.Nil(t, err)
+	err = database.DeleteComment(*deleted)
+	assert.Nil(t, err)
+	_, err = database.CreateComment("body", "author", "/test1", false, nil)
+	assert.Nil(t, err)
+	newest, err := database.CreateComment("body", "author", "/test1", true, nil)
+	assert.Nil(t, err)
+	comment, err := database.GetComment(*newest)
+	assert.Nil(t, err)
+	stats, err = database.GetThreadStats()
+	assert.Nil(t, err)
+	assert.Len(t, stats, 2)
+	counts := make(map[uuid.UUID]int)
+	for _, v := range stats {
+		counts[v.ThreadId] = v.CommentCount
+		if v.ThreadId == comment.ThreadId {
+			assert.True(t, comment.CreatedAt.Equal(v.LastCommentAt), "%v != %v", comment.CreatedAt, v.LastCommentAt)
+		}
+	}
+	thread, err := database.GetThread("/test")
+	assert.Nil(t, err)
+	assert.Equal(t, 1, counts[thread.Id])
+	assert.Equal(t, 2, counts[comment.ThreadId])
+}
+
 // GetAllCommentsEmptyDatabase asserts that we return an empty dataset
 func (ts TestSuite) GetAllCommentsEmptyDatabase(t *testing.T, database abstraction.Database) {
 	comments, err := database.GetAllComments()
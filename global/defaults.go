@@ -10,20 +10,164 @@ const DefaultBindAddress = "0.0.0.0"
 // DefaultPort is the default value for api port
 const DefaultPort = 8080
 
+// UnixSocketPrefix starts the bind addresses of the unix sockets
+const UnixSocketPrefix = "unix:"
+
+// DefaultUnixSocketMode default file mode of the unix socket the api listens on
+const DefaultUnixSocketMode = "0660"
+
+// DefaultShutdownTimeoutInSeconds default time the requests in flight get to finish on shutdown
+const DefaultShutdownTimeoutInSeconds = 30
+
 // DefaultDynamoDbThreadTableName default suffix for dynamodb thread
 const DefaultDynamoDbThreadTableName = "mouthful_thread"
 
 // DefaultDynamoDbCommentTableName default suffix for dynamodb comment
 const DefaultDynamoDbCommentTableName = "mouthful_comment"
 
+// DefaultDynamoDbAuditTableName default suffix for dynamodb audit log
+const DefaultDynamoDbAuditTableName = "mouthful_audit"
+
+// DefaultDynamoDbAuditUnits default read and write units for the dynamodb audit log table
+const DefaultDynamoDbAuditUnits = int64(1)
+
+// DefaultDynamoDbThreadAliasTableName default suffix for dynamodb thread aliases. The table uses the thread table units
+const DefaultDynamoDbThreadAliasTableName = "mouthful_thread_alias"
+
+// DefaultDynamoDbSiteTableName default suffix for dynamodb sites. The table uses the thread table units
+const DefaultDynamoDbSiteTableName = "mouthful_site"
+
+// DefaultDynamoDbAdminSessionTableName default suffix for dynamodb admin sessions. The table uses the audit table units
+const DefaultDynamoDbAdminSessionTableName = "mouthful_admin_session"
+
+// DefaultDynamoDbLoginFailureTableName default suffix for dynamodb login failures. The table uses the audit table units
+const DefaultDynamoDbLoginFailureTableName = "mouthful_login_failure"
+
+// DefaultDynamoDbTwoFactorTableName default suffix for dynamodb two-factor secrets. The table uses the audit table units
+const DefaultDynamoDbTwoFactorTableName = "mouthful_two_factor"
+
+// DefaultDynamoDbWebAuthnCredentialTableName default suffix for dynamodb webauthn credentials. The table uses the audit table units
+const DefaultDynamoDbWebAuthnCredentialTableName = "mouthful_webauthn_credential"
+
+// DefaultDynamoDbAPITokenTableName default suffix for dynamodb api tokens. The table uses the audit table units
+const DefaultDynamoDbAPITokenTableName = "mouthful_api_token"
+
 // DefaultCommentLengthLimit default comment length limit
 const DefaultCommentLengthLimit = 0
 
 // DefaultSessionName default sesion name for api
 const DefaultSessionName = "mouthful-session"
 
+// DefaultSessionDurationSeconds is how long admin sessions last if the config does not say
+const DefaultSessionDurationSeconds = 1800
+
+// MinSessionSecretLength is the minimum length of the secret the admin sessions are signed with
+const MinSessionSecretLength = 32
+
+// MinTwoFactorEncryptionKeyLength is the minimum length of the key the totp secrets are encrypted with
+const MinTwoFactorEncryptionKeyLength = 32
+
+// DefaultTwoFactorIssuer is the name the admin accounts show up under in the authenticator apps if the config does not say
+const DefaultTwoFactorIssuer = "mouthful"
+
+// TwoFactorLoginTimeoutSeconds is how long an admin has to enter the second factor after the password
+const TwoFactorLoginTimeoutSeconds = 300
+
+// DefaultWebAuthnRPName is the name the relying party shows up under in the passkey prompts if the config does not say
+const DefaultWebAuthnRPName = "mouthful"
+
+// WebAuthnTimeoutSeconds is how long an admin has to answer a webauthn registration or login prompt
+const WebAuthnTimeoutSeconds = 300
+
+// MaxWebAuthnChallengesPerClient is the maximum amount of webauthn registrations and logins a client can have waiting for the response of the authenticator at once
+const MaxWebAuthnChallengesPerClient = 10
+
+// MaxWebAuthnCredentialNameLength is the maximum length of the names the admins give their webauthn credentials
+const MaxWebAuthnCredentialNameLength = 100
+
+// MaxAPITokenNameLength is the maximum length of the names the admins give their api tokens
+const MaxAPITokenNameLength = 100
+
+// DefaultAuditLogPageSize is the amount of audit log entries returned when the request doesn't give a limit
+const DefaultAuditLogPageSize = 100
+
+// MaxAuditLogPageSize is the most audit log entries returned at once
+const MaxAuditLogPageSize = 1000
+
+// DefaultProxyAuthUserHeader is the header the single sign-on proxy sends the user in if the config does not say
+const DefaultProxyAuthUserHeader = "Remote-User"
+
+// DefaultLoginBaseDelaySeconds is how long the admin logins of a client or account are blocked after the first failure. The delay doubles with each failure in a row
+const DefaultLoginBaseDelaySeconds = 1
+
+// DefaultLoginMaxDelaySeconds is the longest the admin logins are blocked for between two failures, short of a lockout
+const DefaultLoginMaxDelaySeconds = 60
+
+// DefaultLoginMaxFailures is the amount of failed admin logins in a row a client or account gets locked out after
+const DefaultLoginMaxFailures = 10
+
+// DefaultLoginLockoutSeconds is how long the lockouts last, and how long it takes for the failed logins to be forgotten
+const DefaultLoginLockoutSeconds = 900
+
 // DefaultAuthorLengthLimit default author length limit
 const DefaultAuthorLengthLimit = 50
 
+// DefaultThreadTitleLengthLimit is the length thread titles are cut to
+const DefaultThreadTitleLengthLimit = 255
+
+// DefaultThreadURLLengthLimit is the maximum length of thread urls, longer ones are dropped
+const DefaultThreadURLLengthLimit = 2048
+
+// DefaultThreadKeyLengthLimit is the maximum length of explicit thread keys
+const DefaultThreadKeyLengthLimit = 200
+
+// DefaultSiteKeyLengthLimit is the maximum length of site keys
+const DefaultSiteKeyLengthLimit = 64
+
 // DefaultCleanupPeriod default cleanup period time
 const DefaultCleanupPeriod = int64(86400)
+
+// DefaultStreamHeartbeatSeconds default interval between heartbeats on comment streams
+const DefaultStreamHeartbeatSeconds = 30
+
+// DefaultStreamMaxConnectionsPerIP default limit of simultaneous comment streams a single ip can open
+const DefaultStreamMaxConnectionsPerIP = 10
+
+// DefaultStreamBufferSize default amount of events retained per thread for stream resumption
+const DefaultStreamBufferSize = 100
+
+// DefaultCacheControl default Cache-Control header value for the comment responses, forcing revalidation
+const DefaultCacheControl = "no-cache"
+
+// DefaultSurrogateKey default surrogate key attached to all the comment responses
+const DefaultSurrogateKey = "mouthful"
+
+// DefaultGzipLevel default gzip compression level for the responses
+const DefaultGzipLevel = 6
+
+// DefaultBrotliQuality default brotli compression quality for the responses
+const DefaultBrotliQuality = 5
+
+// DefaultContentSecurityPolicy default Content-Security-Policy header value. The admin panel gets a nonce for its scripts on top of it
+const DefaultContentSecurityPolicy = "default-src 'self'; script-src 'self'; style-src 'self' 'unsafe-inline'; img-src 'self' data: https:; connect-src 'self'; object-src 'none'; base-uri 'self'; form-action 'self'"
+
+// DefaultEmbedContentSecurityPolicy default Content-Security-Policy header value for the rendered comments, which have no scripts to run
+const DefaultEmbedContentSecurityPolicy = "default-src 'none'; style-src 'self' 'unsafe-inline'; img-src 'self' data: https:; base-uri 'none'; form-action 'self'"
+
+// DefaultReferrerPolicy default Referrer-Policy header value
+const DefaultReferrerPolicy = "same-origin"
+
+// DefaultPermissionsPolicy default Permissions-Policy header value, turning off the browser features mouthful has no use for
+const DefaultPermissionsPolicy = "accelerometer=(), camera=(), geolocation=(), gyroscope=(), magnetometer=(), microphone=(), payment=(), usb=()"
+
+// DefaultHSTSMaxAgeInSeconds default max-age of the Strict-Transport-Security header, sent over TLS only
+const DefaultHSTSMaxAgeInSeconds = 31536000
+
+// DefaultACMECacheDirectory default directory the certificates and the account key obtained through ACME are kept in
+const DefaultACMECacheDirectory = "./data/acme"
+
+// DefaultACMERedirectAddress default address of the plain http listener answering the ACME http-01 challenges
+const DefaultACMERedirectAddress = ":80"
+
+// DefaultMaxPinsPerThread default limit of pinned comments per thread
+const DefaultMaxPinsPerThread = 3
@@ -25,3 +25,102 @@ var ErrCouldNotFindBundleFile = errors.New("Can't find bundle file")
 
 // ErrCouldNotOverrideBundlePath indicates that we could not find override the path in bundle
 var ErrCouldNotOverrideBundlePath = errors.New("Can't override bundle file path")
+
+// ErrTooManyConnections indicates that the client has too many open connections
+var ErrTooManyConnections = errors.New("Too many connections")
+
+// ErrCantPinHiddenComment indicates that the comment is pending or deleted, so it can't be pinned
+var ErrCantPinHiddenComment = errors.New("Only visible comments can be pinned")
+
+// ErrTooManyPinnedComments indicates that the thread already has the maximum amount of pinned comments
+var ErrTooManyPinnedComments = errors.New("Too many pinned comments in this thread")
+
+// ErrReservedAuthorName indicates that the author name is reserved for the site staff
+var ErrReservedAuthorName = errors.New("This name is reserved")
+
+// ErrThreadLocked indicates that the thread no longer accepts comments from the readers
+var ErrThreadLocked = errors.New("This thread is locked")
+
+// ErrThreadArchived indicates that the thread is read-only
+var ErrThreadArchived = errors.New("This thread is archived")
+
+// ErrThreadAlreadyExists indicates that there already is a thread with the given path
+var ErrThreadAlreadyExists = errors.New("A thread with this path already exists")
+
+// ErrCantMergeThreadIntoItself indicates that the source and target threads of a merge are the same
+var ErrCantMergeThreadIntoItself = errors.New("Can't merge a thread into itself")
+
+// ErrThreadAliasNotFound indicates that the path is not an alias of any thread
+var ErrThreadAliasNotFound = errors.New("Thread alias not found")
+
+// ErrThreadAliasAlreadyExists indicates that the path already is an alias of a thread
+var ErrThreadAliasAlreadyExists = errors.New("This path already is an alias")
+
+// ErrInvalidThreadKey indicates that the thread key is empty, too long or contains characters other than letters, digits and ._:/-
+var ErrInvalidThreadKey = errors.New("Invalid thread key")
+
+// ErrCantMergeAcrossSites indicates that the source and target threads of a merge belong to different sites
+var ErrCantMergeAcrossSites = errors.New("Can't merge threads of different sites")
+
+// ErrSiteNotFound indicates that the site was not found
+var ErrSiteNotFound = errors.New("Site not found")
+
+// ErrSiteAlreadyExists indicates that a site with the same key already exists
+var ErrSiteAlreadyExists = errors.New("Site already exists")
+
+// ErrSiteHasThreads indicates that the site can't be deleted, as threads still belong to it
+var ErrSiteHasThreads = errors.New("Site still has threads")
+
+// ErrInvalidSiteKey indicates that the site key is empty, too long or contains characters other than lowercase letters, digits and -
+var ErrInvalidSiteKey = errors.New("Invalid site key")
+
+// ErrOriginNotAllowed indicates that the request came from an origin the requested site does not allow
+var ErrOriginNotAllowed = errors.New("Origin not allowed for this site")
+
+// ErrPostOriginNotAllowed indicates that comments can't be posted from the origin of the request
+var ErrPostOriginNotAllowed = errors.New("Comments can't be posted from this origin")
+
+// ErrPathNotAllowed indicates that no thread can be created for the requested path
+var ErrPathNotAllowed = errors.New("Comments can't be posted for this path")
+
+// ErrSessionNotFound indicates that the admin session does not exist, was revoked or has expired
+var ErrSessionNotFound = errors.New("Session not found")
+
+// ErrInvalidOrigin indicates that the admin request came from an origin other than the admin panel's
+var ErrInvalidOrigin = errors.New("Invalid origin")
+
+// ErrInvalidCSRFToken indicates that the admin request did not carry the csrf token of the session
+var ErrInvalidCSRFToken = errors.New("Invalid csrf token")
+
+// ErrLoginFailureNotFound indicates that there are no failed logins recorded for the client or account
+var ErrLoginFailureNotFound = errors.New("Login failure not found")
+
+// ErrTooManyLoginAttempts indicates that the logins of the client or account are blocked after too many failed attempts
+var ErrTooManyLoginAttempts = errors.New("Too many login attempts, try again later")
+
+// ErrTwoFactorNotFound indicates that the admin account has no second factor set up
+var ErrTwoFactorNotFound = errors.New("Two-factor authentication is not set up")
+
+// ErrTwoFactorAlreadyEnabled indicates that the admin account already has a confirmed second factor
+var ErrTwoFactorAlreadyEnabled = errors.New("Two-factor authentication is already enabled")
+
+// ErrInvalidTwoFactorCode indicates that the totp or recovery code is wrong, or was used already
+var ErrInvalidTwoFactorCode = errors.New("Invalid two-factor code")
+
+// ErrPasswordLoginOnly indicates that the request is only possible for the admins logged in with a password
+var ErrPasswordLoginOnly = errors.New("Only possible for the admins logged in with a password")
+
+// ErrWebAuthnCredentialNotFound indicates that the webauthn credential is not registered
+var ErrWebAuthnCredentialNotFound = errors.New("WebAuthn credential not found")
+
+// ErrWebAuthnCredentialExists indicates that the webauthn credential is registered already
+var ErrWebAuthnCredentialExists = errors.New("WebAuthn credential is already registered")
+
+// ErrInvalidWebAuthnResponse indicates that the response of the authenticator does not check out
+var ErrInvalidWebAuthnResponse = errors.New("Invalid WebAuthn response")
+
+// ErrTooManyWebAuthnChallenges indicates that the client has too many webauthn registrations and logins in progress to begin another one
+var ErrTooManyWebAuthnChallenges = errors.New("Too many WebAuthn ceremonies in progress from this client, try again later")
+
+// ErrAPITokenNotFound indicates that the api token does not exist, or was revoked
+var ErrAPITokenNotFound = errors.New("API token not found")
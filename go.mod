@@ -3,6 +3,7 @@ module github.com/vkuznecovas/mouthful
 go 1.14
 
 require (
+	github.com/andybalholm/brotli v1.0.6
 	github.com/appleboy/gofight v2.0.0+incompatible
 	github.com/aws/aws-sdk-go v1.34.31
 	github.com/buger/jsonparser v1.1.1 // indirect
@@ -10,7 +11,7 @@ require (
 	github.com/gin-contrib/cors v1.3.1
 	github.com/gin-contrib/sessions v0.0.3
 	github.com/gin-contrib/static v0.0.0-20200916080430-d45d9a37d28e
-	github.com/gin-gonic/gin v1.6.3
+	github.com/gin-gonic/gin v1.7.7
 	github.com/go-sql-driver/mysql v1.5.0
 	github.com/gofrs/uuid v3.3.0+incompatible
 	github.com/guregu/dynamo v1.9.1
@@ -26,8 +27,10 @@ require (
 	github.com/shurcooL/sanitized_anchor_name v1.0.0 // indirect
 	github.com/spf13/afero v1.4.0
 	github.com/stretchr/testify v1.6.1
+	github.com/ugorji/go/codec v1.1.7
 	github.com/ulule/limiter v2.2.2+incompatible
 	github.com/urfave/cli v1.22.4
+	golang.org/x/crypto v0.0.0-20200622213623-75b288015ac9
 	google.golang.org/appengine v1.6.6 // indirect
 	gopkg.in/gin-gonic/gin.v1 v1.3.0 // indirect
 )
@@ -3,6 +3,7 @@ package job
 import (
 	"fmt"
 	"log"
+	"sync"
 	"time"
 
 	"github.com/vkuznecovas/mouthful/config/model"
@@ -10,6 +11,14 @@ import (
 	"github.com/vkuznecovas/mouthful/global"
 )
 
+// running holds the stop channels of the running cleanup jobs
+var running struct {
+	mutex sync.Mutex
+	stops []chan struct{}
+	// jobs lets StopCleanupJobs wait for the cleanups in progress
+	jobs sync.WaitGroup
+}
+
 // StartCleanupJobs starts the required scheduled tasks to delete stale data
 func StartCleanupJobs(db abstraction.Database, config *model.PeriodicCleanUp) error {
 	if config == nil || !config.Enabled {
@@ -43,10 +52,19 @@ func StartCleanupJobs(db abstraction.Database, config *model.PeriodicCleanUp) er
 func StartCleanupJob(db abstraction.Database, olderThan int64, every int64, t global.CleanupType) {
 	duration := time.Duration(every) * time.Second
 	ticker := time.NewTicker(duration)
+	stop := make(chan struct{})
+	running.mutex.Lock()
+	running.stops = append(running.stops, stop)
+	running.jobs.Add(1)
+	running.mutex.Unlock()
 	log.Printf("Cleanup job %v is up and running.\n", t)
 	go func() {
+		defer running.jobs.Done()
+		defer ticker.Stop()
 		for {
 			select {
+			case <-stop:
+				return
 			case <-ticker.C:
 				log.Printf("Removing old %v comments...\n", t)
 				err := db.CleanUpStaleData(t, olderThan)
@@ -60,3 +78,14 @@ func StartCleanupJob(db abstraction.Database, olderThan int64, every int64, t gl
 		}
 	}()
 }
+
+// StopCleanupJobs stops all the running cleanup jobs, waiting for the cleanups in progress to finish
+func StopCleanupJobs() {
+	running.mutex.Lock()
+	for _, stop := range running.stops {
+		close(stop)
+	}
+	running.stops = nil
+	running.mutex.Unlock()
+	running.jobs.Wait()
+}
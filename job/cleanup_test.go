@@ -63,3 +63,14 @@ func TestStartCleanupJobsReturnsNonNilErrorOnZeroTimeoutForUnconfirmed(t *testin
 	assert.NotNil(t, err)
 	assert.Equal(t, "UnconfirmedTimeoutSeconds not specified but the deletion job is enabled, please specify a value in config", err.Error())
 }
+
+func TestStopCleanupJobs(t *testing.T) {
+	db := sqlite.CreateTestDatabase()
+	err := job.StartCleanupJobs(db, &cleanupConfig)
+	assert.Nil(t, err)
+	job.StopCleanupJobs()
+	// stopping again, and after a restart, is fine
+	job.StopCleanupJobs()
+	job.StartCleanupJob(db, 1, 1, global.Deleted)
+	job.StopCleanupJobs()
+}
@@ -4,11 +4,17 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"io/ioutil"
 	"log"
+	"net"
+	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/vkuznecovas/mouthful/global"
 
@@ -88,18 +94,89 @@ func main() {
 		port = *config.API.Port
 	}
 
-	// add GIN bind address, serving on all by default
-	bindAddress := global.DefaultBindAddress
-	if config.API.BindAddress != nil {
-		bindAddress = *config.API.BindAddress
+	// set up TLS, if enabled
+	tlsSettings, err := api.NewTLS(config.API.TLS, port)
+	if err != nil {
+		panic(err)
+	}
+
+	// listen on the sockets passed by systemd, or the bind address, serving on all by default
+	listeners, err := api.Listen(config.API)
+	if err != nil {
+		panic(err)
 	}
 
-	// run the server
-	fullAddress := fmt.Sprintf("%v:%v", bindAddress, port)
+	// the streams never finish by themselves, so their requests are cancelled on shutdown
+	baseContext, cancelRequests := context.WithCancel(context.Background())
+	server := &http.Server{
+		Handler:     service,
+		BaseContext: func(net.Listener) context.Context { return baseContext },
+	}
+	server.RegisterOnShutdown(cancelRequests)
+	servers := []*http.Server{server}
+
+	// run the servers
+	serveErrors := make(chan error, len(listeners)+1)
 	color.Set(color.FgGreen)
-	log.Println("Running mouthful server on ", fullAddress)
+	if tlsSettings != nil {
+		server.TLSConfig = tlsSettings.Config
+		if tlsSettings.RedirectAddress != "" {
+			log.Println("Redirecting to https from ", tlsSettings.RedirectAddress)
+			redirectServer := &http.Server{Addr: tlsSettings.RedirectAddress, Handler: tlsSettings.RedirectHandler}
+			servers = append(servers, redirectServer)
+			go func() {
+				serveErrors <- redirectServer.ListenAndServe()
+			}()
+		}
+	}
+	for _, listener := range listeners {
+		if tlsSettings != nil {
+			log.Println("Running mouthful server with TLS on ", listener.Addr())
+		} else {
+			log.Println("Running mouthful server on ", listener.Addr())
+		}
+		go func(listener net.Listener) {
+			if tlsSettings != nil {
+				serveErrors <- server.ServeTLS(listener, "", "")
+			} else {
+				serveErrors <- server.Serve(listener)
+			}
+		}(listener)
+	}
 	color.Unset()
-	service.Run(fullAddress)
+
+	// wait for a signal to shut down, or for a server to fail
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, os.Interrupt)
+	select {
+	case err = <-serveErrors:
+		log.Println(err)
+	case received := <-signals:
+		log.Printf("Received %v, shutting down...\n", received)
+	}
+	signal.Stop(signals)
+
+	// drain the requests in flight, then stop the jobs before closing the database they use
+	shutdownTimeout := global.DefaultShutdownTimeoutInSeconds
+	if config.API.ShutdownTimeoutInSeconds != nil {
+		shutdownTimeout = *config.API.ShutdownTimeoutInSeconds
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(shutdownTimeout)*time.Second)
+	defer cancel()
+	for _, v := range servers {
+		if shutdownErr := v.Shutdown(ctx); shutdownErr != nil {
+			log.Println("Couldn't finish the requests in flight: ", shutdownErr)
+			v.Close()
+		}
+	}
+	job.StopCleanupJobs()
+	if closeErr := database.Close(); closeErr != nil {
+		log.Println("Couldn't close the database: ", closeErr)
+	}
+	if err != nil {
+		os.Exit(1)
+	}
+	log.Println("Mouthful has shut down")
 }
 
 func howto() {